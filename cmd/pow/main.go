@@ -0,0 +1,87 @@
+// Package main is a NIP-13 proof of work miner that stamps nostr events with
+// a nonce tag, so they are accepted by relays that require proof of work.
+package main
+
+import (
+	"fmt"
+	"io"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/bech32encoding"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/protocol/pow"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/interrupt"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/lol"
+	"os"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+const secEnv = "NOSTR_SECRET_KEY"
+
+var args struct {
+	Difficulty int `arg:"positional,required" help:"the number of leading zero bits the event id must have"`
+	Threads    int `help:"number of threads to mine with - defaults to using all CPU threads available"`
+}
+
+func fail(format string, a ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
+
+func main() {
+	lol.SetLogLevel("info")
+	p := arg.MustParse(&args)
+	if args.Difficulty < 1 || args.Difficulty > 256 {
+		p.Fail("difficulty must be between 1 and 256")
+	}
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fail("failed to read event: %s", err)
+	}
+	ev := event.New()
+	if _, err = ev.Unmarshal(b); chk.E(err) {
+		fail("failed to decode event: %s", err)
+	}
+	// the pubkey is part of the mined hash, so it must be set before mining
+	var sign *p256k.Signer
+	if nsec := os.Getenv(secEnv); nsec != "" {
+		var sk []byte
+		if sk, err = bech32encoding.NsecToBytes([]byte(nsec)); chk.E(err) {
+			fail("failed to decode nsec: '%s'", err.Error())
+		}
+		sign = &p256k.Signer{}
+		if err = sign.InitSec(sk); chk.E(err) {
+			fail("failed to init signer: '%s'", err.Error())
+		}
+		ev.Pubkey = sign.Pub()
+	} else {
+		log.W.F(
+			"no secret key in environment variable %s, event will not be signed",
+			secEnv,
+		)
+	}
+	c, cancel := context.Cancel(context.Bg())
+	interrupt.AddHandler(cancel)
+	started := time.Now()
+	var attempts int64
+	if attempts, err = pow.Mine(
+		c, ev, args.Difficulty, args.Threads,
+	); err != nil {
+		fail(err.Error())
+	}
+	log.I.F(
+		"mined difficulty %d in %d attempts, taking %v", ev.Difficulty(),
+		attempts, time.Now().Sub(started),
+	)
+	// the signature is invalidated by mining
+	if sign != nil {
+		if err = ev.Sign(sign); chk.E(err) {
+			fail("failed to sign event: '%s'", err.Error())
+		}
+	}
+	fmt.Println(string(ev.Serialize()))
+}
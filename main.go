@@ -11,7 +11,6 @@ import (
 	"orly.dev/pkg/app/config"
 	"orly.dev/pkg/app/relay"
 	"orly.dev/pkg/app/relay/options"
-	"orly.dev/pkg/database"
 	"orly.dev/pkg/protocol/openapi"
 	"orly.dev/pkg/protocol/servemux"
 	"orly.dev/pkg/utils/chk"
@@ -42,6 +41,24 @@ func main() {
 		config.PrintHelp(cfg, os.Stderr)
 		os.Exit(0)
 	}
+	if config.StatsRequested() {
+		if err = app2.PrintStats(cfg, os.Stdout); chk.E(err) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if config.BackupRequested() {
+		if err = app2.Backup(cfg, os.Args[2:]); chk.E(err) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if config.RestoreRequested() {
+		if err = app2.Restore(cfg, os.Args[2:]); chk.E(err) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	lol.SetLogLevel(cfg.LogLevel)
 	if cfg.Pprof != "" {
 		switch cfg.Pprof {
@@ -57,13 +74,10 @@ func main() {
 		}
 	}
 	c, cancel := context.Cancel(context.Bg())
-	var storage *database.D
-	if storage, err = database.New(
-		c, cancel, cfg.DataDir, cfg.DbLogLevel,
-	); chk.E(err) {
+	var r *app2.Relay
+	if r, err = app2.New(c, cancel, cfg); chk.E(err) {
 		os.Exit(1)
 	}
-	r := &app2.Relay{C: cfg, Store: storage}
 	go app2.MonitorResources(c)
 	var server *relay.Server
 	serverParams := &relay.ServerParams{
@@ -71,7 +85,7 @@ func main() {
 		Cancel:   cancel,
 		Rl:       r,
 		DbPath:   cfg.DataDir,
-		MaxLimit: 512, // Default max limit for events
+		MaxLimit: cfg.MaxLimit,
 		C:        cfg,
 	}
 	var opts []options.O
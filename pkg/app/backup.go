@@ -0,0 +1,148 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"os"
+	"strconv"
+)
+
+// Backup writes a backup of the badger event store in the DataDir of the
+// configuration. The arguments are the file to write it to, which is stdout
+// if it is missing or "-", and the version to back up the changes since,
+// which is the next version printed by the backup before it, or 0 for a full
+// backup. The info of the backup is printed to stderr.
+//
+// The relay must not be running; a running relay is backed up with the
+// /backup endpoint of its API.
+func Backup(cfg *config.C, args []string) (err error) {
+	out, since := io.Writer(os.Stdout), uint64(0)
+	if len(args) > 1 {
+		if since, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			err = errorf.E("invalid version to back up since %q", args[1])
+			return
+		}
+	}
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	var d *database.D
+	if d, err = openStore(c, cancel, cfg); err != nil {
+		return
+	}
+	defer d.Close()
+	if len(args) > 0 && args[0] != "-" {
+		var f *os.File
+		if f, err = os.Create(args[0]); chk.E(err) {
+			return
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	var info *store.BackupInfo
+	if info, err = d.Backup(c, w, since); chk.E(err) {
+		return
+	}
+	if err = w.Flush(); chk.E(err) {
+		return
+	}
+	fmt.Fprintf(
+		os.Stderr, "backed up versions %d to %d, %d bytes, sha256 %s\n"+
+			"the next incremental backup is since %d\n", info.Since,
+		info.Upto, info.Bytes, info.Sha256, info.Next,
+	)
+	return
+}
+
+// Restore restores the backups in files into the badger event store in the
+// DataDir of the configuration. The first must be a full backup if the store
+// has no events, and each backup after it must be the incremental backup
+// since the one before. All of them are checked before any is restored.
+//
+// The relay must not be running.
+func Restore(cfg *config.C, files []string) (err error) {
+	if len(files) == 0 {
+		err = errorf.E("no backups to restore")
+		return
+	}
+	var next uint64
+	for i, file := range files {
+		var info *store.BackupInfo
+		if info, err = verifyFile(file); err != nil {
+			err = errorf.E("%s: %v", file, err)
+			return
+		}
+		if i > 0 && info.Since != next {
+			err = errorf.E(
+				"%s is a backup since %d, expected the backup since %d",
+				file, info.Since, next,
+			)
+			return
+		}
+		next = info.Next
+	}
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	var d *database.D
+	if d, err = openStore(c, cancel, cfg); err != nil {
+		return
+	}
+	defer d.Close()
+	for _, file := range files {
+		var f *os.File
+		if f, err = os.Open(file); chk.E(err) {
+			return
+		}
+		var info *store.BackupInfo
+		info, err = d.Restore(c, bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			err = errorf.E("%s: %v", file, err)
+			return
+		}
+		fmt.Fprintf(
+			os.Stderr, "restored %s, versions %d to %d\n", file, info.Since,
+			info.Upto,
+		)
+	}
+	return
+}
+
+// verifyFile checks the checksum of the backup in a file.
+func verifyFile(file string) (info *store.BackupInfo, err error) {
+	var f *os.File
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+	return database.VerifyBackup(bufio.NewReader(f))
+}
+
+// openStore opens the badger event store in the DataDir of the configuration,
+// for the commands that run while the relay is stopped.
+func openStore(c context.T, cancel context.F, cfg *config.C) (
+	d *database.D, err error,
+) {
+	if cfg.DbType != "" && cfg.DbType != "badger" {
+		err = errorf.E("the %s event store is not supported", cfg.DbType)
+		return
+	}
+	if d, err = database.New(c, cancel, cfg.DataDir, "warn"); err != nil {
+		err = errorf.E(
+			"failed to open the event store, stop the relay or use its API: "+
+				"%v", err,
+		)
+		return
+	}
+	d.SetGCPolicy(GCPolicy(cfg))
+	d.SetTagRules(database.ParseTagRules(cfg.TagIndexes))
+	d.SetChangeRetention(cfg.DbChangeLogRetention)
+	return
+}
@@ -30,6 +30,7 @@ type C struct {
 	Config         string   `env:"ORLY_CONFIG_DIR" usage:"location for configuration file, which has the name '.env' to make it harder to delete, and is a standard environment KEY=value<newline>... style" default:"~/.config/orly"`
 	State          string   `env:"ORLY_STATE_DATA_DIR" usage:"storage location for state data affected by dynamic interactive interfaces" default:"~/.local/state/orly"`
 	DataDir        string   `env:"ORLY_DATA_DIR" usage:"storage location for the event store" default:"~/.local/cache/orly"`
+	DbType         string   `env:"ORLY_DB_TYPE" default:"badger" usage:"event store backend: badger, memory (events are lost when the relay stops) or sqlite" enum:"badger,memory,sqlite"`
 	Listen         string   `env:"ORLY_LISTEN" default:"0.0.0.0" usage:"network listen address"`
 	Port           int      `env:"ORLY_PORT" default:"3334" usage:"port to listen on"`
 	LogLevel       string   `env:"ORLY_LOG_LEVEL" default:"info" usage:"debug level: fatal error warn info debug trace"`
@@ -42,8 +43,57 @@ type C struct {
 	Owners         []string `env:"ORLY_OWNERS" usage:"list of users whose follow lists designate whitelisted users who can publish events, and who can read if public readable is false (comma separated)"`
 	Private        bool     `env:"ORLY_PRIVATE" usage:"do not spider for user metadata because the relay is private and this would leak relay memberships" default:"false"`
 	Whitelist      []string `env:"ORLY_WHITELIST" usage:"only allow connections from this list of IP addresses"`
+	BlockList      []string `env:"ORLY_BLOCKLIST" usage:"ignore connections from this list of IP addresses and CIDR ranges, such as 10.0.0.0/8"`
 	RelaySecret    string   `env:"ORLY_SECRET_KEY" usage:"secret key for relay cluster replication authentication"`
 	PeerRelays     []string `env:"ORLY_PEER_RELAYS" usage:"list of peer relays URLs that new events are pushed to in format <pubkey>|<url>"`
+	KeepReplaced   bool     `env:"ORLY_KEEP_REPLACED" default:"false" usage:"retain superseded versions of replaceable events as history instead of deleting them"`
+	TagIndexes     []string `env:"ORLY_TAG_INDEXES" usage:"tags indexed in addition to the first value of one letter tags, as name[:position[:type]] where the type is text, number or timestamp, such as imeta,price:1:number (comma separated) (badger only, rescan after changing them)"`
+
+	DbGCInterval          time.Duration `env:"ORLY_DB_GC_INTERVAL" default:"1h" usage:"interval between garbage collections of the event store (0 disables them) (badger only)"`
+	DbGCDiscardPercent    int           `env:"ORLY_DB_GC_DISCARD_PERCENT" default:"50" usage:"percentage of a value log file that must be garbage for the collection to rewrite it"`
+	DbGCMinValueLogMB     int           `env:"ORLY_DB_GC_MIN_VLOG_MB" default:"256" usage:"size in megabytes the value log must reach before the collection rewrites its files"`
+	DbCompactStalePercent int           `env:"ORLY_DB_COMPACT_STALE_PERCENT" default:"25" usage:"percentage of the LSM tree that must be deleted or overwritten data for the collection to compact it (0 disables it)"`
+	DbChangeLogRetention  time.Duration `env:"ORLY_CHANGE_LOG_RETENTION" default:"168h" usage:"how long the entries of the change log of saved and deleted events are kept for the systems that follow it (0 keeps them forever) (badger only)"`
+
+	RetentionInterval     time.Duration `env:"ORLY_RETENTION_INTERVAL" default:"10m" usage:"interval between the deletions of the events that break the retention rules (0 disables them, but the quotas are still checked on writes)"`
+	RetentionMaxAge       []string      `env:"ORLY_RETENTION_MAX_AGE" usage:"maximum age of the events of a kind, as kind:duration, such as 1:2160h (comma separated)"`
+	RetentionKeepLatest   []string      `env:"ORLY_RETENTION_KEEP_LATEST" usage:"number of the newest events of a kind kept for each author, as kind:count, such as 7:1000 (comma separated)"`
+	RetentionQuotaEvents  int           `env:"ORLY_RETENTION_QUOTA_EVENTS" default:"0" usage:"maximum number of events stored for each pubkey, writes over it are rejected and the oldest events over it are deleted (0 for no limit)"`
+	RetentionQuotaKB      int           `env:"ORLY_RETENTION_QUOTA_KB" default:"0" usage:"maximum size in kilobytes of the events stored for each pubkey, writes over it are rejected and the oldest events over it are deleted (0 for no limit)"`
+	RetentionDiskBudgetMB int           `env:"ORLY_RETENTION_DISK_BUDGET_MB" default:"0" usage:"size in megabytes of the event store on disk above which the oldest events are deleted (0 for no limit)"`
+
+	ClusterName          string        `env:"ORLY_CLUSTER_NAME" usage:"name of the relay cluster this relay is a node of, which enables the cluster mode (requires ORLY_SECRET_KEY and ORLY_CLUSTER_ADDRESS)"`
+	ClusterAddress       string        `env:"ORLY_CLUSTER_ADDRESS" usage:"base URL that the other cluster nodes reach this relay at, such as https://relay1.example.com"`
+	ClusterHeartbeat     time.Duration `env:"ORLY_CLUSTER_HEARTBEAT" default:"30s" usage:"interval between the announcements of this relay to the other cluster nodes"`
+	ClusterReconcile     time.Duration `env:"ORLY_CLUSTER_RECONCILE" default:"10m" usage:"interval between the reconciliations of the recent events of the event store with each of the other cluster nodes"`
+	ClusterFullReconcile time.Duration `env:"ORLY_CLUSTER_FULL_RECONCILE" default:"24h" usage:"interval between the reconciliations of all the events of the event store with each of the other cluster nodes"`
+	ClusterForget        time.Duration `env:"ORLY_CLUSTER_FORGET" default:"24h" usage:"how long a cluster node can go without being heard from before it is removed from the cluster"`
+
+	MaxMessageLength int `env:"ORLY_MAX_MESSAGE_LENGTH" default:"1048576" usage:"maximum size in bytes of a websocket message from a client"`
+	MaxSubscriptions int `env:"ORLY_MAX_SUBSCRIPTIONS" default:"20" usage:"maximum number of open subscriptions on a websocket connection"`
+	MaxFilters       int `env:"ORLY_MAX_FILTERS" default:"10" usage:"maximum number of filters in a subscription"`
+	MaxLimit         int `env:"ORLY_MAX_LIMIT" default:"512" usage:"maximum number of events returned for each filter, larger limits are reduced to this"`
+	MaxSubidLength   int `env:"ORLY_MAX_SUBID_LENGTH" default:"64" usage:"maximum length of a subscription id"`
+
+	PolicyKindsAllow        []string      `env:"ORLY_POLICY_KINDS_ALLOW" usage:"only accept events of these kinds (comma separated)"`
+	PolicyKindsDeny         []string      `env:"ORLY_POLICY_KINDS_DENY" usage:"reject events of these kinds (comma separated)"`
+	PolicyMaxContentLength  int           `env:"ORLY_POLICY_MAX_CONTENT_LENGTH" default:"0" usage:"maximum length of event content in characters (0 for no limit)"`
+	PolicyMaxTags           int           `env:"ORLY_POLICY_MAX_TAGS" default:"0" usage:"maximum number of tags in an event (0 for no limit)"`
+	PolicyMaxTagLength      int           `env:"ORLY_POLICY_MAX_TAG_LENGTH" default:"0" usage:"maximum length in bytes of a single tag (0 for no limit)"`
+	PolicyCreatedAtPast     time.Duration `env:"ORLY_POLICY_CREATED_AT_PAST" default:"0s" usage:"reject events with created_at further than this in the past (0 for no limit)"`
+	PolicyCreatedAtFuture   time.Duration `env:"ORLY_POLICY_CREATED_AT_FUTURE" default:"0s" usage:"reject events with created_at further than this in the future (0 for no limit)"`
+	PolicyMinPow            int           `env:"ORLY_POLICY_MIN_POW" default:"0" usage:"minimum NIP-13 proof of work difficulty of events (0 for none)"`
+	PolicyMinPowKinds       []string      `env:"ORLY_POLICY_MIN_POW_KINDS" usage:"minimum proof of work difficulty for specific kinds, as kind:difficulty (comma separated)"`
+	PolicyPowWhitelist      []string      `env:"ORLY_POLICY_POW_WHITELIST" usage:"pubkeys, in hex or npub format, that only need the whitelisted proof of work difficulty (comma separated)"`
+	PolicyMinPowWhitelisted int           `env:"ORLY_POLICY_MIN_POW_WHITELISTED" default:"0" usage:"minimum proof of work difficulty for events from whitelisted pubkeys"`
+	PolicyPublishLimit      int           `env:"ORLY_POLICY_PUBLISH_LIMIT" default:"0" usage:"maximum number of events a pubkey may publish in each publish window (0 for no limit)"`
+	PolicyPublishWindow     time.Duration `env:"ORLY_POLICY_PUBLISH_WINDOW" default:"1h" usage:"length of the window that the publish limit of each pubkey applies to"`
+
+	RateLimitEvents      int           `env:"ORLY_RATE_LIMIT_EVENTS" default:"0" usage:"EVENT submissions per minute allowed from each IP address and each authed pubkey (0 for no limit)"`
+	RateLimitReqs        int           `env:"ORLY_RATE_LIMIT_REQS" default:"0" usage:"REQ subscriptions per minute allowed from each IP address and each authed pubkey (0 for no limit)"`
+	RateLimitBytes       int           `env:"ORLY_RATE_LIMIT_BYTES" default:"0" usage:"bytes per second that are read from each IP address and each authed pubkey (0 for no limit)"`
+	RateLimitBurst       time.Duration `env:"ORLY_RATE_LIMIT_BURST" default:"10s" usage:"the rate limits allow bursts of this much time's worth of requests"`
+	RateLimitMemberScale int           `env:"ORLY_RATE_LIMIT_MEMBER_SCALE" default:"10" usage:"multiplier of the rate limits for users followed by the owners or their follows (owners are not limited)"`
 }
 
 // New creates and initializes a new configuration object for the relay
@@ -152,6 +202,44 @@ func GetEnv() (requested bool) {
 	return
 }
 
+// StatsRequested checks if the first command line argument is "stats", which
+// prints a report of the event store and exits.
+func StatsRequested() (requested bool) {
+	if len(os.Args) > 1 {
+		switch strings.ToLower(os.Args[1]) {
+		case "stats":
+			requested = true
+		}
+	}
+	return
+}
+
+// BackupRequested checks if the first command line argument is "backup", which
+// writes a backup of the event store to the file in the next argument, or
+// stdout, and exits.
+func BackupRequested() (requested bool) {
+	if len(os.Args) > 1 {
+		switch strings.ToLower(os.Args[1]) {
+		case "backup":
+			requested = true
+		}
+	}
+	return
+}
+
+// RestoreRequested checks if the first command line argument is "restore",
+// which restores the backups in the files of the following arguments into the
+// event store and exits.
+func RestoreRequested() (requested bool) {
+	if len(os.Args) > 1 {
+		switch strings.ToLower(os.Args[1]) {
+		case "restore":
+			requested = true
+		}
+	}
+	return
+}
+
 // KV is a key/value pair.
 type KV struct{ Key, Value string }
 
@@ -297,6 +385,8 @@ func PrintHelp(cfg *C, printer io.Writer) {
 			" this file will be created on first startup.\nenvironment overrides it and "+
 			"you can also edit the file to set configuration options\n\n"+
 			"use the parameter 'env' to print out the current configuration to the terminal\n\n"+
+			"use the parameter 'stats' to print a report of the event store, while the relay is stopped\n\n"+
+			"use the parameters 'backup [file] [since]' and 'restore file...' to back up and restore the event store, while the relay is stopped\n\n"+
 			"set the environment using\n\n\t%s env > %s/.env\n",
 		cfg.Config,
 		os.Args[0],
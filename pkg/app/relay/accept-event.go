@@ -6,10 +6,12 @@ import (
 
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/normalize"
 )
 
 // AcceptEvent determines whether an incoming event should be accepted for
-// processing based on authentication requirements.
+// processing, by checking the owner lists and then the write policy chain of
+// the Server.
 //
 // # Parameters
 //
@@ -27,27 +29,59 @@ import (
 //
 //   - accept: boolean indicating whether the event should be accepted
 //
-//   - notice: string providing a message or error notice
+//   - notice: if the event is rejected, a message with a NIP-20 machine
+//     readable prefix giving the reason
 //
 //   - afterSave: function to execute after saving the event (if applicable)
 //
 // # Expected Behaviour:
 //
-// - If authentication is required and no public key is provided, reject the
-// event.
+// - The owner and follow lists are checked first, see acceptLists.
 //
-// - Otherwise, accept the event for processing.
+// - Then the policies enabled in the configuration, and any added with
+// options.WithPolicies, are checked in turn, and the first to reject the event
+// gives the notice.
 func (s *Server) AcceptEvent(
 	c context.T, ev *event.E, hr *http.Request, authedPubkey []byte,
 	remote string,
 ) (accept bool, notice string, afterSave func()) {
+	var reason []byte
+	if accept, reason = s.acceptLists(
+		c, ev, authedPubkey, remote, hr,
+	); !accept {
+		notice = string(reason)
+		return
+	}
+	if accept, reason = s.policy.Accept(
+		c, ev, authedPubkey, remote, hr,
+	); !accept {
+		notice = string(reason)
+	}
+	return
+}
+
+// acceptLists is the write policy for the owner follow lists.
+//
+// # Expected Behaviour:
+//
+// - If authentication is not required, accept the event.
+//
+// - If authentication is required and no public key is provided, reject the
+// event.
+//
+// - Accept the event if the authed user is followed by an owner or by one of
+// their follows, and is not muted by an owner.
+func (s *Server) acceptLists(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte) {
 	if !s.AuthRequired() {
 		accept = true
 		return
 	}
 	// if auth is required and the user is not authed, reject
-	if s.AuthRequired() && len(authedPubkey) == 0 {
-		notice = "client isn't authed"
+	if len(authedPubkey) == 0 {
+		reason = normalize.AuthRequired.F("client isn't authed")
 		return
 	}
 	// check if the authed user is on the lists
@@ -59,11 +93,15 @@ func (s *Server) AcceptEvent(
 		}
 	}
 	if !accept {
+		reason = normalize.Restricted.F("user is not a member of this relay")
 		return
 	}
 	for _, u := range s.OwnersMuted() {
 		if bytes.Equal(u, authedPubkey) {
-			notice = "event author is banned from this relay"
+			accept = false
+			reason = normalize.Blocked.F(
+				"event author is banned from this relay",
+			)
 			return
 		}
 	}
@@ -6,8 +6,11 @@ import (
 	"testing"
 
 	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/app/relay/policy"
 	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
 	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/normalize"
 )
 
 // mockServerForEvent is a simple mock implementation of the Server struct for testing AcceptEvent
@@ -192,19 +195,21 @@ func TestAcceptEventWithRealServer(t *testing.T) {
 
 	// Create a Server instance with configuration
 	s := &Server{
-		C: &config.C{
-			AuthRequired: true,
-		},
 		Lists: new(Lists),
 	}
+	s.conf.Store(
+		&config.C{
+			AuthRequired: true,
+		},
+	)
 
 	// Test with no authenticated pubkey
 	accept, notice, afterSave := s.AcceptEvent(ctx, testEvent, req, nil, "127.0.0.1")
 	if accept {
 		t.Error("AcceptEvent() accept = true, want false")
 	}
-	if notice != "" {
-		t.Errorf("AcceptEvent() notice = %v, want empty string", notice)
+	if !normalize.AuthRequired.IsPrefix([]byte(notice)) {
+		t.Errorf("AcceptEvent() notice = %v, want auth-required", notice)
 	}
 	if afterSave != nil {
 		t.Error("AcceptEvent() afterSave is not nil, but should be nil")
@@ -235,3 +240,35 @@ func TestAcceptEventWithRealServer(t *testing.T) {
 		t.Error("AcceptEvent() accept = false, want true")
 	}
 }
+
+// TestAcceptEventPolicy tests that the write policy chain is applied after the
+// owner lists.
+func TestAcceptEventPolicy(t *testing.T) {
+	ctx := context.Bg()
+	ev := &event.E{Kind: kind.EncryptedDirectMessage}
+	s := &Server{
+		Lists:  new(Lists),
+		policy: policy.Chain{&policy.Kinds{Deny: []uint16{4}}},
+	}
+	s.conf.Store(&config.C{AuthRequired: true})
+	s.SetOwnersFollowed([][]byte{[]byte("test-pubkey")})
+	accept, notice, _ := s.AcceptEvent(
+		ctx, ev, nil, []byte("test-pubkey"), "127.0.0.1",
+	)
+	if accept || !normalize.Blocked.IsPrefix([]byte(notice)) {
+		t.Errorf("AcceptEvent() accept = %v, notice = %s", accept, notice)
+	}
+	ev.Kind = kind.TextNote
+	if accept, notice, _ = s.AcceptEvent(
+		ctx, ev, nil, []byte("test-pubkey"), "127.0.0.1",
+	); !accept {
+		t.Errorf("AcceptEvent() accept = false, notice = %s", notice)
+	}
+	// muted users are rejected before the policies are checked
+	s.SetOwnersMuted([][]byte{[]byte("test-pubkey")})
+	if accept, notice, _ = s.AcceptEvent(
+		ctx, ev, nil, []byte("test-pubkey"), "127.0.0.1",
+	); accept || !normalize.Blocked.IsPrefix([]byte(notice)) {
+		t.Errorf("AcceptEvent() accept = %v, notice = %s", accept, notice)
+	}
+}
@@ -164,12 +164,14 @@ func TestAcceptReqWithRealServer(t *testing.T) {
 
 	// Create a Server instance with configuration
 	s := &Server{
-		C: &config.C{
+		Lists: new(Lists),
+	}
+	s.conf.Store(
+		&config.C{
 			AuthRequired:   true,
 			PublicReadable: false,
 		},
-		Lists: new(Lists),
-	}
+	)
 
 	// Test with no authenticated pubkey
 	allowed, accept, modified := s.AcceptReq(ctx, req, testFilters, nil, "127.0.0.1")
@@ -196,7 +198,9 @@ func TestAcceptReqWithRealServer(t *testing.T) {
 	}
 
 	// Test with public readable
-	s.C.PublicReadable = true
+	c := *s.Config()
+	c.PublicReadable = true
+	s.conf.Store(&c)
 	allowed, accept, modified = s.AcceptReq(ctx, req, testFilters, nil, "127.0.0.1")
 	if !accept {
 		t.Error("AcceptReq() accept = false, want true")
@@ -1,18 +1,9 @@
 package relay
 
 import (
-	"bytes"
 	"errors"
-	"fmt"
-	"io"
 	"net/http"
-	"net/url"
-	"orly.dev/pkg/crypto/ec/secp256k1"
-	"orly.dev/pkg/encoders/hex"
-	"orly.dev/pkg/protocol/httpauth"
 	"orly.dev/pkg/utils/chk"
-	"orly.dev/pkg/utils/log"
-	realy_lol "orly.dev/pkg/version"
 	"regexp"
 	"strings"
 
@@ -27,21 +18,6 @@ var (
 	NIP20prefixmatcher = regexp.MustCompile(`^\w+: `)
 )
 
-var userAgent = fmt.Sprintf("orly/%s", realy_lol.V)
-
-type WriteCloser struct {
-	*bytes.Buffer
-}
-
-func (w *WriteCloser) Close() error {
-	w.Buffer.Reset()
-	return nil
-}
-
-func NewWriteCloser(w []byte) *WriteCloser {
-	return &WriteCloser{bytes.NewBuffer(w)}
-}
-
 // AddEvent processes an incoming event, saves it if valid, and delivers it to
 // subscribers.
 //
@@ -76,6 +52,9 @@ func NewWriteCloser(w []byte) *WriteCloser {
 //
 // - Delivers the event to subscribers via the listeners' Deliver method.
 //
+// - Queues the event to be replicated to the peer relays it did not come
+// from, which are the ones with the pubkeys in pubkeys.
+//
 // - Returns a boolean indicating whether the event was accepted and any
 // relevant message.
 func (s *Server) AddEvent(
@@ -89,7 +68,8 @@ func (s *Server) AddEvent(
 	if ev.Kind.IsEphemeral() {
 	} else {
 		if saveErr := s.Publish(c, ev); saveErr != nil {
-			if errors.Is(saveErr, store.ErrDupEvent) {
+			if errors.Is(saveErr, store.ErrDupEvent) ||
+				errors.Is(saveErr, store.ErrSuperseded) {
 				return false, []byte(saveErr.Error())
 			}
 			errmsg := saveErr.Error()
@@ -111,76 +91,10 @@ func (s *Server) AddEvent(
 	}
 	// notify subscribers
 	s.listeners.Deliver(ev)
-	// push the new event to replicas if replicas are configured, and the relay
-	// has an identity key.
-	var err error
-	if len(s.Peers.Addresses) > 0 &&
-		len(s.Peers.I.Sec()) == secp256k1.SecKeyBytesLen {
-		evb := ev.Marshal(nil)
-		var payload io.ReadCloser
-		payload = NewWriteCloser(evb)
-	replica:
-		for i, a := range s.Peers.Addresses {
-			// the peer address index is the same as the list of pubkeys
-			// (they're unpacked from a string containing both, appended at the
-			// same time), so if the pubkeys from the http event endpoint sent
-			// us here matches the index of this address, we can skip it.
-			for _, pk := range pubkeys {
-				if bytes.Equal(s.Peers.Pubkeys[i], pk) {
-					log.I.F(
-						"not sending back to replica that just sent us this event %0x %s",
-						ev.ID, a,
-					)
-					continue replica
-				}
-			}
-			var ur *url.URL
-			if ur, err = url.Parse(a + "/api/event"); chk.E(err) {
-				continue
-			}
-			var r *http.Request
-			r = &http.Request{
-				Method:        "POST",
-				URL:           ur,
-				Proto:         "HTTP/1.1",
-				ProtoMajor:    1,
-				ProtoMinor:    1,
-				Header:        make(http.Header),
-				Body:          payload,
-				ContentLength: int64(len(evb)),
-				Host:          ur.Host,
-			}
-			r.Header.Add("User-Agent", userAgent)
-			if err = httpauth.AddNIP98Header(
-				r, ur, "POST", "", s.Peers.I, 0,
-			); chk.E(err) {
-				continue
-			}
-			// add this replica's pubkey to the list to prevent re-sending to
-			// other replicas more than twice
-			pubkeys = append(pubkeys, s.Peers.Pub())
-			var pubkeysHeader []byte
-			for j, pk := range pubkeys {
-				pubkeysHeader = hex.EncAppend(pubkeysHeader, pk)
-				if j < len(pubkeys)-1 {
-					pubkeysHeader = append(pubkeysHeader, ':')
-				}
-			}
-			r.Header.Add("X-Pubkeys", string(pubkeysHeader))
-			r.GetBody = func() (rc io.ReadCloser, err error) {
-				rc = payload
-				return
-			}
-			client := &http.Client{}
-			if _, err = client.Do(r); chk.E(err) {
-				continue
-			}
-			log.I.F(
-				"event pushed to replica %s\n%s",
-				ur.String(), evb,
-			)
-			break
-		}
+	// queue the new event to be pushed to the peer relays, except those it
+	// came through.
+	if s.replicator != nil {
+		chk.E(s.replicator.Enqueue(ev, pubkeys))
 	}
 	accepted = true
 	return
@@ -29,7 +29,7 @@ func (s *Server) AdminAuth(
 		)
 		return
 	}
-	for _, pk := range s.ownersPubkeys {
+	for _, pk := range s.Lists.OwnersPubkeys() {
 		if bytes.Equal(pk, pubkey) {
 			authed = true
 			return
@@ -0,0 +1,87 @@
+package relay
+
+import (
+	"orly.dev/pkg/app/relay/cluster"
+	"orly.dev/pkg/crypto/ec/secp256k1"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+	"path/filepath"
+)
+
+// startCluster makes the relay a node of the cluster with the configured name,
+// with the configured peers as the first members, and starts announcing itself
+// to the members and reconciling the store with them.
+func (s *Server) startCluster(storage store.I) (err error) {
+	c, p := s.Config(), s.Peers()
+	if len(p.I.Sec()) != secp256k1.SecKeyBytesLen {
+		return errorf.E("cluster mode requires the relay to have a secret key")
+	}
+	if c.ClusterAddress == "" {
+		return errorf.E("cluster mode requires the address of the relay")
+	}
+	if s.cluster, err = cluster.New(
+		s.Ctx, cluster.Config{
+			Name:          c.ClusterName,
+			Address:       c.ClusterAddress,
+			Heartbeat:     c.ClusterHeartbeat,
+			Reconcile:     c.ClusterReconcile,
+			FullReconcile: c.ClusterFullReconcile,
+			Forget:        c.ClusterForget,
+			File:          filepath.Join(c.State, "cluster.json"),
+		}, p.I, storage, s.setReplicationPeers,
+	); err != nil {
+		s.cluster = nil
+		return
+	}
+	s.cluster.SetSeeds(p.list())
+	s.cluster.Start()
+	log.I.F(
+		"joined cluster %s as %s", c.ClusterName, c.ClusterAddress,
+	)
+	return
+}
+
+// ClusterAnnounce processes the announcement of a node of the cluster, and
+// returns the announcement of this relay in reply.
+func (s *Server) ClusterAnnounce(ev *event.E) (reply *event.E, err error) {
+	if s.cluster == nil {
+		err = cluster.ErrDisabled
+		return
+	}
+	if err = s.cluster.Receive(ev); err != nil {
+		return
+	}
+	return s.cluster.Announcement()
+}
+
+// Cluster returns the state of the members of the cluster.
+func (s *Server) Cluster() (members []cluster.MemberState, err error) {
+	if s.cluster == nil {
+		err = cluster.ErrDisabled
+		return
+	}
+	members = s.cluster.State()
+	return
+}
+
+// ClusterAdd adds a node to the cluster, the other members learn of it from
+// the announcements of this relay.
+func (s *Server) ClusterAdd(pubkey []byte, address string) (err error) {
+	if s.cluster == nil {
+		return cluster.ErrDisabled
+	}
+	s.cluster.Add(pubkey, address)
+	return
+}
+
+// ClusterRemove removes a node from the cluster, the other members learn of
+// it from the announcements of this relay.
+func (s *Server) ClusterRemove(pubkey []byte) (err error) {
+	if s.cluster == nil {
+		return cluster.ErrDisabled
+	}
+	s.cluster.Remove(pubkey)
+	return
+}
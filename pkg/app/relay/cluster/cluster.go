@@ -0,0 +1,399 @@
+// Package cluster runs a relay as a node of a multi-master cluster of relays,
+// whose members find each other at runtime, so nodes can join and leave
+// without changing the configuration of every node.
+//
+// Each node periodically sends an announcement to the other members, which is
+// an event signed by the identity key of the relay, with the address it can be
+// reached at and its view of the membership. The membership is a
+// last-writer-wins element set: each member has the time it was added and the
+// time it was removed, and it is in the cluster while it was added later than
+// it was removed. Merging two views takes the latest of each time, so the
+// nodes converge on the same membership whatever order the announcements
+// arrive in.
+//
+// Only the announcements of members are accepted, so a node joins the cluster
+// when an admin adds it on any one of the members, and the others learn of it
+// from the announcements of that member. A node that is not heard from for long
+// enough is removed, as is one that an admin removes. A node that was removed
+// for not being heard from is still sent announcements, and is added again
+// when it is heard from, with a newer time it was added.
+//
+// The events accepted by a node are pushed to the other members as they arrive
+// by replication, and the stores of the members are periodically reconciled
+// with negentropy, fetching the events that are missing. As the store keeps
+// only the newest version of replaceable events, and the tombstones of deleted
+// events, the stores of the members converge, whatever order the events are
+// received in.
+package cluster
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"orly.dev/pkg/app/relay/replicate"
+	"orly.dev/pkg/interfaces/signer"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config is the configuration of a node of a cluster.
+type Config struct {
+	// Name is the name of the cluster, announcements of other clusters are
+	// ignored.
+	Name string
+	// Address is the base URL that the other members reach this node at.
+	Address string
+	// Heartbeat is the interval between the announcements to the members.
+	Heartbeat time.Duration
+	// Reconcile is the interval between the reconciliations of the store with
+	// the members, which only cover the events since the last one.
+	Reconcile time.Duration
+	// FullReconcile is the interval between the reconciliations of all the
+	// events of the store with each member.
+	FullReconcile time.Duration
+	// Forget is how long a member can go without being heard from before it
+	// is removed.
+	Forget time.Duration
+	// File is the path of the file that the membership is saved in.
+	File string
+}
+
+// Member is an entry of the membership of the cluster.
+type Member struct {
+	// Pubkey is the identity of the member in hex.
+	Pubkey string `json:"pubkey"`
+	// Address is the base URL of the member when it was added.
+	Address string `json:"address"`
+	// Added is the unix time the member was added.
+	Added int64 `json:"added"`
+	// Removed is the unix time the member was removed, if it was.
+	Removed int64 `json:"removed,omitempty"`
+	// Forgotten is true if the member was removed for not being heard from,
+	// rather than by an admin, so it is added again when it is heard from.
+	Forgotten bool `json:"forgotten,omitempty"`
+}
+
+// In returns true if the member is in the cluster.
+func (m *Member) In() bool { return m.Added > m.Removed }
+
+// lost returns true if the member was removed for not being heard from.
+func (m *Member) lost() bool { return !m.In() && m.Forgotten }
+
+// readd adds the member again after it was removed, with a newer time it was
+// added than the time it was removed.
+func (m *Member) readd() {
+	m.Added = max(time.Now().Unix(), m.Removed+1)
+}
+
+// merge updates the entry with the times of another entry of the same member,
+// and returns true if it changed.
+func (m *Member) merge(o *Member) (changed bool) {
+	if o.Added > m.Added {
+		m.Added, m.Address, changed = o.Added, o.Address, true
+	}
+	if o.Removed > m.Removed {
+		m.Removed, m.Forgotten, changed = o.Removed, o.Forgotten, true
+	}
+	return
+}
+
+// MemberState is the state of a member of the cluster.
+type MemberState struct {
+	Pubkey        string `json:"pubkey" doc:"pubkey of the member in hex"`
+	Address       string `json:"address" doc:"base URL of the member"`
+	Self          bool   `json:"self,omitempty" doc:"true for the node that responded"`
+	Up            bool   `json:"up" doc:"true if the member has been heard from recently"`
+	Added         int64  `json:"added" doc:"unix time the member was added"`
+	LastSeen      int64  `json:"last_seen,omitempty" doc:"unix time of the last announcement of the member"`
+	LastReconcile int64  `json:"last_reconcile,omitempty" doc:"unix time of the last reconciliation with the member"`
+	LastFull      int64  `json:"last_full_reconcile,omitempty" doc:"unix time of the start of the last successful reconciliation of all the events with the member"`
+	FullUntil     int64  `json:"full_reconcile_until,omitempty" doc:"created_at up to which the events remain to be synced by the reconciliation of all the events in progress"`
+	Pulled        int    `json:"pulled,omitempty" doc:"number of events fetched in the last reconciliation with the member"`
+	LastError     string `json:"last_error,omitempty" doc:"error of the last failed announcement to or reconciliation with the member"`
+}
+
+// node is what is known about a member from talking to it.
+type node struct {
+	// address is the address in the last announcement of the member.
+	address       string
+	lastSeen      int64
+	lastReconcile int64
+	// synced and full are the start times of the last successful
+	// reconciliation, and of the last one of all the events.
+	synced int64
+	full   int64
+	// fullStart is the start time of the reconciliation of all the events in
+	// progress, and fullUntil the created_at up to which its events remain to
+	// be synced, or zero if none is in progress.
+	fullStart int64
+	fullUntil int64
+	pulled    int
+	lastError string
+}
+
+var (
+	// ErrNotMember is the error of an announcement from a node that is not a
+	// member of the cluster.
+	ErrNotMember = errors.New("not a member of the cluster")
+	// ErrInvalid is the error of an announcement that is not valid.
+	ErrInvalid = errors.New("invalid cluster announcement")
+	// ErrDisabled is the error of a relay that is not a node of a cluster.
+	ErrDisabled = errors.New("relay is not a node of a cluster")
+)
+
+// S is a node of a cluster.
+type S struct {
+	Config
+	ctx     context.T
+	sign    signer.I
+	self    string
+	store   ws.SyncStore
+	changed func()
+	started int64
+	mx      sync.Mutex
+	members map[string]*Member
+	nodes   map[string]*node
+}
+
+// New creates a node of a cluster with the identity of a signer, which
+// reconciles a store with the other members. The membership saved in the state
+// file is loaded, and changed is called when it changes after that. The node
+// is stopped when the context is cancelled.
+func New(
+	c context.T, conf Config, sign signer.I, sto ws.SyncStore, changed func(),
+) (s *S, err error) {
+	s = &S{
+		Config:  conf,
+		ctx:     c,
+		sign:    sign,
+		self:    hex.EncodeToString(sign.Pub()),
+		store:   sto,
+		changed: changed,
+		started: time.Now().Unix(),
+		members: make(map[string]*Member),
+		nodes:   make(map[string]*node),
+	}
+	if err = s.load(); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Start starts sending announcements and reconciling the store.
+func (s *S) Start() {
+	go s.gossip()
+	go s.reconcile()
+}
+
+// SetSeeds adds the configured peers to the membership, as members that were
+// added at the start of time, so they are removed if any member removed them.
+func (s *S) SetSeeds(peers []replicate.Peer) {
+	s.update(
+		func() (changed bool) {
+			for _, p := range peers {
+				if s.mergeMember(
+					&Member{
+						Pubkey:  hex.EncodeToString(p.Pubkey),
+						Address: p.Address,
+						Added:   1,
+					},
+				) {
+					changed = true
+				}
+			}
+			return
+		},
+	)
+}
+
+// Add adds a member to the cluster.
+func (s *S) Add(pubkey []byte, address string) {
+	pk := hex.EncodeToString(pubkey)
+	s.update(
+		func() bool {
+			added := time.Now().Unix()
+			if m, ok := s.members[pk]; ok {
+				added = max(added, m.Removed+1)
+			}
+			return s.mergeMember(
+				&Member{Pubkey: pk, Address: address, Added: added},
+			)
+		},
+	)
+}
+
+// Remove removes a member from the cluster. A node that removes itself leaves
+// the cluster.
+func (s *S) Remove(pubkey []byte) {
+	pk := hex.EncodeToString(pubkey)
+	s.update(
+		func() bool {
+			m, ok := s.members[pk]
+			if !ok || !m.In() {
+				return false
+			}
+			m.Removed, m.Forgotten = max(time.Now().Unix(), m.Added), false
+			return true
+		},
+	)
+}
+
+// Members returns the members of the cluster other than this node, with the
+// addresses they can be reached at.
+func (s *S) Members() (peers []replicate.Peer) {
+	return s.peers((*Member).In)
+}
+
+// peers returns the members other than this node for which match returns
+// true, with the addresses they can be reached at.
+func (s *S) peers(match func(m *Member) bool) (peers []replicate.Peer) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for _, m := range s.sorted() {
+		if m.Pubkey == s.self || !match(m) {
+			continue
+		}
+		pk, err := hex.DecodeString(m.Pubkey)
+		if chk.E(err) {
+			continue
+		}
+		peers = append(
+			peers, replicate.Peer{Address: s.address(m), Pubkey: pk},
+		)
+	}
+	return
+}
+
+// State returns the state of the members of the cluster.
+func (s *S) State() (states []MemberState) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for _, m := range s.sorted() {
+		if !m.In() {
+			continue
+		}
+		st := MemberState{
+			Pubkey: m.Pubkey, Address: s.address(m), Added: m.Added,
+			Self: m.Pubkey == s.self,
+		}
+		if n, ok := s.nodes[m.Pubkey]; ok {
+			st.LastSeen, st.LastReconcile = n.lastSeen, n.lastReconcile
+			st.LastFull, st.FullUntil = n.full, n.fullUntil
+			st.Pulled, st.LastError = n.pulled, n.lastError
+		}
+		st.Up = st.Self || s.up(m.Pubkey)
+		states = append(states, st)
+	}
+	return
+}
+
+// update runs a change of the membership with the lock held, and if it changed
+// anything, saves it and notifies the relay.
+func (s *S) update(change func() (changed bool)) {
+	s.mx.Lock()
+	changed := change()
+	if changed {
+		chk.E(s.save())
+	}
+	s.mx.Unlock()
+	if changed && s.changed != nil {
+		s.changed()
+	}
+}
+
+// mergeMember merges an entry into the membership, and returns true if it
+// changed.
+func (s *S) mergeMember(o *Member) (changed bool) {
+	if m, ok := s.members[o.Pubkey]; ok {
+		return m.merge(o)
+	}
+	m := *o
+	s.members[o.Pubkey] = &m
+	return true
+}
+
+// sorted returns the members in the order of their pubkeys.
+func (s *S) sorted() (members []*Member) {
+	for _, m := range s.members {
+		members = append(members, m)
+	}
+	sort.Slice(
+		members, func(i, j int) bool {
+			return members[i].Pubkey < members[j].Pubkey
+		},
+	)
+	return
+}
+
+// node returns what is known of a member from talking to it.
+func (s *S) node(pk string) (n *node) {
+	var ok bool
+	if n, ok = s.nodes[pk]; !ok {
+		n = &node{}
+		s.nodes[pk] = n
+	}
+	return
+}
+
+// address returns the address that a member announced, or the one it was
+// added with if it hasn't been heard from.
+func (s *S) address(m *Member) string {
+	if n, ok := s.nodes[m.Pubkey]; ok && n.address != "" {
+		return n.address
+	}
+	return m.Address
+}
+
+// up returns true if a member has been heard from within three heartbeats.
+func (s *S) up(pk string) bool {
+	n, ok := s.nodes[pk]
+	timeout := int64(3 * s.Heartbeat / time.Second)
+	return ok && time.Now().Unix()-n.lastSeen <= timeout
+}
+
+// load reads the membership from the state file, if there is one.
+func (s *S) load() (err error) {
+	var b []byte
+	if b, err = os.ReadFile(s.File); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	var members []*Member
+	if err = json.Unmarshal(b, &members); chk.E(err) {
+		return
+	}
+	for _, m := range members {
+		s.members[m.Pubkey] = m
+	}
+	log.I.F("loaded %d cluster members from %s", len(members), s.File)
+	return
+}
+
+// save writes the membership to the state file, replacing it only once it is
+// completely written.
+func (s *S) save() (err error) {
+	var b []byte
+	if b, err = json.MarshalIndent(s.sorted(), "", "  "); chk.E(err) {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(s.File), 0700); chk.E(err) {
+		return
+	}
+	tmp := s.File + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); chk.E(err) {
+		return
+	}
+	if err = os.Rename(tmp, s.File); chk.E(err) {
+		return
+	}
+	return
+}
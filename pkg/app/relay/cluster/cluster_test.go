@@ -0,0 +1,241 @@
+package cluster
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"orly.dev/pkg/app/relay/replicate"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testNode struct {
+	*S
+	sign *p256k.Signer
+	srv  *httptest.Server
+}
+
+// newTestNode creates a node with an HTTP server that handles announcements
+// like the relay API.
+func newTestNode(t *testing.T, c context.T, dir, name string) (n *testNode) {
+	n = &testNode{sign: new(p256k.Signer)}
+	if err := n.sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	n.srv = httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				j := new(event.J)
+				if err := json.NewDecoder(r.Body).Decode(j); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				ev, err := j.ToEvent()
+				if err == nil {
+					err = n.Receive(ev)
+				}
+				if err != nil {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				if ev, err = n.Announcement(); chk.E(err) {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(ev.ToEventJ())
+			},
+		),
+	)
+	t.Cleanup(n.srv.Close)
+	var err error
+	if n.S, err = New(
+		c, Config{
+			Name: "test", Address: n.srv.URL, Heartbeat: time.Second,
+			Reconcile: time.Hour, Forget: time.Hour,
+			File: filepath.Join(dir, name+".json"),
+		}, n.sign, nil, nil,
+	); err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	return
+}
+
+func (n *testNode) peer() replicate.Peer {
+	return replicate.Peer{Address: n.srv.URL, Pubkey: n.sign.Pub()}
+}
+
+func TestCluster(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	dir := t.TempDir()
+	a := newTestNode(t, ctx, dir, "a")
+	b := newTestNode(t, ctx, dir, "b")
+	c := newTestNode(t, ctx, dir, "c")
+	// a and b are configured as peers of each other, and c only knows a.
+	a.SetSeeds([]replicate.Peer{a.peer(), b.peer()})
+	b.SetSeeds([]replicate.Peer{a.peer(), b.peer()})
+	c.SetSeeds([]replicate.Peer{a.peer()})
+	// c is not a member until it is added
+	ev, err := c.Announcement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Receive(ev); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("Expected a non-member to be refused, got %v", err)
+	}
+	a.Add(c.sign.Pub(), c.srv.URL)
+	// c learns of b from the response of a, and b learns of c from a.
+	c.announce()
+	a.announce()
+	b.announce()
+	c.announce()
+	for _, n := range []*testNode{a, b, c} {
+		states := n.State()
+		if len(states) != 3 {
+			t.Fatalf("Expected 3 members, got %+v", states)
+		}
+		for _, st := range states {
+			if !st.Up {
+				t.Fatalf("Expected all members to be up, got %+v", states)
+			}
+		}
+	}
+	// a removal on one node reaches the others, and the removed node is
+	// refused.
+	b.Remove(c.sign.Pub())
+	b.announce()
+	if len(a.Members()) != 1 || len(b.Members()) != 1 {
+		t.Fatalf(
+			"Expected c to be removed, got %+v %+v", a.State(), b.State(),
+		)
+	}
+	if ev, err = c.Announcement(); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Receive(ev); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("Expected a removed member to be refused, got %v", err)
+	}
+	// a member that is removed for not being heard from is still sent
+	// announcements, and is added again when it responds.
+	added := a.members[b.self].Added
+	a.Forget = -time.Second
+	a.forget()
+	a.Forget = time.Hour
+	if len(a.Members()) != 0 {
+		t.Fatalf("Expected b to be forgotten, got %+v", a.State())
+	}
+	a.announce()
+	if len(a.Members()) != 1 || a.members[b.self].Added <= added {
+		t.Fatalf("Expected b to be added again, got %+v", a.State())
+	}
+	// the announcements of other clusters are ignored
+	a.Name = "other"
+	if ev, err = a.Announcement(); err != nil {
+		t.Fatal(err)
+	}
+	a.Name = "test"
+	if err = b.Receive(ev); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Expected an invalid announcement, got %v", err)
+	}
+	// the membership is loaded when the node restarts
+	var s *S
+	if s, err = New(ctx, a.Config, a.sign, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Members()) != 1 ||
+		hex.EncodeToString(s.Members()[0].Pubkey) != b.self {
+		t.Fatalf("Expected b to be loaded, got %+v", s.Members())
+	}
+}
+
+func TestSyncChunks(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	chunk := ReconcileChunk
+	ReconcileChunk = 3
+	defer func() { ReconcileChunk = chunk }()
+	// the store has an event every 10 seconds from 1000 to 1090, and the
+	// member has one every second from 1000 to 1100.
+	sto, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sto.Close()
+	pk := sha256.Sum256([]byte("pubkey"))
+	for i := range 10 {
+		ev := event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(i)))
+		ev.ID, ev.Pubkey, ev.Sig = id[:], pk[:], make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(int64(1000 + i*10))
+		ev.Kind = kind.TextNote
+		ev.Tags = tags.New()
+		if _, _, err := sto.SaveEvent(ctx, ev, true, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &S{store: sto}
+	// the member refuses the syncs of more than 5 of its events.
+	var synced [][2]int64
+	sync := func(c context.T, f *filter.F) (pulled int, err error) {
+		var since int64
+		if f.Since != nil {
+			since = f.Since.I64()
+		}
+		until := f.Until.I64()
+		for ts := int64(1000); ts <= 1100; ts++ {
+			if ts >= since && ts <= until {
+				pulled++
+			}
+		}
+		if pulled > 5 {
+			return 0, errorf.E(
+				"%w: blocked: too many records", ws.ErrSyncTooLarge,
+			)
+		}
+		synced = append(synced, [2]int64{since, until})
+		return
+	}
+	var progress []int64
+	pulled, err := s.syncChunks(
+		ctx, 0, 2000, sync, func(until int64) {
+			progress = append(progress, until)
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled != 101 {
+		t.Fatalf("Expected 101 events, got %d", pulled)
+	}
+	// the ranges are synced newest first, without gaps or overlaps.
+	until := int64(2000)
+	for _, r := range synced {
+		if r[1] != until || r[0] > r[1] {
+			t.Fatalf("Unexpected ranges %v", synced)
+		}
+		until = r[0] - 1
+	}
+	if until != -1 {
+		t.Fatalf("Expected the ranges to reach 0, got %v", synced)
+	}
+	// the progress is recorded after each range of 3 events of the store.
+	if len(progress) != 4 || progress[0] != 1069 || progress[3] != -1 {
+		t.Fatalf("Unexpected progress %v", progress)
+	}
+}
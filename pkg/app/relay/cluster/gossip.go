@@ -0,0 +1,275 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/version"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxClockSkew is how far the time of an announcement may be from the time
+	// it is received.
+	MaxClockSkew = 5 * time.Minute
+	// Timeout is the time limit of an announcement to a member.
+	Timeout = 30 * time.Second
+)
+
+var userAgent = fmt.Sprintf("orly/%s", version.V)
+
+// Announcement returns the announcement of this node, which has the tags:
+//
+//	["cluster", <name>]
+//	["r", <address of this node>]
+//	["member", <pubkey>, <address>, <added>, <removed>, "forgotten"?] for
+//	each member
+//
+// The removed members are included so that the other nodes learn of their
+// removal, and those that were removed for not being heard from have the
+// "forgotten" marker.
+func (s *S) Announcement() (ev *event.E, err error) {
+	t := tags.New(tag.New("cluster", s.Name), tag.New("r", s.Address))
+	s.mx.Lock()
+	for _, m := range s.sorted() {
+		mt := tag.New(
+			"member", m.Pubkey, m.Address,
+			strconv.FormatInt(m.Added, 10),
+			strconv.FormatInt(m.Removed, 10),
+		)
+		if m.Forgotten {
+			mt.Append([]byte("forgotten"))
+		}
+		t.AppendTags(mt)
+	}
+	s.mx.Unlock()
+	ev = &event.E{
+		Kind:      kind.ClusterAnnouncement,
+		CreatedAt: timestamp.Now(),
+		Tags:      t,
+	}
+	if err = ev.Sign(s.sign); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Receive processes the announcement of another node, merging its view of the
+// membership. ErrNotMember is returned if the node is not a member of the
+// cluster, and ErrInvalid if the announcement is not valid.
+//
+// A node that was removed for not being heard from is added again, as is this
+// node if the other has removed it for that reason, with a newer time it was
+// added, so that it is added again on the other members too.
+func (s *S) Receive(ev *event.E) (err error) {
+	if !ev.Kind.Equal(kind.ClusterAnnouncement) {
+		return errorf.E("%w: kind %d", ErrInvalid, ev.Kind.K)
+	}
+	if !bytes.Equal(ev.GetIDBytes(), ev.ID) {
+		return errorf.E("%w: id is incorrect", ErrInvalid)
+	}
+	var ok bool
+	if ok, err = ev.Verify(); err != nil || !ok {
+		return errorf.E("%w: signature is invalid", ErrInvalid)
+	}
+	if name := ev.Tags.GetFirst(tag.New("cluster")); name == nil ||
+		string(name.Value()) != s.Name {
+		return errorf.E("%w: not for cluster %s", ErrInvalid, s.Name)
+	}
+	skew := time.Since(ev.CreatedAt.Time())
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return errorf.E("%w: created_at is %v off", ErrInvalid, skew)
+	}
+	pk := hex.EncodeToString(ev.Pubkey)
+	if pk == s.self {
+		return errorf.E("%w: announcement of this node", ErrInvalid)
+	}
+	var members []*Member
+	for _, t := range ev.Tags.GetAll(tag.New("member")).ToSliceOfTags() {
+		if t.Len() < 5 {
+			continue
+		}
+		m := &Member{Pubkey: t.S(1), Address: t.S(2)}
+		if _, err = hex.DecodeString(m.Pubkey); err != nil ||
+			len(m.Pubkey) != 64 {
+			return errorf.E("%w: member pubkey %s", ErrInvalid, m.Pubkey)
+		}
+		if m.Added, err = strconv.ParseInt(t.S(3), 10, 64); err != nil {
+			return errorf.E("%w: member added %s", ErrInvalid, t.S(3))
+		}
+		if m.Removed, err = strconv.ParseInt(t.S(4), 10, 64); err != nil {
+			return errorf.E("%w: member removed %s", ErrInvalid, t.S(4))
+		}
+		m.Forgotten = t.Len() > 5 && t.S(5) == "forgotten"
+		members = append(members, m)
+	}
+	err = nil
+	s.update(
+		func() (changed bool) {
+			m, ok := s.members[pk]
+			if !ok || (!m.In() && !m.lost()) {
+				err = ErrNotMember
+				return
+			}
+			if m.lost() {
+				log.I.F(
+					"cluster member %s %s is back, adding it again", m.Pubkey,
+					s.address(m),
+				)
+				m.readd()
+				changed = true
+			}
+			n := s.node(pk)
+			if r := ev.Tags.GetFirst(tag.New("r")); r != nil {
+				n.address = string(r.Value())
+			}
+			n.lastSeen = max(n.lastSeen, ev.CreatedAt.I64())
+			for _, m := range members {
+				if s.mergeMember(m) {
+					changed = true
+				}
+			}
+			if m, ok = s.members[s.self]; ok && m.lost() {
+				m.readd()
+				changed = true
+			}
+			return
+		},
+	)
+	return
+}
+
+// gossip sends the announcements of this node to the members every heartbeat,
+// until the context is cancelled.
+func (s *S) gossip() {
+	ticker := time.NewTicker(s.Heartbeat)
+	defer ticker.Stop()
+	for {
+		s.forget()
+		s.announce()
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// forget removes the members that have not been heard from for longer than
+// the Forget period since they were added or this node started.
+func (s *S) forget() {
+	s.update(
+		func() (changed bool) {
+			now := time.Now().Unix()
+			for _, m := range s.members {
+				if m.Pubkey == s.self || !m.In() {
+					continue
+				}
+				last := max(m.Added, s.started)
+				if n, ok := s.nodes[m.Pubkey]; ok {
+					last = max(last, n.lastSeen)
+				}
+				if now-last > int64(s.Forget/time.Second) {
+					log.W.F(
+						"removing cluster member %s %s, not heard from "+
+							"since %s", m.Pubkey, s.address(m),
+						time.Unix(last, 0),
+					)
+					m.Removed, m.Forgotten, changed = now, true, true
+				}
+			}
+			return
+		},
+	)
+}
+
+// announce sends the announcement of this node to the other members, and to
+// those that were removed for not being heard from, and processes the
+// announcements that they respond with. A node that has been removed from the
+// cluster stops announcing itself.
+func (s *S) announce() {
+	s.mx.Lock()
+	m, ok := s.members[s.self]
+	left := ok && !m.In()
+	s.mx.Unlock()
+	if left {
+		log.D.F("this node has left the cluster, not announcing")
+		return
+	}
+	ev, err := s.Announcement()
+	if chk.E(err) {
+		return
+	}
+	b := ev.Marshal(nil)
+	client := &http.Client{Timeout: Timeout}
+	var wg sync.WaitGroup
+	for _, p := range append(s.Members(), s.peers((*Member).lost)...) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply, err := s.post(client, p.Address, b)
+			if err == nil {
+				err = s.Receive(reply)
+			}
+			s.mx.Lock()
+			n := s.node(hex.EncodeToString(p.Pubkey))
+			if err != nil {
+				n.lastError = err.Error()
+			} else {
+				n.lastError = ""
+			}
+			s.mx.Unlock()
+			if err != nil {
+				log.D.F("announcement to %s failed: %v", p.Address, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// post sends an announcement to the address of a member, and returns the
+// announcement that it responds with.
+func (s *S) post(client *http.Client, address string, b []byte) (
+	reply *event.E, err error,
+) {
+	var r *http.Request
+	if r, err = http.NewRequestWithContext(
+		s.ctx, http.MethodPost, address+"/api/cluster/announce",
+		bytes.NewReader(b),
+	); err != nil {
+		return
+	}
+	r.Header.Add("User-Agent", userAgent)
+	r.Header.Add("Content-Type", "application/json")
+	var res *http.Response
+	if res, err = client.Do(r); err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if b, err = io.ReadAll(res.Body); err != nil {
+		return
+	}
+	if res.StatusCode >= 300 {
+		err = errorf.E("%s responded %s: %s", address, res.Status, b)
+		return
+	}
+	// the response is encoded by encoding/json, so it is decoded the same way.
+	j := new(event.J)
+	if err = json.Unmarshal(b, j); err != nil {
+		return
+	}
+	return j.ToEvent()
+}
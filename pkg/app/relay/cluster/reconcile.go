@@ -0,0 +1,235 @@
+package cluster
+
+import (
+	"encoding/hex"
+	"errors"
+	"orly.dev/pkg/app/relay/replicate"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"strings"
+	"time"
+)
+
+// reconcile reconciles the store with each member that is up every Reconcile
+// period, until the context is cancelled.
+func (s *S) reconcile() {
+	ticker := time.NewTicker(s.Reconcile)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, p := range s.Members() {
+			if s.ctx.Err() != nil {
+				return
+			}
+			s.mx.Lock()
+			up := s.up(hex.EncodeToString(p.Pubkey))
+			s.mx.Unlock()
+			if up {
+				s.ReconcileWith(p)
+			}
+		}
+	}
+}
+
+// ReconcileSkew is how far before the start of the last successful
+// reconciliation with a member the events of the next one begin, to cover the
+// difference between the clocks of the authors and the relays, and the events
+// that were in flight.
+var ReconcileSkew = 10 * time.Minute
+
+// ReconcileChunk is the maximum number of events of the store in the time
+// range of one negentropy sync, which keeps each well under the number of
+// events that a relay reconciles in one sync.
+var ReconcileChunk = 100000
+
+// syncFunc runs a negentropy sync of the events matching a filter with a
+// member, and returns the number of events fetched.
+type syncFunc func(c context.T, f *filter.F) (pulled int, err error)
+
+// ReconcileWith fetches the events that a member has and the store doesn't,
+// using negentropy syncs. The events this node has that the member doesn't
+// are fetched by the member when it reconciles with this node.
+//
+// The sync only covers the events created since shortly before the last
+// successful one, as those before it were already reconciled. The events
+// created earlier that arrive later, which replication missed, are fetched by
+// a reconciliation of all the events every FullReconcile period, which is also
+// the first after the node starts.
+//
+// The events are synced in time ranges of at most ReconcileChunk events of the
+// store, newest first, and the progress of a full reconciliation is recorded
+// after each, so one that doesn't finish within the Reconcile period goes on
+// from where it stopped in the next.
+//
+// The privileged events are only reconciled if the member allows them to be
+// fetched by the other nodes, otherwise they are only sent by replication.
+func (s *S) ReconcileWith(p replicate.Peer) {
+	c, cancel := context.Timeout(s.ctx, s.Reconcile)
+	defer cancel()
+	pk := hex.EncodeToString(p.Pubkey)
+	sync := func(c context.T, f *filter.F) (int, error) {
+		return s.sync(c, p.Address, f)
+	}
+	start := time.Now().Unix()
+	s.mx.Lock()
+	n := s.node(pk)
+	if n.fullUntil == 0 && (n.full == 0 ||
+		start-n.full >= int64(s.FullReconcile/time.Second)) {
+		n.fullStart, n.fullUntil = start, start
+	}
+	// the events before the start of a full reconciliation in progress are
+	// covered by it.
+	since := n.synced
+	fullStart, fullUntil := n.fullStart, n.fullUntil
+	if fullUntil != 0 {
+		since = max(since, fullStart)
+	}
+	s.mx.Unlock()
+	pulled, err := s.syncChunks(
+		c, since-int64(ReconcileSkew/time.Second), start, sync, nil,
+	)
+	if err == nil {
+		s.mx.Lock()
+		s.node(pk).synced = start
+		s.mx.Unlock()
+		if fullUntil != 0 {
+			var full int
+			full, err = s.syncChunks(
+				c, 0, fullUntil, sync, func(until int64) {
+					s.mx.Lock()
+					s.node(pk).fullUntil = until
+					s.mx.Unlock()
+				},
+			)
+			pulled += full
+		}
+	}
+	s.mx.Lock()
+	n = s.node(pk)
+	n.lastReconcile, n.pulled = time.Now().Unix(), pulled
+	if err != nil {
+		n.lastError = err.Error()
+	} else if fullUntil != 0 {
+		n.full, n.fullUntil = fullStart, 0
+	}
+	s.mx.Unlock()
+	if err != nil {
+		log.W.F("reconciliation with %s failed: %v", p.Address, err)
+		return
+	}
+	if pulled > 0 {
+		log.I.F("fetched %d events from cluster member %s", pulled, p.Address)
+	}
+}
+
+// syncChunks syncs the events created from since to until, newest first, in
+// time ranges of at most ReconcileChunk events of the store. If done is not
+// nil, it is called after each range with the until of the events that
+// remain.
+func (s *S) syncChunks(
+	c context.T, since, until int64, sync syncFunc, done func(until int64),
+) (pulled int, err error) {
+	for until >= since {
+		var from int64
+		if from, err = s.chunkStart(c, since, until); err != nil {
+			return
+		}
+		var n int
+		n, err = s.syncSplit(c, from, until, sync)
+		pulled += n
+		if err != nil {
+			return
+		}
+		until = from - 1
+		if done != nil {
+			done(until)
+		}
+	}
+	return
+}
+
+// chunkStart returns the created_at of the oldest of the newest
+// ReconcileChunk events of the store from since to until, or since if there
+// are fewer.
+func (s *S) chunkStart(c context.T, since, until int64) (
+	from int64, err error,
+) {
+	f := rangeFilter(since, until)
+	limit := uint(ReconcileChunk)
+	f.Limit = &limit
+	var idPkTs []store.IdPkTs
+	if idPkTs, err = s.store.QueryForIds(c, f); err != nil {
+		return
+	}
+	if len(idPkTs) < ReconcileChunk {
+		return since, nil
+	}
+	// the results are newest first.
+	from = max(idPkTs[len(idPkTs)-1].Ts, since)
+	return
+}
+
+// syncSplit syncs the events created from since to until, splitting the range
+// in half while the member refuses it as too large, as it has many more events
+// in it than the store.
+func (s *S) syncSplit(c context.T, since, until int64, sync syncFunc) (
+	pulled int, err error,
+) {
+	if pulled, err = sync(c, rangeFilter(since, until)); err == nil ||
+		!errors.Is(err, ws.ErrSyncTooLarge) || since >= until {
+		return
+	}
+	mid := since + (until-since)/2
+	if pulled, err = s.syncSplit(c, mid+1, until, sync); err != nil {
+		return
+	}
+	var n int
+	n, err = s.syncSplit(c, since, mid, sync)
+	pulled += n
+	return
+}
+
+// rangeFilter returns a filter of the events created from since to until.
+func rangeFilter(since, until int64) (f *filter.F) {
+	f = &filter.F{Until: timestamp.FromUnix(until)}
+	if since > 0 {
+		f.Since = timestamp.FromUnix(since)
+	}
+	return
+}
+
+// sync runs a negentropy sync of the events matching a filter with the relay
+// at an address, saving the events that are missing in the store. If the relay
+// requires auth, the node authenticates with its key and retries, so that the
+// relay serves it the events it only serves to authenticated peers.
+func (s *S) sync(c context.T, address string, f *filter.F) (
+	pulled int, err error,
+) {
+	// the websocket of the relay is at the same address as the HTTP API.
+	u := address
+	if strings.HasPrefix(u, "http") {
+		u = "ws" + strings.TrimPrefix(u, "http")
+	}
+	var cli *ws.Client
+	if cli, err = ws.RelayConnect(c, u); err != nil {
+		return
+	}
+	defer cli.Close()
+	pulled, _, err = cli.Sync(c, f, s.store, true, false)
+	if errors.Is(err, ws.ErrSyncAuthRequired) {
+		if err = cli.Auth(c, s.sign); chk.E(err) {
+			return
+		}
+		pulled, _, err = cli.Sync(c, f, s.store, true, false)
+	}
+	return
+}
@@ -0,0 +1,120 @@
+package relay
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/app/relay/cluster"
+	"orly.dev/pkg/app/relay/replicate"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/servemux"
+	"orly.dev/pkg/utils/context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestClusterReconcileDeletion checks that a deletion that only one node of a
+// cluster received, as when the replication to the other was lost, reaches
+// the other by reconciliation alone, also from a relay that requires auth.
+func TestClusterReconcileDeletion(t *testing.T) {
+	for _, conf := range []*config.C{{}, {AuthRequired: true}} {
+		t.Run(
+			fmt.Sprintf("auth=%v", conf.AuthRequired), func(t *testing.T) {
+				testClusterReconcileDeletion(t, conf)
+			},
+		)
+	}
+}
+
+func testClusterReconcileDeletion(t *testing.T, conf *config.C) {
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	dir := t.TempDir()
+	open := func(name string) (d *database.D) {
+		var err error
+		if d, err = database.New(
+			c, cancel, filepath.Join(dir, name), "error",
+		); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Close() })
+		return
+	}
+	a, b := open("a"), open("b")
+	author := new(p256k.Signer)
+	if err := author.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	sign := func(k *kind.T, content string, t0 int64, tg ...*tag.T) *event.E {
+		ev := &event.E{
+			CreatedAt: timestamp.FromUnix(t0), Kind: k,
+			Tags: tags.New(tg...), Content: []byte(content),
+		}
+		if err := ev.Sign(author); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	now := time.Now().Unix()
+	note := sign(kind.TextNote, "deleted later", now-60)
+	deletion := sign(
+		kind.Deletion, "", now, tag.New([]byte("e"), hex.EncAppend(nil, note.ID)),
+	)
+	// both nodes have the note, and only a received its deletion.
+	for _, d := range []*database.D{a, b} {
+		if _, _, err := d.SaveEvent(c, note, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := a.SaveEvent(c, deletion, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(
+		&ServerParams{
+			Ctx: c, Cancel: cancel, Rl: &testRelay{storage: a},
+			C: conf,
+		}, servemux.NewServeMux(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	nodeA, nodeB := new(p256k.Signer), new(p256k.Signer)
+	for _, s := range []*p256k.Signer{nodeA, nodeB} {
+		if err = s.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var nb *cluster.S
+	if nb, err = cluster.New(
+		c, cluster.Config{
+			Name: "test", Address: "http://127.0.0.1:1",
+			Heartbeat: time.Hour, Reconcile: time.Minute, Forget: time.Hour,
+			File: filepath.Join(dir, "b.json"),
+		}, nodeB, b, nil,
+	); err != nil {
+		t.Fatal(err)
+	}
+	nb.ReconcileWith(replicate.Peer{Address: hs.URL, Pubkey: nodeA.Pub()})
+	var evs event.S
+	if evs, err = b.QueryEvents(
+		c, &filter.F{Ids: tag.New(deletion.ID)},
+	); err != nil || len(evs) != 1 {
+		t.Fatalf("Expected the deletion to be fetched, got %d %v", len(evs), err)
+	}
+	if evs, err = b.QueryEvents(
+		c, &filter.F{Ids: tag.New(note.ID)},
+	); err != nil || len(evs) != 0 {
+		t.Fatalf("Expected the note to be deleted, got %d %v", len(evs), err)
+	}
+}
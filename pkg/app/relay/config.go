@@ -4,7 +4,15 @@ import (
 	"orly.dev/pkg/app/config"
 )
 
+// Config returns the current configuration of the relay, which must not be
+// modified, as it is shared with every reader of it.
 func (s *Server) Config() (c *config.C) {
-	c = s.C
+	c = s.conf.Load()
+	return
+}
+
+// Peers returns the current peer relays and the identity of the relay.
+func (s *Server) Peers() (p *Peers) {
+	p = s.peers.Load()
 	return
 }
@@ -0,0 +1,226 @@
+package relay
+
+import (
+	"net"
+	"slices"
+	"strings"
+
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/keys"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/lol"
+)
+
+// Configuration returns the parts of the configuration of the relay that can be
+// changed while it is running.
+func (s *Server) Configuration() (conf *store.Configuration) {
+	c := s.Config()
+	conf = &store.Configuration{
+		BlockList:        slices.Clone(c.BlockList),
+		Owners:           slices.Clone(c.Owners),
+		Whitelist:        slices.Clone(c.Whitelist),
+		SpiderSeeds:      slices.Clone(c.SpiderSeeds),
+		PeerRelays:       slices.Clone(c.PeerRelays),
+		LogLevel:         c.LogLevel,
+		DbLogLevel:       c.DbLogLevel,
+		MaxMessageLength: c.MaxMessageLength,
+		MaxSubscriptions: c.MaxSubscriptions,
+		MaxFilters:       c.MaxFilters,
+		MaxLimit:         c.MaxLimit,
+		MaxSubidLength:   c.MaxSubidLength,
+	}
+	return
+}
+
+// SetConfiguration checks a configuration, stores it in the database, and
+// applies it to the running relay.
+//
+// The configuration replaces all of the runtime-changeable parts of the
+// current one, except that empty log levels and limits of zero leave the
+// current levels and limits unchanged. If the owners changed, the spider is
+// run to fetch their follow and mute lists, and if the peer relays changed,
+// they are initialized again.
+//
+// The configuration is stored and applied under one lock, so that concurrent
+// changes are applied in the same order they are stored.
+func (s *Server) SetConfiguration(conf *store.Configuration) (err error) {
+	if err = CheckConfiguration(conf); chk.E(err) {
+		return
+	}
+	s.configMx.Lock()
+	if sto := s.Storage(); sto != nil {
+		if err = sto.SetConfiguration(conf); chk.E(err) {
+			s.configMx.Unlock()
+			return
+		}
+	}
+	ownersChanged, peersChanged := s.applyConfiguration(conf)
+	if peersChanged {
+		c := s.Config()
+		p := new(Peers)
+		chk.E(p.Init(c.PeerRelays, c.RelaySecret))
+		s.peers.Store(p)
+		if s.cluster != nil {
+			s.cluster.SetSeeds(p.list())
+		}
+		s.setReplicationPeers()
+	}
+	s.configMx.Unlock()
+	if ownersChanged {
+		if err = s.Spider(s.Config().Private); chk.E(err) {
+			err = nil
+		}
+	}
+	log.I.F("configuration updated")
+	return
+}
+
+// CheckConfiguration returns an error if a configuration has invalid values.
+// The errors are a store.ErrInvalidConfiguration.
+func CheckConfiguration(conf *store.Configuration) (err error) {
+	for _, b := range conf.BlockList {
+		if _, err = parseAddress(b); err != nil {
+			return errorf.E(
+				"%w: invalid blocked address '%s', must be an IP address "+
+					"or CIDR range", store.ErrInvalidConfiguration, b,
+			)
+		}
+	}
+	for _, o := range conf.Owners {
+		if _, err = keys.DecodeNpubOrHex(o); err != nil {
+			return errorf.E(
+				"%w: invalid owner '%s': %s", store.ErrInvalidConfiguration,
+				o, err.Error(),
+			)
+		}
+	}
+	for _, p := range conf.PeerRelays {
+		if split := strings.Split(p, "@"); len(split) != 2 {
+			return errorf.E(
+				"%w: invalid peer relay '%s', must be <pubkey>@<url>",
+				store.ErrInvalidConfiguration, p,
+			)
+		}
+	}
+	for _, l := range []string{conf.LogLevel, conf.DbLogLevel} {
+		if l != "" && !slices.Contains(lol.LevelNames, l) {
+			return errorf.E(
+				"%w: invalid log level '%s', must be one of %v",
+				store.ErrInvalidConfiguration, l, lol.LevelNames,
+			)
+		}
+	}
+	if conf.MaxMessageLength < 0 || conf.MaxSubscriptions < 0 ||
+		conf.MaxFilters < 0 || conf.MaxLimit < 0 || conf.MaxSubidLength < 0 {
+		return errorf.E(
+			"%w: limits cannot be negative", store.ErrInvalidConfiguration,
+		)
+	}
+	return
+}
+
+// parseAddress parses an entry of the BlockList, which is either a single IP
+// address or a CIDR range.
+func parseAddress(s string) (n *net.IPNet, err error) {
+	if strings.Contains(s, "/") {
+		_, n, err = net.ParseCIDR(s)
+		return
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		err = errorf.E("invalid IP address '%s'", s)
+		return
+	}
+	bits := 8 * len(ip)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	n = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	return
+}
+
+// blocked returns true if the IP address of a remote is in one of the
+// addresses or CIDR ranges of a BlockList. Entries that can't be parsed, which
+// may come from the environment, are ignored.
+func blocked(remote string, list []string) bool {
+	if len(list) == 0 {
+		return false
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	ip := net.ParseIP(remote)
+	if ip == nil {
+		return false
+	}
+	for _, b := range list {
+		if n, err := parseAddress(b); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConfiguration copies a configuration into a new configuration of the
+// relay, which replaces the current one, and sets the log levels. The caller
+// must hold the configMx, or be the only one changing the configuration.
+//
+// # Return Values
+//
+//   - ownersChanged: true if the list of owners is different.
+//
+//   - peersChanged: true if the list of peer relays is different.
+func (s *Server) applyConfiguration(conf *store.Configuration) (
+	ownersChanged, peersChanged bool,
+) {
+	c := *s.Config()
+	ownersChanged = !slices.Equal(c.Owners, conf.Owners)
+	peersChanged = !slices.Equal(c.PeerRelays, conf.PeerRelays)
+	c.BlockList = slices.Clone(conf.BlockList)
+	c.Owners = slices.Clone(conf.Owners)
+	c.Whitelist = slices.Clone(conf.Whitelist)
+	c.SpiderSeeds = slices.Clone(conf.SpiderSeeds)
+	c.PeerRelays = slices.Clone(conf.PeerRelays)
+	if conf.LogLevel != "" {
+		c.LogLevel = conf.LogLevel
+	}
+	if conf.DbLogLevel != "" {
+		c.DbLogLevel = conf.DbLogLevel
+	}
+	for _, l := range []struct {
+		to   *int
+		from int
+	}{
+		{&c.MaxMessageLength, conf.MaxMessageLength},
+		{&c.MaxSubscriptions, conf.MaxSubscriptions},
+		{&c.MaxFilters, conf.MaxFilters},
+		{&c.MaxLimit, conf.MaxLimit},
+		{&c.MaxSubidLength, conf.MaxSubidLength},
+	} {
+		if l.from > 0 {
+			*l.to = l.from
+		}
+	}
+	// the configuration is shared with the relay and the APIs, which may be
+	// reading it, so a new one replaces it.
+	s.conf.Store(&c)
+	if ownersChanged {
+		// the owners are admins as soon as the configuration is applied, and
+		// removed owners no longer are, without waiting for the spider to
+		// fetch their lists, which may fail.
+		var ownersPubkeys [][]byte
+		for _, o := range c.Owners {
+			if pk, err := keys.DecodeNpubOrHex(o); !chk.E(err) {
+				ownersPubkeys = append(ownersPubkeys, pk)
+			}
+		}
+		s.SetOwnersPubkeys(ownersPubkeys)
+	}
+	lol.SetLogLevel(c.LogLevel)
+	if sto := s.Storage(); sto != nil {
+		sto.SetLogLevel(c.DbLogLevel)
+	}
+	return
+}
@@ -0,0 +1,139 @@
+package relay
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/database/memory"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/httpauth"
+	"slices"
+	"testing"
+)
+
+func TestSetConfiguration(t *testing.T) {
+	s := &Server{
+		relay: &testRelay{},
+	}
+	s.conf.Store(
+		&config.C{
+			LogLevel:   "info",
+			DbLogLevel: "info",
+			MaxFilters: 10,
+			MaxLimit:   500,
+		},
+	)
+	conf := s.Configuration()
+	conf.Whitelist = []string{"127.0.0.1"}
+	conf.MaxFilters = 5
+	conf.LogLevel = ""
+	if err := s.SetConfiguration(conf); err != nil {
+		t.Fatal(err)
+	}
+	// the change is applied without a restart.
+	if !slices.Equal(s.Config().Whitelist, []string{"127.0.0.1"}) {
+		t.Fatalf("whitelist not applied: %v", s.Config().Whitelist)
+	}
+	if l := s.Limits(); l.MaxFilters != 5 || l.MaxLimit != 500 {
+		t.Fatalf("limits not applied: %+v", l)
+	}
+	// an empty log level leaves the current one.
+	if s.Config().LogLevel != "info" {
+		t.Fatalf("log level changed to '%s'", s.Config().LogLevel)
+	}
+	// limits that are left out keep their current values.
+	if err := s.SetConfiguration(
+		&store.Configuration{MaxLimit: 100},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if l := s.Limits(); l.MaxFilters != 5 || l.MaxLimit != 100 {
+		t.Fatalf("limits left out were changed: %+v", l)
+	}
+	for _, bad := range []*store.Configuration{
+		{BlockList: []string{"1.2.3"}},
+		{Owners: []string{"not a pubkey"}},
+		{PeerRelays: []string{"wss://example.com"}},
+		{LogLevel: "loud"},
+		{MaxLimit: -1},
+	} {
+		err := s.SetConfiguration(bad)
+		if !errors.Is(err, store.ErrInvalidConfiguration) {
+			t.Fatalf("expected invalid configuration error for %+v", bad)
+		}
+	}
+	if s.Config().MaxFilters != 5 {
+		t.Fatal("invalid configuration was applied")
+	}
+}
+
+func TestSetConfigurationOwners(t *testing.T) {
+	s := &Server{
+		relay: &testRelay{storage: memory.New()},
+		Lists: new(Lists),
+	}
+	s.conf.Store(&config.C{Private: true, SpiderType: "none"})
+	var owners []*p256k.Signer
+	var hexes []string
+	for range 2 {
+		sign := new(p256k.Signer)
+		if err := sign.Generate(); err != nil {
+			t.Fatal(err)
+		}
+		owners = append(owners, sign)
+		hexes = append(hexes, hex.EncodeToString(sign.Pub()))
+	}
+	// adminAuth returns true if a request signed by an owner passes AdminAuth.
+	adminAuth := func(sign *p256k.Signer) bool {
+		r := httptest.NewRequest(
+			"PUT", "http://example.com/api/configuration", nil,
+		)
+		if err := httpauth.AddNIP98Header(
+			r, r.URL, "PUT", "", sign, 0,
+		); err != nil {
+			t.Fatal(err)
+		}
+		authed, _ := s.AdminAuth(r, "127.0.0.1")
+		return authed
+	}
+	if err := s.SetConfiguration(
+		&store.Configuration{Owners: hexes},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if !adminAuth(owners[0]) || !adminAuth(owners[1]) {
+		t.Fatal("the owners are not admins")
+	}
+	// a removed owner is no longer an admin as soon as the configuration is
+	// applied, without waiting for the spider.
+	if err := s.SetConfiguration(
+		&store.Configuration{Owners: hexes[:1]},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if adminAuth(owners[1]) {
+		t.Fatal("the removed owner is still an admin")
+	}
+	if !adminAuth(owners[0]) {
+		t.Fatal("the remaining owner is not an admin")
+	}
+}
+
+func TestBlocked(t *testing.T) {
+	list := []string{"1.2.3.4", "10.0.0.0/8", "2001:db8::/32"}
+	for remote, expected := range map[string]bool{
+		"1.2.3.4":          true,
+		"1.2.3.4:5678":     true,
+		"1.2.3.45":         false,
+		"10.20.30.40:1000": true,
+		"11.0.0.1":         false,
+		"[2001:db8::1]:80": true,
+		"2001:db9::1":      false,
+	} {
+		if blocked(remote, list) != expected {
+			t.Errorf("blocked(%s) is %v, expected %v", remote, !expected, expected)
+		}
+	}
+}
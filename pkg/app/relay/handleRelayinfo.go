@@ -39,11 +39,15 @@ func (s *Server) HandleRelayInfo(w http.ResponseWriter, r *http.Request) {
 			relayinfo.EventDeletion,
 			relayinfo.RelayInformationDocument,
 			relayinfo.GenericTagQueries,
+			relayinfo.ProofOfWork,
 			// relayinfo.NostrMarketplace,
 			relayinfo.EventTreatment,
 			// relayinfo.CommandResults,
 			relayinfo.ParameterizedReplaceableEvents,
-			// relayinfo.ExpirationTimestamp,
+			relayinfo.CountingResults,
+			relayinfo.SearchCapability,
+			relayinfo.ExpirationTimestamp,
+			relayinfo.NegentropySyncing,
 			// relayinfo.ProtectedEvents,
 			// relayinfo.RelayListMetadata,
 		)
@@ -54,10 +58,7 @@ func (s *Server) HandleRelayInfo(w http.ResponseWriter, r *http.Request) {
 			Description: version.Description,
 			Nips:        supportedNIPs, Software: version.URL,
 			Version: version.V,
-			Limitation: relayinfo.Limits{
-				AuthRequired:     s.C.AuthRequired,
-				RestrictedWrites: s.C.AuthRequired,
-			},
+			Limitation: s.Limits(),
 			Icon: "https://cdn.satellite.earth/ac9778868fbf23b63c47c769a74e163377e6ea94d3f0f31711931663d035c4f6.png",
 		}
 	}
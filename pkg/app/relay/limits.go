@@ -0,0 +1,35 @@
+package relay
+
+import (
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/relayinfo"
+)
+
+// Limits returns the limits on subscriptions, filters, messages and events
+// that the relay enforces, as published in the NIP-11 relay information
+// document. A value of zero is not enforced.
+func (s *Server) Limits() (l relayinfo.Limits) {
+	c := s.Config()
+	l = relayinfo.Limits{
+		MaxMessageLength: c.MaxMessageLength,
+		MaxSubscriptions: c.MaxSubscriptions,
+		MaxFilters:       c.MaxFilters,
+		MaxLimit:         c.MaxLimit,
+		MaxSubidLength:   c.MaxSubidLength,
+		MaxEventTags:     c.PolicyMaxTags,
+		MaxContentLength: c.PolicyMaxContentLength,
+		MinPowDifficulty: c.PolicyMinPow,
+		AuthRequired:     c.AuthRequired,
+		RestrictedWrites: c.AuthRequired,
+	}
+	// the created_at limits are a number of seconds from the current time.
+	if c.PolicyCreatedAtPast > 0 {
+		l.Oldest = timestamp.FromUnix(int64(c.PolicyCreatedAtPast.Seconds()))
+	}
+	if c.PolicyCreatedAtFuture > 0 {
+		l.Newest = timestamp.FromUnix(
+			int64(c.PolicyCreatedAtFuture.Seconds()),
+		)
+	}
+	return
+}
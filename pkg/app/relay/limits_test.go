@@ -0,0 +1,45 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/protocol/relayinfo"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	s := &Server{
+		relay: &testRelay{},
+	}
+	s.conf.Store(
+		&config.C{
+			MaxMessageLength:       1000,
+			MaxSubscriptions:       20,
+			MaxFilters:             10,
+			MaxLimit:               500,
+			MaxSubidLength:         64,
+			PolicyMaxTags:          100,
+			PolicyMaxContentLength: 8196,
+			PolicyMinPow:           8,
+			PolicyCreatedAtPast:    time.Hour,
+		},
+	)
+	w := httptest.NewRecorder()
+	s.HandleRelayInfo(w, httptest.NewRequest("GET", "/", nil))
+	var info relayinfo.T
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	l := info.Limitation
+	if l.MaxMessageLength != 1000 || l.MaxSubscriptions != 20 ||
+		l.MaxFilters != 10 || l.MaxLimit != 500 || l.MaxSubidLength != 64 ||
+		l.MaxEventTags != 100 || l.MaxContentLength != 8196 ||
+		l.MinPowDifficulty != 8 {
+		t.Fatalf("unexpected limits %+v", l)
+	}
+	if l.Oldest == nil || l.Oldest.I64() != 3600 || l.Newest != nil {
+		t.Fatalf("unexpected created_at limits %v %v", l.Oldest, l.Newest)
+	}
+}
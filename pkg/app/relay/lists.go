@@ -1,6 +1,7 @@
 package relay
 
 import (
+	"bytes"
 	"sync"
 )
 
@@ -106,3 +107,27 @@ func (l *Lists) SetOwnersMuted(pks [][]byte) {
 	l.ownersMuted = pks
 	return
 }
+
+// IsOwner returns true if the pubkey is one of the owners.
+func (l *Lists) IsOwner(pk []byte) bool {
+	l.Lock()
+	defer l.Unlock()
+	return contains(l.ownersPubkeys, pk)
+}
+
+// IsFollowed returns true if the pubkey is followed by an owner or by one of
+// the owners' follows.
+func (l *Lists) IsFollowed(pk []byte) bool {
+	l.Lock()
+	defer l.Unlock()
+	return contains(l.ownersFollowed, pk) || contains(l.followedFollows, pk)
+}
+
+func contains(pks [][]byte, pk []byte) bool {
+	for _, p := range pks {
+		if bytes.Equal(p, pk) {
+			return true
+		}
+	}
+	return false
+}
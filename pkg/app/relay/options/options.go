@@ -1,11 +1,11 @@
 // Package options provides some option configurations for the relay.
 //
-// None of this package is actually in use, and the skip event function has not
-// been implemented. In theory, this could be used for something but it currently
-// isn't.
+// The skip event function has not been implemented. In theory, this could be
+// used for something but it currently isn't.
 package options
 
 import (
+	"orly.dev/pkg/app/relay/policy"
 	"orly.dev/pkg/encoders/event"
 )
 
@@ -16,6 +16,9 @@ type T struct {
 	// SkipEventFunc is in theory a function to test whether an event should not
 	// be sent in response to a query.
 	SkipEventFunc
+	// Policies are write policies that are checked after the built-in ones
+	// when an event is submitted.
+	Policies policy.Chain
 }
 
 // O is a function that processes an options.T.
@@ -33,3 +36,11 @@ func WithSkipEventFunc(skipEventFunc func(*event.E) bool) O {
 		o.SkipEventFunc = skipEventFunc
 	}
 }
+
+// WithPolicies is an options.T generator that adds write policies to the
+// relay, to apply custom rules to submitted events.
+func WithPolicies(p ...policy.I) O {
+	return func(o *T) {
+		o.Policies = append(o.Policies, p...)
+	}
+}
@@ -1,12 +1,17 @@
 package relay
 
 import (
+	"bytes"
+	"orly.dev/pkg/app/relay/replicate"
+	"orly.dev/pkg/crypto/ec/secp256k1"
 	"orly.dev/pkg/crypto/p256k"
 	"orly.dev/pkg/encoders/bech32encoding"
 	"orly.dev/pkg/interfaces/signer"
 	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
 	"orly.dev/pkg/utils/keys"
 	"orly.dev/pkg/utils/log"
+	"slices"
 	"strings"
 )
 
@@ -41,11 +46,13 @@ func (p *Peers) Init(
 			log.E.F("invalid peer address: %s", address)
 			continue
 		}
-		p.Addresses = append(p.Addresses, split[1])
 		var pk []byte
 		if pk, err = keys.DecodeNpubOrHex(split[0]); chk.D(err) {
 			continue
 		}
+		// the addresses and pubkeys are only added together so they stay at
+		// the same index.
+		p.Addresses = append(p.Addresses, split[1])
 		p.Pubkeys = append(p.Pubkeys, pk)
 		log.I.F("peer %s added; pubkey: %0x", split[1], pk)
 	}
@@ -67,3 +74,78 @@ func (p *Peers) Init(
 	)
 	return
 }
+
+// setReplicationPeers starts the replication of events to the current peers
+// and cluster members, and stops it for those that have been removed, whose
+// queues are kept until they are dropped. Replication needs the relay to have
+// an identity key to authenticate to the peers, and without one the peers are
+// left unchanged, so their queues are kept until there is one.
+func (s *Server) setReplicationPeers() {
+	if s.replicator == nil {
+		return
+	}
+	p := s.Peers()
+	if len(p.I.Sec()) != secp256k1.SecKeyBytesLen {
+		if len(p.Addresses) > 0 {
+			log.W.F(
+				"peer relays are configured but the relay has no secret key",
+			)
+		}
+		return
+	}
+	peers := p.list()
+	// the members of the cluster that are not configured as peers are also
+	// replicated to.
+	if s.cluster != nil {
+	next:
+		for _, m := range s.cluster.Members() {
+			for _, peer := range peers {
+				if bytes.Equal(peer.Pubkey, m.Pubkey) {
+					continue next
+				}
+			}
+			peers = append(peers, m)
+		}
+	}
+	s.replicator.SetPeers(peers, p.I)
+}
+
+// list returns the configured peers.
+func (p *Peers) list() (peers []replicate.Peer) {
+	peers = make([]replicate.Peer, len(p.Addresses))
+	for i, a := range p.Addresses {
+		peers[i] = replicate.Peer{Address: a, Pubkey: p.Pubkeys[i]}
+	}
+	return
+}
+
+// peerPubkeys returns the pubkeys of the configured peers and the members of
+// the cluster, which are trusted to replicate all events.
+func (s *Server) peerPubkeys() (pks [][]byte) {
+	pks = slices.Clone(s.Peers().Pubkeys)
+	if s.cluster != nil {
+		for _, m := range s.cluster.Members() {
+			pks = append(pks, m.Pubkey)
+		}
+	}
+	return
+}
+
+// Replication returns the state of the replication of events to each of the
+// peer relays.
+func (s *Server) Replication() (peers []replicate.PeerState) {
+	if s.replicator == nil {
+		return
+	}
+	return s.replicator.State()
+}
+
+// DropReplication removes the queue of a peer relay that was removed, which is
+// kept in case the peer is added again. replicate.ErrConfigured is returned if
+// the peer is still replicated to.
+func (s *Server) DropReplication(pubkey []byte) (err error) {
+	if s.replicator == nil {
+		return errorf.E("relay has no storage to replicate from")
+	}
+	return s.replicator.Drop(pubkey)
+}
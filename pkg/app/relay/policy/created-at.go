@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"net/http"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/normalize"
+	"time"
+)
+
+// CreatedAt is a write policy that only accepts events with a created_at
+// timestamp within a window around the current time. A zero duration is not
+// checked.
+type CreatedAt struct {
+	// Past is how far before now created_at may be.
+	Past time.Duration
+	// Future is how far after now created_at may be.
+	Future time.Duration
+}
+
+// Accept rejects events with a created_at outside the window.
+func (p *CreatedAt) Accept(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte) {
+	now := time.Now()
+	ts := ev.CreatedAt.Time()
+	if p.Past > 0 && ts.Before(now.Add(-p.Past)) {
+		reason = normalize.Invalid.F(
+			"created_at is more than %v in the past", p.Past,
+		)
+		return
+	}
+	if p.Future > 0 && ts.After(now.Add(p.Future)) {
+		reason = normalize.Invalid.F(
+			"created_at is more than %v in the future", p.Future,
+		)
+		return
+	}
+	accept = true
+	return
+}
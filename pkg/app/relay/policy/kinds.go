@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"net/http"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/normalize"
+)
+
+// Kinds is a write policy that restricts the kinds of events the relay will
+// store.
+type Kinds struct {
+	// Allow is the list of kinds that are accepted. If it is empty, all kinds
+	// not in Deny are accepted.
+	Allow []uint16
+	// Deny is the list of kinds that are rejected.
+	Deny []uint16
+}
+
+// Accept rejects events whose kind is in Deny, or is not in Allow when Allow
+// is not empty.
+func (p *Kinds) Accept(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte) {
+	k := ev.Kind.ToU16()
+	for _, d := range p.Deny {
+		if k == d {
+			reason = normalize.Blocked.F("kind %d is not accepted", k)
+			return
+		}
+	}
+	if len(p.Allow) == 0 {
+		accept = true
+		return
+	}
+	for _, a := range p.Allow {
+		if k == a {
+			accept = true
+			return
+		}
+	}
+	reason = normalize.Blocked.F("kind %d is not accepted", k)
+	return
+}
@@ -0,0 +1,195 @@
+// Package policy is a chain of write policies that decide whether an event
+// submitted to the relay is accepted, replacing fixed rules in the relay with
+// a list of checks that can be configured and extended.
+package policy
+
+import (
+	"net/http"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/encoders/bech32encoding"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"strconv"
+	"strings"
+)
+
+// I is a write policy, a single rule applied to events submitted to the
+// relay.
+type I interface {
+	// Accept decides whether an event may be stored.
+	//
+	// # Parameters
+	//
+	//   - c: the context of the request
+	//
+	//   - ev: the event being submitted
+	//
+	//   - authedPubkey: the public key the client has authenticated as, if any
+	//
+	//   - remote: the address of the client
+	//
+	//   - hr: the HTTP request of the connection, if any
+	//
+	// # Return Values
+	//
+	//   - accept: true if the event passes this policy
+	//
+	//   - reason: if the event is rejected, a NIP-20 message with a machine
+	//     readable prefix, as made by normalize.Reason.F
+	Accept(
+		c context.T, ev *event.E, authedPubkey []byte, remote string,
+		hr *http.Request,
+	) (accept bool, reason []byte)
+}
+
+// Func is a function that can be used as a write policy.
+type Func func(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte)
+
+// Accept calls the function.
+func (f Func) Accept(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte) {
+	return f(c, ev, authedPubkey, remote, hr)
+}
+
+// Chain is a list of write policies that an event must all pass, in order.
+type Chain []I
+
+// Accept runs each policy of the Chain in turn, and returns the reason given
+// by the first one that rejects the event. An empty Chain accepts everything.
+func (ch Chain) Accept(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte) {
+	for _, p := range ch {
+		if accept, reason = p.Accept(
+			c, ev, authedPubkey, remote, hr,
+		); !accept {
+			log.D.F("%s rejected event %0x: %s", remote, ev.ID, reason)
+			return
+		}
+	}
+	accept = true
+	return
+}
+
+// FromConfig creates the Chain of built-in policies that are enabled in the
+// configuration. The policies that are cheapest to check come first, and the
+// PublishLimit comes last, so events that are rejected anyway do not count
+// against it.
+func FromConfig(cfg *config.C) (ch Chain) {
+	if len(cfg.PolicyKindsAllow) > 0 || len(cfg.PolicyKindsDeny) > 0 {
+		ch = append(
+			ch, &Kinds{
+				Allow: parseKinds(cfg.PolicyKindsAllow),
+				Deny:  parseKinds(cfg.PolicyKindsDeny),
+			},
+		)
+	}
+	if cfg.PolicyMaxContentLength > 0 || cfg.PolicyMaxTags > 0 ||
+		cfg.PolicyMaxTagLength > 0 {
+		ch = append(
+			ch, &Size{
+				MaxContentLength: cfg.PolicyMaxContentLength,
+				MaxTags:          cfg.PolicyMaxTags,
+				MaxTagLength:     cfg.PolicyMaxTagLength,
+			},
+		)
+	}
+	if cfg.PolicyCreatedAtPast > 0 || cfg.PolicyCreatedAtFuture > 0 {
+		ch = append(
+			ch, &CreatedAt{
+				Past:   cfg.PolicyCreatedAtPast,
+				Future: cfg.PolicyCreatedAtFuture,
+			},
+		)
+	}
+	if cfg.PolicyMinPow > 0 || len(cfg.PolicyMinPowKinds) > 0 {
+		ch = append(
+			ch, &PoW{
+				MinDifficulty:       cfg.PolicyMinPow,
+				Kinds:               parseKindDifficulties(cfg.PolicyMinPowKinds),
+				Whitelist:           parsePubkeys(cfg.PolicyPowWhitelist),
+				WhitelistDifficulty: cfg.PolicyMinPowWhitelisted,
+			},
+		)
+	}
+	if cfg.PolicyPublishLimit > 0 && cfg.PolicyPublishWindow > 0 {
+		ch = append(
+			ch, NewPublishLimit(
+				cfg.PolicyPublishLimit, cfg.PolicyPublishWindow,
+			),
+		)
+	}
+	return
+}
+
+// parseKinds converts a list of kind numbers from the configuration, skipping
+// any that are not valid.
+func parseKinds(s []string) (k []uint16) {
+	for _, v := range s {
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			log.W.F("invalid kind %q in write policy configuration", v)
+			continue
+		}
+		k = append(k, uint16(n))
+	}
+	return
+}
+
+// parseKindDifficulties converts a list of kind:difficulty pairs from the
+// configuration, skipping any that are not valid.
+func parseKindDifficulties(s []string) (kd map[uint16]int) {
+	kd = make(map[uint16]int)
+	for _, v := range s {
+		if v == "" {
+			continue
+		}
+		ks, ds, _ := strings.Cut(v, ":")
+		k, err := strconv.ParseUint(ks, 10, 16)
+		if err != nil {
+			log.W.F("invalid kind %q in proof of work configuration", v)
+			continue
+		}
+		var d int
+		if d, err = strconv.Atoi(ds); err != nil {
+			log.W.F("invalid difficulty %q in proof of work configuration", v)
+			continue
+		}
+		kd[uint16(k)] = d
+	}
+	return
+}
+
+// parsePubkeys converts a list of pubkeys in hex or npub format from the
+// configuration, skipping any that are not valid.
+func parsePubkeys(s []string) (pks [][]byte) {
+	for _, v := range s {
+		if v == "" {
+			continue
+		}
+		var pk []byte
+		var err error
+		if strings.HasPrefix(v, "npub1") {
+			pk, err = bech32encoding.NpubToBytes([]byte(v))
+		} else {
+			pk, err = hex.Dec(v)
+		}
+		if err != nil || len(pk) != 32 {
+			log.W.F("invalid pubkey %q in write policy configuration", v)
+			continue
+		}
+		pks = append(pks, pk)
+	}
+	return
+}
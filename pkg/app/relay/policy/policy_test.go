@@ -0,0 +1,195 @@
+package policy
+
+import (
+	"bytes"
+	"net/http"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/normalize"
+	"testing"
+	"time"
+)
+
+func newEvent() (ev *event.E) {
+	ev = event.New()
+	ev.ID = make([]byte, 32)
+	ev.ID[0] = 0xff
+	ev.Pubkey = make([]byte, 32)
+	ev.Kind = kind.TextNote
+	ev.CreatedAt = timestamp.Now()
+	ev.Content = []byte("hello")
+	ev.Tags = tags.New(tag.New("t", "nostr"))
+	return
+}
+
+func TestChain(t *testing.T) {
+	ctx := context.Bg()
+	cfg := &config.C{
+		PolicyKindsDeny:        []string{"4"},
+		PolicyMaxContentLength: 10,
+		PolicyMaxTags:          1,
+		PolicyMaxTagLength:     16,
+		PolicyCreatedAtPast:    time.Hour,
+		PolicyCreatedAtFuture:  time.Minute,
+		PolicyPublishLimit:     2,
+		PolicyPublishWindow:    time.Hour,
+	}
+	ch := FromConfig(cfg)
+	if len(ch) != 4 {
+		t.Fatalf("got %d policies, expected 4", len(ch))
+	}
+	tests := []struct {
+		name   string
+		modify func(ev *event.E)
+		prefix normalize.Reason
+	}{
+		{"accepted", func(ev *event.E) {}, nil},
+		{
+			"denied kind",
+			func(ev *event.E) { ev.Kind = kind.EncryptedDirectMessage },
+			normalize.Blocked,
+		},
+		{
+			"content too long",
+			func(ev *event.E) { ev.Content = []byte("hello world!") },
+			normalize.Invalid,
+		},
+		{
+			"too many tags",
+			func(ev *event.E) { ev.Tags.AppendTags(tag.New("t", "other")) },
+			normalize.Invalid,
+		},
+		{
+			"tag too long",
+			func(ev *event.E) {
+				ev.Tags = tags.New(tag.New("t", "a very long hashtag"))
+			},
+			normalize.Invalid,
+		},
+		{
+			"too old",
+			func(ev *event.E) {
+				ev.CreatedAt = timestamp.FromTime(time.Now().Add(-2 * time.Hour))
+			},
+			normalize.Invalid,
+		},
+		{
+			"in the future",
+			func(ev *event.E) {
+				ev.CreatedAt = timestamp.FromTime(time.Now().Add(time.Hour))
+			},
+			normalize.Invalid,
+		},
+		{"second accepted", func(ev *event.E) {}, nil},
+		{"over the publish limit", func(ev *event.E) {}, normalize.RateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				ev := newEvent()
+				tt.modify(ev)
+				accept, reason := ch.Accept(ctx, ev, nil, "127.0.0.1", nil)
+				if tt.prefix == nil {
+					if !accept {
+						t.Fatalf("event was rejected: %s", reason)
+					}
+					return
+				}
+				if accept {
+					t.Fatalf("event was accepted")
+				}
+				if !tt.prefix.IsPrefix(reason) {
+					t.Fatalf("reason %s, expected prefix %s", reason, tt.prefix)
+				}
+			},
+		)
+	}
+}
+
+func TestKindsAllow(t *testing.T) {
+	p := &Kinds{Allow: parseKinds([]string{"1", "x", "30023"})}
+	ev := newEvent()
+	if accept, reason := p.Accept(
+		context.Bg(), ev, nil, "", nil,
+	); !accept {
+		t.Fatalf("kind 1 was rejected: %s", reason)
+	}
+	ev.Kind = kind.Reaction
+	if accept, _ := p.Accept(context.Bg(), ev, nil, "", nil); accept {
+		t.Fatalf("kind 7 was accepted")
+	}
+}
+
+func TestPoW(t *testing.T) {
+	p := &PoW{MinDifficulty: 12}
+	ev := newEvent()
+	ev.ID[0], ev.ID[1] = 0, 0x0f
+	if accept, reason := p.Accept(
+		context.Bg(), ev, nil, "", nil,
+	); !accept {
+		t.Fatalf("difficulty 12 was rejected: %s", reason)
+	}
+	ev.ID[1] = 0x10
+	accept, reason := p.Accept(context.Bg(), ev, nil, "", nil)
+	if accept || !normalize.PoW.IsPrefix(reason) {
+		t.Fatalf("difficulty 11 was not rejected: %s", reason)
+	}
+	// a lower committed target is rejected even if the id is difficult enough
+	ev.ID[1] = 0x0f
+	ev.Tags = tags.New(tag.New("nonce", "1", "8"))
+	if accept, _ = p.Accept(context.Bg(), ev, nil, "", nil); accept {
+		t.Fatalf("committed target 8 was accepted")
+	}
+	ev.Tags = tags.New(tag.New("nonce", "1", "12"))
+	// per kind and whitelisted pubkey difficulties
+	p = FromConfig(
+		&config.C{
+			PolicyMinPow:            12,
+			PolicyMinPowKinds:       []string{"7:4"},
+			PolicyPowWhitelist:      []string{hex.Enc(ev.Pubkey)},
+			PolicyMinPowWhitelisted: 2,
+		},
+	)[0].(*PoW)
+	ev.Kind = kind.Reaction
+	if d := p.Required(ev); d != 2 {
+		t.Fatalf("whitelisted pubkey requires %d", d)
+	}
+	ev.Pubkey = bytes.Repeat([]byte{1}, 32)
+	if d := p.Required(ev); d != 4 {
+		t.Fatalf("kind 7 requires %d", d)
+	}
+	ev.Kind = kind.TextNote
+	if d := p.Required(ev); d != 12 {
+		t.Fatalf("kind 1 requires %d", d)
+	}
+}
+
+func TestFunc(t *testing.T) {
+	ch := Chain{
+		Func(
+			func(
+				c context.T, ev *event.E, authedPubkey []byte, remote string,
+				hr *http.Request,
+			) (accept bool, reason []byte) {
+				if bytes.Contains(ev.Content, []byte("spam")) {
+					return false, normalize.Blocked.F("no spam")
+				}
+				return true, nil
+			},
+		),
+	}
+	ev := newEvent()
+	if accept, _ := ch.Accept(context.Bg(), ev, nil, "", nil); !accept {
+		t.Fatal("event was rejected")
+	}
+	ev.Content = []byte("spam spam spam")
+	if accept, _ := ch.Accept(context.Bg(), ev, nil, "", nil); accept {
+		t.Fatal("spam was accepted")
+	}
+}
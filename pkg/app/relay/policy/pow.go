@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"bytes"
+	"net/http"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/normalize"
+)
+
+// PoW is a write policy that requires events to carry NIP-13 proof of work.
+type PoW struct {
+	// MinDifficulty is the minimum number of leading zero bits of the event
+	// id.
+	MinDifficulty int
+	// Kinds overrides MinDifficulty for specific kinds.
+	Kinds map[uint16]int
+	// Whitelist is a list of pubkeys whose events only need
+	// WhitelistDifficulty, if it is lower than the difficulty otherwise
+	// required.
+	Whitelist [][]byte
+	// WhitelistDifficulty is the minimum difficulty of events published by
+	// pubkeys in the Whitelist.
+	WhitelistDifficulty int
+}
+
+// Required returns the difficulty an event needs to be accepted.
+func (p *PoW) Required(ev *event.E) (d int) {
+	d = p.MinDifficulty
+	if kd, ok := p.Kinds[ev.Kind.ToU16()]; ok {
+		d = kd
+	}
+	if p.WhitelistDifficulty < d {
+		for _, pk := range p.Whitelist {
+			if bytes.Equal(pk, ev.Pubkey) {
+				d = p.WhitelistDifficulty
+				break
+			}
+		}
+	}
+	return
+}
+
+// Accept rejects events with an id of lower difficulty than required, or that
+// commit in their nonce tag to a target lower than required, as the id may
+// have met the difficulty by chance.
+func (p *PoW) Accept(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte) {
+	required := p.Required(ev)
+	if required <= 0 {
+		accept = true
+		return
+	}
+	if d := ev.Difficulty(); d < required {
+		reason = normalize.PoW.F(
+			"difficulty %d is less than %d", d, required,
+		)
+		return
+	}
+	if target, ok := ev.PowTarget(); ok && target < required {
+		reason = normalize.PoW.F(
+			"committed target %d is less than %d", target, required,
+		)
+		return
+	}
+	accept = true
+	return
+}
@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"net/http"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/normalize"
+	"sync"
+	"time"
+)
+
+// PublishLimit is a write policy that limits the number of events each pubkey
+// may publish in a period of time.
+//
+// Counts are kept in memory only, and are reset when the relay restarts.
+type PublishLimit struct {
+	// Max is the number of events a pubkey may publish in each Window.
+	Max int
+	// Window is the length of the period the limit applies to.
+	Window time.Duration
+	sync.Mutex
+	counts map[string]*publishCount
+	pruned time.Time
+}
+
+type publishCount struct {
+	start time.Time
+	n     int
+}
+
+// NewPublishLimit creates a PublishLimit allowing max events per pubkey in
+// each window.
+func NewPublishLimit(max int, window time.Duration) (p *PublishLimit) {
+	return &PublishLimit{
+		Max:    max,
+		Window: window,
+		counts: make(map[string]*publishCount),
+		pruned: time.Now(),
+	}
+}
+
+// Accept rejects events from pubkeys that have already published Max events
+// in the current Window, and otherwise counts the event.
+func (p *PublishLimit) Accept(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte) {
+	p.Lock()
+	defer p.Unlock()
+	now := time.Now()
+	if now.Sub(p.pruned) > p.Window {
+		// forget pubkeys whose window has ended so the map does not grow
+		// without bound.
+		for k, v := range p.counts {
+			if now.Sub(v.start) > p.Window {
+				delete(p.counts, k)
+			}
+		}
+		p.pruned = now
+	}
+	qc, ok := p.counts[string(ev.Pubkey)]
+	if !ok || now.Sub(qc.start) > p.Window {
+		qc = &publishCount{start: now}
+		p.counts[string(ev.Pubkey)] = qc
+	}
+	if qc.n >= p.Max {
+		reason = normalize.RateLimited.F(
+			"pubkey has published %d events in the last %v", qc.n, p.Window,
+		)
+		return
+	}
+	qc.n++
+	accept = true
+	return
+}
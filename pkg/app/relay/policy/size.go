@@ -0,0 +1,63 @@
+package policy
+
+import (
+	"net/http"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/normalize"
+	"unicode/utf8"
+)
+
+// Size is a write policy that limits the size of the content and tags of an
+// event. A limit of zero is not checked.
+type Size struct {
+	// MaxContentLength is the maximum length of the content in characters, as
+	// defined in NIP-11.
+	MaxContentLength int
+	// MaxTags is the maximum number of tags.
+	MaxTags int
+	// MaxTagLength is the maximum length in bytes of all the fields of a
+	// single tag together.
+	MaxTagLength int
+}
+
+// Accept rejects events that exceed any of the limits.
+func (p *Size) Accept(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte) {
+	if p.MaxContentLength > 0 {
+		if n := utf8.RuneCount(ev.Content); n > p.MaxContentLength {
+			reason = normalize.Invalid.F(
+				"content is %d characters, maximum is %d", n,
+				p.MaxContentLength,
+			)
+			return
+		}
+	}
+	if ev.Tags != nil {
+		if p.MaxTags > 0 && ev.Tags.Len() > p.MaxTags {
+			reason = normalize.Invalid.F(
+				"event has %d tags, maximum is %d", ev.Tags.Len(), p.MaxTags,
+			)
+			return
+		}
+		if p.MaxTagLength > 0 {
+			for _, t := range ev.Tags.ToSliceOfTags() {
+				var l int
+				for _, f := range t.ToSliceOfBytes() {
+					l += len(f)
+				}
+				if l > p.MaxTagLength {
+					reason = normalize.Invalid.F(
+						"tag %s is %d bytes, maximum is %d", t.Key(), l,
+						p.MaxTagLength,
+					)
+					return
+				}
+			}
+		}
+	}
+	accept = true
+	return
+}
@@ -0,0 +1,93 @@
+package relay
+
+import (
+	"net"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/utils/ratelimit"
+)
+
+// RateLimits are the token bucket limits on EVENT submissions, REQ
+// subscriptions and bytes read from clients. A nil Limiter is not enforced.
+type RateLimits struct {
+	Events *ratelimit.Limiter
+	Reqs   *ratelimit.Limiter
+	Bytes  *ratelimit.Limiter
+	// MemberScale is the multiplier of the limits for users followed by the
+	// owners or their follows.
+	MemberScale float64
+}
+
+// NewRateLimits creates the RateLimits enabled in the configuration.
+func NewRateLimits(cfg *config.C) (rl *RateLimits) {
+	rl = &RateLimits{MemberScale: float64(cfg.RateLimitMemberScale)}
+	burst := cfg.RateLimitBurst.Seconds()
+	if cfg.RateLimitEvents > 0 {
+		rate := float64(cfg.RateLimitEvents) / 60
+		rl.Events = ratelimit.New(rate, rate*burst)
+	}
+	if cfg.RateLimitReqs > 0 {
+		rate := float64(cfg.RateLimitReqs) / 60
+		rl.Reqs = ratelimit.New(rate, rate*burst)
+	}
+	if cfg.RateLimitBytes > 0 {
+		rate := float64(cfg.RateLimitBytes)
+		rl.Bytes = ratelimit.New(rate, rate*burst)
+	}
+	return
+}
+
+// AllowEvent returns false if the client has submitted too many events.
+func (s *Server) AllowEvent(remote string, authedPubkey []byte) bool {
+	if s.rateLimits == nil {
+		return true
+	}
+	return s.allow(s.rateLimits.Events, remote, authedPubkey, 1)
+}
+
+// AllowReq returns false if the client has opened too many subscriptions.
+func (s *Server) AllowReq(remote string, authedPubkey []byte) bool {
+	if s.rateLimits == nil {
+		return true
+	}
+	return s.allow(s.rateLimits.Reqs, remote, authedPubkey, 1)
+}
+
+// AllowBytes returns false if the client has sent too much data. A message
+// larger than the burst of the limit is allowed once the buckets are full,
+// too large messages are refused by the MaxMessageLength. A length of zero or
+// less is allowed.
+func (s *Server) AllowBytes(remote string, authedPubkey []byte, n int) bool {
+	if s.rateLimits == nil || n <= 0 {
+		return true
+	}
+	return s.allow(s.rateLimits.Bytes, remote, authedPubkey, float64(n))
+}
+
+// allow takes n tokens from the buckets of the IP address of the client and,
+// if it is authed, its pubkey, only if both have enough. Owners are not limited, and users followed by
+// the owners or their follows get the MemberScale.
+func (s *Server) allow(
+	l *ratelimit.Limiter, remote string, authedPubkey []byte, n float64,
+) bool {
+	if l == nil {
+		return true
+	}
+	scale := 1.0
+	if len(authedPubkey) > 0 && s.Lists != nil {
+		if s.IsOwner(authedPubkey) {
+			return true
+		}
+		if s.IsFollowed(authedPubkey) {
+			scale = s.rateLimits.MemberScale
+		}
+	}
+	// clients share the bucket of their IP address whatever port they use
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	keys := []string{remote}
+	if len(authedPubkey) > 0 {
+		keys = append(keys, string(authedPubkey))
+	}
+	return l.AllowAll(keys, n, scale)
+}
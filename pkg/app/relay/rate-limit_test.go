@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"orly.dev/pkg/app/config"
+	"testing"
+	"time"
+)
+
+func TestRateLimits(t *testing.T) {
+	s := &Server{
+		Lists: new(Lists),
+		rateLimits: NewRateLimits(
+			&config.C{
+				RateLimitEvents:      60,
+				RateLimitBytes:       100,
+				RateLimitBurst:       5 * time.Second,
+				RateLimitMemberScale: 2,
+			},
+		),
+	}
+	s.conf.Store(&config.C{})
+	owner, member, other := []byte("owner"), []byte("member"), []byte("other")
+	s.SetOwnersPubkeys([][]byte{owner})
+	s.SetOwnersFollowed([][]byte{member})
+	count := func(remote string, pubkey []byte) (n int) {
+		for n < 100 && s.AllowEvent(remote, pubkey) {
+			n++
+		}
+		return
+	}
+	if n := count("1.2.3.4:1000", nil); n != 5 {
+		t.Fatalf("anonymous client sent %d events, expected 5", n)
+	}
+	// the IP address is limited whatever port is used
+	if s.AllowEvent("1.2.3.4:1001", nil) {
+		t.Fatal("another connection from the same IP was not limited")
+	}
+	// the pubkey is limited whatever IP address is used
+	if n := count("1.1.1.1:1000", other); n != 5 {
+		t.Fatalf("authed client sent %d events, expected 5", n)
+	}
+	if s.AllowEvent("2.2.2.2:1000", other) {
+		t.Fatal("the same pubkey from another IP was not limited")
+	}
+	// the refused event was not charged to the IP address
+	if n := count("2.2.2.2:1000", nil); n != 5 {
+		t.Fatalf("IP of a limited pubkey sent %d events, expected 5", n)
+	}
+	if n := count("3.3.3.3:1000", member); n != 10 {
+		t.Fatalf("member sent %d events, expected 10", n)
+	}
+	if n := count("4.4.4.4:1000", owner); n != 100 {
+		t.Fatalf("owner sent %d events, expected no limit", n)
+	}
+	// requests are not limited as no limit is configured
+	if !s.AllowReq("1.2.3.4:1000", nil) {
+		t.Fatal("request was limited")
+	}
+	if !s.AllowBytes("5.5.5.5:1000", nil, 500) ||
+		s.AllowBytes("5.5.5.5:1000", nil, 1) {
+		t.Fatal("bytes were not limited to the burst")
+	}
+}
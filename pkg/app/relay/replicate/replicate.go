@@ -0,0 +1,664 @@
+// Package replicate pushes the events accepted by the relay to its peer
+// relays, through an outbound queue for each peer that is stored in the
+// database, so events published while a peer is unreachable are delivered when
+// it comes back.
+//
+// Each peer has a worker that takes batches of events from the front of its
+// queue and posts each batch in one request to the Replicate method of the HTTP
+// API of the peer, removing them from the queue up to the last of the batch
+// once it is delivered. A failed delivery is retried with an exponential
+// backoff, and the events behind it wait, so a peer receives events in the
+// order they were accepted.
+//
+// A peer that responds to the Replicate method with 404 or 405, which is a
+// relay that doesn't have it, is sent the events one at a time through its
+// Event method instead, the way events were pushed to peers before.
+//
+// A peer that refuses the requests with a 4xx status, such as one that doesn't
+// authorize this relay, is parked after MaxRefusals attempts, and only tried
+// again after ParkTime. The queue of each peer is bounded by MaxQueue, beyond
+// which the oldest events are dropped.
+//
+// The queue of a peer that is removed is kept, and delivered if the peer is
+// added again, until it is dropped with Drop.
+package replicate
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/interfaces/signer"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/httpauth"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/version"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// BatchSize is the maximum number of events taken from a queue at once,
+	// and sent to a peer in one request.
+	BatchSize = 100
+	// MaxBatchBytes is the size limit of the body of a request with a batch of
+	// events, which a batch is cut short to fit in, unless it is one event.
+	MaxBatchBytes = 4 << 20
+	// MinBackoff is the delay before the first retry of a failed delivery.
+	MinBackoff = time.Second
+	// MaxBackoff is the longest delay between retries of a failed delivery.
+	MaxBackoff = 5 * time.Minute
+	// Timeout is the time limit of a request to a peer.
+	Timeout = 30 * time.Second
+	// MaxRefusals is the number of consecutive deliveries a peer refuses with
+	// a 4xx status after which it is parked.
+	MaxRefusals = 5
+	// ParkTime is the delay before a delivery is tried again to a parked peer.
+	ParkTime = time.Hour
+	// MaxQueue is the maximum number of events in the queue of a peer, beyond
+	// which the oldest are dropped.
+	MaxQueue = 100000
+)
+
+var userAgent = fmt.Sprintf("orly/%s", version.V)
+
+// ErrConfigured is the error of dropping the queue of a peer that is still
+// replicated to.
+var ErrConfigured = errors.New("peer is still configured")
+
+// Peer is a peer relay that events are replicated to.
+type Peer struct {
+	// Address is the base URL of the peer.
+	Address string
+	// Pubkey is the identity of the peer.
+	Pubkey []byte
+}
+
+// Item is an event replicated to a peer, in a line of the JSONL body of a
+// request to its Replicate method.
+type Item struct {
+	Via   []string        `json:"via,omitempty" doc:"pubkeys in hex of the relays the event has already passed through"`
+	Event json.RawMessage `json:"event" doc:"the event"`
+}
+
+// Rejection is an event of a batch that a peer refused.
+type Rejection struct {
+	Id     string `json:"id" doc:"id of the event in hex"`
+	Reason string `json:"reason" doc:"why the event was refused"`
+}
+
+// Result is the response of a peer to a batch of events.
+type Result struct {
+	Accepted int         `json:"accepted" doc:"number of events of the batch that were accepted"`
+	Rejected []Rejection `json:"rejected,omitempty" doc:"the events of the batch that were refused, which are not sent again"`
+}
+
+// PeerState is the state of the replication to a peer.
+type PeerState struct {
+	Address      string `json:"address" doc:"base URL of the peer"`
+	Pubkey       string `json:"pubkey" doc:"pubkey of the peer in hex"`
+	Healthy      bool   `json:"healthy" doc:"false if the last delivery to the peer failed"`
+	Queued       int    `json:"queued" doc:"number of events waiting to be delivered"`
+	Lag          int64  `json:"lag" doc:"seconds the oldest waiting event has been queued"`
+	Delivered    uint64 `json:"delivered" doc:"number of events delivered since the relay started"`
+	Rejected     uint64 `json:"rejected" doc:"number of events the peer refused since the relay started, which are not retried"`
+	Failures     int    `json:"failures" doc:"number of consecutive failed deliveries"`
+	LastDelivery int64  `json:"last_delivery,omitempty" doc:"unix time of the last delivery"`
+	LastError    string `json:"last_error,omitempty" doc:"error of the last failed delivery"`
+	LastErrorAt  int64  `json:"last_error_at,omitempty" doc:"unix time of the last failed delivery"`
+	NextAttempt  int64  `json:"next_attempt,omitempty" doc:"unix time of the next retry, while the peer is failing"`
+	Parked       bool   `json:"parked" doc:"true if the peer refused too many deliveries, and is only retried after a long delay"`
+	Dropped      uint64 `json:"dropped" doc:"number of events dropped from the full queue since the relay started"`
+	Legacy       bool   `json:"legacy" doc:"true if the peer doesn't have the Replicate method, and events are posted to its Event method one at a time"`
+	Removed      bool   `json:"removed" doc:"true if the peer was removed, and its queue is kept until it is dropped"`
+}
+
+// S replicates events to the peers of a relay.
+type S struct {
+	ctx    context.T
+	queue  store.Replicator
+	client *http.Client
+	mx     sync.Mutex
+	sign   signer.I
+	peers  map[string]*worker
+	// the limits, which are the constants of the same names except in tests.
+	maxQueue   int
+	minBackoff time.Duration
+	parkTime   time.Duration
+}
+
+// New creates a new replicator for events queued in a store. The workers stop
+// when the context is cancelled.
+func New(c context.T, queue store.Replicator) (s *S) {
+	return &S{
+		ctx:        c,
+		queue:      queue,
+		client:     &http.Client{Timeout: Timeout},
+		peers:      make(map[string]*worker),
+		maxQueue:   MaxQueue,
+		minBackoff: MinBackoff,
+		parkTime:   ParkTime,
+	}
+}
+
+// SetPeers sets the peers events are replicated to, and the signer used to
+// authenticate to them. Workers are started for new peers, and stopped for
+// peers that are removed, whose queues are kept until they are dropped with
+// Drop.
+func (s *S) SetPeers(peers []Peer, sign signer.I) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.sign = sign
+	keep := make(map[string]struct{})
+	for _, p := range peers {
+		k := hex.EncodeToString(p.Pubkey)
+		keep[k] = struct{}{}
+		if w, ok := s.peers[k]; ok && !w.removed {
+			if w.Address == p.Address {
+				continue
+			}
+			w.cancel()
+		}
+		w := &worker{s: s, Peer: p, notify: make(chan struct{}, 1)}
+		var err error
+		w.queued, _, err = s.queue.ReplicationQueueLen(p.Pubkey)
+		chk.E(err)
+		var c context.T
+		c, w.cancel = context.Cancel(s.ctx)
+		s.peers[k] = w
+		go w.run(c)
+	}
+	for k, w := range s.peers {
+		if _, ok := keep[k]; ok || w.removed {
+			continue
+		}
+		w.cancel()
+		w.removed = true
+		log.I.F(
+			"stopped replication to removed peer %s, its queue is kept until "+
+				"it is dropped", w.Address,
+		)
+	}
+}
+
+// Drop removes the queue of a peer that is not replicated to, which is kept
+// after the peer is removed in case it is added again. ErrConfigured is
+// returned if the peer is still replicated to.
+func (s *S) Drop(pubkey []byte) (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	k := hex.EncodeToString(pubkey)
+	if w, ok := s.peers[k]; ok {
+		if !w.removed {
+			return errorf.E("%w: %s", ErrConfigured, w.Address)
+		}
+		delete(s.peers, k)
+	}
+	return s.queue.DropReplication(pubkey)
+}
+
+// Enqueue adds an event to the queues of the peers that it has not already
+// passed through, which are the ones with the pubkeys in via.
+func (s *S) Enqueue(ev *event.E, via [][]byte) (err error) {
+	s.mx.Lock()
+	var peers [][]byte
+	var workers []*worker
+	if s.sign != nil {
+		// the peers don't send the event back to this relay.
+		via = append(via[:len(via):len(via)], s.sign.Pub())
+	}
+next:
+	for _, w := range s.peers {
+		if w.removed {
+			continue
+		}
+		for _, pk := range via {
+			if bytes.Equal(w.Pubkey, pk) {
+				log.T.F(
+					"not sending back to replica that just sent us this "+
+						"event %0x %s", ev.ID, w.Address,
+				)
+				continue next
+			}
+		}
+		peers = append(peers, w.Pubkey)
+		workers = append(workers, w)
+	}
+	s.mx.Unlock()
+	if len(peers) == 0 {
+		return
+	}
+	if err = s.queue.EnqueueReplication(
+		peers, &store.ReplicationItem{
+			Queued: time.Now().Unix(), Via: via, Event: ev.Marshal(nil),
+		},
+	); chk.E(err) {
+		return
+	}
+	for _, w := range workers {
+		w.added()
+		w.wake()
+	}
+	return
+}
+
+// State returns the state of the replication to each peer.
+func (s *S) State() (states []PeerState) {
+	s.mx.Lock()
+	workers := make([]*worker, 0, len(s.peers))
+	removed := make([]bool, 0, len(s.peers))
+	for _, w := range s.peers {
+		workers = append(workers, w)
+		removed = append(removed, w.removed)
+	}
+	s.mx.Unlock()
+	now := time.Now().Unix()
+	for i, w := range workers {
+		w.mx.Lock()
+		st := w.state
+		w.mx.Unlock()
+		st.Address, st.Pubkey = w.Address, hex.EncodeToString(w.Pubkey)
+		st.Removed = removed[i]
+		st.Healthy = st.Failures == 0
+		var oldest int64
+		var err error
+		if st.Queued, oldest, err = s.queue.ReplicationQueueLen(
+			w.Pubkey,
+		); chk.E(err) {
+			continue
+		}
+		if st.Queued > 0 {
+			st.Lag = max(now-oldest, 0)
+		}
+		states = append(states, st)
+	}
+	return
+}
+
+func (s *S) signer() (sign signer.I) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.sign
+}
+
+// worker delivers the queue of a peer.
+type worker struct {
+	Peer
+	s      *S
+	cancel context.F
+	notify chan struct{}
+	// removed is true once the peer is removed, which is guarded by the mx of
+	// S.
+	removed bool
+	// legacy is true once the peer has responded that it doesn't have the
+	// Replicate method, which is only used by the worker.
+	legacy bool
+	mx     sync.Mutex
+	state  PeerState
+	// queued is the number of items in the queue, and acked the Seq of the
+	// last item removed from it, which are kept in step by qmx.
+	qmx    sync.Mutex
+	queued int
+	acked  uint64
+}
+
+// added counts an item added to the queue, and drops the oldest items if the
+// queue is then longer than the limit.
+func (w *worker) added() {
+	w.qmx.Lock()
+	defer w.qmx.Unlock()
+	w.queued++
+	over := w.queued - w.s.maxQueue
+	if over <= 0 {
+		return
+	}
+	items, err := w.s.queue.ReplicationBatch(w.Pubkey, over)
+	if chk.E(err) || len(items) == 0 {
+		return
+	}
+	if err = w.s.queue.AckReplication(
+		w.Pubkey, items[len(items)-1].Seq,
+	); chk.E(err) {
+		return
+	}
+	w.queued -= len(items)
+	w.acked = items[len(items)-1].Seq
+	w.mx.Lock()
+	w.state.Dropped += uint64(len(items))
+	w.mx.Unlock()
+	log.W.F(
+		"replication queue of %s is full, dropped %d events", w.Address,
+		len(items),
+	)
+}
+
+// ack removes the items of the queue up to the last of a batch that was sent,
+// some of which may already have been dropped.
+func (w *worker) ack(sent []*store.ReplicationItem) {
+	w.qmx.Lock()
+	defer w.qmx.Unlock()
+	seq := sent[len(sent)-1].Seq
+	if seq <= w.acked {
+		return
+	}
+	if chk.E(w.s.queue.AckReplication(w.Pubkey, seq)) {
+		return
+	}
+	for _, item := range sent {
+		if item.Seq > w.acked {
+			w.queued--
+		}
+	}
+	w.acked = seq
+}
+
+// wake signals the worker that events have been queued.
+func (w *worker) wake() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *worker) run(c context.T) {
+	backoff := w.s.minBackoff
+	var refusals int
+	for {
+		items, err := w.s.queue.ReplicationBatch(w.Pubkey, BatchSize)
+		if chk.E(err) {
+			items = nil
+		}
+		if len(items) == 0 {
+			select {
+			case <-c.Done():
+				return
+			case <-w.notify:
+			}
+			continue
+		}
+		var res *Result
+		var sent []*store.ReplicationItem
+		var refused bool
+		if res, sent, refused, err = w.deliver(c, items); err == nil {
+			// the batch is acked by the last of its events.
+			w.ack(sent)
+			rejected := min(len(res.Rejected), len(sent))
+			backoff, refusals = w.s.minBackoff, 0
+			w.mx.Lock()
+			w.state.Rejected += uint64(rejected)
+			w.state.Delivered += uint64(len(sent) - rejected)
+			w.state.LastDelivery = time.Now().Unix()
+			w.state.Failures, w.state.NextAttempt = 0, 0
+			w.state.Parked = false
+			w.mx.Unlock()
+			continue
+		}
+		if c.Err() != nil {
+			return
+		}
+		// a peer that keeps refusing the requests won't accept them until it
+		// is changed, so it is parked rather than retried every MaxBackoff.
+		wait := backoff
+		if refused {
+			refusals++
+		} else {
+			refusals = 0
+		}
+		parked := refusals >= MaxRefusals
+		if parked {
+			wait = w.s.parkTime
+		}
+		w.mx.Lock()
+		w.state.Failures++
+		w.state.LastError = err.Error()
+		w.state.LastErrorAt = time.Now().Unix()
+		w.state.NextAttempt = time.Now().Add(wait).Unix()
+		w.state.Parked = parked
+		w.mx.Unlock()
+		if parked {
+			log.W.F(
+				"replication to %s parked for %v after %d refusals: %v",
+				w.Address, wait, refusals, err,
+			)
+		} else {
+			log.W.F(
+				"replication to %s failed, retrying in %v: %v", w.Address,
+				wait, err,
+			)
+		}
+		select {
+		case <-c.Done():
+			return
+		case <-time.After(wait):
+		}
+		if !parked {
+			backoff = min(backoff*2, MaxBackoff)
+		}
+	}
+}
+
+// errNoReplicate is the error of a peer that doesn't have the Replicate
+// method.
+var errNoReplicate = errors.New("peer doesn't have the Replicate method")
+
+// deliver sends a batch of events to the peer, and returns the result and the
+// items that were sent. The events the peer refuses are in the result, and are
+// not retried.
+//
+// The batch is posted to the Replicate method of the peer, unless the peer
+// has responded that it doesn't have it, after which the events are posted to
+// its Event method until the worker is restarted.
+//
+// An error is returned if the batch was not delivered, and refused is true if
+// the peer responded with a 4xx status other than a timeout or rate limit,
+// which won't succeed until the peer is changed, rather than failing in a way
+// that may succeed later, such as when it can't be reached or has an error.
+func (w *worker) deliver(c context.T, items []*store.ReplicationItem) (
+	res *Result, sent []*store.ReplicationItem, refused bool, err error,
+) {
+	if !w.legacy {
+		res, sent, refused, err = w.deliverBatch(c, items)
+		if !errors.Is(err, errNoReplicate) {
+			return
+		}
+		log.I.F(
+			"%s doesn't have the Replicate method, posting events to its "+
+				"Event method", w.Address,
+		)
+		w.legacy = true
+		w.mx.Lock()
+		w.state.Legacy = true
+		w.mx.Unlock()
+	}
+	return w.deliverEvents(c, items)
+}
+
+// deliverBatch posts a batch of events to the Replicate method of the peer, as
+// many of the items as fit in MaxBatchBytes. errNoReplicate is returned if the
+// peer responds with 404 or 405.
+func (w *worker) deliverBatch(c context.T, items []*store.ReplicationItem) (
+	res *Result, sent []*store.ReplicationItem, refused bool, err error,
+) {
+	sign := w.s.signer()
+	if sign == nil {
+		err = errorf.E("relay has no identity to authenticate with")
+		return
+	}
+	var ur *url.URL
+	if ur, err = url.Parse(w.Address + "/api/replicate"); chk.E(err) {
+		return
+	}
+	body := new(bytes.Buffer)
+	for _, item := range items {
+		it := Item{Event: item.Event}
+		for _, pk := range item.Via {
+			it.Via = append(it.Via, hex.EncodeToString(pk))
+		}
+		var line []byte
+		if line, err = json.Marshal(it); chk.E(err) {
+			return
+		}
+		if len(sent) > 0 && body.Len()+len(line)+1 > MaxBatchBytes {
+			break
+		}
+		body.Write(line)
+		body.WriteByte('\n')
+		sent = append(sent, item)
+	}
+	var r *http.Request
+	if r, err = http.NewRequestWithContext(
+		c, http.MethodPost, ur.String(), body,
+	); chk.E(err) {
+		return
+	}
+	r.Header.Add("User-Agent", userAgent)
+	r.Header.Add("Content-Type", "application/jsonl")
+	if err = httpauth.AddNIP98Header(
+		r, ur, http.MethodPost, "", sign, 0,
+	); chk.E(err) {
+		return
+	}
+	var hr *http.Response
+	if hr, err = w.s.client.Do(r); err != nil {
+		return
+	}
+	defer hr.Body.Close()
+	res = new(Result)
+	switch {
+	case hr.StatusCode < 300:
+		if err = json.NewDecoder(hr.Body).Decode(res); err != nil {
+			err = errorf.E("%s responded with an invalid result: %v", ur, err)
+			return
+		}
+		log.T.F(
+			"%d events pushed to replica %s, %d rejected", len(sent), ur,
+			len(res.Rejected),
+		)
+		for _, rej := range res.Rejected {
+			log.W.F("replica %s rejected event %s: %s", ur, rej.Id, rej.Reason)
+		}
+	case hr.StatusCode == http.StatusRequestTimeout,
+		hr.StatusCode == http.StatusTooManyRequests,
+		hr.StatusCode >= 500:
+		err = errorf.E("%s responded %s", ur, hr.Status)
+	case hr.StatusCode == http.StatusNotFound,
+		hr.StatusCode == http.StatusMethodNotAllowed:
+		err = errorf.E("%w: %s responded %s", errNoReplicate, ur, hr.Status)
+	default:
+		// such as a peer that doesn't authorize this relay.
+		refused = true
+		err = errorf.E("%s refused the batch: %s", ur, hr.Status)
+	}
+	// the rest of the body is read so the connection can be reused.
+	_, _ = io.Copy(io.Discard, hr.Body)
+	return
+}
+
+// deliverEvents posts the events of a batch one at a time to the Event method
+// of the peer, with the relays they have passed through in the X-Pubkeys
+// header, and stops at the first that fails. The events that were sent before
+// it are returned without an error, so they are acked and the one that failed
+// is retried first.
+//
+// An event that the peer responds to with a 4xx status that is about the
+// event, rather than the request, is refused, and not retried.
+func (w *worker) deliverEvents(c context.T, items []*store.ReplicationItem) (
+	res *Result, sent []*store.ReplicationItem, refused bool, err error,
+) {
+	sign := w.s.signer()
+	if sign == nil {
+		err = errorf.E("relay has no identity to authenticate with")
+		return
+	}
+	var ur *url.URL
+	if ur, err = url.Parse(w.Address + "/api/event"); chk.E(err) {
+		return
+	}
+	res = new(Result)
+	for _, item := range items {
+		var reason string
+		if reason, refused, err = w.postEvent(c, ur, sign, item); err != nil {
+			break
+		}
+		if reason != "" {
+			var ev struct {
+				Id string `json:"id"`
+			}
+			_ = json.Unmarshal(item.Event, &ev)
+			res.Rejected = append(
+				res.Rejected, Rejection{Id: ev.Id, Reason: reason},
+			)
+			log.W.F("replica %s rejected event %s: %s", ur, ev.Id, reason)
+		} else {
+			res.Accepted++
+		}
+		sent = append(sent, item)
+	}
+	if len(sent) > 0 {
+		refused, err = false, nil
+		log.T.F("%d events pushed to replica %s", len(sent), ur)
+	}
+	return
+}
+
+// postEvent posts an event to the Event method of a peer, and returns the
+// reason the peer refused the event, if it did.
+func (w *worker) postEvent(
+	c context.T, ur *url.URL, sign signer.I, item *store.ReplicationItem,
+) (reason string, refused bool, err error) {
+	var r *http.Request
+	if r, err = http.NewRequestWithContext(
+		c, http.MethodPost, ur.String(), bytes.NewReader(item.Event),
+	); chk.E(err) {
+		return
+	}
+	r.Header.Add("User-Agent", userAgent)
+	r.Header.Add("Content-Type", "application/json")
+	if err = httpauth.AddNIP98Header(
+		r, ur, http.MethodPost, "", sign, 0,
+	); chk.E(err) {
+		return
+	}
+	var via []string
+	for _, pk := range item.Via {
+		via = append(via, hex.EncodeToString(pk))
+	}
+	r.Header.Add("X-Pubkeys", strings.Join(via, ":"))
+	var hr *http.Response
+	if hr, err = w.s.client.Do(r); err != nil {
+		return
+	}
+	defer hr.Body.Close()
+	switch {
+	case hr.StatusCode < 300:
+	case hr.StatusCode == http.StatusRequestTimeout,
+		hr.StatusCode == http.StatusTooManyRequests,
+		hr.StatusCode >= 500:
+		err = errorf.E("%s responded %s", ur, hr.Status)
+	case hr.StatusCode == http.StatusUnauthorized,
+		hr.StatusCode == http.StatusForbidden,
+		hr.StatusCode == http.StatusNotFound,
+		hr.StatusCode == http.StatusMethodNotAllowed:
+		refused = true
+		err = errorf.E("%s refused the event: %s", ur, hr.Status)
+	default:
+		// the event is invalid, or not accepted by the policy of the peer.
+		var b []byte
+		b, _ = io.ReadAll(io.LimitReader(hr.Body, 1024))
+		if reason = strings.TrimSpace(string(b)); reason == "" {
+			reason = hr.Status
+		}
+	}
+	// the rest of the body is read so the connection can be reused.
+	_, _ = io.Copy(io.Discard, hr.Body)
+	return
+}
@@ -0,0 +1,387 @@
+package replicate
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplicate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-db-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := database.New(ctx, cancel, tempDir, "info")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	sign, peerSign := new(p256k.Signer), new(p256k.Signer)
+	if err = sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	if err = peerSign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	// the peer is down for the first requests
+	var mx sync.Mutex
+	var requests int
+	var received []string
+	peer := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				mx.Lock()
+				defer mx.Unlock()
+				if requests++; requests <= 2 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				// the events are posted in batches of JSONL items.
+				var res Result
+				dec := json.NewDecoder(r.Body)
+				for dec.More() {
+					var item Item
+					if err := dec.Decode(&item); chk.E(err) {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					ev := event.New()
+					if _, err := ev.Unmarshal(item.Event); chk.E(err) {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					received = append(received, string(ev.Content))
+					res.Accepted++
+				}
+				_ = json.NewEncoder(w).Encode(res)
+			},
+		),
+	)
+	defer peer.Close()
+	s := New(ctx, db)
+	s.SetPeers([]Peer{{Address: peer.URL, Pubkey: peerSign.Pub()}}, sign)
+	var sent []string
+	for i := range 5 {
+		ev := event.New()
+		ev.Kind = kind.TextNote
+		ev.CreatedAt = timestamp.Now()
+		ev.Tags = tags.New()
+		ev.Content = []byte{'a' + byte(i)}
+		if err = ev.Sign(sign); chk.E(err) {
+			t.Fatal(err)
+		}
+		sent = append(sent, string(ev.Content))
+		if err = s.Enqueue(ev, nil); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+	// an event that came from the peer is not sent back to it
+	ev := event.New()
+	ev.Kind = kind.TextNote
+	ev.CreatedAt = timestamp.Now()
+	ev.Tags = tags.New()
+	ev.Content = []byte("from peer")
+	if err = ev.Sign(peerSign); chk.E(err) {
+		t.Fatal(err)
+	}
+	if err = s.Enqueue(ev, [][]byte{peerSign.Pub()}); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		states := s.State()
+		if len(states) != 1 {
+			t.Fatalf("Expected the state of 1 peer, got %d", len(states))
+		}
+		if states[0].Queued == 0 {
+			if !states[0].Healthy || states[0].Delivered != 5 {
+				t.Fatalf("Unexpected state after delivery %+v", states[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Events were not delivered %+v", states[0])
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	// the queued events are sent together once the peer is up.
+	if requests != 3 {
+		t.Fatalf("Expected the events in one request, got %d", requests-2)
+	}
+	if len(received) != len(sent) {
+		t.Fatalf("Expected %d events, got %d", len(sent), len(received))
+	}
+	for i := range sent {
+		if received[i] != sent[i] {
+			t.Fatalf("Expected events in order %v, got %v", sent, received)
+		}
+	}
+}
+
+func TestReplicateRefused(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	sign, peerSign := new(p256k.Signer), new(p256k.Signer)
+	if err = sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	if err = peerSign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	// the peer doesn't authorize this relay.
+	var mx sync.Mutex
+	var requests int
+	peer := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				mx.Lock()
+				requests++
+				mx.Unlock()
+				w.WriteHeader(http.StatusForbidden)
+			},
+		),
+	)
+	defer peer.Close()
+	s := New(ctx, db)
+	s.minBackoff, s.maxQueue = time.Millisecond, 3
+	s.SetPeers([]Peer{{Address: peer.URL, Pubkey: peerSign.Pub()}}, sign)
+	for i := range 5 {
+		ev := event.New()
+		ev.Kind = kind.TextNote
+		ev.CreatedAt = timestamp.Now()
+		ev.Tags = tags.New()
+		ev.Content = []byte{'a' + byte(i)}
+		if err = ev.Sign(sign); chk.E(err) {
+			t.Fatal(err)
+		}
+		if err = s.Enqueue(ev, nil); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		states := s.State()
+		if len(states) != 1 {
+			t.Fatalf("Expected the state of 1 peer, got %d", len(states))
+		}
+		if states[0].Parked {
+			// the refused events are kept for when the peer is changed, up
+			// to the limit of the queue.
+			if states[0].Queued != 3 || states[0].Dropped != 2 ||
+				states[0].Delivered != 0 {
+				t.Fatalf("Unexpected state after parking %+v", states[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Peer was not parked %+v", states[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// a parked peer is not tried again until ParkTime has passed.
+	time.Sleep(100 * time.Millisecond)
+	mx.Lock()
+	defer mx.Unlock()
+	if requests != MaxRefusals {
+		t.Fatalf("Expected %d requests, got %d", MaxRefusals, requests)
+	}
+}
+
+func TestReplicateLegacy(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	sign, peerSign, otherSign := new(p256k.Signer), new(p256k.Signer),
+		new(p256k.Signer)
+	for _, sg := range []*p256k.Signer{sign, peerSign, otherSign} {
+		if err = sg.Generate(); chk.E(err) {
+			t.Fatal(err)
+		}
+	}
+	// the peer only has the Event method, and refuses one of the events.
+	var mx sync.Mutex
+	var received []string
+	var headers []string
+	peer := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/event" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				b, err := io.ReadAll(r.Body)
+				if chk.E(err) {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				ev := event.New()
+				if _, err = ev.Unmarshal(b); chk.E(err) {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if string(ev.Content) == "c" {
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					return
+				}
+				mx.Lock()
+				defer mx.Unlock()
+				received = append(received, string(ev.Content))
+				headers = append(headers, r.Header.Get("X-Pubkeys"))
+			},
+		),
+	)
+	defer peer.Close()
+	s := New(ctx, db)
+	s.SetPeers([]Peer{{Address: peer.URL, Pubkey: peerSign.Pub()}}, sign)
+	for i := range 4 {
+		ev := event.New()
+		ev.Kind = kind.TextNote
+		ev.CreatedAt = timestamp.Now()
+		ev.Tags = tags.New()
+		ev.Content = []byte{'a' + byte(i)}
+		if err = ev.Sign(sign); chk.E(err) {
+			t.Fatal(err)
+		}
+		if err = s.Enqueue(ev, [][]byte{otherSign.Pub()}); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		st := s.State()[0]
+		if st.Queued == 0 {
+			if !st.Legacy || st.Delivered != 3 || st.Rejected != 1 {
+				t.Fatalf("Unexpected state after delivery %+v", st)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Events were not delivered %+v", st)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	if strings.Join(received, "") != "abd" {
+		t.Fatalf("Expected events a b d in order, got %v", received)
+	}
+	// the peer learns the relays the events passed through from the header.
+	via := hex.EncodeToString(otherSign.Pub()) + ":" +
+		hex.EncodeToString(sign.Pub())
+	for _, h := range headers {
+		if h != via {
+			t.Fatalf("Expected X-Pubkeys %s, got %s", via, h)
+		}
+	}
+}
+
+func TestReplicateRemoved(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	sign, peerSign := new(p256k.Signer), new(p256k.Signer)
+	if err = sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	if err = peerSign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	// the peer is down.
+	peer := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		),
+	)
+	defer peer.Close()
+	s := New(ctx, db)
+	peers := []Peer{{Address: peer.URL, Pubkey: peerSign.Pub()}}
+	s.SetPeers(peers, sign)
+	for i := range 3 {
+		ev := event.New()
+		ev.Kind = kind.TextNote
+		ev.CreatedAt = timestamp.Now()
+		ev.Tags = tags.New()
+		ev.Content = []byte{'a' + byte(i)}
+		if err = ev.Sign(sign); chk.E(err) {
+			t.Fatal(err)
+		}
+		if err = s.Enqueue(ev, nil); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+	if err = s.Drop(peerSign.Pub()); !errors.Is(err, ErrConfigured) {
+		t.Fatalf("Expected ErrConfigured dropping a configured peer, got %v", err)
+	}
+	// the queue of the removed peer is kept, and nothing more is queued.
+	s.SetPeers(nil, sign)
+	ev := event.New()
+	ev.Kind = kind.TextNote
+	ev.CreatedAt = timestamp.Now()
+	ev.Tags = tags.New()
+	ev.Content = []byte("after removal")
+	if err = ev.Sign(sign); chk.E(err) {
+		t.Fatal(err)
+	}
+	if err = s.Enqueue(ev, nil); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	states := s.State()
+	if len(states) != 1 || !states[0].Removed || states[0].Queued != 3 {
+		t.Fatalf("Unexpected state after removal %+v", states)
+	}
+	// a peer that is added again resumes from its queue.
+	s.SetPeers(peers, sign)
+	if states = s.State(); states[0].Removed || states[0].Queued != 3 {
+		t.Fatalf("Unexpected state after adding again %+v", states[0])
+	}
+	s.SetPeers(nil, sign)
+	if err = s.Drop(peerSign.Pub()); chk.E(err) {
+		t.Fatal(err)
+	}
+	if states = s.State(); len(states) != 0 {
+		t.Fatalf("Expected no peers after dropping, got %+v", states)
+	}
+	var n int
+	if n, _, err = db.ReplicationQueueLen(peerSign.Pub()); chk.E(err) {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("Expected the queue to be dropped, %d events remain", n)
+	}
+}
@@ -0,0 +1,486 @@
+// Package retention enforces declarative rules on how long events are kept
+// and how much each author may store, so the event store doesn't grow without
+// bound.
+//
+// The rules are a maximum age for events of a kind, a number of the newest
+// events of a kind that are kept for each author, a quota of events and bytes
+// for each author, and a budget for the size of the store on disk. A
+// background job deletes the events that break them through the DeleteEvent
+// method of the store, and S is also a write policy that rejects events that
+// would put an author over their quota.
+//
+// The quotas are checked against the usage of the authors that the store
+// keeps as it saves and deletes events, so they are only enforced with stores
+// that are a store.Meter. Only the events that are store.Metered count against
+// them, and only those are deleted to keep an author within them or the store
+// within its budget, which is only kept with stores that are a store.Evicter.
+package retention
+
+import (
+	"net/http"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/normalize"
+	"orly.dev/pkg/utils/units"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReserveTTL is how long the quota reserved for an event that was accepted is
+// held if it is not released after it is saved.
+var ReserveTTL = time.Minute
+
+// Rules are the retention rules of a relay. The zero value keeps everything.
+type Rules struct {
+	// MaxAge is the age after which events of each kind are deleted.
+	MaxAge map[uint16]time.Duration
+	// KeepLatest is the number of the newest events of each kind kept for
+	// each author.
+	KeepLatest map[uint16]int
+	// MaxEvents is the number of events each author may store.
+	MaxEvents int
+	// MaxBytes is the store.Size in bytes of the events that each author may
+	// store.
+	MaxBytes int64
+	// DiskBudget is the size in bytes of the data directory of the store
+	// above which the oldest events are deleted.
+	DiskBudget int64
+	// Interval is the time between enforcements of the rules.
+	Interval time.Duration
+}
+
+// FromConfig returns the Rules set in the configuration, skipping any that are
+// not valid.
+func FromConfig(cfg *config.C) (r Rules) {
+	r = Rules{
+		MaxAge:     make(map[uint16]time.Duration),
+		KeepLatest: make(map[uint16]int),
+		MaxEvents:  cfg.RetentionQuotaEvents,
+		MaxBytes:   int64(cfg.RetentionQuotaKB) * units.Kb,
+		DiskBudget: int64(cfg.RetentionDiskBudgetMB) * units.Mb,
+		Interval:   cfg.RetentionInterval,
+	}
+	for _, v := range cfg.RetentionMaxAge {
+		if v == "" {
+			continue
+		}
+		ks, ds, _ := strings.Cut(v, ":")
+		k, err := strconv.ParseUint(ks, 10, 16)
+		if err != nil {
+			log.W.F("invalid kind %q in retention configuration", v)
+			continue
+		}
+		var d time.Duration
+		if d, err = time.ParseDuration(ds); err != nil || d <= 0 {
+			log.W.F("invalid age %q in retention configuration", v)
+			continue
+		}
+		r.MaxAge[uint16(k)] = d
+	}
+	for _, v := range cfg.RetentionKeepLatest {
+		if v == "" {
+			continue
+		}
+		ks, ns, _ := strings.Cut(v, ":")
+		k, err := strconv.ParseUint(ks, 10, 16)
+		if err != nil {
+			log.W.F("invalid kind %q in retention configuration", v)
+			continue
+		}
+		var n int
+		if n, err = strconv.Atoi(ns); err != nil || n < 0 {
+			log.W.F("invalid count %q in retention configuration", v)
+			continue
+		}
+		r.KeepLatest[uint16(k)] = n
+	}
+	return
+}
+
+// Quotas returns true if the authors have a quota.
+func (r Rules) Quotas() bool { return r.MaxEvents > 0 || r.MaxBytes > 0 }
+
+// Enabled returns true if any rule is set.
+func (r Rules) Enabled() bool {
+	return len(r.MaxAge) > 0 || len(r.KeepLatest) > 0 || r.Quotas() ||
+		r.DiskBudget > 0
+}
+
+// S enforces the retention Rules on an event store.
+type S struct {
+	ctx   context.T
+	sto   store.I
+	rules Rules
+	// Exempt returns true for authors that have no quota, such as the owners
+	// of the relay.
+	Exempt func(pubkey []byte) bool
+	// meter is the usage of the authors, if the store keeps it.
+	meter store.Meter
+	mx    sync.Mutex
+	// reserved are the events that were accepted and are not saved yet, by
+	// id, which count against the quota of their author until they are
+	// released.
+	reserved map[string]*reservation
+	// evicter is the store, if its oldest events can be deleted to keep it
+	// within the DiskBudget.
+	evicter store.Evicter
+	// pruned is the size of the store on disk when the oldest events were
+	// last deleted to keep it within the DiskBudget.
+	pruned int64
+}
+
+// reservation is the quota reserved for an event that was accepted.
+type reservation struct {
+	pubkey string
+	bytes  int64
+	at     time.Time
+}
+
+// New creates an S that enforces the Rules on an event store.
+func New(c context.T, sto store.I, r Rules) (s *S) {
+	s = &S{
+		ctx: c, sto: sto, rules: r,
+		reserved: make(map[string]*reservation),
+	}
+	var ok bool
+	if s.meter, ok = sto.(store.Meter); !ok && r.Quotas() {
+		log.W.F(
+			"the event store doesn't keep the usage of the authors, the " +
+				"quotas are not enforced",
+		)
+	}
+	if s.evicter, ok = sto.(store.Evicter); !ok && r.DiskBudget > 0 {
+		log.W.F(
+			"the event store is not on disk, the disk budget is not " +
+				"enforced",
+		)
+	}
+	return
+}
+
+// Run enforces the Rules every Interval until the context is cancelled.
+func (s *S) Run() {
+	if s.rules.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.rules.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.Enforce()
+			if err != nil {
+				log.E.F("failed to enforce the retention rules: %v", err)
+			}
+			if n > 0 {
+				log.I.F("deleted %d events by the retention rules", n)
+			}
+		}
+	}
+}
+
+// Accept rejects events that would put their author over the quota of events
+// or bytes, counting the events that were accepted and are not saved yet, and
+// reserves the quota for the event until it is released.
+func (s *S) Accept(
+	c context.T, ev *event.E, authedPubkey []byte, remote string,
+	hr *http.Request,
+) (accept bool, reason []byte) {
+	accept = true
+	if !s.rules.Quotas() || s.meter == nil || !store.Metered(ev) ||
+		(s.Exempt != nil && s.Exempt(ev.Pubkey)) {
+		return
+	}
+	size := store.Size(ev)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	// the usage is read with the lock held, so an event that is released
+	// after it is saved is counted by the store if it isn't reserved.
+	u, ok := s.meter.Usage(ev.Pubkey)
+	if !ok {
+		// the usage is still being counted, which is not the fault of the
+		// author.
+		return
+	}
+	now := time.Now()
+	for id, r := range s.reserved {
+		if now.Sub(r.at) >= ReserveTTL {
+			delete(s.reserved, id)
+			continue
+		}
+		if r.pubkey == string(ev.Pubkey) {
+			u.Events++
+			u.Bytes += r.bytes
+		}
+	}
+	switch {
+	case s.rules.MaxEvents > 0 && u.Events+1 > s.rules.MaxEvents:
+		accept = false
+		reason = normalize.Blocked.F(
+			"storage quota exceeded, pubkey has %d events stored of %d",
+			u.Events, s.rules.MaxEvents,
+		)
+	case s.rules.MaxBytes > 0 && u.Bytes+size > s.rules.MaxBytes:
+		accept = false
+		reason = normalize.Blocked.F(
+			"storage quota exceeded, pubkey has %d bytes stored of %d",
+			u.Bytes, s.rules.MaxBytes,
+		)
+	default:
+		s.reserved[string(ev.ID)] = &reservation{
+			pubkey: string(ev.Pubkey), bytes: size, at: now,
+		}
+	}
+	return
+}
+
+// Release releases the quota reserved for an event when it was accepted, once
+// it has been saved, and is counted by the store, or has failed to be.
+func (s *S) Release(ev *event.E) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.reserved, string(ev.ID))
+}
+
+// Enforce deletes the events that break the Rules, and returns the number of
+// events deleted.
+func (s *S) Enforce() (n int, err error) {
+	var d int
+	for k, age := range s.rules.MaxAge {
+		if d, err = s.deleteOlder(k, age); chk.E(err) {
+			return
+		}
+		n += d
+	}
+	for k, keep := range s.rules.KeepLatest {
+		if d, err = s.keepLatest(k, keep); chk.E(err) {
+			return
+		}
+		n += d
+	}
+	if d, err = s.enforceQuotas(); chk.E(err) {
+		return
+	}
+	n += d
+	if d, err = s.enforceBudget(); chk.E(err) {
+		return
+	}
+	n += d
+	return
+}
+
+// deleteOlder deletes the events of a kind that are older than age.
+func (s *S) deleteOlder(k uint16, age time.Duration) (n int, err error) {
+	var evs []store.IdPkTs
+	if evs, err = s.sto.QueryForIds(
+		s.ctx, &filter.F{
+			Kinds: kinds.New(kind.New(k)),
+			Until: timestamp.FromUnix(time.Now().Add(-age).Unix()),
+		},
+	); chk.E(err) {
+		return
+	}
+	for _, ev := range evs {
+		if err = s.deleteEvent(ev.Id); err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// keepLatest deletes the events of a kind of each author except the newest
+// keep of them.
+func (s *S) keepLatest(k uint16, keep int) (n int, err error) {
+	var evs []store.IdPkTs
+	if evs, err = s.sto.QueryForIds(
+		s.ctx, &filter.F{Kinds: kinds.New(kind.New(k))},
+	); chk.E(err) {
+		return
+	}
+	// the results are newest first.
+	kept := make(map[string]int)
+	for _, ev := range evs {
+		if kept[string(ev.Pub)] < keep {
+			kept[string(ev.Pub)]++
+			continue
+		}
+		if err = s.deleteEvent(ev.Id); err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// enforceQuotas deletes the oldest events of the authors over their quota,
+// which the store has the usage of, as they can have more events than the
+// quota allows when they are exempt, or the events came from a sync or
+// import, or the quota was lowered.
+func (s *S) enforceQuotas() (n int, err error) {
+	if !s.rules.Quotas() || s.meter == nil {
+		return
+	}
+	usages, ok := s.meter.Usages()
+	if !ok {
+		return
+	}
+	for pk, u := range usages {
+		if !s.overQuota([]byte(pk), u, 0) {
+			continue
+		}
+		var d int
+		if d, err = s.trimAuthor([]byte(pk)); err != nil {
+			return
+		}
+		n += d
+	}
+	return
+}
+
+// trimAuthor deletes the oldest events of an author that are over their
+// quota.
+func (s *S) trimAuthor(pubkey []byte) (n int, err error) {
+	var u store.Usage
+	var over [][]byte
+	// the events are streamed newest first, so the events past the quota are
+	// the oldest.
+	if err = s.sto.StreamEvents(
+		s.ctx, &filter.F{Authors: tag.New(pubkey)}, func(ev *event.E) bool {
+			if !store.Metered(ev) {
+				return true
+			}
+			size := store.Size(ev)
+			if s.overQuota(ev.Pubkey, u, size) {
+				over = append(over, ev.ID)
+				return true
+			}
+			u.Events++
+			u.Bytes += size
+			return true
+		},
+	); chk.E(err) {
+		return
+	}
+	for _, id := range over {
+		if err = s.deleteEvent(id); err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// overQuota returns true if an author with a usage is over their quota, or
+// would be with an event of a size.
+func (s *S) overQuota(pubkey []byte, u store.Usage, size int64) bool {
+	if !s.rules.Quotas() || (s.Exempt != nil && s.Exempt(pubkey)) {
+		return false
+	}
+	events := u.Events
+	if size > 0 {
+		events++
+	}
+	return (s.rules.MaxEvents > 0 && events > s.rules.MaxEvents) ||
+		(s.rules.MaxBytes > 0 && u.Bytes+size > s.rules.MaxBytes)
+}
+
+// enforceBudget deletes the oldest events, in the order they were created, if
+// the store is larger on disk than the DiskBudget, in proportion to how much
+// it is over. The proportion is of the total size of the events, which is
+// known if the store is a store.Meter, and otherwise the events that are
+// deleted are as large as the excess.
+//
+// Deleted events only free space on disk once the garbage of the store is
+// collected, which is started after deleting them, so no more events are
+// deleted until the store has shrunk from its size when they were.
+func (s *S) enforceBudget() (n int, err error) {
+	if s.rules.DiskBudget <= 0 || s.evicter == nil {
+		return
+	}
+	var size int64
+	if size, err = s.evicter.DiskSize(); chk.E(err) {
+		return
+	}
+	if size <= s.rules.DiskBudget {
+		s.pruned = 0
+		return
+	}
+	if s.pruned > 0 && size >= s.pruned {
+		log.W.F(
+			"event store is %d bytes, over its budget of %d, waiting for "+
+				"its garbage to be collected", size, s.rules.DiskBudget,
+		)
+		return
+	}
+	excess := float64(size - s.rules.DiskBudget)
+	if s.meter != nil {
+		if usages, ok := s.meter.Usages(); ok {
+			var total int64
+			for _, u := range usages {
+				total += u.Bytes
+			}
+			excess = excess / float64(size) * float64(total)
+		}
+	}
+	// the events are read oldest first until they cover the excess.
+	var ids [][]byte
+	var sum float64
+	var until int64
+	if err = s.evicter.StreamOldest(
+		s.ctx, func(ev *event.E) bool {
+			if !store.Metered(ev) {
+				return true
+			}
+			ids = append(ids, ev.ID)
+			until = ev.CreatedAt.I64()
+			sum += float64(store.Size(ev))
+			return sum < excess
+		},
+	); chk.E(err) || len(ids) == 0 {
+		return
+	}
+	log.I.F(
+		"event store is %d bytes, over its budget of %d, deleting %d "+
+			"events created up to %s", size, s.rules.DiskBudget, len(ids),
+		time.Unix(until, 0).Format(time.RFC3339),
+	)
+	for _, id := range ids {
+		if err = s.deleteEvent(id); err != nil {
+			return
+		}
+		n++
+	}
+	s.pruned = size
+	if gc, ok := s.sto.(store.GarbageCollector); ok {
+		if run := gc.CollectGarbage(); run.Error != "" {
+			log.E.F(
+				"failed to collect the garbage of the store: %s", run.Error,
+			)
+		}
+	}
+	return
+}
+
+// deleteEvent deletes an event from the store.
+func (s *S) deleteEvent(id []byte) (err error) {
+	if err = s.ctx.Err(); err != nil {
+		return
+	}
+	chk.E(s.sto.DeleteEvent(s.ctx, eventid.NewWith(id)))
+	return
+}
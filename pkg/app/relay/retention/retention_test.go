@@ -0,0 +1,209 @@
+package retention
+
+import (
+	"bytes"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/database/memory"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	now   = time.Now().Unix()
+	alice = sha256.Sum256([]byte("alice"))
+	bob   = sha256.Sum256([]byte("bob"))
+	n     int
+)
+
+// newEvent returns an event of a kind by an author, created age seconds ago.
+func newEvent(pk [32]byte, k *kind.T, age int64) (ev *event.E) {
+	n++
+	ev = event.New()
+	id := sha256.Sum256([]byte(strconv.Itoa(n)))
+	ev.ID = id[:]
+	ev.Pubkey = pk[:]
+	ev.Sig = make([]byte, 64)
+	ev.CreatedAt = timestamp.FromUnix(now - age)
+	ev.Kind = k
+	ev.Content = []byte("story " + strconv.Itoa(n))
+	ev.Tags = tags.New()
+	return
+}
+
+func save(t *testing.T, s store.I, evs ...*event.E) {
+	for _, ev := range evs {
+		if _, _, err := s.SaveEvent(context.Bg(), ev, true, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// count returns the number of events of an author, of a kind if it isn't nil.
+func count(t *testing.T, s store.I, pk [32]byte, k *kind.T) (n int) {
+	evs, err := s.QueryEvents(
+		context.Bg(), &filter.F{Authors: tag.New(pk[:])},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range evs {
+		if k == nil || ev.Kind.Equal(k) {
+			n++
+		}
+	}
+	return
+}
+
+func TestEnforce(t *testing.T) {
+	s := memory.New()
+	for i := int64(0); i < 5; i++ {
+		save(
+			t, s, newEvent(alice, kind.TextNote, i*3600),
+			newEvent(alice, kind.Reaction, i*3600+1800),
+			newEvent(bob, kind.TextNote, i*3600),
+		)
+	}
+	save(t, s, newEvent(bob, kind.ProfileMetadata, 10*3600))
+	r := New(
+		context.Bg(), s, Rules{
+			MaxAge: map[uint16]time.Duration{
+				kind.Reaction.K: 2 * time.Hour,
+			},
+			KeepLatest: map[uint16]int{kind.TextNote.K: 4},
+			MaxEvents:  3,
+		},
+	)
+	r.Exempt = func(pk []byte) bool { return bytes.Equal(pk, bob[:]) }
+	deleted, err := r.Enforce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// alice loses 3 reactions by age, a note by the number kept, and a
+	// reaction and 2 notes by the quota. bob loses a note by the number kept,
+	// and the profile of bob is not counted.
+	if deleted != 8 {
+		t.Fatalf("deleted %d events, expected 8", deleted)
+	}
+	if c := count(t, s, alice, nil); c != 3 {
+		t.Fatalf("alice has %d events, expected 3", c)
+	}
+	if c := count(t, s, alice, kind.Reaction); c != 1 {
+		t.Fatalf("alice has %d reactions, expected the newest", c)
+	}
+	if c := count(t, s, bob, nil); c != 5 {
+		t.Fatalf("bob has %d events, expected 5", c)
+	}
+	// alice is at the quota, and bob is exempt.
+	ev := newEvent(alice, kind.TextNote, 0)
+	if ok, reason := r.Accept(
+		context.Bg(), ev, nil, "", nil,
+	); ok || !bytes.HasPrefix(reason, []byte("blocked: ")) {
+		t.Fatalf("accepted an event over the quota: %s", reason)
+	}
+	if ok, _ := r.Accept(
+		context.Bg(), newEvent(alice, kind.FollowList, 0), nil, "", nil,
+	); !ok {
+		t.Fatal("rejected a replaceable event by the quota")
+	}
+	if ok, reason := r.Accept(
+		context.Bg(), newEvent(bob, kind.TextNote, 0), nil, "", nil,
+	); !ok {
+		t.Fatalf("rejected an event of an exempt author: %s", reason)
+	}
+	// the quota frees up when an event of alice is deleted.
+	evs, err := s.QueryEvents(
+		context.Bg(), &filter.F{Authors: tag.New(alice[:])},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteEvent(
+		context.Bg(), eventid.NewWith(evs[len(evs)-1].ID),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Enforce(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, reason := r.Accept(context.Bg(), ev, nil, "", nil); !ok {
+		t.Fatalf("rejected an event under the quota: %s", reason)
+	}
+	// the quota is reserved for the event until it is saved.
+	if ok, _ := r.Accept(
+		context.Bg(), newEvent(alice, kind.TextNote, 0), nil, "", nil,
+	); ok {
+		t.Fatal("the accepted event was not counted against the quota")
+	}
+	save(t, s, ev)
+	r.Release(ev)
+	if ok, _ := r.Accept(
+		context.Bg(), newEvent(alice, kind.TextNote, 0), nil, "", nil,
+	); ok {
+		t.Fatal("the saved event was not counted against the quota")
+	}
+}
+
+func TestEnforceBudget(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	// the newest note is an hour old and the oldest ten.
+	for i := int64(10); i > 0; i-- {
+		save(t, d, newEvent(alice, kind.TextNote, i*3600))
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if _, ok := d.Usages(); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the usage was not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var size int64
+	if size, err = d.DiskSize(); err != nil {
+		t.Fatal(err)
+	}
+	r := New(ctx, d, Rules{DiskBudget: size / 2})
+	var deleted int
+	if deleted, err = r.Enforce(); err != nil {
+		t.Fatal(err)
+	}
+	// about half the events are deleted, the oldest first.
+	if deleted == 0 || deleted == 10 {
+		t.Fatalf("Expected about half the events deleted, got %d", deleted)
+	}
+	var evs event.S
+	if evs, err = d.QueryEvents(
+		ctx, &filter.F{Authors: tag.New(alice[:])},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 10-deleted {
+		t.Fatalf("Expected %d events, got %d", 10-deleted, len(evs))
+	}
+	for _, ev := range evs {
+		if ev.CreatedAt.I64() < now-int64(10-deleted)*3600 {
+			t.Fatalf("An event newer than a deleted one was deleted")
+		}
+	}
+	// no more are deleted until the store has shrunk.
+	if deleted, err = r.Enforce(); err != nil || deleted != 0 {
+		t.Fatalf("Expected no events deleted, got %d %v", deleted, err)
+	}
+}
@@ -10,13 +10,20 @@ import (
 	"orly.dev/pkg/protocol/socketapi"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/app/relay/cluster"
 	"orly.dev/pkg/app/relay/helpers"
 	"orly.dev/pkg/app/relay/options"
+	"orly.dev/pkg/app/relay/policy"
 	"orly.dev/pkg/app/relay/publish"
+	"orly.dev/pkg/app/relay/replicate"
+	"orly.dev/pkg/app/relay/retention"
 	"orly.dev/pkg/interfaces/relay"
+	"orly.dev/pkg/interfaces/store"
 	"orly.dev/pkg/protocol/servemux"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
@@ -37,10 +44,29 @@ type Server struct {
 	mux        *servemux.S
 	httpServer *http.Server
 	listeners  *publish.S
-	*config.C
+	policy     policy.Chain
+	rateLimits *RateLimits
+	// configMx serializes changes to the configuration while the relay is
+	// running.
+	configMx sync.Mutex
+	// conf is the configuration of the relay, which is replaced as a whole
+	// when it is changed, so the readers of it get a consistent snapshot
+	// without a lock.
+	conf atomic.Pointer[config.C]
 	*Lists
-	*Peers
-	Mux *servemux.S
+	// peers is the peer relays and the identity of the relay, replaced as a
+	// whole when the peer relays are changed.
+	peers atomic.Pointer[Peers]
+	// replicator pushes accepted events to the peers through their outbound
+	// queues.
+	replicator *replicate.S
+	// cluster is the membership of the relay cluster, if the relay is a node
+	// of one.
+	cluster *cluster.S
+	// retention enforces the retention rules and quotas, if any are
+	// configured.
+	retention *retention.S
+	Mux       *servemux.S
 }
 
 // ServerParams represents the configuration parameters for initializing a
@@ -77,11 +103,21 @@ type ServerParams struct {
 //
 // - Initializes storage with the provided database path.
 //
+// - Applies the configuration stored in the database, if there is one, over
+// the configuration in the parameters.
+//
 // - Configures the server's options using the default settings and applies any
 // optional settings provided.
 //
 // - Sets up a ServeMux for handling HTTP requests.
 //
+// - Joins the relay cluster, if a cluster name is configured.
+//
+// - Starts the replication of events to the peer relays, which resumes the
+// delivery of the events left in their queues.
+//
+// - Starts the enforcement of the retention rules, if any are configured.
+//
 // - Initializes the relay, starting its operation in a separate goroutine.
 func NewServer(
 	sp *ServerParams, serveMux *servemux.S, opts ...options.O,
@@ -101,13 +137,48 @@ func NewServer(
 		relay:   sp.Rl,
 		mux:     serveMux,
 		options: op,
-		C:       sp.C,
 		Lists:   new(Lists),
-		Peers:   new(Peers),
 	}
-	chk.E(
-		s.Peers.Init(sp.C.PeerRelays, sp.C.RelaySecret),
-	)
+	conf := *sp.C
+	// the limit given in the parameters overrides the configuration
+	if sp.MaxLimit > 0 {
+		conf.MaxLimit = sp.MaxLimit
+	}
+	s.conf.Store(&conf)
+	// the configured policies are checked before any added in the options.
+	s.policy = append(policy.FromConfig(sp.C), op.Policies...)
+	s.rateLimits = NewRateLimits(sp.C)
+	// the configuration stored in the database overrides the environment.
+	if storage := sp.Rl.Storage(); storage != nil {
+		var conf *store.Configuration
+		if conf, err = storage.GetConfiguration(); chk.E(err) {
+			err = nil
+		} else if conf != nil {
+			if err = CheckConfiguration(conf); chk.E(err) {
+				err = nil
+			} else {
+				log.I.F("loaded configuration from the database")
+				s.applyConfiguration(conf)
+			}
+		}
+	}
+	c := s.Config()
+	peers := new(Peers)
+	chk.E(peers.Init(c.PeerRelays, c.RelaySecret))
+	s.peers.Store(peers)
+	if storage := sp.Rl.Storage(); storage != nil {
+		s.replicator = replicate.New(s.Ctx, storage)
+		if c.ClusterName != "" {
+			chk.E(s.startCluster(storage))
+		}
+		s.setReplicationPeers()
+		if rules := retention.FromConfig(c); rules.Enabled() {
+			s.retention = retention.New(s.Ctx, storage, rules)
+			s.retention.Exempt = s.IsOwner
+			s.policy = append(s.policy, s.retention)
+			go s.retention.Run()
+		}
+	}
 	s.listeners = publish.New(socketapi.New(s), openapi.NewPublisher(s))
 	go func() {
 		if err := s.relay.Init(); chk.E(err) {
@@ -155,6 +226,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !whitelisted {
 		return
 	}
+	if blocked(remote, c.BlockList) {
+		return
+	}
 	// standard nostr protocol only governs the "root" path of the relay and
 	// websockets
 	if r.URL.Path == "/" {
@@ -207,9 +281,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (s *Server) Start(
 	host string, port int, started ...chan bool,
 ) (err error) {
-	if len(s.C.Owners) > 0 {
+	if len(s.Config().Owners) > 0 {
 		// start up spider
-		if err = s.Spider(s.C.Private); chk.E(err) {
+		if err = s.Spider(s.Config().Private); chk.E(err) {
 			// there wasn't any owners, or they couldn't be found on the spider
 			// seeds.
 			err = nil
@@ -221,7 +295,7 @@ func (s *Server) Start(
 		for {
 			select {
 			case <-ticker.C:
-				if err = s.Spider(s.C.Private); chk.E(err) {
+				if err = s.Spider(s.Config().Private); chk.E(err) {
 					// there wasn't any owners, or they couldn't be found on the spider
 					// seeds.
 					err = nil
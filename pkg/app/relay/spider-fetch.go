@@ -1,6 +1,7 @@
 package relay
 
 import (
+	"errors"
 	"orly.dev/pkg/crypto/ec/schnorr"
 	"orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/event"
@@ -9,6 +10,7 @@ import (
 	"orly.dev/pkg/encoders/kinds"
 	"orly.dev/pkg/encoders/tag"
 	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
 	"orly.dev/pkg/protocol/ws"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
@@ -104,7 +106,7 @@ func (s *Server) SpiderFetch(
 
 	log.I.F("%d events found of type %s", len(pkKindMap), kindsList)
 
-	if !noFetch && len(s.C.SpiderSeeds) > 0 {
+	if !noFetch && len(s.Config().SpiderSeeds) > 0 {
 		// we need to search the spider seeds.
 		// Break up pubkeys into batches of 128
 		for i := 0; i < len(pubkeys); i += 128 {
@@ -132,7 +134,7 @@ func (s *Server) SpiderFetch(
 				Since:   since,
 				Limit:   l,
 			}
-			for _, seed := range s.C.SpiderSeeds {
+			for _, seed := range s.Config().SpiderSeeds {
 				select {
 				case <-s.Ctx.Done():
 					return
@@ -179,7 +181,11 @@ func (s *Server) SpiderFetch(
 						// Save the event to the database
 						if _, _, err = s.Storage().SaveEvent(
 							s.Ctx, ev, true, nil,
-						); chk.E(err) {
+						); err != nil {
+							// a newer version of the list is already stored.
+							if !errors.Is(err, store.ErrSuperseded) {
+								chk.E(err)
+							}
 							err = nil
 							continue
 						}
@@ -11,7 +11,7 @@ import (
 
 func (s *Server) Spider(noFetch ...bool) (err error) {
 	var ownersPubkeys [][]byte
-	for _, v := range s.C.Owners {
+	for _, v := range s.Config().Owners {
 		var pk []byte
 		if pk, err = keys.DecodeNpubOrHex(v); chk.E(err) {
 			continue
@@ -20,7 +20,12 @@ func (s *Server) Spider(noFetch ...bool) (err error) {
 		ownersPubkeys = append(ownersPubkeys, pk)
 	}
 	if len(ownersPubkeys) == 0 {
-		// there is no OwnersPubkeys, so there is nothing to do.
+		// there is no OwnersPubkeys, so there is nothing to do, except clear
+		// the lists in case the owners were removed.
+		s.SetOwnersPubkeys(nil)
+		s.SetOwnersFollowed(nil)
+		s.SetFollowedFollows(nil)
+		s.SetOwnersMuted(nil)
 		return
 	}
 	go func() {
@@ -97,10 +102,10 @@ func (s *Server) Spider(noFetch ...bool) (err error) {
 		s.SetFollowedFollows(followedFollows)
 		s.SetOwnersMuted(ownersMuted)
 		// lastly, update all followed users new events in the background
-		if !dontFetch && s.C.SpiderType != "none" {
+		if !dontFetch && s.Config().SpiderType != "none" {
 			go func() {
 				var k *kinds.T
-				if s.C.SpiderType == "directory" {
+				if s.Config().SpiderType == "directory" {
 					k = kinds.New(
 						kind.ProfileMetadata, kind.RelayListMetadata,
 						kind.DMRelaysList,
@@ -36,8 +36,8 @@ func (s *Server) UserAuth(
 	}
 	// if the client is one of the relay cluster replicas, also set the super
 	// flag to indicate that privilege checks can be bypassed.
-	if len(s.Peers.Pubkeys) > 0 {
-		for _, pk := range s.Peers.Pubkeys {
+	if peers := s.peerPubkeys(); len(peers) > 0 {
+		for _, pk := range peers {
 			if bytes.Equal(pk, pubkey) {
 				authed = true
 				super = true
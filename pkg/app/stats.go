@@ -0,0 +1,33 @@
+package app
+
+import (
+	"io"
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+)
+
+// StatsTop is the number of authors with the most events in the stats report.
+var StatsTop = 20
+
+// PrintStats writes a report of the badger event store in the DataDir of the
+// configuration. Badger only lets one process open a store, so the relay must
+// not be running; the report of a running relay is at the /stats endpoint of
+// its API.
+func PrintStats(cfg *config.C, w io.Writer) (err error) {
+	c, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	var d *database.D
+	if d, err = openStore(c, cancel, cfg); err != nil {
+		return
+	}
+	defer d.Close()
+	var s *store.Stats
+	if s, err = d.Stats(c, StatsTop); chk.E(err) {
+		return
+	}
+	err = s.Print(w)
+	return
+}
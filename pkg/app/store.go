@@ -0,0 +1,65 @@
+package app
+
+import (
+	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/database/memory"
+	"orly.dev/pkg/database/sqlite"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/units"
+)
+
+// New creates a relay with the event store backend of the DbType of the
+// configuration, in its DataDir. The store is closed when the context is
+// cancelled.
+func New(c context.T, cancel context.F, cfg *config.C) (
+	r *Relay, err error,
+) {
+	var storage store.I
+	replacePolicy := store.DefaultReplacePolicy
+	replacePolicy.History = cfg.KeepReplaced
+	switch cfg.DbType {
+	case "", "badger":
+		var d *database.D
+		if d, err = database.New(
+			c, cancel, cfg.DataDir, cfg.DbLogLevel,
+		); chk.E(err) {
+			return
+		}
+		d.SetReplacePolicy(replacePolicy)
+		d.SetGCPolicy(GCPolicy(cfg))
+		d.SetTagRules(database.ParseTagRules(cfg.TagIndexes))
+		d.SetChangeRetention(cfg.DbChangeLogRetention)
+		storage = d
+	case "memory":
+		m := memory.New()
+		m.SetReplacePolicy(replacePolicy)
+		storage = m
+	case "sqlite":
+		var s *sqlite.S
+		if s, err = sqlite.New(c, cfg.DataDir); chk.E(err) {
+			return
+		}
+		s.SetReplacePolicy(replacePolicy)
+		storage = s
+	default:
+		err = errorf.E("unknown event store backend %q", cfg.DbType)
+		return
+	}
+	r = &Relay{C: cfg, Store: storage}
+	return
+}
+
+// GCPolicy returns the policy for collecting the garbage of a badger event
+// store that the configuration sets.
+func GCPolicy(cfg *config.C) (p database.GCPolicy) {
+	return database.GCPolicy{
+		Interval:        cfg.DbGCInterval,
+		DiscardRatio:    float64(cfg.DbGCDiscardPercent) / 100,
+		MinValueLogSize: int64(cfg.DbGCMinValueLogMB) * units.Mb,
+		CompactRatio:    float64(cfg.DbCompactStalePercent) / 100,
+	}
+}
@@ -0,0 +1,537 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"hash"
+	"io"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"os"
+	"time"
+)
+
+// A backup is the stream of a badger backup in an archive that can be checked
+// before it is loaded:
+//
+//	"orly backup\n"
+//	header as a line of JSON: {"format":1,"created":...,"since":...}
+//	frames of the badger backup: 4 byte big endian length|data
+//	a frame of length 0
+//	trailer as a line of JSON: {"upto":...,"next":...,"sha256":"..."}
+//
+// The checksum in the trailer is of everything before it.
+const (
+	backupMagic   = "orly backup\n"
+	backupFormat  = 1
+	backupFrame   = 1 << 20
+	backupMaxLine = 4096
+	// restorePendingWrites is the number of batches of keys restored at once.
+	restorePendingWrites = 256
+)
+
+// backupKey is the key the backupState of the store is stored under.
+var backupKey = []byte("BACKUP")
+
+// backupState is the position of the store in the chains of incremental
+// backups that are made of it and restored into it.
+type backupState struct {
+	// Restored is the Next of the last backup restored into the store, which
+	// is the Since of the backup that can be restored after it.
+	Restored uint64 `json:"restored,omitempty"`
+	// BackedUp is the Next of the last backup made of the store, the latest
+	// version an incremental backup can be made since without leaving out
+	// changes.
+	BackedUp uint64 `json:"backed_up,omitempty"`
+}
+
+// backupTrailer is the end of a backup.
+type backupTrailer struct {
+	Upto   uint64 `json:"upto"`
+	Next   uint64 `json:"next"`
+	Sha256 string `json:"sha256"`
+}
+
+// Backup writes a backup of the keys of the database that have changed since
+// a version, or all of them if since is 0. The backup is of a snapshot of the
+// database when it starts, and the relay keeps saving events while it is
+// made.
+//
+// The Next version of the returned info is the since of the next incremental
+// backup, and is recorded in the store, so a backup since a later version,
+// which would leave out the changes in between, is refused.
+func (d *D) Backup(c context.T, w io.Writer, since uint64) (
+	info *store.BackupInfo, err error,
+) {
+	var st backupState
+	if st, err = d.backupState(); chk.E(err) {
+		return
+	}
+	if st.BackedUp > 0 && since > st.BackedUp {
+		err = errorf.E(
+			"the last backup ends before version %d, a backup since %d "+
+				"would leave out the changes in between", st.BackedUp, since,
+		)
+		return
+	}
+	info = &store.BackupInfo{
+		Format: backupFormat, Created: time.Now().Unix(), Since: since,
+	}
+	h := sha256.New()
+	cw := &countWriter{w: io.MultiWriter(w, h)}
+	var header []byte
+	if header, err = json.Marshal(info); chk.E(err) {
+		return
+	}
+	if _, err = io.WriteString(cw, backupMagic); err != nil {
+		return
+	}
+	if _, err = cw.Write(append(header, '\n')); err != nil {
+		return
+	}
+	fw := bufio.NewWriterSize(&frameWriter{w: cw}, backupFrame)
+	// badger backs up the versions at or after the one it is given.
+	var upto uint64
+	if upto, err = d.DB.Backup(
+		&cancelWriter{c: c, w: fw}, since,
+	); err != nil {
+		return
+	}
+	if err = fw.Flush(); err != nil {
+		return
+	}
+	// the frame that ends the backup.
+	if _, err = cw.Write([]byte{0, 0, 0, 0}); err != nil {
+		return
+	}
+	if upto == 0 && since > 0 {
+		// nothing has changed since.
+		upto = since - 1
+	}
+	info.Upto, info.Next = upto, upto+1
+	info.Sha256 = hex.Enc(h.Sum(nil))
+	var trailer []byte
+	if trailer, err = json.Marshal(
+		backupTrailer{Upto: info.Upto, Next: info.Next, Sha256: info.Sha256},
+	); chk.E(err) {
+		return
+	}
+	if _, err = cw.Write(append(trailer, '\n')); err != nil {
+		return
+	}
+	info.Bytes = cw.n
+	if st, err = d.backupState(); chk.E(err) {
+		return
+	}
+	st.BackedUp = max(st.BackedUp, info.Next)
+	err = d.setBackupState(st)
+	return
+}
+
+// Restore loads a backup into the database, with the serials of the events
+// and their indexes as they were when it was made. A full backup can only be
+// restored into a database with no events, and the incremental backups made
+// after it must be restored in order, as each replays the changes, including
+// deletions, since the one before. The Next of the last backup restored is
+// recorded in the store, and an incremental backup that is not since it is
+// refused.
+//
+// The checksum of a backup is at its end, so the backup is copied to a
+// temporary file as it is checked, and only loaded from it once it is known to
+// be intact, so that a corrupt or truncated backup leaves the store as it was.
+// The errors of the check are a store.ErrInvalidBackup.
+func (d *D) Restore(c context.T, r io.Reader) (
+	info *store.BackupInfo, err error,
+) {
+	var f *os.File
+	if f, err = os.CreateTemp("", "orly-restore-*"); chk.E(err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	var br *backupReader
+	if br, err = newBackupReader(
+		io.TeeReader(&cancelReader{c: c, r: r}, f),
+	); err != nil {
+		err = errorf.E("%w: %v", store.ErrInvalidBackup, err)
+		return
+	}
+	info = br.info
+	// the state is loaded from the backup with the other keys, and replaced
+	// with the state of this store after it.
+	var st backupState
+	if st, err = d.backupState(); chk.E(err) {
+		return
+	}
+	if info.Since > 0 && info.Since != st.Restored {
+		if st.Restored == 0 {
+			err = errorf.E(
+				"the backup is of the changes since version %d, and no "+
+					"backup has been restored into the store before it",
+				info.Since,
+			)
+		} else {
+			err = errorf.E(
+				"the backup is of the changes since version %d, and the "+
+					"last backup restored ends before version %d, the "+
+					"backups must be restored in order", info.Since,
+				st.Restored,
+			)
+		}
+		return
+	}
+	if info.Since == 0 {
+		var n uint64
+		if n, err = d.EventCount(); chk.E(err) {
+			return
+		}
+		if n > 0 {
+			err = errorf.E(
+				"a full backup can only be restored into an empty store, "+
+					"this one has %d events", n,
+			)
+			return
+		}
+	}
+	// the rest of the backup is copied and checked.
+	if _, err = io.Copy(io.Discard, br); err != nil {
+		err = errorf.E("%w: %v", store.ErrInvalidBackup, err)
+		return
+	}
+	if _, err = f.Seek(0, io.SeekStart); chk.E(err) {
+		return
+	}
+	if br, err = newBackupReader(bufio.NewReader(f)); chk.E(err) {
+		return
+	}
+	if err = d.DB.Load(
+		&cancelReader{c: c, r: br}, restorePendingWrites,
+	); err != nil {
+		return
+	}
+	// the sequences restored can be older than those leased when the
+	// database was opened, which would then number new events and queued
+	// items with the serials of restored ones.
+	if err = d.advanceSequence(
+		d.seq, []byte("EVENTS"), []byte(indexes.EventPrefix), 3, 5,
+	); chk.E(err) {
+		return
+	}
+	// the deletions in the change log have serials after the events.
+	if err = d.advanceSequence(
+		d.seq, []byte("EVENTS"), changePrefix, len(changePrefix), 8,
+	); chk.E(err) {
+		return
+	}
+	// the entries of the change log restored are made visible by a serial
+	// handed out after them.
+	var sers []uint64
+	sers, err = d.nextSerials(1)
+	d.settle(sers)
+	if chk.E(err) {
+		return
+	}
+	if err = d.advanceSequence(
+		d.replSeq, []byte("REPLICATION"), replicationPrefix,
+		len(replicationPrefix)+32, 8,
+	); chk.E(err) {
+		return
+	}
+	if !br.done {
+		err = errorf.E("the backup ended before its trailer")
+		return
+	}
+	st.Restored = info.Next
+	if err = d.setBackupState(st); chk.E(err) {
+		return
+	}
+	// the keys restored are not counted as they are loaded.
+	if err = d.loadCardinality(); chk.E(err) {
+		return
+	}
+	err = d.loadUsage()
+	return
+}
+
+// backupState returns the backupState of the store, which is empty if no
+// backup has been made of it or restored into it.
+func (d *D) backupState() (st backupState, err error) {
+	err = d.View(
+		func(txn *badger.Txn) (err error) {
+			var item *badger.Item
+			if item, err = txn.Get(backupKey); err != nil {
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					err = nil
+				}
+				return
+			}
+			return item.Value(
+				func(val []byte) error { return json.Unmarshal(val, &st) },
+			)
+		},
+	)
+	return
+}
+
+// setBackupState stores the backupState of the store.
+func (d *D) setBackupState(st backupState) (err error) {
+	var b []byte
+	if b, err = json.Marshal(st); chk.E(err) {
+		return
+	}
+	err = d.Update(
+		func(txn *badger.Txn) error { return txn.Set(backupKey, b) },
+	)
+	return
+}
+
+// advanceSequence makes the numbers of a sequence greater than the greatest
+// number in the keys with a prefix, which is the n bytes at an offset of the
+// keys, in big endian.
+func (d *D) advanceSequence(
+	seq *badger.Sequence, key, prf []byte, offset, n int,
+) (err error) {
+	var last uint64
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: prf, PrefetchValues: false},
+			)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				k := it.Item().Key()
+				if len(k) < offset+n {
+					continue
+				}
+				var b [8]byte
+				copy(b[8-n:], k[offset:offset+n])
+				last = max(last, binary.BigEndian.Uint64(b[:]))
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	if err = d.Update(
+		func(txn *badger.Txn) (err error) {
+			var stored uint64
+			var item *badger.Item
+			if item, err = txn.Get(key); err == nil {
+				if err = item.Value(
+					func(v []byte) (err error) {
+						if len(v) == 8 {
+							stored = binary.BigEndian.Uint64(v)
+						}
+						return
+					},
+				); err != nil {
+					return
+				}
+			} else if err != badger.ErrKeyNotFound {
+				return
+			}
+			if stored > last {
+				return nil
+			}
+			return txn.Set(key, binary.BigEndian.AppendUint64(nil, last+1))
+		},
+	); err != nil {
+		return
+	}
+	// the sequence reads the stored number when its lease runs out.
+	for next := uint64(0); next <= last; {
+		if next, err = seq.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// VerifyBackup reads a backup to its end, checking its checksum, and returns
+// its info.
+func VerifyBackup(r io.Reader) (info *store.BackupInfo, err error) {
+	var br *backupReader
+	if br, err = newBackupReader(r); err != nil {
+		return
+	}
+	if _, err = io.Copy(io.Discard, br); err != nil {
+		return
+	}
+	info = br.info
+	return
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+	return
+}
+
+// frameWriter writes each write as a frame of a backup. Writes of nothing are
+// skipped, as a frame of length 0 ends the backup.
+type frameWriter struct{ w io.Writer }
+
+func (w *frameWriter) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return
+	}
+	if _, err = w.w.Write(
+		binary.BigEndian.AppendUint32(nil, uint32(len(p))),
+	); err != nil {
+		return
+	}
+	return w.w.Write(p)
+}
+
+// cancelWriter fails writes once its context is cancelled.
+type cancelWriter struct {
+	c context.T
+	w io.Writer
+}
+
+func (w *cancelWriter) Write(p []byte) (n int, err error) {
+	if err = w.c.Err(); err != nil {
+		return
+	}
+	return w.w.Write(p)
+}
+
+// cancelReader fails reads once its context is cancelled.
+type cancelReader struct {
+	c context.T
+	r io.Reader
+}
+
+func (r *cancelReader) Read(p []byte) (n int, err error) {
+	if err = r.c.Err(); err != nil {
+		return
+	}
+	return r.r.Read(p)
+}
+
+// backupReader reads the stream of the badger backup from the frames of a
+// backup, and checks its checksum at the end of it.
+type backupReader struct {
+	r    *bufio.Reader
+	h    hash.Hash
+	info *store.BackupInfo
+	// left is the number of bytes left to read of the current frame.
+	left int
+	done bool
+}
+
+// newBackupReader reads the header of a backup, which fills the info of the
+// returned reader, except for what is in the trailer, which is filled when
+// the reader reaches its end.
+func newBackupReader(r io.Reader) (br *backupReader, err error) {
+	br = &backupReader{r: bufio.NewReader(r), h: sha256.New()}
+	var magic []byte
+	if magic, err = br.line(); err != nil {
+		return
+	}
+	if string(magic) != backupMagic {
+		err = errorf.E("not an orly backup")
+		return
+	}
+	var header []byte
+	if header, err = br.line(); err != nil {
+		return
+	}
+	br.info = new(store.BackupInfo)
+	if err = json.Unmarshal(header, br.info); err != nil {
+		err = errorf.E("invalid backup header: %v", err)
+		return
+	}
+	if br.info.Format != backupFormat {
+		err = errorf.E("unknown backup format %d", br.info.Format)
+		return
+	}
+	return
+}
+
+// line reads a line of the backup, adding it to the checksum.
+func (br *backupReader) line() (b []byte, err error) {
+	for len(b) < backupMaxLine {
+		var c byte
+		if c, err = br.r.ReadByte(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		b = append(b, c)
+		if c == '\n' {
+			br.h.Write(b)
+			return
+		}
+	}
+	err = errorf.E("invalid backup, line too long")
+	return
+}
+
+func (br *backupReader) Read(p []byte) (n int, err error) {
+	if br.done {
+		return 0, io.EOF
+	}
+	if br.left == 0 {
+		var l [4]byte
+		if _, err = io.ReadFull(br.r, l[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		br.h.Write(l[:])
+		if br.left = int(binary.BigEndian.Uint32(l[:])); br.left == 0 {
+			err = br.trailer()
+			return
+		}
+	}
+	if len(p) > br.left {
+		p = p[:br.left]
+	}
+	if n, err = br.r.Read(p); n > 0 {
+		br.h.Write(p[:n])
+		br.left -= n
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// trailer reads the trailer of the backup and checks its checksum.
+func (br *backupReader) trailer() (err error) {
+	sum := hex.Enc(br.h.Sum(nil))
+	var b []byte
+	if b, err = br.line(); err != nil {
+		return
+	}
+	var t backupTrailer
+	if err = json.Unmarshal(b, &t); err != nil {
+		return errorf.E("invalid backup trailer: %v", err)
+	}
+	if t.Sha256 != sum {
+		return errorf.E(
+			"backup checksum is %s, expected %s, it is corrupt", sum,
+			t.Sha256,
+		)
+	}
+	br.info.Upto, br.info.Next, br.info.Sha256 = t.Upto, t.Next, t.Sha256
+	br.done = true
+	return io.EOF
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	open := func() (d *D) {
+		var err error
+		if d, err = New(ctx, cancel, t.TempDir(), "error"); err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		t.Cleanup(func() { d.Close() })
+		return
+	}
+	src := open()
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("pubkey"))
+	var evs []*event.E
+	save := func(d *D, n int) {
+		for i := 0; i < n; i++ {
+			ev := event.New()
+			id := sha256.Sum256([]byte(strconv.Itoa(len(evs))))
+			ev.ID = id[:]
+			ev.Pubkey = pk[:]
+			ev.Sig = make([]byte, 64)
+			ev.CreatedAt = timestamp.FromUnix(now - int64(len(evs)))
+			ev.Kind = kind.TextNote
+			ev.Content = []byte("story " + strconv.Itoa(len(evs)))
+			ev.Tags = tags.New()
+			if _, _, err := d.SaveEvent(ctx, ev, false, nil); err != nil {
+				t.Fatal(err)
+			}
+			evs = append(evs, ev)
+		}
+	}
+	save(src, 10)
+	full := new(bytes.Buffer)
+	info, err := src.Backup(ctx, full, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Bytes != int64(full.Len()) || info.Next <= info.Upto {
+		t.Fatalf("invalid info of the full backup %+v", info)
+	}
+	// changes since the full backup, including a deletion.
+	save(src, 5)
+	if err = src.DeleteEvent(ctx, eventid.NewWith(evs[0].ID)); err != nil {
+		t.Fatal(err)
+	}
+	incremental := new(bytes.Buffer)
+	info2, err := src.Backup(ctx, incremental, info.Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info2.Since != info.Next || info2.Upto < info2.Since {
+		t.Fatalf("invalid info of the incremental backup %+v", info2)
+	}
+	// a corrupt backup fails its check.
+	corrupt := bytes.Clone(full.Bytes())
+	corrupt[len(corrupt)/2] ^= 1
+	if _, err = VerifyBackup(bytes.NewReader(corrupt)); err == nil {
+		t.Fatal("a corrupt backup passed its check")
+	}
+	if _, err = VerifyBackup(bytes.NewReader(full.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	// a backup since a version after the last backup would leave out the
+	// changes in between.
+	if _, err = src.Backup(ctx, io.Discard, info2.Next+1); err == nil {
+		t.Fatal("backed up since a version after the last backup")
+	}
+	dst := open()
+	// nothing is loaded from a corrupt backup.
+	if _, err = dst.Restore(
+		ctx, bytes.NewReader(corrupt),
+	); !errors.Is(err, store.ErrInvalidBackup) {
+		t.Fatalf("expected a corrupt backup to be invalid, got %v", err)
+	}
+	if n, err := dst.EventCount(); err != nil || n != 0 {
+		t.Fatalf("a corrupt backup restored %d events %v", n, err)
+	}
+	if _, err = dst.Restore(
+		ctx, bytes.NewReader(incremental.Bytes()),
+	); err == nil {
+		t.Fatal("restored an incremental backup before the full backup")
+	}
+	incr := bytes.Clone(incremental.Bytes())
+	for _, b := range []*bytes.Buffer{full, incremental} {
+		if _, err = dst.Restore(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = dst.Restore(ctx, bytes.NewReader(incr)); err == nil {
+		t.Fatal("restored an incremental backup twice")
+	}
+	// the store is checked for events before the backup is read.
+	if _, err = dst.Restore(
+		ctx, bytes.NewReader(corrupt),
+	); err == nil {
+		t.Fatal("restored a full backup into a store with events")
+	}
+	got, err := dst.QueryEvents(
+		ctx, &filter.F{Kinds: kinds.New(kind.TextNote)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 14 {
+		t.Fatalf("restored %d events, expected 14", len(got))
+	}
+	// the events keep their serials.
+	for _, ev := range evs[1:] {
+		s1, err := src.GetSerialById(ev.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s2, err := dst.GetSerialById(ev.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s1.Get() != s2.Get() {
+			t.Fatalf(
+				"event %0x has serial %d, expected %d", ev.ID, s2.Get(),
+				s1.Get(),
+			)
+		}
+	}
+	// new events are numbered after the restored ones.
+	save(dst, 1)
+	last, err := dst.GetSerialById(evs[len(evs)-1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range evs[1 : len(evs)-1] {
+		s, _ := dst.GetSerialById(ev.ID)
+		if s.Get() >= last.Get() {
+			t.Fatalf(
+				"new event has serial %d, not after restored %d", last.Get(),
+				s.Get(),
+			)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/log"
+	"sync"
+)
+
+// cardinality is the number of events of each kind, of each author and with
+// each tag value, which the query planner uses to estimate how many keys each
+// index it could drive a query with will match.
+//
+// The counts are taken from the kc-, pc- and tc- keys by a scan of the store
+// when it is opened, restored, rescanned or wiped, and kept up to date with
+// the keys that are written and deleted in between. They are estimates, a key
+// that is deleted twice is counted twice, until the next scan.
+type cardinality struct {
+	sync.RWMutex
+	loaded  bool
+	total   uint64
+	kinds   map[uint16]uint64
+	authors map[[8]byte]uint64
+	tags    map[[9]byte]uint64
+}
+
+// the lengths of the keys that are counted, a 3 byte prefix, the fields of
+// the key, an 8 byte timestamp and a 5 byte serial.
+const (
+	kindKeyLen   = 3 + 2 + 8 + 5
+	pubkeyKeyLen = 3 + 8 + 8 + 5
+	tagKeyLen    = 3 + 1 + 8 + 8 + 5
+)
+
+// newCardinality returns an empty set of counts.
+func newCardinality() (c *cardinality) {
+	return &cardinality{
+		kinds:   make(map[uint16]uint64),
+		authors: make(map[[8]byte]uint64),
+		tags:    make(map[[9]byte]uint64),
+	}
+}
+
+// add counts a key that has been written, if it is one that is counted, or
+// uncounts it if it has been deleted. Every event has one kc- key, so they
+// are also the total of events.
+func (c *cardinality) add(key []byte, deleted bool) {
+	switch {
+	case len(key) == kindKeyLen &&
+		bytes.HasPrefix(key, []byte(indexes.KindPrefix)):
+		bump(c.kinds, binary.BigEndian.Uint16(key[3:]), deleted)
+		if !deleted {
+			c.total++
+		} else if c.total > 0 {
+			c.total--
+		}
+	case len(key) == pubkeyKeyLen &&
+		bytes.HasPrefix(key, []byte(indexes.PubkeyPrefix)):
+		bump(c.authors, [8]byte(key[3:11]), deleted)
+	case len(key) == tagKeyLen &&
+		bytes.HasPrefix(key, []byte(indexes.TagPrefix)):
+		bump(c.tags, [9]byte(key[3:12]), deleted)
+	}
+}
+
+// bump adds one to a count, or takes one from it, removing it from the map
+// when it reaches zero so the maps don't keep the values that are no longer
+// in the store.
+func bump[K comparable](m map[K]uint64, k K, deleted bool) {
+	n := m[k]
+	if !deleted {
+		n++
+	} else if n > 0 {
+		n--
+	}
+	if n == 0 {
+		delete(m, k)
+		return
+	}
+	m[k] = n
+}
+
+// count updates the counts with the keys written or deleted by a transaction
+// that has been committed.
+func (d *D) count(keys [][]byte, deleted bool) {
+	d.card.Lock()
+	defer d.card.Unlock()
+	for _, key := range keys {
+		d.card.add(key, deleted)
+	}
+}
+
+// loadCardinality counts the kc-, pc- and tc- keys in the store, and replaces
+// the counts with them.
+func (d *D) loadCardinality() (err error) {
+	c := newCardinality()
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			for _, p := range []indexes.I{
+				indexes.KindPrefix, indexes.PubkeyPrefix, indexes.TagPrefix,
+			} {
+				prf := []byte(p)
+				it := txn.NewIterator(
+					badger.IteratorOptions{Prefix: prf},
+				)
+				for it.Rewind(); it.Valid(); it.Next() {
+					c.add(it.Item().Key(), false)
+				}
+				it.Close()
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	c.loaded = true
+	d.card.Lock()
+	d.card.loaded, d.card.total = c.loaded, c.total
+	d.card.kinds, d.card.authors, d.card.tags = c.kinds, c.authors, c.tags
+	d.card.Unlock()
+	log.D.F(
+		"counted %d events, %d kinds, %d authors and %d tag values in %s",
+		c.total, len(c.kinds), len(c.authors), len(c.tags), d.dataDir,
+	)
+	return
+}
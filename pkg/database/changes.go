@@ -0,0 +1,436 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"iter"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"sync"
+	"time"
+)
+
+// changePrefix is the prefix of the keys of the change log. Like the
+// replication queues, it is not an index prefix, so the log survives a Wipe,
+// which is recorded in it.
+//
+//	"CHNG"|8 seq
+var changePrefix = []byte("CHNG")
+
+// changeTrimKey is the key of the first Seq of the change log that has not
+// been trimmed, as 8 bytes, which is absent until the log is first trimmed.
+var changeTrimKey = []byte("CHANGETRIM")
+
+var (
+	// DefaultChangeRetention is how long the entries of the change log are
+	// kept.
+	DefaultChangeRetention = 7 * 24 * time.Hour
+	// ChangeBatchSize is the number of changes Follow reads at a time.
+	ChangeBatchSize = 256
+)
+
+// changeLog tracks the serials that have been handed out to changes that are
+// not yet committed, so that the log is only read up to the first of them,
+// and a change committed out of order is never skipped.
+type changeLog struct {
+	sync.Mutex
+	// pending are the serials of the changes being written.
+	pending map[uint64]struct{}
+	// last is the greatest serial handed out, which starts as one taken when
+	// the database is opened, so the entries made before are visible.
+	last uint64
+	// wake is closed and replaced when changes are committed.
+	wake chan struct{}
+	// retention is how long the entries are kept, zero keeps them forever.
+	retention time.Duration
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{
+		pending:   make(map[uint64]struct{}),
+		wake:      make(chan struct{}),
+		retention: DefaultChangeRetention,
+	}
+}
+
+// SetChangeRetention sets how long the entries of the change log are kept,
+// zero keeps them forever.
+func (d *D) SetChangeRetention(retention time.Duration) {
+	d.changes.Lock()
+	defer d.changes.Unlock()
+	d.changes.retention = retention
+}
+
+// changeKey returns the key of the change with a serial.
+func changeKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(bytes.Clone(changePrefix), seq)
+}
+
+// nextSerials returns n serials from the event sequence, for an event and
+// the changes of the log, which must be settled once they are committed or
+// abandoned. The serials of the events are not dense, as those of the changes
+// and the abandoned ones are skipped.
+func (d *D) nextSerials(n int) (sers []uint64, err error) {
+	d.changes.Lock()
+	defer d.changes.Unlock()
+	for range n {
+		var ser uint64
+		if ser, err = d.seq.Next(); chk.E(err) {
+			return
+		}
+		d.changes.pending[ser] = struct{}{}
+		d.changes.last = max(d.changes.last, ser)
+		sers = append(sers, ser)
+	}
+	return
+}
+
+// settle marks the serials of changes as no longer being written, and wakes
+// the readers waiting for changes.
+func (d *D) settle(sers []uint64) {
+	if len(sers) == 0 {
+		return
+	}
+	d.changes.Lock()
+	defer d.changes.Unlock()
+	for _, ser := range sers {
+		delete(d.changes.pending, ser)
+	}
+	close(d.changes.wake)
+	d.changes.wake = make(chan struct{})
+}
+
+// visible returns the serial before which all the changes of the log are
+// committed, and the channel that is closed when it may have grown.
+func (d *D) visible() (end uint64, wake <-chan struct{}) {
+	d.changes.Lock()
+	defer d.changes.Unlock()
+	end = d.changes.last + 1
+	for ser := range d.changes.pending {
+		end = min(end, ser)
+	}
+	return end, d.changes.wake
+}
+
+// logChange writes an entry of the change log in a transaction.
+func logChange(txn *badger.Txn, ch *store.Change) (err error) {
+	var v []byte
+	if v, err = json.Marshal(ch); chk.E(err) {
+		return
+	}
+	err = txn.Set(changeKey(ch.Seq), v)
+	return
+}
+
+// savedChange returns the change of an event saved with a serial.
+func savedChange(typ string, ev *event.E, serial uint64) *store.Change {
+	return &store.Change{
+		Seq: serial, Type: typ, Time: time.Now().Unix(), Serial: serial,
+		Id: hex.Enc(ev.ID), Pubkey: hex.Enc(ev.Pubkey), Kind: ev.Kind.K,
+	}
+}
+
+// deletedChange returns the change of an event with a serial deleted for a
+// reason, by the event with the id by, if there is one. Its Seq is set when it
+// is logged.
+func deletedChange(
+	ev *event.E, serial uint64, reason string, by []byte,
+) (ch *store.Change) {
+	ch = savedChange(store.ChangeDelete, ev, serial)
+	ch.Seq, ch.Reason = 0, reason
+	if by != nil {
+		ch.By = hex.Enc(by)
+	}
+	return
+}
+
+// logDeletions writes the changes of deleted events in a transaction, with
+// new serials as their Seq, which are appended to sers to be settled.
+func (d *D) logDeletions(
+	txn *badger.Txn, changes []*store.Change, sers *[]uint64,
+) (err error) {
+	var next []uint64
+	next, err = d.nextSerials(len(changes))
+	*sers = append(*sers, next...)
+	if chk.E(err) {
+		return
+	}
+	for i, ch := range changes {
+		ch.Seq = next[i]
+		if err = logChange(txn, ch); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// Changes returns up to max of the changes from a Seq, in order, and the Seq
+// to get the next changes from.
+//
+// The log is only read up to the first change that is still being written, so
+// one that is committed after a later one is not skipped. The changes of
+// events that are saved have the event, if it is still stored, and the next
+// Seq is past the last of the changes when there are gaps in the log, from
+// serials of events that were not saved.
+//
+// A from below the first Seq that has not been trimmed is refused with
+// store.ErrChangesTrimmed, as the changes after it may be lost, except 0,
+// which gets the changes from the first that is kept.
+func (d *D) Changes(c context.T, from uint64, max int) (
+	changes []*store.Change, next uint64, err error,
+) {
+	if max <= 0 {
+		max = ChangeBatchSize
+	}
+	end, _ := d.visible()
+	next = from
+	if end <= from {
+		return
+	}
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			var first uint64
+			if first, err = changesTrimmed(txn); chk.E(err) {
+				return
+			}
+			if from > 0 && from < first {
+				return errorf.E(
+					"%w: from %d, the log starts at %d",
+					store.ErrChangesTrimmed, from, first,
+				)
+			}
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: changePrefix},
+			)
+			defer it.Close()
+			for it.Seek(changeKey(from)); it.ValidForPrefix(
+				changePrefix,
+			); it.Next() {
+				if err = c.Err(); err != nil {
+					return
+				}
+				item := it.Item()
+				seq := binary.BigEndian.Uint64(item.Key()[len(changePrefix):])
+				if seq >= end {
+					break
+				}
+				if len(changes) >= max {
+					return
+				}
+				ch := new(store.Change)
+				if err = item.Value(
+					func(v []byte) error { return json.Unmarshal(v, ch) },
+				); chk.E(err) {
+					return
+				}
+				if err = changedEvent(txn, ch); chk.E(err) {
+					return
+				}
+				changes = append(changes, ch)
+				next = seq + 1
+			}
+			// all the changes before the end have been read.
+			next = end
+			return
+		},
+	); err != nil {
+		return
+	}
+	return
+}
+
+// changesTrimmed returns the first Seq of the change log that has not been
+// trimmed, which is 0 if it never was.
+func changesTrimmed(txn *badger.Txn) (first uint64, err error) {
+	var item *badger.Item
+	if item, err = txn.Get(changeTrimKey); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			err = nil
+		}
+		return
+	}
+	err = item.Value(
+		func(v []byte) error {
+			first = binary.BigEndian.Uint64(v)
+			return nil
+		},
+	)
+	return
+}
+
+// changedEvent sets the Event of the change of a saved event, if it is still
+// stored.
+func changedEvent(txn *badger.Txn, ch *store.Change) (err error) {
+	if ch.Type != store.ChangeInsert && ch.Type != store.ChangeReplace {
+		return
+	}
+	ser := new(types.Uint40)
+	if err = ser.Set(ch.Serial); chk.E(err) {
+		return
+	}
+	k := new(bytes.Buffer)
+	if err = indexes.EventEnc(ser).MarshalWrite(k); chk.E(err) {
+		return
+	}
+	var item *badger.Item
+	if item, err = txn.Get(k.Bytes()); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			// the event has since been deleted, which is a later change.
+			err = nil
+		}
+		return
+	}
+	ev := new(event.E)
+	if err = item.Value(
+		func(v []byte) error {
+			return ev.UnmarshalBinary(bytes.NewBuffer(v))
+		},
+	); chk.E(err) {
+		return
+	}
+	ch.Event = ev.ToEventJ()
+	return
+}
+
+// WaitChanges blocks until there may be changes from a Seq, or the context is
+// cancelled.
+func (d *D) WaitChanges(c context.T, from uint64) (err error) {
+	for {
+		end, wake := d.visible()
+		if end > from {
+			return
+		}
+		select {
+		case <-c.Done():
+			return c.Err()
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// Follow yields the changes from a Seq as they are made, reading
+// ChangeBatchSize of them at a time, until the iteration is stopped or the
+// context is cancelled.
+func (d *D) Follow(c context.T, from uint64) iter.Seq2[*store.Change, error] {
+	return func(yield func(*store.Change, error) bool) {
+		for {
+			changes, next, err := d.Changes(c, from, ChangeBatchSize)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, ch := range changes {
+				if !yield(ch, nil) {
+					return
+				}
+			}
+			from = next
+			if len(changes) == ChangeBatchSize {
+				continue
+			}
+			if err = d.WaitChanges(c, from); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
+
+// TrimChanges deletes the entries of the change log made before a unix time,
+// and returns how many were deleted.
+//
+// The Seq after the last of them is recorded as the first of the log before
+// any is deleted, so that Changes refuses a from that would skip them.
+func (d *D) TrimChanges(before int64) (count int, err error) {
+	var keys [][]byte
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: changePrefix},
+			)
+			defer it.Close()
+			for it.Seek(changePrefix); it.ValidForPrefix(
+				changePrefix,
+			); it.Next() {
+				item := it.Item()
+				ch := new(store.Change)
+				if err = item.Value(
+					func(v []byte) error { return json.Unmarshal(v, ch) },
+				); chk.E(err) {
+					return
+				}
+				// the entries are in the order they were made.
+				if ch.Time >= before {
+					return
+				}
+				keys = append(keys, item.KeyCopy(nil))
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	last := keys[len(keys)-1]
+	first := binary.BigEndian.Uint64(last[len(changePrefix):]) + 1
+	if err = d.Update(
+		func(txn *badger.Txn) (err error) {
+			var trimmed uint64
+			if trimmed, err = changesTrimmed(txn); chk.E(err) {
+				return
+			}
+			if first <= trimmed {
+				return
+			}
+			return txn.Set(
+				changeTrimKey, binary.BigEndian.AppendUint64(nil, first),
+			)
+		},
+	); chk.E(err) {
+		return
+	}
+	// the keys are deleted in batches, a transaction has a size limit.
+	for len(keys) > 0 {
+		n := min(len(keys), max(d.expirationPolicy().BatchSize, 1))
+		if err = d.Update(
+			func(txn *badger.Txn) (err error) {
+				for _, key := range keys[:n] {
+					if err = txn.Delete(key); chk.E(err) {
+						return
+					}
+				}
+				return
+			},
+		); chk.E(err) {
+			return
+		}
+		count += n
+		keys = keys[n:]
+	}
+	return
+}
+
+// trimChanges deletes the entries of the change log older than its
+// retention.
+func (d *D) trimChanges() (count int, err error) {
+	d.changes.Lock()
+	retention := d.changes.retention
+	d.changes.Unlock()
+	if retention <= 0 {
+		return
+	}
+	return d.TrimChanges(time.Now().Add(-retention).Unix())
+}
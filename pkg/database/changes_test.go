@@ -0,0 +1,190 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChanges(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	sign := new(p256k.Signer)
+	if err = sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	newEvent := func(k uint16, createdAt int64, kv ...string) (ev *event.E) {
+		ev = event.New()
+		ev.Kind = kind.New(k)
+		ev.CreatedAt = timestamp.FromUnix(createdAt)
+		ev.Content = []byte(strconv.FormatInt(createdAt, 10))
+		ev.Tags = tags.New()
+		for i := 0; i+1 < len(kv); i += 2 {
+			ev.Tags.AppendTags(tag.New(kv[i], kv[i+1]))
+		}
+		if err := ev.Sign(sign); err != nil {
+			t.Fatalf("Failed to sign event: %v", err)
+		}
+		return
+	}
+	save := func(ev *event.E) {
+		if _, _, err := d.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatalf("Failed to save event: %v", err)
+		}
+	}
+	note := newEvent(1, now-50)
+	v1 := newEvent(30023, now-40, "d", "article")
+	v2 := newEvent(30023, now-30, "d", "article")
+	removed := newEvent(1, now-20)
+	expiring := newEvent(
+		1, now-10, "expiration", strconv.FormatInt(now+100, 10),
+	)
+	deletion := newEvent(5, now, "e", hex.Enc(note.ID))
+	for _, ev := range []*event.E{note, v1, v2, removed, expiring, deletion} {
+		save(ev)
+	}
+	if err = d.DeleteEvent(ctx, eventid.NewWith(removed.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.DeleteExpired(now + 200); err != nil {
+		t.Fatal(err)
+	}
+	// a change that is still being written hides the changes after it.
+	var sers []uint64
+	if sers, err = d.nextSerials(1); err != nil {
+		t.Fatal(err)
+	}
+	later := newEvent(1, now)
+	save(later)
+	describe := func(changes []*store.Change) (s []string) {
+		for _, ch := range changes {
+			desc := ch.Type + " " + ch.Id[:8]
+			if ch.Reason != "" {
+				desc += " " + ch.Reason
+			}
+			if ch.By != "" {
+				desc += " by " + ch.By[:8]
+			}
+			if ch.Event != nil {
+				desc += " stored"
+			}
+			s = append(s, desc)
+		}
+		return
+	}
+	id := func(ev *event.E) string { return hex.Enc(ev.ID)[:8] }
+	want := []string{
+		"insert " + id(note),
+		"insert " + id(v1),
+		"replace " + id(v2) + " stored",
+		"delete " + id(v1) + " replaced by " + id(v2),
+		"insert " + id(removed),
+		"insert " + id(expiring),
+		"insert " + id(deletion) + " stored",
+		"delete " + id(note) + " deletion by " + id(deletion),
+		"delete " + id(removed) + " removed",
+		"delete " + id(expiring) + " expired",
+	}
+	changes, next, err := d.Changes(ctx, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(describe(changes)) != fmt.Sprint(want) {
+		t.Fatalf("changes are\n%v\nexpected\n%v", describe(changes), want)
+	}
+	for i, ch := range changes {
+		if i > 0 && ch.Seq <= changes[i-1].Seq {
+			t.Fatalf("change %d is not after the one before it", i)
+		}
+	}
+	if next != sers[0] {
+		t.Fatalf("next is %d, expected the pending serial %d", next, sers[0])
+	}
+	d.settle(sers)
+	if changes, next, err = d.Changes(ctx, next, 100); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(describe(changes)) != fmt.Sprint(
+		[]string{"insert " + id(later) + " stored"},
+	) {
+		t.Fatalf("changes after the pending one are %v", describe(changes))
+	}
+	// the log is read in pages from the next of the page before.
+	var paged []*store.Change
+	for from := uint64(0); ; {
+		var page []*store.Change
+		if page, from, err = d.Changes(ctx, from, 3); err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+	}
+	if len(paged) != len(want)+1 {
+		t.Fatalf(
+			"paged through %d changes, expected %d", len(paged), len(want)+1,
+		)
+	}
+	// Follow waits for the changes after those in the log.
+	c, stop := context.Timeout(ctx, 10*time.Second)
+	defer stop()
+	followed := make(chan *store.Change)
+	go func() {
+		for ch, err := range d.Follow(c, next) {
+			if err != nil {
+				close(followed)
+				return
+			}
+			followed <- ch
+		}
+	}()
+	if err = d.Wipe(); err != nil {
+		t.Fatal(err)
+	}
+	ch, ok := <-followed
+	if !ok || ch.Type != store.ChangeWipe || ch.Seq < next {
+		t.Fatalf("followed %+v, expected the wipe", ch)
+	}
+	// the changes from a seq that has been trimmed are refused, from 0 they
+	// start at the first that is kept.
+	if _, err = d.TrimChanges(time.Now().Unix() + 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = d.Changes(
+		ctx, ch.Seq, 100,
+	); !errors.Is(err, store.ErrChangesTrimmed) {
+		t.Fatalf("changes from a trimmed seq returned %v", err)
+	}
+	if changes, next, err = d.Changes(ctx, 0, 100); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 || next <= ch.Seq {
+		t.Fatalf(
+			"%d changes from 0 after the log was trimmed, next %d",
+			len(changes), next,
+		)
+	}
+	if _, _, err = d.Changes(ctx, next, 100); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+)
+
+// configurationKey is the key the configuration document is stored under. It
+// is not an index prefix, so it survives a Wipe.
+var configurationKey = []byte("CONFIGURATION")
+
+// GetConfiguration returns the configuration stored in the database, or nil if
+// none has been stored.
+func (d *D) GetConfiguration() (c *store.Configuration, err error) {
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			var item *badger.Item
+			if item, err = txn.Get(configurationKey); err != nil {
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					err = nil
+				}
+				return
+			}
+			return item.Value(
+				func(val []byte) (err error) {
+					c = new(store.Configuration)
+					return json.Unmarshal(val, c)
+				},
+			)
+		},
+	); chk.E(err) {
+		return
+	}
+	return
+}
+
+// SetConfiguration stores the configuration in the database, replacing any
+// that was stored before.
+func (d *D) SetConfiguration(c *store.Configuration) (err error) {
+	var b []byte
+	if b, err = json.Marshal(c); chk.E(err) {
+		return
+	}
+	if err = d.Update(
+		func(txn *badger.Txn) (err error) {
+			return txn.Set(configurationKey, b)
+		},
+	); chk.E(err) {
+		return
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestConfiguration(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-db-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, tempDir, "info")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	var c *store.Configuration
+	if c, err = db.GetConfiguration(); err != nil {
+		t.Fatalf("Failed to get configuration: %v", err)
+	}
+	if c != nil {
+		t.Fatalf("Expected no configuration, got %v", c)
+	}
+	want := &store.Configuration{
+		Owners:     []string{"npub1abc"},
+		Whitelist:  []string{"127.0.0.1"},
+		LogLevel:   "debug",
+		MaxFilters: 5,
+	}
+	if err = db.SetConfiguration(want); err != nil {
+		t.Fatalf("Failed to set configuration: %v", err)
+	}
+	// the configuration is not an index, so it is kept when the database is
+	// wiped.
+	if err = db.Wipe(); err != nil {
+		t.Fatalf("Failed to wipe database: %v", err)
+	}
+	if c, err = db.GetConfiguration(); err != nil {
+		t.Fatalf("Failed to get configuration: %v", err)
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("Expected configuration %v, got %v", want, c)
+	}
+}
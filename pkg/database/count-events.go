@@ -0,0 +1,249 @@
+package database
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"time"
+)
+
+// CountEvents returns the number of stored events that match a filter, as
+// required for the NIP-45 COUNT request.
+//
+// The count is of the events a query for the filter returns, but only the
+// index keys are scanned, the serial at the end of each key is all that is
+// needed, so no event is fetched or decoded. The filter limit is ignored, as
+// a count is of all the matching events.
+//
+// The events that match a filter are found by GetSerialsByFilter, or for a
+// search by the results of QueryForIds, which also only reads the indexes.
+//
+// Deletion events, expired events and the superseded versions of replaceable
+// events are then taken out by the Kind, Expiration and Address indexes. The
+// events of a filter with Ids are those with the ids that also match the rest
+// of the filter, and only the expired ones are taken out, as by QueryEvents.
+//
+// The approximate flag is always false as the count is computed exactly.
+func (d *D) CountEvents(c context.T, f *filter.F) (
+	count int, approximate bool, err error,
+) {
+	now := time.Now().Unix()
+	var found map[uint64]struct{}
+	if f.Ids != nil && f.Ids.Len() > 0 {
+		if found, err = d.countIds(c, f); chk.E(err) {
+			return
+		}
+	} else {
+		if found, err = d.matchingSerials(c, f); chk.E(err) {
+			return
+		}
+		if err = d.uncountDeletions(f, found); chk.E(err) {
+			return
+		}
+		if err = d.uncountSuperseded(f, found); chk.E(err) {
+			return
+		}
+	}
+	if err = d.uncountExpired(now, found); chk.E(err) {
+		return
+	}
+	count = len(found)
+	return
+}
+
+// countIds returns the serials of the events with the ids of a filter that
+// match the rest of it, which is checked by finding the serial among those
+// that match the rest of the filter at the timestamp of the event.
+func (d *D) countIds(c context.T, f *filter.F) (
+	found map[uint64]struct{}, err error,
+) {
+	found = make(map[uint64]struct{})
+	for _, id := range f.Ids.ToSliceOfBytes() {
+		var ser *types.Uint40
+		if ser, err = d.GetSerialById(id); err != nil || ser == nil {
+			err = nil
+			continue
+		}
+		var fidpk *store.IdPkTs
+		if fidpk, err = d.GetFullIdPubkeyBySerial(ser); chk.E(err) {
+			return
+		}
+		if fidpk == nil ||
+			(f.Since != nil && f.Since.I64() != 0 && fidpk.Ts < f.Since.I64()) ||
+			(f.Until != nil && f.Until.I64() != 0 && fidpk.Ts > f.Until.I64()) {
+			continue
+		}
+		ff := *f
+		ff.Ids, ff.Limit, ff.Cursor = nil, nil, nil
+		ff.Since, ff.Until = timestamp.FromUnix(fidpk.Ts),
+			timestamp.FromUnix(fidpk.Ts)
+		var sers map[uint64]struct{}
+		if sers, err = d.matchingSerials(c, &ff); chk.E(err) {
+			return
+		}
+		if _, ok := sers[fidpk.Ser]; ok {
+			found[fidpk.Ser] = struct{}{}
+		}
+	}
+	return
+}
+
+// matchingSerials returns the set of serials of the events that match the
+// fields of a filter other than its Ids.
+func (d *D) matchingSerials(c context.T, f *filter.F) (
+	found map[uint64]struct{}, err error,
+) {
+	// the limit and cursor do not apply to a count
+	ff := *f
+	ff.Limit, ff.Cursor = nil, nil
+	if len(ff.Search) == 0 {
+		return d.GetSerialsByFilter(&ff)
+	}
+	var idPkTs []store.IdPkTs
+	if idPkTs, err = d.QueryForIds(c, &ff); chk.E(err) {
+		return
+	}
+	found = make(map[uint64]struct{}, len(idPkTs))
+	for _, idpk := range idPkTs {
+		found[idpk.Ser] = struct{}{}
+	}
+	return
+}
+
+// uncountDeletions removes the serials of deletion events from found, which
+// are those in the Kind index of kind 5 in the time range of the filter.
+func (d *D) uncountDeletions(f *filter.F, found map[uint64]struct{}) (
+	err error,
+) {
+	if len(found) == 0 ||
+		(f.Kinds != nil && f.Kinds.Len() > 0 && !f.Kinds.Contains(kind.Deletion)) {
+		return
+	}
+	var deletions map[uint64]struct{}
+	if deletions, err = d.getSerialsByRanges(
+		&filter.F{
+			Kinds: kinds.New(kind.Deletion), Since: f.Since, Until: f.Until,
+		},
+	); chk.E(err) {
+		return
+	}
+	for ser := range deletions {
+		delete(found, ser)
+	}
+	return
+}
+
+// uncountSuperseded removes from found the serials of the versions of
+// replaceable events for which a newer version is in found, as a query only
+// returns the newest version of each that matches the filter.
+//
+// The versions are in the Address index in order of their timestamps, so the
+// one found last for an address is the newest.
+func (d *D) uncountSuperseded(f *filter.F, found map[uint64]struct{}) (
+	err error,
+) {
+	if len(found) == 0 {
+		return
+	}
+	var prfs [][]byte
+	if f.Kinds != nil && f.Kinds.Len() > 0 {
+		for _, k := range f.Kinds.K {
+			if !k.IsReplaceable() && !k.IsParameterizedReplaceable() {
+				continue
+			}
+			kk := new(types.Uint16)
+			kk.Set(k.K)
+			buf := new(bytes.Buffer)
+			if err = indexes.NewPrefix(indexes.Address).MarshalWrite(
+				buf,
+			); chk.E(err) {
+				return
+			}
+			if err = kk.MarshalWrite(buf); chk.E(err) {
+				return
+			}
+			prfs = append(prfs, buf.Bytes())
+		}
+	} else {
+		prfs = append(prfs, []byte(indexes.AddressPrefix))
+	}
+	// the address is the prefix, kind, pubkey hash and d tag hash, before
+	// the timestamp and serial.
+	const addressLen = 3 + 2 + 8 + 8
+	err = d.View(
+		func(txn *badger.Txn) (err error) {
+			for _, prf := range prfs {
+				it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+				var last []byte
+				var lastSer uint64
+				for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+					key := it.Item().Key()
+					ser := new(types.Uint40)
+					if err = ser.UnmarshalRead(
+						bytes.NewBuffer(key[len(key)-5:]),
+					); chk.E(err) {
+						it.Close()
+						return
+					}
+					if _, ok := found[ser.Get()]; !ok {
+						continue
+					}
+					if last != nil && bytes.Equal(last, key[:addressLen]) {
+						delete(found, lastSer)
+					}
+					last = append(last[:0], key[:addressLen]...)
+					lastSer = ser.Get()
+				}
+				it.Close()
+			}
+			return
+		},
+	)
+	return
+}
+
+// uncountExpired removes from found the serials of the events in the
+// Expiration index that expired before now, and have not yet been deleted.
+func (d *D) uncountExpired(now int64, found map[uint64]struct{}) (
+	err error,
+) {
+	if len(found) == 0 {
+		return
+	}
+	end := new(bytes.Buffer)
+	exp := new(types.Uint64)
+	exp.Set(uint64(now))
+	if err = indexes.ExpirationEnc(exp, nil).MarshalWrite(end); chk.E(err) {
+		return
+	}
+	prf := []byte(indexes.ExpirationPrefix)
+	err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+			defer it.Close()
+			for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+				key := it.Item().Key()
+				if bytes.Compare(key, end.Bytes()) >= 0 {
+					return
+				}
+				ser := new(types.Uint40)
+				if err = ser.UnmarshalRead(
+					bytes.NewBuffer(key[len(key)-5:]),
+				); chk.E(err) {
+					return
+				}
+				delete(found, ser.Get())
+			}
+			return
+		},
+	)
+	return
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/event/examples"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"os"
+	"testing"
+)
+
+func TestCountEvents(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-db-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, tempDir, "info")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	// retain superseded versions of replaceable events, which are not counted
+	db.SetReplacePolicy(ReplacePolicy{History: true})
+	scanner := bufio.NewScanner(bytes.NewBuffer(examples.Cache))
+	scanner.Buffer(make([]byte, 0, 1_000_000_000), 1_000_000_000)
+	var events event.S
+	for scanner.Scan() {
+		ev := event.New()
+		if _, err = ev.Unmarshal(scanner.Bytes()); chk.E(err) {
+			t.Fatal(err)
+		}
+		if _, _, err = db.SaveEvent(ctx, ev, false, nil); err != nil {
+			// events may be rejected, eg by deletes; only count stored ones.
+			continue
+		}
+		events = append(events, ev)
+	}
+	var total uint64
+	if total, err = db.EventCount(); chk.E(err) {
+		t.Fatal(err)
+	}
+	if total != uint64(len(events)) {
+		t.Fatalf("EventCount got %d, expected %d", total, len(events))
+	}
+	// find a tagged event to construct tag filters from
+	var tagged *event.E
+	for _, ev := range events {
+		if ev.Tags.Len() > 1 && ev.Tags.ToSliceOfTags()[0].Len() > 1 &&
+			ev.Tags.ToSliceOfTags()[1].Len() > 1 &&
+			!bytes.Equal(
+				ev.Tags.ToSliceOfTags()[0].Key(),
+				ev.Tags.ToSliceOfTags()[1].Key(),
+			) &&
+			len(ev.Tags.ToSliceOfTags()[0].Key()) == 1 &&
+			len(ev.Tags.ToSliceOfTags()[1].Key()) == 1 {
+			tagged = ev
+			break
+		}
+	}
+	ff := []*filter.F{
+		{Kinds: kinds.New(kind.TextNote)},
+		{Authors: tag.New(events[1].Pubkey)},
+		{
+			Kinds:   kinds.New(events[1].Kind),
+			Authors: tag.New(events[1].Pubkey),
+		},
+		{Kinds: kinds.New(kind.ProfileMetadata, kind.FollowList)},
+		{Ids: tag.New(events[0].ID, events[5].ID)},
+		filter.New(),
+	}
+	if tagged != nil {
+		t0, t1 := tagged.Tags.ToSliceOfTags()[0], tagged.Tags.ToSliceOfTags()[1]
+		f := filter.New()
+		f.Tags.AppendTags(
+			tag.New(append([]byte{'#'}, t0.Key()...), t0.Value()),
+			tag.New(append([]byte{'#'}, t1.Key()...), t1.Value()),
+		)
+		ff = append(ff, f)
+	}
+	for _, f := range ff {
+		// the count is of the events a query returns, which leaves out the
+		// deletion events and the superseded versions of replaceable events.
+		var evs event.S
+		if evs, err = db.QueryEvents(ctx, f); chk.E(err) {
+			t.Fatal(err)
+		}
+		var count int
+		if count, _, err = db.CountEvents(ctx, f); chk.E(err) {
+			t.Fatal(err)
+		}
+		if count != len(evs) {
+			t.Fatalf(
+				"CountEvents got %d, expected %d for filter %s",
+				count, len(evs), f.Marshal(nil),
+			)
+		}
+	}
+	// the ids of a filter must match the rest of it too.
+	other := kind.TextNote
+	if events[0].Kind.Equal(kind.TextNote) {
+		other = kind.ProfileMetadata
+	}
+	var count int
+	if count, _, err = db.CountEvents(
+		ctx, &filter.F{Ids: tag.New(events[0].ID), Kinds: kinds.New(other)},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("CountEvents got %d for an id of another kind", count)
+	}
+}
@@ -2,7 +2,7 @@ package database
 
 import (
 	"github.com/dgraph-io/badger/v4"
-	"orly.dev/pkg/encoders/eventidserial"
+	"orly.dev/pkg/interfaces/store"
 	"orly.dev/pkg/utils/apputil"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
@@ -11,6 +11,7 @@ import (
 	"orly.dev/pkg/utils/units"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 type D struct {
@@ -20,6 +21,39 @@ type D struct {
 	Logger  *logger
 	*badger.DB
 	seq *badger.Sequence
+	// replSeq numbers the items of the outbound queues of peer relays.
+	replSeq *badger.Sequence
+	// replaceMx serializes the saving of replaceable events, so that two
+	// versions can't both be found to be the newest.
+	replaceMx     sync.Mutex
+	replacePolicy ReplacePolicy
+	// rescanMx is held for reading by the writes of events and their indexes,
+	// and for writing by a Rescan, so that none happen while the indexes are
+	// rebuilt.
+	rescanMx sync.RWMutex
+	// expiryMx guards the ExpirationPolicy.
+	expiryMx     sync.Mutex
+	expiryPolicy ExpirationPolicy
+	// gcMx guards the GCPolicy and the result of the last collection, and
+	// gcRunMx is held while the garbage is collected.
+	gcMx     sync.Mutex
+	gcRunMx  sync.Mutex
+	gcPolicy GCPolicy
+	lastGC   *store.GCRun
+	// card is the number of events of each kind, author and tag value, for
+	// the query planner.
+	card *cardinality
+	// planMx guards the PlanCosts of the query planner.
+	planMx    sync.Mutex
+	planCosts PlanCosts
+	// tagRules are the tags indexed in addition to those with a one letter
+	// key.
+	tagRulesMx sync.RWMutex
+	tagRules   []TagRule
+	// changes tracks the entries of the change log being written.
+	changes *changeLog
+	// meter is the usage of each author, for their quotas.
+	meter *meter
 }
 
 func New(ctx context.T, cancel context.F, dataDir, logLevel string) (
@@ -32,6 +66,14 @@ func New(ctx context.T, cancel context.F, dataDir, logLevel string) (
 		Logger:  NewLogger(lol.GetLogLevel(logLevel), dataDir),
 		DB:      nil,
 		seq:     nil,
+
+		replacePolicy: DefaultReplacePolicy,
+		expiryPolicy:  DefaultExpirationPolicy,
+		gcPolicy:      DefaultGCPolicy,
+		planCosts:     DefaultPlanCosts,
+		card:          newCardinality(),
+		changes:       newChangeLog(),
+		meter:         newMeter(),
 	}
 
 	// Ensure the data directory exists
@@ -57,10 +99,23 @@ func New(ctx context.T, cancel context.F, dataDir, logLevel string) (
 	if d.seq, err = d.DB.GetSequence([]byte("EVENTS"), 1000); chk.E(err) {
 		return
 	}
+	if d.changes.last, err = d.seq.Next(); chk.E(err) {
+		return
+	}
+	if d.replSeq, err = d.DB.GetSequence(
+		[]byte("REPLICATION"), 100,
+	); chk.E(err) {
+		return
+	}
+	go d.reapExpired()
+	go d.collectGarbage()
+	go d.loadCardinality()
+	go d.loadUsage()
 	go func() {
 		<-d.ctx.Done()
 		d.cancel()
 		d.seq.Release()
+		d.replSeq.Release()
 		d.DB.Close()
 	}()
 	return
@@ -69,22 +124,10 @@ func New(ctx context.T, cancel context.F, dataDir, logLevel string) (
 // Path returns the path where the database files are stored.
 func (d *D) Path() string { return d.dataDir }
 
-func (d *D) Wipe() (err error) {
-	// TODO implement me
-	panic("implement me")
-}
-
 func (d *D) SetLogLevel(level string) {
 	d.Logger.SetLogLevel(lol.GetLogLevel(level))
 }
 
-func (d *D) EventIdsBySerial(start uint64, count int) (
-	evs []eventidserial.E, err error,
-) {
-	// TODO implement me
-	panic("implement me")
-}
-
 // Init initializes the database with the given path.
 func (d *D) Init(path string) (err error) {
 	// The database is already initialized in the New function,
@@ -106,6 +149,11 @@ func (d *D) Close() (err error) {
 			return
 		}
 	}
+	if d.replSeq != nil {
+		if err = d.replSeq.Release(); chk.E(err) {
+			return
+		}
+	}
 	if d.DB != nil {
 		if err = d.DB.Close(); chk.E(err) {
 			return
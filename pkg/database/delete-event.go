@@ -7,14 +7,20 @@ import (
 	"orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/interfaces/store"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
 )
 
-// DeleteEvent removes an event from the database identified by `eid`. If
-// noTombstone is false or not provided, a tombstone is created for the event.
+// DeleteEvent removes an event from the database identified by `eid`.
+//
+// No tombstone is recorded for the event, so it can be saved again. Events
+// deleted by a NIP-09 deletion event are removed by SaveEvent instead. The
+// deletion is recorded in the change log.
 func (d *D) DeleteEvent(c context.T, eid *eventid.T) (err error) {
 	d.Logger.Warningf("deleting event %0x", eid.Bytes())
+	d.rescanMx.RLock()
+	defer d.rescanMx.RUnlock()
 
 	// Get the serial number for the event ID
 	var ser *types.Uint40
@@ -38,7 +44,7 @@ func (d *D) DeleteEvent(c context.T, eid *eventid.T) (err error) {
 	}
 	// Get all indexes for the event
 	var idxs [][]byte
-	idxs, err = GetIndexesForEvent(ev, ser.Get())
+	idxs, err = d.indexesForEvent(ev, ser.Get())
 	if chk.E(err) {
 		return
 	}
@@ -48,8 +54,18 @@ func (d *D) DeleteEvent(c context.T, eid *eventid.T) (err error) {
 		return
 	}
 	// Delete the event and all its indexes in a transaction
+	var sers []uint64
+	defer func() { d.settle(sers) }()
+	done := d.startWrite()
 	err = d.Update(
 		func(txn *badger.Txn) (err error) {
+			if err = d.logDeletions(
+				txn, []*store.Change{
+					deletedChange(ev, ser.Get(), store.ReasonRemoved, nil),
+				}, &sers,
+			); chk.E(err) {
+				return
+			}
 			// Delete the event
 			if err = txn.Delete(eventKey.Bytes()); chk.E(err) {
 				return
@@ -63,5 +79,11 @@ func (d *D) DeleteEvent(c context.T, eid *eventid.T) (err error) {
 			return
 		},
 	)
+	m := make(metered)
+	m.add(ev, true)
+	done(m, err == nil)
+	if err == nil {
+		d.count(idxs, true)
+	}
 	return
 }
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/log"
+	"time"
+)
+
+// ExpirationPolicy decides how often and in what batches the events with a
+// NIP-40 expiration timestamp in the past are deleted.
+type ExpirationPolicy struct {
+	// ReapInterval is the time between scans for expired events to delete.
+	ReapInterval time.Duration
+	// BatchSize is the maximum number of expired events deleted in one
+	// transaction.
+	BatchSize int
+}
+
+// DefaultExpirationPolicy scans for expired events every minute and deletes
+// them 256 at a time.
+var DefaultExpirationPolicy = ExpirationPolicy{
+	ReapInterval: time.Minute,
+	BatchSize:    256,
+}
+
+// SetExpirationPolicy sets the policy for deleting expired events. It applies
+// from the next scan.
+func (d *D) SetExpirationPolicy(p ExpirationPolicy) {
+	d.expiryMx.Lock()
+	defer d.expiryMx.Unlock()
+	d.expiryPolicy = p
+}
+
+// expirationPolicy returns the current ExpirationPolicy.
+func (d *D) expirationPolicy() (p ExpirationPolicy) {
+	d.expiryMx.Lock()
+	defer d.expiryMx.Unlock()
+	return d.expiryPolicy
+}
+
+// DeleteExpired deletes all events with a NIP-40 expiration timestamp before
+// now, along with all of their indexes, in batches of the BatchSize of the
+// ExpirationPolicy per transaction. It returns the number of events deleted.
+func (d *D) DeleteExpired(now int64) (count int, err error) {
+	batch := max(d.expirationPolicy().BatchSize, 1)
+	for {
+		var n int
+		var more bool
+		if n, more, err = d.deleteExpiredBatch(now, batch); chk.E(err) {
+			return
+		}
+		count += n
+		if !more {
+			return
+		}
+	}
+}
+
+// deleteExpiredBatch deletes up to batch of the events found in the
+// Expiration index with a timestamp before now, and records their deletion in
+// the change log. more is true if a whole batch of the index was read, so
+// there may be more expired events.
+//
+// An expired event that can't be decoded is logged and its Expiration key is
+// deleted without it, otherwise it would be found first by every scan and
+// stop the expiry of all the events after it.
+func (d *D) deleteExpiredBatch(now int64, batch int) (
+	count int, more bool, err error,
+) {
+	d.rescanMx.RLock()
+	defer d.rescanMx.RUnlock()
+	end := new(bytes.Buffer)
+	exp := new(types.Uint64)
+	exp.Set(uint64(now))
+	if err = indexes.ExpirationEnc(exp, nil).MarshalWrite(end); chk.E(err) {
+		return
+	}
+	prf := []byte(indexes.ExpirationPrefix)
+	// collect the keys first, the iterator must be closed before deleting.
+	var keys [][]byte
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: prf},
+			)
+			defer it.Close()
+			for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				// keys with an expiration at or after now sort after the end
+				if bytes.Compare(key, end.Bytes()) >= 0 {
+					return
+				}
+				keys = append(keys, key)
+				if len(keys) >= batch {
+					return
+				}
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	more = len(keys) >= batch
+	var gone [][]byte
+	var sers []uint64
+	defer func() { d.settle(sers) }()
+	m := make(metered)
+	done := d.startWrite()
+	err = d.Update(
+		func(txn *badger.Txn) (err error) {
+			for _, key := range keys {
+				ser := new(types.Uint40)
+				if err = ser.UnmarshalRead(
+					bytes.NewBuffer(key[len(key)-5:]),
+				); chk.E(err) {
+					return
+				}
+				evKey := new(bytes.Buffer)
+				if err = indexes.EventEnc(ser).MarshalWrite(evKey); chk.E(err) {
+					return
+				}
+				var item *badger.Item
+				if item, err = txn.Get(evKey.Bytes()); err != nil {
+					if !errors.Is(err, badger.ErrKeyNotFound) {
+						chk.E(err)
+						return
+					}
+					// the event is already gone, remove the dangling index
+					err = nil
+					if err = txn.Delete(key); chk.E(err) {
+						return
+					}
+					continue
+				}
+				var v []byte
+				if v, err = item.ValueCopy(nil); chk.E(err) {
+					return
+				}
+				ev := new(event.E)
+				var idxs [][]byte
+				if err = ev.UnmarshalBinary(bytes.NewBuffer(v)); err == nil {
+					idxs, err = d.indexesForEvent(ev, ser.Get())
+				}
+				if err != nil {
+					log.E.F(
+						"skipping expired event %d that can't be decoded: %v",
+						ser.Get(), err,
+					)
+					if err = txn.Delete(key); chk.E(err) {
+						return
+					}
+					continue
+				}
+				for _, k := range idxs {
+					if err = txn.Delete(k); chk.E(err) {
+						return
+					}
+				}
+				if err = txn.Delete(evKey.Bytes()); chk.E(err) {
+					return
+				}
+				if err = d.logDeletions(
+					txn, []*store.Change{
+						deletedChange(
+							ev, ser.Get(), store.ReasonExpired, nil,
+						),
+					}, &sers,
+				); chk.E(err) {
+					return
+				}
+				gone = append(gone, idxs...)
+				m.add(ev, true)
+				count++
+			}
+			return
+		},
+	)
+	done(m, err == nil)
+	if err == nil {
+		d.count(gone, true)
+	}
+	return
+}
+
+// reapExpired runs DeleteExpired every ReapInterval of the ExpirationPolicy
+// until the database context is cancelled, and trims the change log to its
+// retention.
+func (d *D) reapExpired() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(d.expirationPolicy().ReapInterval):
+			n, err := d.DeleteExpired(time.Now().Unix())
+			if err != nil {
+				log.E.F("failed to delete expired events: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.I.F("deleted %d expired events", n)
+			}
+			if n, err = d.trimChanges(); err != nil {
+				log.E.F("failed to trim the change log: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.I.F("trimmed %d entries of the change log", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeleteExpired(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-db-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, tempDir, "info")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("pubkey"))
+	newEvent := func(i int, exp int64) (ev *event.E) {
+		ev = event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(i)))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - 10)
+		ev.Kind = kind.TextNote
+		ev.Content = []byte("story " + strconv.Itoa(i))
+		ev.Tags = tags.New()
+		if exp != 0 {
+			ev.Tags.AppendTags(
+				tag.New("expiration", strconv.FormatInt(exp, 10)),
+			)
+		}
+		return
+	}
+	// already expired events are rejected
+	if _, _, err = db.SaveEvent(
+		ctx, newEvent(0, now-1), false, nil,
+	); err == nil {
+		t.Fatal("expected expired event to be rejected")
+	}
+	// more than a batch of events that expire later, and some that never do
+	db.SetExpirationPolicy(
+		ExpirationPolicy{ReapInterval: time.Minute, BatchSize: 10},
+	)
+	for i := 1; i <= 25; i++ {
+		exp := now + 100
+		if i > 20 {
+			exp = 0
+		}
+		if _, _, err = db.SaveEvent(
+			ctx, newEvent(i, exp), false, nil,
+		); chk.E(err) {
+			t.Fatal(err)
+		}
+	}
+	var n int
+	if n, err = db.DeleteExpired(now); chk.E(err) {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("deleted %d events before they expired", n)
+	}
+	if n, err = db.DeleteExpired(now + 101); chk.E(err) {
+		t.Fatal(err)
+	}
+	if n != 20 {
+		t.Fatalf("deleted %d expired events, expected 20", n)
+	}
+	var count uint64
+	if count, err = db.EventCount(); chk.E(err) {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Fatalf("%d events remain, expected 5", count)
+	}
+	// the indexes of the deleted events are gone too
+	var c int
+	if c, _, err = db.CountEvents(
+		ctx, &filter.F{Search: []byte("story")},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	if c != 5 {
+		t.Fatalf("%d events found in indexes, expected 5", c)
+	}
+	// events that expire after being saved are not returned by queries
+	if _, _, err = db.SaveEvent(
+		ctx, newEvent(100, time.Now().Unix()+1), false, nil,
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	time.Sleep(2100 * time.Millisecond)
+	var evs event.S
+	if evs, err = db.QueryEvents(
+		ctx, &filter.F{Kinds: kinds.New(kind.TextNote)},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	if len(evs) != 5 {
+		t.Fatalf("query returned %d events, expected 5", len(evs))
+	}
+}
+
+func TestDeleteExpiredUndecodable(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("pubkey"))
+	for i := 0; i < 3; i++ {
+		ev := event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(i)))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - 10)
+		ev.Kind = kind.TextNote
+		ev.Tags = tags.New(
+			tag.New("expiration", strconv.FormatInt(now+100, 10)),
+		)
+		if _, _, err = db.SaveEvent(ctx, ev, false, nil); chk.E(err) {
+			t.Fatal(err)
+		}
+	}
+	// corrupt the event found first in the expiration index
+	prf := []byte(indexes.ExpirationPrefix)
+	if err = db.Update(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+			it.Seek(prf)
+			key := it.Item().KeyCopy(nil)
+			it.Close()
+			ser := new(types.Uint40)
+			if err = ser.UnmarshalRead(
+				bytes.NewBuffer(key[len(key)-5:]),
+			); chk.E(err) {
+				return
+			}
+			evKey := new(bytes.Buffer)
+			if err = indexes.EventEnc(ser).MarshalWrite(evKey); chk.E(err) {
+				return
+			}
+			return txn.Set(evKey.Bytes(), []byte{0xff})
+		},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	var n int
+	if n, err = db.DeleteExpired(now + 101); chk.E(err) {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("deleted %d expired events, expected 2", n)
+	}
+	// the expiration key of the corrupt event is gone, so it isn't found
+	// again by the next scans.
+	var found bool
+	if err = db.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+			defer it.Close()
+			it.Seek(prf)
+			found = it.ValidForPrefix(prf)
+			return
+		},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	if found {
+		t.Fatal("the expiration key of the corrupt event was not deleted")
+	}
+}
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/utils/chk"
+)
+
+// EventCount returns the total number of events in the store.
+//
+// This only iterates the keys of the event table, the values are never read.
+func (d *D) EventCount() (count uint64, err error) {
+	prf := []byte(indexes.EventPrefix)
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{
+					Prefix:         prf,
+					PrefetchValues: false,
+				},
+			)
+			defer it.Close()
+			for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+				count++
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/eventidserial"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils/chk"
+)
+
+// EventIdsBySerial returns the event Ids and serials of up to count events,
+// starting from the given serial, in the order they were stored.
+//
+// Only the FullIdPubkey index is read, which is ordered by serial, so no event
+// is decoded. To page through all events, call again with a start one greater
+// than the last serial returned, until fewer than count are returned.
+//
+// The serials are not dense: the entries of the change log take their Seq
+// from the same sequence as the events, as do the events that are not saved
+// in the end, and the part of its lease that is unused when the store is
+// closed is skipped, so a gap between two serials is not a missing event.
+func (d *D) EventIdsBySerial(start uint64, count int) (
+	evs []eventidserial.E, err error,
+) {
+	if count <= 0 {
+		return
+	}
+	ser := new(types.Uint40)
+	if err = ser.Set(start); chk.E(err) {
+		return
+	}
+	from := new(bytes.Buffer)
+	if err = indexes.FullIdPubkeyEnc(
+		ser, nil, nil, nil,
+	).MarshalWrite(from); chk.E(err) {
+		return
+	}
+	prf := []byte(indexes.FullIdPubkeyPrefix)
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+			defer it.Close()
+			for it.Seek(from.Bytes()); it.ValidForPrefix(prf); it.Next() {
+				s, fid, p, ca := indexes.FullIdPubkeyVars()
+				if err = indexes.FullIdPubkeyDec(
+					s, fid, p, ca,
+				).UnmarshalRead(
+					bytes.NewBuffer(it.Item().KeyCopy(nil)),
+				); chk.E(err) {
+					return
+				}
+				evs = append(
+					evs, eventidserial.E{
+						Serial:  s.Get(),
+						EventId: hex.Enc(fid.Bytes()),
+					},
+				)
+				if len(evs) >= count {
+					return
+				}
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	return
+}
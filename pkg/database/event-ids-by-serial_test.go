@@ -0,0 +1,54 @@
+package database
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventidserial"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils/chk"
+	"os"
+	"testing"
+)
+
+func TestEventIdsBySerial(t *testing.T) {
+	db, events, _, cancel, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir)
+	defer cancel()
+	defer db.Close()
+	// superseded versions of replaceable events are deleted when the newer
+	// version is saved, so only the events that are still stored are expected.
+	var stored []*event.E
+	for _, ev := range events {
+		if ser, _ := db.GetSerialById(ev.ID); ser != nil {
+			stored = append(stored, ev)
+		}
+	}
+	events = stored
+	var all []eventidserial.E
+	var start uint64
+	for {
+		page, err := db.EventIdsBySerial(start, 100)
+		if chk.E(err) {
+			t.Fatal(err)
+		}
+		all = append(all, page...)
+		if len(page) < 100 {
+			break
+		}
+		start = page[len(page)-1].Serial + 1
+	}
+	if len(all) != len(events) {
+		t.Fatalf("got %d event ids, expected %d", len(all), len(events))
+	}
+	for i := range all {
+		if i > 0 && all[i].Serial <= all[i-1].Serial {
+			t.Fatalf("serials out of order at %d", i)
+		}
+		// events are saved in order, so the serials follow the same order
+		if all[i].EventId != hex.Enc(events[i].ID) {
+			t.Fatalf(
+				"event id %d is %s, expected %0x", i, all[i].EventId,
+				events[i].ID,
+			)
+		}
+	}
+}
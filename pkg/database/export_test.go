@@ -34,6 +34,8 @@ func TestExport(t *testing.T) {
 		t.Fatalf("Failed to create database: %v", err)
 	}
 	defer db.Close()
+	// Retain superseded versions of replaceable events so all are exported
+	db.SetReplacePolicy(ReplacePolicy{History: true})
 
 	// Create a scanner to read events from examples.Cache
 	scanner := bufio.NewScanner(bytes.NewBuffer(examples.Cache))
@@ -12,20 +12,7 @@ import (
 func (d *D) FetchEventBySerial(ser *types.Uint40) (ev *event.E, err error) {
 	if err = d.View(
 		func(txn *badger.Txn) (err error) {
-			buf := new(bytes.Buffer)
-			if err = indexes.EventEnc(ser).MarshalWrite(buf); chk.E(err) {
-				return
-			}
-			var item *badger.Item
-			if item, err = txn.Get(buf.Bytes()); chk.E(err) {
-				return
-			}
-			var v []byte
-			if v, err = item.ValueCopy(nil); chk.E(err) {
-				return
-			}
-			ev = new(event.E)
-			if err = ev.UnmarshalBinary(bytes.NewBuffer(v)); chk.E(err) {
+			if ev, err = fetchEventBySerial(txn, ser); chk.E(err) {
 				return
 			}
 			return
@@ -35,3 +22,27 @@ func (d *D) FetchEventBySerial(ser *types.Uint40) (ev *event.E, err error) {
 	}
 	return
 }
+
+// fetchEventBySerial returns the event with a serial in a transaction, or
+// badger.ErrKeyNotFound if there is none.
+func fetchEventBySerial(txn *badger.Txn, ser *types.Uint40) (
+	ev *event.E, err error,
+) {
+	buf := new(bytes.Buffer)
+	if err = indexes.EventEnc(ser).MarshalWrite(buf); chk.E(err) {
+		return
+	}
+	var item *badger.Item
+	if item, err = txn.Get(buf.Bytes()); err != nil {
+		return
+	}
+	var v []byte
+	if v, err = item.ValueCopy(nil); chk.E(err) {
+		return
+	}
+	ev = new(event.E)
+	if err = ev.UnmarshalBinary(bytes.NewBuffer(v)); chk.E(err) {
+		return
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/units"
+	"time"
+)
+
+// GCPolicy decides when the garbage of the store is collected. Badger doesn't
+// reclaim the space of deleted and overwritten values in the value log by
+// itself, and the LSM tree is only compacted as it grows, so a relay that
+// deletes and replaces many events keeps growing on disk without it.
+type GCPolicy struct {
+	// Interval is the time between collections. Zero disables them, except
+	// those run by CollectGarbage.
+	Interval time.Duration
+	// DiscardRatio is the fraction of a value log file that must be garbage
+	// for it to be rewritten.
+	DiscardRatio float64
+	// MinValueLogSize is the size in bytes the value log must be before its
+	// files are rewritten.
+	MinValueLogSize int64
+	// CompactRatio is the fraction of the LSM tree that must be deleted or
+	// overwritten data for the tree to be compacted into one level. Zero
+	// disables it.
+	CompactRatio float64
+}
+
+// DefaultGCPolicy collects the garbage hourly, rewriting the value log files
+// that are half garbage once it is larger than 256Mb, and compacting the LSM
+// tree when a quarter of it is stale.
+var DefaultGCPolicy = GCPolicy{
+	Interval:        time.Hour,
+	DiscardRatio:    0.5,
+	MinValueLogSize: 256 * units.Mb,
+	CompactRatio:    0.25,
+}
+
+// gcCheckInterval is how often a disabled collection checks if it has been
+// enabled.
+var gcCheckInterval = time.Minute
+
+// SetGCPolicy sets the policy for collecting the garbage of the store. It
+// applies from the next collection.
+func (d *D) SetGCPolicy(p GCPolicy) {
+	d.gcMx.Lock()
+	defer d.gcMx.Unlock()
+	d.gcPolicy = p
+}
+
+// CollectGarbage rewrites the value log files with more garbage than the
+// DiscardRatio of the GCPolicy, and compacts the LSM tree if more of it is
+// stale than the CompactRatio. Only one collection runs at a time, others
+// wait for it to finish.
+func (d *D) CollectGarbage() (run *store.GCRun) {
+	d.gcRunMx.Lock()
+	defer d.gcRunMx.Unlock()
+	d.gcMx.Lock()
+	p := d.gcPolicy
+	d.gcMx.Unlock()
+	start := time.Now()
+	run = &store.GCRun{Time: start.Unix()}
+	before, _ := dirSize(d.dataDir)
+	var err error
+	if _, vlog := d.DB.Size(); p.DiscardRatio > 0 &&
+		vlog >= p.MinValueLogSize {
+		for {
+			if err = d.DB.RunValueLogGC(p.DiscardRatio); err != nil {
+				if errors.Is(err, badger.ErrNoRewrite) ||
+					errors.Is(err, badger.ErrRejected) {
+					err = nil
+				}
+				break
+			}
+			run.Rewritten++
+		}
+	}
+	if err == nil && p.CompactRatio > 0 {
+		lsm, _ := d.DB.Size()
+		var stale int64
+		for _, l := range d.DB.Levels() {
+			stale += l.StaleDatSize
+		}
+		if lsm > 0 && float64(stale) >= float64(lsm)*p.CompactRatio {
+			if err = d.DB.Flatten(1); err == nil {
+				run.Flattened = true
+			}
+		}
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	after, _ := dirSize(d.dataDir)
+	run.Freed = before - after
+	run.Duration = time.Since(start).Round(time.Millisecond).String()
+	d.gcMx.Lock()
+	d.lastGC = run
+	d.gcMx.Unlock()
+	return
+}
+
+// collectGarbage runs CollectGarbage every Interval of the GCPolicy until the
+// database context is cancelled.
+func (d *D) collectGarbage() {
+	for {
+		d.gcMx.Lock()
+		interval := d.gcPolicy.Interval
+		d.gcMx.Unlock()
+		if interval <= 0 {
+			interval = gcCheckInterval
+		}
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		d.gcMx.Lock()
+		enabled := d.gcPolicy.Interval > 0
+		d.gcMx.Unlock()
+		if !enabled {
+			continue
+		}
+		run := d.CollectGarbage()
+		if run.Error != "" {
+			log.E.F(
+				"failed to collect the garbage of the store: %s", run.Error,
+			)
+			continue
+		}
+		if run.Rewritten > 0 || run.Flattened {
+			log.I.F(
+				"collected the garbage of the store in %s, rewrote %d value "+
+					"log files, freed %d bytes", run.Duration, run.Rewritten,
+				run.Freed,
+			)
+		}
+	}
+}
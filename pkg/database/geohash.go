@@ -0,0 +1,138 @@
+package database
+
+import (
+	"bytes"
+	"math"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/geohash"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/utils/chk"
+)
+
+// appendGeohashes appends the Geohash and GeohashKind indexes of the geohashes
+// of the g tags of an event.
+func appendGeohashes(
+	idxs *[][]byte, ev *event.E, k *types.Uint16, ca *types.Uint64,
+	ser *types.Uint40,
+) (err error) {
+	if ev.Tags == nil {
+		return
+	}
+	seen := make(map[uint64]bool)
+	for _, t := range ev.Tags.ToSliceOfTags() {
+		if t.Len() < 2 || !bytes.Equal(t.Key(), filter.GeoTag) {
+			continue
+		}
+		u, ok := geohash.Uint64(t.B(1))
+		if !ok || seen[u] {
+			continue
+		}
+		seen[u] = true
+		g := new(types.Uint64)
+		g.Set(u)
+		if err = appendIndexBytes(
+			idxs, indexes.GeohashEnc(g, ca, ser),
+		); chk.E(err) {
+			return
+		}
+		if err = appendIndexBytes(
+			idxs, indexes.GeohashKindEnc(k, g, ca, ser),
+		); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// geoCovers returns true if the geohash indexes can find the values of a g tag
+// of a filter, which are all geohashes or a GeoQuery, and whether the events
+// they find are all in the areas of the values, so the tag doesn't have to be
+// checked on the events.
+func geoCovers(t *tag.T) (covers, exact bool) {
+	exact = true
+	for _, v := range t.ToSliceOfBytes()[1:] {
+		if q, ok := filter.ParseGeoQuery(v); ok {
+			exact = exact && q.Exact()
+		} else if !geohash.Valid(v) {
+			return false, false
+		}
+	}
+	return true, exact
+}
+
+// geoCells returns the number of the geohash cells that the values of a g
+// tag of a filter are covered with.
+func geoCells(t *tag.T) (cells int) {
+	for _, v := range t.ToSliceOfBytes()[1:] {
+		if q, ok := filter.ParseGeoQuery(v); ok {
+			cells += len(q.Cells())
+		} else {
+			cells++
+		}
+	}
+	return
+}
+
+// geoRanges returns the ranges of the Geohash index, or of the GeohashKind
+// index for each of the kinds if there are any, that contain the geohashes of
+// the values of a g tag of a filter.
+//
+// The keys are in the order of the geohashes, not the timestamps, so the
+// timestamps of the events found in them have to be checked.
+func geoRanges(t *tag.T, ks *kinds.T) (ranges []Range, err error) {
+	maxTs := new(types.Uint64)
+	maxTs.Set(math.MaxUint64)
+	for _, v := range t.ToSliceOfBytes()[1:] {
+		var bounds [][2]uint64
+		if q, ok := filter.ParseGeoQuery(v); ok {
+			for _, cell := range q.Cells() {
+				first, last, _ := geohash.PrefixRange(cell)
+				bounds = append(bounds, [2]uint64{first, last})
+			}
+		} else if u, ok := geohash.Uint64(v); ok {
+			bounds = append(bounds, [2]uint64{u, u})
+		}
+		for _, b := range bounds {
+			lo, hi := new(types.Uint64), new(types.Uint64)
+			lo.Set(b[0])
+			hi.Set(b[1])
+			if ks == nil || ks.Len() == 0 {
+				if err = appendRange(
+					&ranges, indexes.GeohashEnc(lo, nil, nil),
+					indexes.GeohashEnc(hi, maxTs, nil),
+				); chk.E(err) {
+					return
+				}
+				continue
+			}
+			for _, kn := range ks.ToUint16() {
+				k := new(types.Uint16)
+				k.Set(kn)
+				if err = appendRange(
+					&ranges, indexes.GeohashKindEnc(k, lo, nil, nil),
+					indexes.GeohashKindEnc(k, hi, maxTs, nil),
+				); chk.E(err) {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// appendRange appends the range between the keys of two indexes.
+func appendRange(ranges *[]Range, start, end *indexes.T) (err error) {
+	s, e := new(bytes.Buffer), new(bytes.Buffer)
+	if err = start.MarshalWrite(s); chk.E(err) {
+		return
+	}
+	if err = end.MarshalWrite(e); chk.E(err) {
+		return
+	}
+	*ranges = append(*ranges, Range{s.Bytes(), e.Bytes()})
+	return
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/geohash"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGeohashQueries(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("organizer"))
+	calendar := kind.New(31923)
+	var evs []*event.E
+	// save an event with the g tags of a location at several precisions, as
+	// NIP-52 suggests.
+	save := func(k *kind.T, lat, lon float64) {
+		ev := event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(len(evs))))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - int64(len(evs)))
+		ev.Kind = k
+		ev.Content = []byte("event " + strconv.Itoa(len(evs)))
+		ev.Tags = tags.New(tag.New("d", strconv.Itoa(len(evs))))
+		g := geohash.Encode(lat, lon, 9)
+		for i := 4; i <= len(g); i++ {
+			ev.Tags.AppendTags(tag.New("g", string(g[:i])))
+		}
+		if _, _, err := d.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	// oslo, with calendar events and notes, and bergen, with many calendar
+	// events.
+	for i := 0; i < 5; i++ {
+		save(calendar, 59.9139, 10.7522)
+	}
+	for i := 0; i < 3; i++ {
+		save(kind.TextNote, 59.9139, 10.7522)
+	}
+	for i := 0; i < 200; i++ {
+		save(calendar, 60.3913, 5.3221)
+	}
+	if err = d.loadCardinality(); err != nil {
+		t.Fatal(err)
+	}
+	oslo := string(geohash.Encode(59.9139, 10.7522, 5))
+	limit := uint(2)
+	for _, tc := range []struct {
+		name    string
+		f       *filter.F
+		index   string
+		results int
+	}{
+		{
+			"prefix and kind", &filter.F{
+				Kinds: kinds.New(calendar),
+				Tags:  tags.New(tag.New("#g", oslo+"*")),
+			}, "gkc", 5,
+		},
+		{
+			"prefix", &filter.F{
+				Tags: tags.New(tag.New("#g", oslo+"*")),
+			}, "geo", 8,
+		},
+		{
+			"radius and kind", &filter.F{
+				Kinds: kinds.New(calendar),
+				Tags:  tags.New(tag.New("#g", "59.91,10.75,5000")),
+			}, "gkc", 5,
+		},
+		{
+			"box", &filter.F{
+				Tags: tags.New(tag.New("#g", "59.8,10.6..60.0,10.9")),
+			}, "geo", 8,
+		},
+		{
+			"box and time", &filter.F{
+				Tags:  tags.New(tag.New("#g", "59.8,10.6..60.0,10.9")),
+				Since: timestamp.FromUnix(now - 6),
+				Until: timestamp.FromUnix(now - 2),
+			}, "geo", 5,
+		},
+		{
+			"prefix with a limit", &filter.F{
+				Tags:  tags.New(tag.New("#g", oslo+"*")),
+				Limit: &limit,
+			}, "geo", 2,
+		},
+		{
+			"geohash", &filter.F{
+				Tags: tags.New(tag.New("#g", oslo)),
+			}, "tc-", 8,
+		},
+	} {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				var plan *store.QueryPlan
+				if plan, err = d.Explain(ctx, tc.f); err != nil {
+					t.Fatal(err)
+				}
+				if plan.Index != tc.index || plan.Results != tc.results {
+					t.Fatalf(
+						"plan %s found %d events, expected %s and %d",
+						plan.Index, plan.Results, tc.index, tc.results,
+					)
+				}
+				var idPkTs []store.IdPkTs
+				if idPkTs, err = d.QueryForIds(ctx, tc.f); err != nil {
+					t.Fatal(err)
+				}
+				// the results are the newest of the events that match.
+				var n int
+				for _, ev := range evs {
+					if n == len(idPkTs) {
+						break
+					}
+					if !tc.f.Matches(ev) {
+						continue
+					}
+					if string(idPkTs[n].Id) != string(ev.ID) {
+						t.Fatalf(
+							"result %d is %0x, expected %0x", n, idPkTs[n].Id,
+							ev.ID,
+						)
+					}
+					n++
+				}
+				if n != tc.results {
+					t.Fatalf("found %d events, expected %d", n, tc.results)
+				}
+			},
+		)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"orly.dev/pkg/database/indexes"
 	. "orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/words"
 	"orly.dev/pkg/utils/chk"
 )
 
@@ -135,6 +137,18 @@ func GetIndexesForEvent(ev *event.E, serial uint64) (
 			}
 		}
 	}
+	// Address index, if the event is replaceable
+	if isAddressable(ev.Kind) {
+		if err = appendAddress(&idxs, ev, pubHash, createdAt, ser); chk.E(err) {
+			return
+		}
+	}
+	// Tombstone indexes, if the event is a NIP-09 deletion
+	if ev.Kind.Equal(kind.Deletion) {
+		if err = appendTombstones(&idxs, ev, pubHash, createdAt, ser); chk.E(err) {
+			return
+		}
+	}
 	kind := new(Uint16)
 	kind.Set(uint16(ev.Kind.K))
 	// Kind index
@@ -150,5 +164,27 @@ func GetIndexesForEvent(ev *event.E, serial uint64) (
 	if err = appendIndexBytes(&idxs, kindPubkeyIndex); chk.E(err) {
 		return
 	}
+	// Expiration index, if the event has a NIP-40 expiration tag
+	if exp, ok := ev.Expiration(); ok && exp > 0 {
+		expiration := new(Uint64)
+		expiration.Set(uint64(exp))
+		expirationIndex := indexes.ExpirationEnc(expiration, ser)
+		if err = appendIndexBytes(&idxs, expirationIndex); chk.E(err) {
+			return
+		}
+	}
+	// Word indexes for full text search
+	for _, w := range words.FromEvent(ev) {
+		word := new(Word)
+		word.FromWord(w)
+		wordIndex := indexes.WordEnc(word, createdAt, ser)
+		if err = appendIndexBytes(&idxs, wordIndex); chk.E(err) {
+			return
+		}
+	}
+	// Geohash indexes of the g tags
+	if err = appendGeohashes(&idxs, ev, kind, createdAt, ser); chk.E(err) {
+		return
+	}
 	return
 }
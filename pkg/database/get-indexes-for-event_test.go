@@ -85,9 +85,10 @@ func testBasicEvent(t *testing.T) {
 		t.Fatalf("GetIndexesForEvent failed: %v", err)
 	}
 
-	// Verify the number of indexes (should be 6 for a basic event without tags)
-	if len(idxs) != 6 {
-		t.Fatalf("Expected 6 indexes, got %d", len(idxs))
+	// Verify the number of indexes (should be 6 for a basic event without
+	// tags, plus a Word index for each of the 2 words in the content)
+	if len(idxs) != 8 {
+		t.Fatalf("Expected 8 indexes, got %d", len(idxs))
 	}
 
 	// Create and verify the expected indexes
@@ -144,6 +145,14 @@ func testBasicEvent(t *testing.T) {
 	// 6. KindPubkey index
 	kindPubkeyIndex := indexes.KindPubkeyEnc(kind, pubHash, createdAt, ser)
 	verifyIndexIncluded(t, idxs, kindPubkeyIndex)
+
+	// 7. Word indexes, lower case
+	for _, w := range []string{"test", "content"} {
+		word := new(types2.Word)
+		word.FromWord([]byte(w))
+		wordIndex := indexes.WordEnc(word, createdAt, ser)
+		verifyIndexIncluded(t, idxs, wordIndex)
+	}
 }
 
 // Test event with tags
@@ -196,10 +205,11 @@ func testEventWithTags(t *testing.T) {
 		t.Fatalf("GetIndexesForEvent failed: %v", err)
 	}
 
-	// Verify the number of indexes (should be 14 for an event with 2 tags)
-	// 6 basic indexes + 4 indexes per tag (TagPubkey, Tag, TagKind, TagKindPubkey)
-	if len(idxs) != 14 {
-		t.Fatalf("Expected 14 indexes, got %d", len(idxs))
+	// Verify the number of indexes (should be 17 for an event with 2 tags)
+	// 6 basic indexes + 4 indexes per tag (TagPubkey, Tag, TagKind,
+	// TagKindPubkey) + 3 Word indexes for the content
+	if len(idxs) != 17 {
+		t.Fatalf("Expected 17 indexes, got %d", len(idxs))
 	}
 
 	// Create and verify the basic indexes (same as in testBasicEvent)
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"bytes"
+	"math"
+	"orly.dev/pkg/database/indexes"
+	types2 "orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/utils/chk"
+)
+
+// GetIndexesFromSearch returns a range of the Word index for each of the given
+// search terms, bounded by the Since and Until of the filter.
+func GetIndexesFromSearch(f *filter.F, terms [][]byte) (
+	idxs []Range, err error,
+) {
+	caStart := new(types2.Uint64)
+	caEnd := new(types2.Uint64)
+	if f.Since != nil && f.Since.V != 0 {
+		caStart.Set(uint64(f.Since.V))
+	} else {
+		caStart.Set(uint64(0))
+	}
+	if f.Until != nil && f.Until.V != 0 {
+		caEnd.Set(uint64(f.Until.V + 1))
+	} else {
+		caEnd.Set(uint64(math.MaxInt64))
+	}
+	for _, term := range terms {
+		word := new(types2.Word)
+		word.FromWord(term)
+		start, end := new(bytes.Buffer), new(bytes.Buffer)
+		idxS := indexes.WordEnc(word, caStart, nil)
+		if err = idxS.MarshalWrite(start); chk.E(err) {
+			return
+		}
+		idxE := indexes.WordEnc(word, caEnd, nil)
+		if err = idxE.MarshalWrite(end); chk.E(err) {
+			return
+		}
+		idxs = append(idxs, Range{start.Bytes(), end.Bytes()})
+	}
+	return
+}
@@ -11,35 +11,43 @@ import (
 )
 
 func (d *D) GetSerialById(id []byte) (ser *types.Uint40, err error) {
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			ser, err = getSerialById(txn, id)
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	return
+}
+
+// getSerialById returns the serial of the event with an id in a transaction,
+// or nil if there is none.
+func getSerialById(txn *badger.Txn, id []byte) (ser *types.Uint40, err error) {
 	var idxs []Range
 	if idxs, err = GetIndexesFromFilter(&filter.F{Ids: tag.New(id)}); chk.E(err) {
 		return
 	}
 	if len(idxs) == 0 {
 		err = errorf.E("no indexes found for id %0x", id)
+		return
 	}
-	if err = d.View(
-		func(txn *badger.Txn) (err error) {
-			it := txn.NewIterator(badger.DefaultIteratorOptions)
-			var key []byte
-			defer it.Close()
-			it.Seek(idxs[0].Start)
-			if it.ValidForPrefix(idxs[0].Start) {
-				item := it.Item()
-				key = item.KeyCopy(nil)
-				ser = new(types.Uint40)
-				buf := bytes.NewBuffer(key[len(key)-5:])
-				if err = ser.UnmarshalRead(buf); chk.E(err) {
-					return
-				}
-			} else {
-				// just don't return what we don't have? others may be
-				// found tho.
-			}
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	var key []byte
+	defer it.Close()
+	it.Seek(idxs[0].Start)
+	if it.ValidForPrefix(idxs[0].Start) {
+		item := it.Item()
+		key = item.KeyCopy(nil)
+		ser = new(types.Uint40)
+		buf := bytes.NewBuffer(key[len(key)-5:])
+		if err = ser.UnmarshalRead(buf); chk.E(err) {
 			return
-		},
-	); chk.E(err) {
-		return
+		}
+	} else {
+		// just don't return what we don't have? others may be
+		// found tho.
 	}
 	return
 }
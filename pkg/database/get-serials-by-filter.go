@@ -0,0 +1,103 @@
+package database
+
+import (
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+)
+
+// GetSerialsByFilter returns the set of serials of the events that match the
+// kinds, authors, tags and timestamps of a filter, using only the indexes.
+//
+// Each distinct tag key in a filter is resolved to its own set of serials,
+// where any of the values may match, and the sets of each key are then
+// intersected, so that events must match all the tag keys of the filter.
+//
+// The Ids, Search and Limit fields of the filter are not used.
+//
+// A filter with a tag that is not a NIP-01 tag, one with a longer name, a
+// position or a range, is run by the query planner instead, which finds it
+// with the index of a TagRule or checks it on the events.
+func (d *D) GetSerialsByFilter(f *filter.F) (
+	found map[uint64]struct{}, err error,
+) {
+	ff := *f
+	ff.Ids = nil
+	if ff.Tags != nil {
+		for _, t := range ff.Tags.ToSliceOfTags() {
+			if filter.IsTagQuery(t) {
+				return d.getSerialsByPlan(&ff)
+			}
+		}
+	}
+	if ff.Tags == nil || ff.Tags.Len() < 2 {
+		return d.getSerialsByRanges(&ff)
+	}
+	for i, t := range f.Tags.ToSliceOfTags() {
+		// make a copy of the filter with only this tag so the ranges for the
+		// values of each tag key can be united separately.
+		ff.Tags = tags.New(t)
+		var sers map[uint64]struct{}
+		if sers, err = d.getSerialsByRanges(&ff); chk.E(err) {
+			return
+		}
+		if i == 0 {
+			found = sers
+			continue
+		}
+		for ser := range found {
+			if _, ok := sers[ser]; !ok {
+				delete(found, ser)
+			}
+		}
+		if len(found) == 0 {
+			break
+		}
+	}
+	return
+}
+
+// getSerialsByRanges collects the set of serials found in all the index ranges
+// derived from a filter.
+func (d *D) getSerialsByRanges(f *filter.F) (
+	found map[uint64]struct{}, err error,
+) {
+	var idxs []Range
+	if idxs, err = GetIndexesFromFilter(f); chk.E(err) {
+		return
+	}
+	found = make(map[uint64]struct{})
+	for _, idx := range idxs {
+		var sers types.Uint40s
+		if sers, err = d.GetSerialsByRange(idx); chk.E(err) {
+			return
+		}
+		for _, ser := range sers {
+			found[ser.Get()] = struct{}{}
+		}
+	}
+	return
+}
+
+// getSerialsByPlan collects the set of serials of the events the query
+// planner finds for a filter, without its limit.
+func (d *D) getSerialsByPlan(f *filter.F) (
+	found map[uint64]struct{}, err error,
+) {
+	f.Limit = nil
+	var p *queryPlan
+	if p, _, err = d.planQuery(f); chk.E(err) {
+		return
+	}
+	var idPkTs []store.IdPkTs
+	if idPkTs, err = d.runPlan(d.ctx, p, nil); chk.E(err) {
+		return
+	}
+	found = make(map[uint64]struct{}, len(idPkTs))
+	for _, idpk := range idPkTs {
+		found[idpk.Ser] = struct{}{}
+	}
+	return
+}
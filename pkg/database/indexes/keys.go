@@ -67,6 +67,21 @@ const (
 	TagKindPrefix       = I("tkc") // tag, kind, created at
 	TagPubkeyPrefix     = I("tpc") // tag, pubkey, created at
 	TagKindPubkeyPrefix = I("tkp") // tag, kind, pubkey, created at
+
+	WordPrefix = I("wrd") // word, created at
+
+	ExpirationPrefix = I("exp") // expiration
+
+	TombstoneIdPrefix      = I("tbi") // deleted id, deleting pubkey
+	TombstoneAddressPrefix = I("tba") // deleted address, deleted until
+
+	AddressPrefix = I("adr") // kind, pubkey, d tag, created at
+
+	TagNamePrefix  = I("tgn") // tag name, position, value, created at
+	TagValuePrefix = I("tgv") // tag name, position, ordered value, created at
+
+	GeohashPrefix     = I("geo") // geohash, created at
+	GeohashKindPrefix = I("gkc") // kind, geohash, created at
 )
 
 // Prefix returns the three byte human-readable prefixes that go in front of
@@ -97,6 +112,39 @@ func Prefix(prf int) (i I) {
 		return TagPubkeyPrefix
 	case TagKindPubkey:
 		return TagKindPubkeyPrefix
+
+	case Word:
+		return WordPrefix
+
+	case Expiration:
+		return ExpirationPrefix
+
+	case TombstoneId:
+		return TombstoneIdPrefix
+	case TombstoneAddress:
+		return TombstoneAddressPrefix
+
+	case Address:
+		return AddressPrefix
+
+	case TagName:
+		return TagNamePrefix
+	case TagValue:
+		return TagValuePrefix
+
+	case Geohash:
+		return GeohashPrefix
+	case GeohashKind:
+		return GeohashKindPrefix
+	}
+	return
+}
+
+// Prefixes returns the prefixes of all of the indexes, in the order they are
+// declared.
+func Prefixes() (prefixes []I) {
+	for i := 0; Prefix(i) != ""; i++ {
+		prefixes = append(prefixes, Prefix(i))
 	}
 	return
 }
@@ -135,6 +183,30 @@ func Identify(r io.Reader) (i int, err error) {
 		i = TagPubkey
 	case TagKindPubkeyPrefix:
 		i = TagKindPubkey
+
+	case WordPrefix:
+		i = Word
+
+	case ExpirationPrefix:
+		i = Expiration
+
+	case TombstoneIdPrefix:
+		i = TombstoneId
+	case TombstoneAddressPrefix:
+		i = TombstoneAddress
+
+	case AddressPrefix:
+		i = Address
+
+	case TagNamePrefix:
+		i = TagName
+	case TagValuePrefix:
+		i = TagValue
+
+	case GeohashPrefix:
+		i = Geohash
+	case GeohashKindPrefix:
+		i = GeohashKind
 	}
 	return
 }
@@ -383,3 +455,213 @@ func TagKindPubkeyDec(
 ) (enc *T) {
 	return New(NewPrefix(), ki, p, k, v, ca, ser)
 }
+
+// Word is the full text search index for NIP-50, containing each of the
+// distinct words found in the content and selected tags of an event. The word
+// is terminated by a zero byte so a search for a word does not match the words
+// it is a prefix of.
+//
+//	3 prefix|word|1 zero|8 timestamp|5 serial
+var Word = next()
+
+func WordVars() (w *types.Word, ca *types.Uint64, ser *types.Uint40) {
+	return new(types.Word), new(types.Uint64), new(types.Uint40)
+}
+func WordEnc(w *types.Word, ca *types.Uint64, ser *types.Uint40) (enc *T) {
+	return New(NewPrefix(Word), w, ca, ser)
+}
+func WordDec(w *types.Word, ca *types.Uint64, ser *types.Uint40) (enc *T) {
+	return New(NewPrefix(), w, ca, ser)
+}
+
+// Expiration is an index of the NIP-40 expiration timestamps of events, so that
+// expired events can be found in order of expiry and deleted.
+//
+//	3 prefix|8 expiration timestamp|5 serial
+var Expiration = next()
+
+func ExpirationVars() (exp *types.Uint64, ser *types.Uint40) {
+	return new(types.Uint64), new(types.Uint40)
+}
+func ExpirationEnc(exp *types.Uint64, ser *types.Uint40) (enc *T) {
+	return New(NewPrefix(Expiration), exp, ser)
+}
+func ExpirationDec(exp *types.Uint64, ser *types.Uint40) (enc *T) {
+	return New(NewPrefix(), exp, ser)
+}
+
+// TombstoneId records that a NIP-09 deletion event deleted an event id, so
+// that the event is not saved again if it is published by the pubkey of the
+// deletion event. The serial is that of the deletion event.
+//
+//	3 prefix|8 ID hash|8 pubkey hash|5 serial
+var TombstoneId = next()
+
+func TombstoneIdVars() (id *types.IdHash, p *types.PubHash, ser *types.Uint40) {
+	return new(types.IdHash), new(types.PubHash), new(types.Uint40)
+}
+func TombstoneIdEnc(
+	id *types.IdHash, p *types.PubHash, ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(TombstoneId), id, p, ser)
+}
+func TombstoneIdDec(
+	id *types.IdHash, p *types.PubHash, ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(), id, p, ser)
+}
+
+// TombstoneAddress records that a NIP-09 deletion event deleted the versions
+// of a replaceable or parameterized replaceable event, identified by its kind,
+// pubkey and d tag, up to the timestamp of the deletion event. The serial is
+// that of the deletion event.
+//
+//	3 prefix|2 kind|8 pubkey hash|8 d tag hash|8 timestamp|5 serial
+var TombstoneAddress = next()
+
+func TombstoneAddressVars() (
+	k *types.Uint16, p *types.PubHash, d *types.Ident, ca *types.Uint64,
+	ser *types.Uint40,
+) {
+	return new(types.Uint16), new(types.PubHash), new(types.Ident),
+		new(types.Uint64), new(types.Uint40)
+}
+func TombstoneAddressEnc(
+	k *types.Uint16, p *types.PubHash, d *types.Ident, ca *types.Uint64,
+	ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(TombstoneAddress), k, p, d, ca, ser)
+}
+func TombstoneAddressDec(
+	k *types.Uint16, p *types.PubHash, d *types.Ident, ca *types.Uint64,
+	ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(), k, p, d, ca, ser)
+}
+
+// Address is an index of the versions of replaceable and parameterized
+// replaceable events by their address, the kind, pubkey and d tag, so that the
+// current version can be found when a new version is saved. The d tag is empty
+// for replaceable events.
+//
+//	3 prefix|2 kind|8 pubkey hash|8 d tag hash|8 timestamp|5 serial
+var Address = next()
+
+func AddressVars() (
+	k *types.Uint16, p *types.PubHash, d *types.Ident, ca *types.Uint64,
+	ser *types.Uint40,
+) {
+	return new(types.Uint16), new(types.PubHash), new(types.Ident),
+		new(types.Uint64), new(types.Uint40)
+}
+func AddressEnc(
+	k *types.Uint16, p *types.PubHash, d *types.Ident, ca *types.Uint64,
+	ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(Address), k, p, d, ca, ser)
+}
+func AddressDec(
+	k *types.Uint16, p *types.PubHash, d *types.Ident, ca *types.Uint64,
+	ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(), k, p, d, ca, ser)
+}
+
+// TagName is an index of the tags that the relay is configured to index in
+// addition to those with a one letter key, by the hash of the name of the tag,
+// the position of the value in the tag, and the hash of the value.
+//
+//	3 prefix|8 name hash|2 position|8 value hash|8 timestamp|5 serial
+var TagName = next()
+
+func TagNameVars() (
+	n *types.Ident, pos *types.Uint16, v *types.Ident, ca *types.Uint64,
+	ser *types.Uint40,
+) {
+	return new(types.Ident), new(types.Uint16), new(types.Ident),
+		new(types.Uint64), new(types.Uint40)
+}
+func TagNameEnc(
+	n *types.Ident, pos *types.Uint16, v *types.Ident, ca *types.Uint64,
+	ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(TagName), n, pos, v, ca, ser)
+}
+func TagNameDec(
+	n *types.Ident, pos *types.Uint16, v *types.Ident, ca *types.Uint64,
+	ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(), n, pos, v, ca, ser)
+}
+
+// TagValue is an index of the tags that the relay is configured to index with
+// numbers or timestamps as their values, by the hash of the name of the tag,
+// the position of the value in the tag, and the value, encoded so that the
+// keys are in the order of the values, so a range of values can be scanned.
+//
+//	3 prefix|8 name hash|2 position|8 ordered value|8 timestamp|5 serial
+var TagValue = next()
+
+func TagValueVars() (
+	n *types.Ident, pos *types.Uint16, v *types.Uint64, ca *types.Uint64,
+	ser *types.Uint40,
+) {
+	return new(types.Ident), new(types.Uint16), new(types.Uint64),
+		new(types.Uint64), new(types.Uint40)
+}
+func TagValueEnc(
+	n *types.Ident, pos *types.Uint16, v *types.Uint64, ca *types.Uint64,
+	ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(TagValue), n, pos, v, ca, ser)
+}
+func TagValueDec(
+	n *types.Ident, pos *types.Uint16, v *types.Uint64, ca *types.Uint64,
+	ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(), n, pos, v, ca, ser)
+}
+
+// Geohash is an index of the geohashes of the g tags of events, that NIP-52
+// defines, encoded so that the keys are in the order of the geohashes, so the
+// geohashes that start with a prefix can be scanned as a range.
+//
+//	3 prefix|8 geohash|8 timestamp|5 serial
+var Geohash = next()
+
+func GeohashVars() (g *types.Uint64, ca *types.Uint64, ser *types.Uint40) {
+	return new(types.Uint64), new(types.Uint64), new(types.Uint40)
+}
+func GeohashEnc(g *types.Uint64, ca *types.Uint64, ser *types.Uint40) (
+	enc *T,
+) {
+	return New(NewPrefix(Geohash), g, ca, ser)
+}
+func GeohashDec(g *types.Uint64, ca *types.Uint64, ser *types.Uint40) (
+	enc *T,
+) {
+	return New(NewPrefix(), g, ca, ser)
+}
+
+// GeohashKind is an index of the geohashes of the g tags of events by the kind
+// of the event, like Geohash.
+//
+//	3 prefix|2 kind|8 geohash|8 timestamp|5 serial
+var GeohashKind = next()
+
+func GeohashKindVars() (
+	k *types.Uint16, g *types.Uint64, ca *types.Uint64, ser *types.Uint40,
+) {
+	return new(types.Uint16), new(types.Uint64), new(types.Uint64),
+		new(types.Uint40)
+}
+func GeohashKindEnc(
+	k *types.Uint16, g *types.Uint64, ca *types.Uint64, ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(GeohashKind), k, g, ca, ser)
+}
+func GeohashKindDec(
+	k *types.Uint16, g *types.Uint64, ca *types.Uint64, ser *types.Uint40,
+) (enc *T) {
+	return New(NewPrefix(), k, g, ca, ser)
+}
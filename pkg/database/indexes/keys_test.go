@@ -96,6 +96,15 @@ func TestPrefixFunction(t *testing.T) {
 			"TagKindPubkey", TagKindPubkey,
 			TagKindPubkeyPrefix,
 		},
+		{"Word", Word, WordPrefix},
+		{"Expiration", Expiration, ExpirationPrefix},
+		{"TombstoneId", TombstoneId, TombstoneIdPrefix},
+		{"TombstoneAddress", TombstoneAddress, TombstoneAddressPrefix},
+		{"Address", Address, AddressPrefix},
+		{"TagName", TagName, TagNamePrefix},
+		{"TagValue", TagValue, TagValuePrefix},
+		{"Geohash", Geohash, GeohashPrefix},
+		{"GeohashKind", GeohashKind, GeohashKindPrefix},
 		{"Invalid", -1, ""},
 	}
 
@@ -114,6 +123,27 @@ func TestPrefixFunction(t *testing.T) {
 	}
 }
 
+// TestPrefixes tests that Prefixes returns every index prefix once
+func TestPrefixes(t *testing.T) {
+	prefixes := Prefixes()
+	if len(prefixes) != GeohashKind+1 {
+		t.Fatalf(
+			"Prefixes returned %d prefixes, expected %d", len(prefixes),
+			GeohashKind+1,
+		)
+	}
+	seen := make(map[I]struct{})
+	for _, p := range prefixes {
+		if len(p) != 3 {
+			t.Errorf("prefix %q is not 3 bytes", p)
+		}
+		if _, ok := seen[p]; ok {
+			t.Errorf("prefix %q appears twice", p)
+		}
+		seen[p] = struct{}{}
+	}
+}
+
 // TestIdentify tests the Identify function
 func TestIdentify(t *testing.T) {
 	testCases := []struct {
@@ -135,6 +165,15 @@ func TestIdentify(t *testing.T) {
 			"TagKindPubkey", TagKindPubkeyPrefix,
 			TagKindPubkey,
 		},
+		{"Word", WordPrefix, Word},
+		{"Expiration", ExpirationPrefix, Expiration},
+		{"TombstoneId", TombstoneIdPrefix, TombstoneId},
+		{"TombstoneAddress", TombstoneAddressPrefix, TombstoneAddress},
+		{"Address", AddressPrefix, Address},
+		{"TagName", TagNamePrefix, TagName},
+		{"TagValue", TagValuePrefix, TagValue},
+		{"Geohash", GeohashPrefix, Geohash},
+		{"GeohashKind", GeohashKindPrefix, GeohashKind},
 	}
 
 	for _, tc := range testCases {
@@ -978,3 +1017,288 @@ func TestKindPubkeyTagFunctions(t *testing.T) {
 		t.Errorf("Decoded serial %d, expected %d", newSer.Get(), ser.Get())
 	}
 }
+
+// TestWordFunctions tests the Word-related functions
+func TestWordFunctions(t *testing.T) {
+	// Test WordVars
+	w, ca, ser := WordVars()
+	if w == nil || ca == nil || ser == nil {
+		t.Fatalf("WordVars should return non-nil values")
+	}
+
+	// Set values
+	w.FromWord([]byte("nostr"))
+	ca.Set(98765)
+	ser.Set(12345)
+
+	// Test WordEnc
+	enc := WordEnc(w, ca, ser)
+	if len(enc.Encs) != 4 {
+		t.Errorf(
+			"WordEnc should create T with 4 encoders, got %d",
+			len(enc.Encs),
+		)
+	}
+
+	// Test marshaling and unmarshaling
+	buf := codecbuf.Get()
+	err := enc.MarshalWrite(buf)
+	if chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("wrdnostr\x00")) {
+		t.Errorf("unexpected Word key %q", buf.Bytes())
+	}
+
+	// Create new variables for decoding
+	newW, newCa, newSer := WordVars()
+	newDec := WordDec(newW, newCa, newSer)
+
+	err = newDec.UnmarshalRead(bytes.NewBuffer(buf.Bytes()))
+	if chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+
+	// Verify the decoded values
+	if !bytes.Equal(newW.Bytes(), w.Bytes()) {
+		t.Errorf("Decoded word %s, expected %s", newW.Bytes(), w.Bytes())
+	}
+	if newCa.Get() != ca.Get() {
+		t.Errorf("Decoded created at %d, expected %d", newCa.Get(), ca.Get())
+	}
+	if newSer.Get() != ser.Get() {
+		t.Errorf("Decoded serial %d, expected %d", newSer.Get(), ser.Get())
+	}
+}
+
+// TestExpirationFunctions tests the Expiration-related functions
+func TestExpirationFunctions(t *testing.T) {
+	// Test ExpirationVars
+	exp, ser := ExpirationVars()
+	if exp == nil || ser == nil {
+		t.Fatalf("ExpirationVars should return non-nil values")
+	}
+
+	// Set values
+	exp.Set(1700000000)
+	ser.Set(12345)
+
+	// Test marshaling and unmarshaling
+	enc := ExpirationEnc(exp, ser)
+	buf := codecbuf.Get()
+	err := enc.MarshalWrite(buf)
+	if chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+	if buf.Len() != 16 {
+		t.Errorf("Expiration key should be 16 bytes, got %d", buf.Len())
+	}
+
+	// Create new variables for decoding
+	newExp, newSer := ExpirationVars()
+	newDec := ExpirationDec(newExp, newSer)
+	err = newDec.UnmarshalRead(bytes.NewBuffer(buf.Bytes()))
+	if chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+
+	// Verify the decoded values
+	if newExp.Get() != exp.Get() {
+		t.Errorf("Decoded expiration %d, expected %d", newExp.Get(), exp.Get())
+	}
+	if newSer.Get() != ser.Get() {
+		t.Errorf("Decoded serial %d, expected %d", newSer.Get(), ser.Get())
+	}
+}
+
+// TestTombstoneFunctions tests the TombstoneId and TombstoneAddress functions
+func TestTombstoneFunctions(t *testing.T) {
+	id, p, ser := TombstoneIdVars()
+	if err := id.FromId(make([]byte, 32)); chk.E(err) {
+		t.Fatal(err)
+	}
+	if err := p.FromPubkey(make([]byte, 32)); chk.E(err) {
+		t.Fatal(err)
+	}
+	ser.Set(12345)
+	buf := codecbuf.Get()
+	if err := TombstoneIdEnc(id, p, ser).MarshalWrite(buf); chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+	if buf.Len() != 24 {
+		t.Errorf("TombstoneId key should be 24 bytes, got %d", buf.Len())
+	}
+	newId, newP, newSer := TombstoneIdVars()
+	if err := TombstoneIdDec(newId, newP, newSer).UnmarshalRead(
+		bytes.NewBuffer(buf.Bytes()),
+	); chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+	if !bytes.Equal(newId.Bytes(), id.Bytes()) ||
+		!bytes.Equal(newP.Bytes(), p.Bytes()) || newSer.Get() != ser.Get() {
+		t.Errorf("decoded TombstoneId does not match")
+	}
+
+	k, p, d, ca, ser := TombstoneAddressVars()
+	k.Set(30023)
+	if err := p.FromPubkey(make([]byte, 32)); chk.E(err) {
+		t.Fatal(err)
+	}
+	d.FromIdent([]byte("identifier"))
+	ca.Set(1700000000)
+	ser.Set(12345)
+	buf = codecbuf.Get()
+	if err := TombstoneAddressEnc(k, p, d, ca, ser).MarshalWrite(
+		buf,
+	); chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+	if buf.Len() != 34 {
+		t.Errorf("TombstoneAddress key should be 34 bytes, got %d", buf.Len())
+	}
+	newK, newP, newD, newCa, newSer := TombstoneAddressVars()
+	if err := TombstoneAddressDec(
+		newK, newP, newD, newCa, newSer,
+	).UnmarshalRead(bytes.NewBuffer(buf.Bytes())); chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+	if newK.Get() != k.Get() || !bytes.Equal(newD.Bytes(), d.Bytes()) ||
+		newCa.Get() != ca.Get() || newSer.Get() != ser.Get() {
+		t.Errorf("decoded TombstoneAddress does not match")
+	}
+}
+
+// TestAddressFunctions tests the Address-related functions
+func TestAddressFunctions(t *testing.T) {
+	k, p, d, ca, ser := AddressVars()
+	k.Set(30023)
+	if err := p.FromPubkey(make([]byte, 32)); chk.E(err) {
+		t.Fatal(err)
+	}
+	d.FromIdent([]byte("identifier"))
+	ca.Set(1700000000)
+	ser.Set(12345)
+	buf := codecbuf.Get()
+	if err := AddressEnc(k, p, d, ca, ser).MarshalWrite(buf); chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+	if buf.Len() != 34 {
+		t.Errorf("Address key should be 34 bytes, got %d", buf.Len())
+	}
+	newK, newP, newD, newCa, newSer := AddressVars()
+	if err := AddressDec(
+		newK, newP, newD, newCa, newSer,
+	).UnmarshalRead(bytes.NewBuffer(buf.Bytes())); chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+	if newK.Get() != k.Get() || !bytes.Equal(newP.Bytes(), p.Bytes()) ||
+		!bytes.Equal(newD.Bytes(), d.Bytes()) || newCa.Get() != ca.Get() ||
+		newSer.Get() != ser.Get() {
+		t.Errorf("decoded Address does not match")
+	}
+}
+
+// TestTagNameValueFunctions tests the TagName and TagValue functions
+func TestTagNameValueFunctions(t *testing.T) {
+	n, pos, v, ca, ser := TagNameVars()
+	n.FromIdent([]byte("imeta"))
+	pos.Set(2)
+	v.FromIdent([]byte("m image/png"))
+	ca.Set(1700000000)
+	ser.Set(12345)
+	buf := codecbuf.Get()
+	if err := TagNameEnc(n, pos, v, ca, ser).MarshalWrite(buf); chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+	if buf.Len() != 34 {
+		t.Errorf("TagName key should be 34 bytes, got %d", buf.Len())
+	}
+	newN, newPos, newV, newCa, newSer := TagNameVars()
+	if err := TagNameDec(
+		newN, newPos, newV, newCa, newSer,
+	).UnmarshalRead(bytes.NewBuffer(buf.Bytes())); chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+	if !bytes.Equal(newN.Bytes(), n.Bytes()) || newPos.Get() != pos.Get() ||
+		!bytes.Equal(newV.Bytes(), v.Bytes()) || newCa.Get() != ca.Get() ||
+		newSer.Get() != ser.Get() {
+		t.Errorf("decoded TagName does not match")
+	}
+
+	n2, pos2, ov, ca2, ser2 := TagValueVars()
+	n2.FromIdent([]byte("price"))
+	pos2.Set(1)
+	ov.Set(1 << 63)
+	ca2.Set(1700000000)
+	ser2.Set(12345)
+	buf = codecbuf.Get()
+	if err := TagValueEnc(n2, pos2, ov, ca2, ser2).MarshalWrite(
+		buf,
+	); chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+	if buf.Len() != 34 {
+		t.Errorf("TagValue key should be 34 bytes, got %d", buf.Len())
+	}
+	newN2, newPos2, newOv, newCa2, newSer2 := TagValueVars()
+	if err := TagValueDec(
+		newN2, newPos2, newOv, newCa2, newSer2,
+	).UnmarshalRead(bytes.NewBuffer(buf.Bytes())); chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+	if !bytes.Equal(newN2.Bytes(), n2.Bytes()) ||
+		newPos2.Get() != pos2.Get() || newOv.Get() != ov.Get() ||
+		newCa2.Get() != ca2.Get() || newSer2.Get() != ser2.Get() {
+		t.Errorf("decoded TagValue does not match")
+	}
+}
+
+// TestGeohashFunctions tests the Geohash and GeohashKind functions
+func TestGeohashFunctions(t *testing.T) {
+	g, ca, ser := GeohashVars()
+	g.Set(0x9c4b6e0000000005)
+	ca.Set(1700000000)
+	ser.Set(12345)
+	buf := codecbuf.Get()
+	if err := GeohashEnc(g, ca, ser).MarshalWrite(buf); chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+	if buf.Len() != 24 {
+		t.Errorf("Geohash key should be 24 bytes, got %d", buf.Len())
+	}
+	newG, newCa, newSer := GeohashVars()
+	if err := GeohashDec(newG, newCa, newSer).UnmarshalRead(
+		bytes.NewBuffer(buf.Bytes()),
+	); chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+	if newG.Get() != g.Get() || newCa.Get() != ca.Get() ||
+		newSer.Get() != ser.Get() {
+		t.Errorf("decoded Geohash does not match")
+	}
+
+	k, g2, ca2, ser2 := GeohashKindVars()
+	k.Set(31923)
+	g2.Set(0x9c4b6e0000000005)
+	ca2.Set(1700000000)
+	ser2.Set(12345)
+	buf = codecbuf.Get()
+	if err := GeohashKindEnc(k, g2, ca2, ser2).MarshalWrite(
+		buf,
+	); chk.E(err) {
+		t.Fatalf("MarshalWrite failed: %v", err)
+	}
+	if buf.Len() != 26 {
+		t.Errorf("GeohashKind key should be 26 bytes, got %d", buf.Len())
+	}
+	newK, newG2, newCa2, newSer2 := GeohashKindVars()
+	if err := GeohashKindDec(
+		newK, newG2, newCa2, newSer2,
+	).UnmarshalRead(bytes.NewBuffer(buf.Bytes())); chk.E(err) {
+		t.Fatalf("UnmarshalRead failed: %v", err)
+	}
+	if newK.Get() != k.Get() || newG2.Get() != g2.Get() ||
+		newCa2.Get() != ca2.Get() || newSer2.Get() != ser2.Get() {
+		t.Errorf("decoded GeohashKind does not match")
+	}
+}
@@ -0,0 +1,256 @@
+// Package memory is an implementation of store.I that keeps the events in
+// memory, for tests and for embedding a relay that doesn't need to keep its
+// events when it stops.
+//
+// There are no indexes, queries scan all the events, so it is only suited to
+// stores of up to some tens of thousands of events. Superseded versions of
+// replaceable events are deleted or retained by the store.ReplacePolicy of the
+// store, as they are by database.D.
+package memory
+
+import (
+	"bufio"
+	"io"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/eventidserial"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"sort"
+	"sync"
+)
+
+// S is an in-memory event store.
+type S struct {
+	path string
+	mx   sync.RWMutex
+	// serial is the serial of the last event saved.
+	serial uint64
+	events map[uint64]*event.E
+	// serials are the serials of the events by id.
+	serials map[string]uint64
+	// usage is the usage of each author with store.Metered events.
+	usage map[string]*store.Usage
+	conf  *store.Configuration
+	// replacePolicy decides if superseded versions are retained.
+	replacePolicy store.ReplacePolicy
+	// replSeq is the sequence number of the last item queued for a peer.
+	replSeq uint64
+	queues  map[string][]*store.ReplicationItem
+}
+
+var _ store.I = (*S)(nil)
+
+// New creates an empty in-memory event store.
+func New() (s *S) {
+	s = &S{
+		events:  make(map[uint64]*event.E),
+		serials: make(map[string]uint64),
+		usage:   make(map[string]*store.Usage),
+		queues:  make(map[string][]*store.ReplicationItem),
+
+		replacePolicy: store.DefaultReplacePolicy,
+	}
+	return
+}
+
+// SetReplacePolicy sets the policy for superseded versions of replaceable and
+// parameterized replaceable events.
+func (s *S) SetReplacePolicy(p store.ReplacePolicy) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.replacePolicy = p
+}
+
+// Path returns the path set by Init, as the store has no files.
+func (s *S) Path() string { return s.path }
+
+// Init sets the path of the store.
+func (s *S) Init(path string) (err error) {
+	s.path = path
+	return
+}
+
+// SetLogLevel does nothing, as the store has no logger of its own.
+func (s *S) SetLogLevel(level string) {}
+
+// Sync does nothing, as there are no buffers to flush.
+func (s *S) Sync() (err error) { return }
+
+// Rescan does nothing, as there are no indexes to regenerate.
+func (s *S) Rescan() (err error) { return }
+
+// Close does nothing, the events are released with the store.
+func (s *S) Close() (err error) { return }
+
+// Wipe deletes all the events. The configuration and the replication queues
+// are kept, as they are by database.D.
+func (s *S) Wipe() (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.events = make(map[uint64]*event.E)
+	s.serials = make(map[string]uint64)
+	s.usage = make(map[string]*store.Usage)
+	return
+}
+
+// EventCount returns the number of events in the store.
+func (s *S) EventCount() (count uint64, err error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	count = uint64(len(s.events))
+	return
+}
+
+// GetSerialById returns the serial of the event with an id, or nil if it is
+// not in the store.
+func (s *S) GetSerialById(id []byte) (ser *types.Uint40, err error) {
+	s.mx.RLock()
+	serial, ok := s.serials[string(id)]
+	s.mx.RUnlock()
+	if !ok {
+		return
+	}
+	ser = new(types.Uint40)
+	if err = ser.Set(serial); chk.E(err) {
+		return
+	}
+	return
+}
+
+// EventIdsBySerial returns the event Ids and serials of up to count events,
+// starting from the given serial, in the order they were stored.
+func (s *S) EventIdsBySerial(start uint64, count int) (
+	evs []eventidserial.E, err error,
+) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	for _, serial := range s.sortedSerials() {
+		if len(evs) >= count {
+			break
+		}
+		if serial < start {
+			continue
+		}
+		evs = append(
+			evs, eventidserial.E{
+				Serial: serial, EventId: hex.Enc(s.events[serial].ID),
+			},
+		)
+	}
+	return
+}
+
+// DeleteEvent removes an event from the store. No tombstone is recorded for
+// the event, so it can be saved again.
+func (s *S) DeleteEvent(c context.T, eid *eventid.T) (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if serial, ok := s.serials[string(eid.Bytes())]; ok {
+		s.remove(serial)
+	}
+	return
+}
+
+// Import saves the events of a stream of line structured JSON, and returns
+// when they are all saved.
+func (s *S) Import(r io.Reader) {
+	scan := bufio.NewScanner(r)
+	scan.Buffer(make([]byte, 0, 1<<20), 1<<26)
+	var count int
+	for scan.Scan() {
+		ev := event.New()
+		if _, err := ev.Unmarshal(scan.Bytes()); err != nil {
+			continue
+		}
+		if _, _, err := s.SaveEvent(
+			context.Bg(), ev, false, nil,
+		); err != nil {
+			continue
+		}
+		count++
+	}
+	chk.E(scan.Err())
+	log.I.F("saved %d events", count)
+}
+
+// Export writes all the events in the store, in the order they were stored,
+// in line structured JSON. If pubkeys are given, only the events with one of
+// them as the author or in a p tag are written.
+func (s *S) Export(c context.T, w io.Writer, pubkeys ...[]byte) {
+	s.mx.RLock()
+	var evs event.S
+	for _, serial := range s.sortedSerials() {
+		evs = append(evs, s.events[serial])
+	}
+	s.mx.RUnlock()
+	// the p tags have the pubkeys in hex.
+	var authors, pTags *tag.T
+	if len(pubkeys) > 0 {
+		hexes := make([][]byte, len(pubkeys))
+		for i, pk := range pubkeys {
+			hexes[i] = hex.EncAppend(nil, pk)
+		}
+		authors, pTags = tag.New(pubkeys...), tag.New(hexes...)
+	}
+	for _, ev := range evs {
+		if c.Err() != nil {
+			return
+		}
+		if authors != nil && !authors.Contains(ev.Pubkey) &&
+			!ev.Tags.ContainsAny([]byte("p"), pTags) {
+			continue
+		}
+		if _, err := w.Write(append(ev.Serialize(), '\n')); chk.E(err) {
+			return
+		}
+	}
+}
+
+// GetConfiguration returns the stored configuration, or nil if none has been
+// stored.
+func (s *S) GetConfiguration() (c *store.Configuration, err error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	if s.conf != nil {
+		conf := *s.conf
+		c = &conf
+	}
+	return
+}
+
+// SetConfiguration stores the configuration.
+func (s *S) SetConfiguration(c *store.Configuration) (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	conf := *c
+	s.conf = &conf
+	return
+}
+
+// sortedSerials returns the serials of the events in the order they were
+// stored. The lock must be held.
+func (s *S) sortedSerials() (serials []uint64) {
+	serials = make([]uint64, 0, len(s.events))
+	for serial := range s.events {
+		serials = append(serials, serial)
+	}
+	sort.Slice(
+		serials, func(i, j int) bool { return serials[i] < serials[j] },
+	)
+	return
+}
+
+// remove removes the event with a serial. The lock must be held.
+func (s *S) remove(serial uint64) {
+	if ev, ok := s.events[serial]; ok {
+		delete(s.serials, string(ev.ID))
+		delete(s.events, serial)
+		s.meter(ev, true)
+	}
+}
@@ -0,0 +1,25 @@
+package memory
+
+import (
+	"orly.dev/pkg/database/storetest"
+	"orly.dev/pkg/interfaces/store"
+	"testing"
+)
+
+// TestConformance runs the suite with the replace policy of the relay, and
+// TestConformanceHistory with every superseded version retained.
+func TestConformance(t *testing.T) {
+	storetest.Run(t, opener(store.DefaultReplacePolicy))
+}
+
+func TestConformanceHistory(t *testing.T) {
+	storetest.Run(t, opener(store.ReplacePolicy{History: true}))
+}
+
+func opener(p store.ReplacePolicy) storetest.Opener {
+	return func(t *testing.T) store.I {
+		s := New()
+		s.SetReplacePolicy(p)
+		return s
+	}
+}
@@ -0,0 +1,192 @@
+package memory
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/words"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"sort"
+	"time"
+)
+
+// match is an event that matches a filter.
+type match struct {
+	serial uint64
+	ev     *event.E
+	score  int
+}
+
+// matches returns the events that match a filter, newest first, or in order of
+// relevance for a search. Without a search, the events of a filter with a
+// cursor are those after it. If current is true, the superseded versions of
+// replaceable events that the store retains are left out.
+func (s *S) matches(f *filter.F, current bool) (found []match) {
+	var q *words.Query
+	if len(f.Search) > 0 {
+		q = words.Parse(f.Search)
+	}
+	s.mx.RLock()
+	var newest map[string]*event.E
+	if current {
+		newest = s.newest()
+	}
+	for serial, ev := range s.events {
+		if !store.Matches(f, ev) {
+			continue
+		}
+		if newest != nil && store.IsAddressable(ev.Kind) &&
+			newest[store.Address(ev)] != ev {
+			continue
+		}
+		if q == nil && f.Cursor != nil &&
+			!f.Cursor.After(ev.CreatedAt.I64(), serial) {
+			continue
+		}
+		m := match{serial: serial, ev: ev}
+		if q != nil {
+			m.score = q.Score(ev)
+		}
+		found = append(found, m)
+	}
+	s.mx.RUnlock()
+	sort.Slice(
+		found, func(i, j int) bool {
+			if found[i].score != found[j].score {
+				return found[i].score > found[j].score
+			}
+			if found[i].ev.CreatedAt.I64() != found[j].ev.CreatedAt.I64() {
+				return found[i].ev.CreatedAt.I64() > found[j].ev.CreatedAt.I64()
+			}
+			return found[i].serial > found[j].serial
+		},
+	)
+	return
+}
+
+// newest returns the newest version of each replaceable event by its address.
+// The lock must be held.
+func (s *S) newest() (newest map[string]*event.E) {
+	newest = make(map[string]*event.E)
+	for _, ev := range s.events {
+		if !store.IsAddressable(ev.Kind) {
+			continue
+		}
+		a := store.Address(ev)
+		if n, ok := newest[a]; !ok || store.Supersedes(ev, n) {
+			newest[a] = ev
+		}
+	}
+	return
+}
+
+// QueryForIds returns the ids, pubkeys, timestamps and serials of the events
+// that match a filter, newest first, or in order of relevance for a search,
+// including the retained superseded versions of replaceable events. A filter
+// with Ids is an error.
+func (s *S) QueryForIds(c context.T, f *filter.F) (
+	idPkTs []store.IdPkTs, err error,
+) {
+	if f.Ids != nil && f.Ids.Len() > 0 {
+		err = errorf.E("query for Ids is invalid for a filter with Ids")
+		return
+	}
+	for _, m := range s.matches(f, false) {
+		if f.Limit != nil && len(idPkTs) >= int(*f.Limit) {
+			break
+		}
+		idPkTs = append(
+			idPkTs, store.IdPkTs{
+				Id: m.ev.ID, Pub: m.ev.Pubkey, Ts: m.ev.CreatedAt.I64(),
+				Ser: m.serial,
+			},
+		)
+	}
+	return
+}
+
+// QueryEvents returns the events that match a filter, as StreamEvents yields
+// them.
+func (s *S) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
+	err = s.StreamEvents(
+		c, f, func(ev *event.E) (more bool) {
+			evs = append(evs, ev)
+			return true
+		},
+	)
+	return
+}
+
+// StreamEvents calls fn with each event that matches a filter, newest first,
+// or in order of relevance for a search, until fn returns false or the
+// context is cancelled, in which case the error of the context is returned.
+//
+// Deletion events and the retained superseded versions of replaceable events
+// are only yielded to a query by id, and expired events are not yielded.
+func (s *S) StreamEvents(
+	c context.T, f *filter.F, fn func(ev *event.E) (more bool),
+) (err error) {
+	_, err = s.stream(c, f, fn)
+	return
+}
+
+// stream is StreamEvents, and returns the last match it yielded.
+func (s *S) stream(
+	c context.T, f *filter.F, fn func(ev *event.E) (more bool),
+) (last *match, err error) {
+	now := time.Now().Unix()
+	byId := f.Ids != nil && f.Ids.Len() > 0
+	var n uint
+	for _, m := range s.matches(f, !byId) {
+		if err = c.Err(); err != nil {
+			return
+		}
+		if f.Limit != nil && n >= *f.Limit {
+			return
+		}
+		if (!byId && m.ev.Kind.Equal(kind.Deletion)) || m.ev.IsExpired(now) {
+			continue
+		}
+		n++
+		last = &m
+		if !fn(m.ev) {
+			return
+		}
+	}
+	return
+}
+
+// QueryPage returns the events QueryEvents returns for a filter, and if they
+// reached the Limit of the filter, the Cursor of the last of them.
+func (s *S) QueryPage(c context.T, f *filter.F) (
+	evs event.S, next *filter.Cursor, err error,
+) {
+	var last *match
+	if last, err = s.stream(
+		c, f, func(ev *event.E) (more bool) {
+			evs = append(evs, ev)
+			return true
+		},
+	); err != nil {
+		return
+	}
+	if last != nil && f.Limit != nil && uint(len(evs)) >= *f.Limit &&
+		(f.Ids == nil || f.Ids.Len() == 0) && len(f.Search) == 0 {
+		next = &filter.Cursor{Ts: last.ev.CreatedAt.I64(), Ser: last.serial}
+	}
+	return
+}
+
+// CountEvents returns the number of events that match a filter, ignoring its
+// limit, which leaves out the retained superseded versions of replaceable
+// events unless the filter has ids. The count is never approximate.
+func (s *S) CountEvents(c context.T, f *filter.F) (
+	count int, approximate bool, err error,
+) {
+	ff := *f
+	ff.Limit = nil
+	count = len(s.matches(&ff, f.Ids == nil || f.Ids.Len() == 0))
+	return
+}
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"orly.dev/pkg/interfaces/store"
+)
+
+// EnqueueReplication adds an item to the end of the outbound queues of the
+// peers with the given pubkeys.
+func (s *S) EnqueueReplication(
+	peers [][]byte, item *store.ReplicationItem,
+) (err error) {
+	if len(peers) == 0 {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.replSeq++
+	item.Seq = s.replSeq
+	for _, peer := range peers {
+		it := *item
+		s.queues[string(peer)] = append(s.queues[string(peer)], &it)
+	}
+	return
+}
+
+// ReplicationBatch returns up to max items from the front of the queue of a
+// peer, in the order they were queued.
+func (s *S) ReplicationBatch(peer []byte, max int) (
+	items []*store.ReplicationItem, err error,
+) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	for _, item := range s.queues[string(peer)] {
+		if len(items) >= max {
+			break
+		}
+		it := *item
+		items = append(items, &it)
+	}
+	return
+}
+
+// AckReplication removes the items of the queue of a peer up to and including
+// the one with the given Seq.
+func (s *S) AckReplication(peer []byte, seq uint64) (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	q := s.queues[string(peer)]
+	var i int
+	for i < len(q) && q[i].Seq <= seq {
+		i++
+	}
+	if i == len(q) {
+		delete(s.queues, string(peer))
+		return
+	}
+	s.queues[string(peer)] = q[i:]
+	return
+}
+
+// ReplicationQueueLen returns the number of items in the queue of a peer, and
+// the unix time the oldest of them was queued.
+func (s *S) ReplicationQueueLen(peer []byte) (
+	n int, oldest int64, err error,
+) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	q := s.queues[string(peer)]
+	if n = len(q); n > 0 {
+		oldest = q[0].Queued
+	}
+	return
+}
+
+// DropReplication removes the queue of a peer.
+func (s *S) DropReplication(peer []byte) (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.queues, string(peer))
+	return
+}
@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"time"
+)
+
+// SaveEvent saves an event in the store.
+//
+// Expired events, and events that a stored NIP-09 deletion event deletes, are
+// rejected. A deletion event removes the events it deletes when it is saved.
+// Replaceable and parameterized replaceable events are rejected with
+// store.ErrSuperseded if a newer version is stored, otherwise the versions
+// they supersede are removed, unless the store.ReplacePolicy retains them.
+//
+// The event is not copied, so it must not be changed after it is saved. No
+// bytes are counted as written, as nothing is encoded.
+func (s *S) SaveEvent(
+	c context.T, ev *event.E, noVerify bool, owners [][]byte,
+) (kc, vc int, err error) {
+	if exp, ok := ev.Expiration(); ok && exp < time.Now().Unix() {
+		err = errorf.E("invalid: event %0x expired at %d", ev.ID, exp)
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if _, ok := s.serials[string(ev.ID)]; ok {
+		err = store.ErrDupEvent
+		return
+	}
+	var replaced []uint64
+	address := ""
+	if store.IsAddressable(ev.Kind) {
+		address = store.Address(ev)
+	}
+	isDeletion := ev.Kind.Equal(kind.Deletion)
+	var deleted []uint64
+	for serial, stored := range s.events {
+		if stored.Kind.Equal(kind.Deletion) &&
+			store.DeletedBy(stored, ev, owners) {
+			err = errorf.E("blocked: event %0x has been deleted", ev.ID)
+			return
+		}
+		if isDeletion && store.DeletedBy(ev, stored, owners) {
+			deleted = append(deleted, serial)
+		}
+		if address != "" && store.IsAddressable(stored.Kind) &&
+			store.Address(stored) == address {
+			if !store.Supersedes(ev, stored) {
+				err = store.ErrSuperseded
+				return
+			}
+			if !s.replacePolicy.Retains(stored.Kind) {
+				replaced = append(replaced, serial)
+			}
+		}
+	}
+	for _, serial := range append(deleted, replaced...) {
+		s.remove(serial)
+	}
+	s.serial++
+	s.events[s.serial] = ev
+	s.serials[string(ev.ID)] = s.serial
+	s.meter(ev, false)
+	return
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/interfaces/store"
+)
+
+var _ store.Meter = (*S)(nil)
+
+// Usage returns the store.Usage of an author, which is always counted.
+func (s *S) Usage(pubkey []byte) (u store.Usage, ok bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	if au, found := s.usage[string(pubkey)]; found {
+		u = *au
+	}
+	return u, true
+}
+
+// Usages returns the store.Usage of each author with events.
+func (s *S) Usages() (usages map[string]store.Usage, ok bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	usages = make(map[string]store.Usage, len(s.usage))
+	for pk, u := range s.usage {
+		usages[pk] = *u
+	}
+	return usages, true
+}
+
+// meter counts an event that has been saved, or deleted, in the usage of its
+// author, if it is store.Metered. The lock must be held.
+func (s *S) meter(ev *event.E, deleted bool) {
+	if !store.Metered(ev) {
+		return
+	}
+	u, ok := s.usage[string(ev.Pubkey)]
+	if !ok {
+		u = &store.Usage{}
+		s.usage[string(ev.Pubkey)] = u
+	}
+	n, size := 1, store.Size(ev)
+	if deleted {
+		n, size = -n, -size
+	}
+	u.Events += n
+	u.Bytes += size
+	if u.Events <= 0 {
+		delete(s.usage, string(ev.Pubkey))
+	}
+}
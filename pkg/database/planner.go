@@ -0,0 +1,693 @@
+package database
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/dgraph-io/badger/v4"
+	"math"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"sort"
+	"time"
+)
+
+// PlanCosts are the weights the query planner compares the plans of a filter
+// with.
+type PlanCosts struct {
+	// Seek, Key and Fetch are the costs of the work of a plan: seeking to the
+	// start of a range of an index, reading a key of the range and the full
+	// id index of its event, and fetching the event to check the fields of
+	// the filter the index doesn't cover.
+	Seek, Key, Fetch float64
+	// TagRuleSelectivity and TagRangeSelectivity are the fractions of the
+	// events the planner estimates a value and a range of values of a tag of
+	// a TagRule to match, as they are not counted.
+	TagRuleSelectivity, TagRangeSelectivity float64
+	// GeoSelectivity is the fraction of the events the planner estimates a
+	// geohash cell of a GeoQuery to match, as they are not counted.
+	GeoSelectivity float64
+}
+
+// DefaultPlanCosts weighs a seek as eight keys read and a fetch as four.
+var DefaultPlanCosts = PlanCosts{
+	Seek:                16,
+	Key:                 2,
+	Fetch:               8,
+	TagRuleSelectivity:  0.01,
+	TagRangeSelectivity: 0.1,
+	GeoSelectivity:      0.01,
+}
+
+// SetPlanCosts sets the weights of the query planner. They apply from the
+// next query planned.
+func (d *D) SetPlanCosts(c PlanCosts) {
+	d.planMx.Lock()
+	defer d.planMx.Unlock()
+	d.planCosts = c
+}
+
+// costs returns the current PlanCosts.
+func (d *D) costs() (c PlanCosts) {
+	d.planMx.Lock()
+	defer d.planMx.Unlock()
+	return d.planCosts
+}
+
+// queryPlan is the driving index chosen for a filter, the ranges of it to
+// scan, and the fields of the filter that are checked on what is found.
+type queryPlan struct {
+	store.PlanCandidate
+	ranges []Range
+	limit  int
+	// authors are the pubkey hashes of the authors of the filter, if the
+	// driving index doesn't cover them, which are checked against the full
+	// id index.
+	authors [][]byte
+	// kinds and tags are the kinds and tags of the filter that the driving
+	// index doesn't cover, which are checked on the event.
+	kinds *kinds.T
+	tags  []*tag.T
+	// since and until are the timestamps of the filter, if the driving
+	// index is in the order of the values of a tag instead of timestamps,
+	// which are checked against the full id index.
+	since, until int64
+	// cursor is the cursor of the filter, which the events have to be after.
+	cursor   *filter.Cursor
+	residual []string
+}
+
+// The fields of a filter that an index can cover.
+const (
+	dimKinds = iota
+	dimAuthors
+	dimTag
+	dimTagRule
+	dimGeo
+)
+
+// planDim is a field of a filter that an index can cover.
+type planDim struct {
+	field  int
+	name   string
+	values int
+	// count is the number of events that match any of the values.
+	count uint64
+	// tag is the tag of the filter, for a tag key, and rule is the rule that
+	// indexes it, if it isn't a tag that NIP-01 defines.
+	tag  *tag.T
+	rule *TagRule
+	// exact is false for a g tag with the areas of a GeoQuery, whose cells
+	// contain geohashes outside of them that have to be checked.
+	exact bool
+}
+
+// tagKey returns the key of the tag of a filter without the # it may have.
+func tagKey(t *tag.T) (key []byte) {
+	key, _ = filter.TagKey(t.B(0))
+	return
+}
+
+// ruleCovers returns true if the index of a rule can find the values of a
+// tag of a filter: the exact values for a text rule, numbers and ranges of
+// numbers for a typed one.
+func ruleCovers(rule *TagRule, t *tag.T) bool {
+	for _, v := range t.ToSliceOfBytes()[1:] {
+		_, _, isRange := filter.TagRange(v)
+		if rule.Type == TagText {
+			if isRange {
+				return false
+			}
+		} else if _, isNumber := filter.TagNumber(v); !isRange && !isNumber {
+			return false
+		}
+	}
+	return true
+}
+
+// planQuery chooses the cheapest driving index for a filter, from the counts
+// of the events of each kind, author and tag value, and returns the plans it
+// considered, cheapest first.
+//
+// Each plan scans the ranges of one of the indexes that cover some of the
+// kinds, authors and tag keys of the filter, the index of a TagRule that
+// covers a tag, or the geohash index and the kinds, and checks the fields it
+// doesn't cover on each event it finds. Its cost is the ranges it seeks to,
+// plus the keys it is estimated to read, plus the events it has to fetch to
+// check, and when the filter has a limit, the scan of each range stops when it
+// has found that many events. A filter with tag queries that no TagRule
+// covers is refused unless it has kinds, authors or another indexed tag.
+//
+// The ranges of a filter with a cursor end at the timestamp of the cursor,
+// and the events with that timestamp are only found if their serials are
+// lower than that of the cursor.
+func (d *D) planQuery(f *filter.F) (
+	p *queryPlan, candidates []store.PlanCandidate, err error,
+) {
+	if f.Cursor != nil && (f.Until == nil || f.Until.I64() == 0 ||
+		f.Until.I64() > f.Cursor.Ts) {
+		ff := *f
+		ff.Until = timestamp.FromUnix(f.Cursor.Ts)
+		f = &ff
+	}
+	costs := d.costs()
+	d.card.RLock()
+	loaded, total := d.card.loaded, float64(d.card.total)
+	var kindsDim, authorsDim *planDim
+	if f.Kinds != nil && f.Kinds.Len() > 0 {
+		kindsDim = &planDim{
+			field: dimKinds, name: "kinds", values: f.Kinds.Len(),
+		}
+		for _, k := range f.Kinds.ToUint16() {
+			kindsDim.count += d.card.kinds[k]
+		}
+	}
+	var hashes [][]byte
+	if f.Authors != nil && f.Authors.Len() > 0 {
+		authorsDim = &planDim{
+			field: dimAuthors, name: "authors", values: f.Authors.Len(),
+		}
+		for _, author := range f.Authors.ToSliceOfBytes() {
+			var ph *types.PubHash
+			if ph, err = CreatePubHashFromData(author); chk.E(err) {
+				d.card.RUnlock()
+				return
+			}
+			hashes = append(hashes, ph.Bytes())
+			authorsDim.count += d.card.authors[[8]byte(ph.Bytes())]
+		}
+	}
+	// the first values of the tags with a one letter key are indexed, and
+	// those of the tag rules, other tags are always checked on the events.
+	var tagDims, ruleDims []*planDim
+	var geoDim *planDim
+	var unindexed []*tag.T
+	if f.Tags != nil {
+		for _, t := range f.Tags.ToSliceOfTags() {
+			if t.Len() < 2 {
+				continue
+			}
+			key := tagKey(t)
+			dim := &planDim{
+				field: dimTag, name: string(t.B(0)), values: t.Len() - 1,
+				tag: t,
+			}
+			if !filter.IsTagQuery(t) {
+				for _, v := range t.ToSliceOfBytes()[1:] {
+					var tv [9]byte
+					tv[0] = key[0]
+					ident := new(types.Ident)
+					ident.FromIdent(v)
+					copy(tv[1:], ident.Bytes())
+					dim.count += d.card.tags[tv]
+				}
+				tagDims = append(tagDims, dim)
+				continue
+			}
+			name, position := filter.TagKey(t.B(0))
+			if covers, exact := geoCovers(t); covers && geoDim == nil &&
+				position == 1 && bytes.Equal(name, filter.GeoTag) {
+				dim.field, dim.exact = dimGeo, exact
+				dim.values = geoCells(t)
+				dim.count = uint64(
+					math.Min(1, float64(dim.values)*costs.GeoSelectivity) * total,
+				)
+				geoDim = dim
+				continue
+			}
+			rule := d.tagRule(name, position)
+			if rule == nil || !ruleCovers(rule, t) {
+				unindexed = append(unindexed, t)
+				continue
+			}
+			dim.field, dim.rule = dimTagRule, rule
+			var sel float64
+			for _, v := range t.ToSliceOfBytes()[1:] {
+				if _, _, ok := filter.TagRange(v); ok {
+					sel += costs.TagRangeSelectivity
+				} else {
+					sel += costs.TagRuleSelectivity
+				}
+			}
+			dim.count = uint64(math.Min(1, sel) * total)
+			ruleDims = append(ruleDims, dim)
+		}
+	}
+	d.card.RUnlock()
+	// a tag query that no index covers is checked on every event the other
+	// fields find, so without them it would read the whole store.
+	if len(unindexed) > 0 && kindsDim == nil && authorsDim == nil &&
+		len(tagDims) == 0 && len(ruleDims) == 0 && geoDim == nil {
+		err = errorf.E(
+			"unsupported: no tag index covers %s, filter on the kinds, "+
+				"authors or an indexed tag too", unindexed[0].B(0),
+		)
+		return
+	}
+	var limit int
+	if f.Limit != nil && *f.Limit > 0 {
+		limit = int(*f.Limit)
+	}
+	var dims []*planDim
+	for _, dim := range []*planDim{kindsDim, authorsDim} {
+		if dim != nil {
+			dims = append(dims, dim)
+		}
+	}
+	dims = append(append(dims, tagDims...), ruleDims...)
+	if geoDim != nil {
+		dims = append(dims, geoDim)
+	}
+	// the fraction of the events that match a field.
+	selectivity := func(dim *planDim) float64 {
+		if total == 0 {
+			return 0
+		}
+		return math.Min(1, float64(dim.count)/total)
+	}
+	type option struct {
+		store.PlanCandidate
+		used map[*planDim]bool
+	}
+	var options []option
+	consider := func(used ...*planDim) {
+		o := option{used: make(map[*planDim]bool)}
+		ranges, scanned, matching := 1.0, total, 1.0
+		fetch, ordered := len(unindexed) > 0, true
+		for _, dim := range used {
+			o.used[dim] = true
+			ranges *= float64(dim.values)
+			scanned *= selectivity(dim)
+			if dim.field == dimTagRule && dim.rule.Type != TagText {
+				ordered = false
+			}
+			if dim.field == dimGeo {
+				ordered, fetch = false, fetch || !dim.exact
+			}
+		}
+		for _, dim := range dims {
+			if o.used[dim] {
+				continue
+			}
+			matching *= selectivity(dim)
+			// the authors are checked on the full id index, the other fields
+			// on the event.
+			fetch = fetch || dim.field != dimAuthors
+		}
+		if limit > 0 && ordered && matching > 0 {
+			scanned = math.Min(
+				scanned, ranges*math.Ceil(float64(limit)/matching),
+			)
+		}
+		cost := ranges*costs.Seek + scanned*costs.Key
+		if fetch {
+			cost += scanned * costs.Fetch
+		}
+		o.Index = planIndex(used)
+		o.Ranges = int(ranges)
+		o.Estimate = uint64(math.Ceil(scanned))
+		o.Cost = uint64(math.Ceil(cost))
+		options = append(options, o)
+	}
+	// the combinations of the kinds, the authors and a tag key each have an
+	// index, the index of a tag rule covers only its tag, and the geohash
+	// index a g tag alone or with the kinds.
+	tagChoices := append([]*planDim{nil}, tagDims...)
+	for _, k := range []*planDim{nil, kindsDim} {
+		for _, a := range []*planDim{nil, authorsDim} {
+			for _, t := range tagChoices {
+				var used []*planDim
+				for _, dim := range []*planDim{k, a, t} {
+					if dim != nil {
+						used = append(used, dim)
+					}
+				}
+				consider(used...)
+			}
+			if authorsDim == nil {
+				break
+			}
+		}
+		if kindsDim == nil {
+			break
+		}
+	}
+	for _, dim := range ruleDims {
+		consider(dim)
+	}
+	if geoDim != nil {
+		consider(geoDim)
+		if kindsDim != nil {
+			consider(kindsDim, geoDim)
+		}
+	}
+	// until the counts are loaded the costs are not known, and the index that
+	// covers the most of the filter is the best guess.
+	sort.SliceStable(
+		options, func(i, j int) bool {
+			if loaded && options[i].Cost != options[j].Cost {
+				return options[i].Cost < options[j].Cost
+			}
+			if len(options[i].used) != len(options[j].used) {
+				return len(options[i].used) > len(options[j].used)
+			}
+			return options[i].Ranges < options[j].Ranges
+		},
+	)
+	for _, o := range options {
+		candidates = append(candidates, o.PlanCandidate)
+	}
+	best := options[0]
+	p = &queryPlan{
+		PlanCandidate: best.PlanCandidate, limit: limit, cursor: f.Cursor,
+	}
+	if f.Cursor != nil {
+		p.residual = append(p.residual, "cursor")
+	}
+	// the filter of the fields the driving index covers makes its ranges.
+	ff := &filter.F{Since: f.Since, Until: f.Until}
+	var driver *planDim
+	for _, dim := range dims {
+		switch {
+		case best.used[dim] && dim.field == dimKinds:
+			ff.Kinds = f.Kinds
+		case best.used[dim] && dim.field == dimAuthors:
+			ff.Authors = f.Authors
+		case best.used[dim] && dim.field == dimTag:
+			ff.Tags = tags.New(dim.tag)
+		case best.used[dim]:
+			driver = dim
+		case dim.field == dimKinds:
+			p.kinds = f.Kinds
+			p.residual = append(p.residual, dim.name)
+		case dim.field == dimAuthors:
+			p.authors = hashes
+			p.residual = append(p.residual, dim.name)
+		default:
+			p.tags = append(p.tags, dim.tag)
+			p.residual = append(p.residual, dim.name)
+		}
+	}
+	for _, t := range unindexed {
+		p.tags = append(p.tags, t)
+		p.residual = append(p.residual, string(t.B(0)))
+	}
+	switch {
+	case driver == nil:
+		if p.ranges, err = GetIndexesFromFilter(ff); chk.E(err) {
+			return
+		}
+		return
+	case driver.field == dimGeo:
+		if p.ranges, err = geoRanges(driver.tag, ff.Kinds); chk.E(err) {
+			return
+		}
+		if !driver.exact {
+			p.tags = append(p.tags, driver.tag)
+			p.residual = append(p.residual, driver.name)
+		}
+	default:
+		if p.ranges, err = tagRuleRanges(
+			*driver.rule, driver.tag, f,
+		); chk.E(err) {
+			return
+		}
+	}
+	if driver.field == dimGeo || driver.rule.Type != TagText {
+		// the keys are in the order of the values, so the scan can't stop
+		// at the limit, and the timestamps are checked on each event.
+		p.limit = 0
+		if f.Since != nil {
+			p.since = f.Since.I64()
+		}
+		if f.Until != nil {
+			p.until = f.Until.I64()
+		}
+		if p.since != 0 || p.until != 0 {
+			p.residual = append(p.residual, "since/until")
+		}
+	}
+	return
+}
+
+// planIndex returns the prefix of the index that covers the fields of a
+// filter.
+func planIndex(used []*planDim) (prefix string) {
+	var kinds, authors, tag, geo bool
+	for _, dim := range used {
+		switch dim.field {
+		case dimKinds:
+			kinds = true
+		case dimAuthors:
+			authors = true
+		case dimTag:
+			tag = true
+		case dimTagRule:
+			if dim.rule.Type == TagText {
+				return string(indexes.TagNamePrefix)
+			}
+			return string(indexes.TagValuePrefix)
+		case dimGeo:
+			geo = true
+		}
+	}
+	var i indexes.I
+	switch {
+	case geo && kinds:
+		i = indexes.GeohashKindPrefix
+	case geo:
+		i = indexes.GeohashPrefix
+	case kinds && authors && tag:
+		i = indexes.TagKindPubkeyPrefix
+	case kinds && tag:
+		i = indexes.TagKindPrefix
+	case authors && tag:
+		i = indexes.TagPubkeyPrefix
+	case tag:
+		i = indexes.TagPrefix
+	case kinds && authors:
+		i = indexes.KindPubkeyPrefix
+	case kinds:
+		i = indexes.KindPrefix
+	case authors:
+		i = indexes.PubkeyPrefix
+	default:
+		i = indexes.CreatedAtPrefix
+	}
+	return string(i)
+}
+
+// runPlan scans the ranges of a plan and returns the ids, pubkeys and
+// timestamps of the events that match the filter, newest first, and those
+// with the same timestamp in reverse order of their serials, and records
+// what it did in trace if it isn't nil.
+//
+// The keys of each range are in reverse chronological order, so when the
+// filter has a limit, each range is only scanned until it has found that many
+// matching events, since no event after them can be among the newest.
+func (d *D) runPlan(c context.T, p *queryPlan, trace *store.QueryPlan) (
+	idPkTs []store.IdPkTs, err error,
+) {
+	// the results of the events found in more than one range.
+	matched := make(map[uint64]bool)
+	for _, r := range p.ranges {
+		if err = c.Err(); err != nil {
+			return
+		}
+		pr := store.PlanRange{
+			Start: hex.EncodeToString(r.Start), End: hex.EncodeToString(r.End),
+		}
+		if err = d.scanRange(
+			r, func(ser *types.Uint40) (more bool, err error) {
+				pr.Keys++
+				ok, seen := matched[ser.Get()]
+				if !seen {
+					var idpk *store.IdPkTs
+					var fetched bool
+					if idpk, fetched, err = d.checkPlan(p, ser); chk.E(err) {
+						return
+					}
+					if fetched && trace != nil {
+						trace.Fetched++
+					}
+					ok = idpk != nil
+					matched[ser.Get()] = ok
+					if ok {
+						idPkTs = append(idPkTs, *idpk)
+					}
+				}
+				if ok {
+					pr.Matched++
+				}
+				more = p.limit == 0 || pr.Matched < p.limit
+				return
+			},
+		); chk.E(err) {
+			return
+		}
+		if trace != nil {
+			trace.Ranges = append(trace.Ranges, pr)
+			trace.Keys += pr.Keys
+		}
+	}
+	sort.Slice(
+		idPkTs, func(i, j int) bool {
+			if idPkTs[i].Ts != idPkTs[j].Ts {
+				return idPkTs[i].Ts > idPkTs[j].Ts
+			}
+			return idPkTs[i].Ser > idPkTs[j].Ser
+		},
+	)
+	return
+}
+
+// scanRange calls fn with the serial of each key of a range, newest first,
+// until fn returns false.
+func (d *D) scanRange(
+	idx Range, fn func(ser *types.Uint40) (more bool, err error),
+) (err error) {
+	err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Reverse: true},
+			)
+			defer it.Close()
+			for it.Seek(idx.End); it.Valid(); it.Next() {
+				key := it.Item().Key()
+				if bytes.Compare(key[:len(key)-5], idx.Start) < 0 {
+					return
+				}
+				ser := new(types.Uint40)
+				if err = ser.UnmarshalRead(
+					bytes.NewBuffer(key[len(key)-5:]),
+				); chk.E(err) {
+					return
+				}
+				var more bool
+				if more, err = fn(ser); err != nil || !more {
+					return
+				}
+			}
+			return
+		},
+	)
+	return
+}
+
+// checkPlan returns the id, pubkey and timestamp of the event with a serial
+// if it matches the fields of the filter that the driving index of the plan
+// doesn't cover, or nil if it doesn't.
+func (d *D) checkPlan(p *queryPlan, ser *types.Uint40) (
+	idpk *store.IdPkTs, fetched bool, err error,
+) {
+	var fidpk *store.IdPkTs
+	if fidpk, err = d.GetFullIdPubkeyBySerial(ser); chk.E(err) {
+		return
+	}
+	if fidpk == nil {
+		return
+	}
+	if (p.since != 0 && fidpk.Ts < p.since) ||
+		(p.until != 0 && fidpk.Ts > p.until) {
+		return
+	}
+	if p.cursor != nil && !p.cursor.After(fidpk.Ts, fidpk.Ser) {
+		return
+	}
+	if p.authors != nil {
+		var found bool
+		for _, h := range p.authors {
+			if bytes.Equal(h, fidpk.Pub) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+	if p.kinds != nil || len(p.tags) > 0 {
+		var ev *event.E
+		if ev, err = d.FetchEventBySerial(ser); err != nil {
+			// the event is gone, so it doesn't match.
+			err = nil
+			return
+		}
+		fetched = true
+		if p.kinds != nil && !p.kinds.Contains(ev.Kind) {
+			return
+		}
+		for _, t := range p.tags {
+			if !filter.MatchesTag(ev.Tags, t) {
+				return
+			}
+		}
+	}
+	idpk = fidpk
+	return
+}
+
+// Explain runs the query for a filter, and returns the plan that was chosen
+// for it, the plans that were considered, the ranges of the driving index
+// that were scanned and the keys and events that were read.
+//
+// A filter with ids is found by the eid index, and a filter with a search by
+// the word index, which have no other plan.
+func (d *D) Explain(c context.T, f *filter.F) (
+	plan *store.QueryPlan, err error,
+) {
+	start := time.Now()
+	plan = &store.QueryPlan{}
+	if f.Since != nil {
+		plan.Since = f.Since.I64()
+	}
+	if f.Until != nil {
+		plan.Until = f.Until.I64()
+	}
+	switch {
+	case f.Ids != nil && f.Ids.Len() > 0:
+		plan.Index = string(indexes.IdPrefix)
+		for _, id := range f.Ids.ToSliceOfBytes() {
+			pr := store.PlanRange{Keys: 1}
+			if ser, err := d.GetSerialById(id); err == nil && ser != nil {
+				pr.Matched++
+				plan.Results++
+			}
+			plan.Ranges = append(plan.Ranges, pr)
+			plan.Keys++
+		}
+	case len(f.Search) > 0:
+		plan.Index = string(indexes.WordPrefix)
+		var idPkTs []store.IdPkTs
+		if idPkTs, err = d.QueryForSearch(c, f); chk.E(err) {
+			return
+		}
+		plan.Results = len(idPkTs)
+	default:
+		var p *queryPlan
+		if p, plan.Candidates, err = d.planQuery(f); chk.E(err) {
+			return
+		}
+		plan.Index, plan.Estimate, plan.Cost = p.Index, p.Estimate, p.Cost
+		plan.Residual, plan.Limit = p.residual, p.limit
+		var idPkTs []store.IdPkTs
+		if idPkTs, err = d.runPlan(c, p, plan); chk.E(err) {
+			return
+		}
+		if f.Limit != nil && len(idPkTs) > int(*f.Limit) {
+			idPkTs = idPkTs[:*f.Limit]
+		}
+		plan.Results = len(idPkTs)
+	}
+	plan.Micros = time.Since(start).Microseconds()
+	return
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPlanner(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	now := time.Now().Unix()
+	busy := sha256.Sum256([]byte("busy"))
+	quiet := sha256.Sum256([]byte("quiet"))
+	var evs []*event.E
+	save := func(pk []byte, k *kind.T, tt ...*tag.T) {
+		ev := event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(len(evs))))
+		ev.ID = id[:]
+		ev.Pubkey = pk
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - int64(len(evs)))
+		ev.Kind = k
+		ev.Content = []byte("note " + strconv.Itoa(len(evs)))
+		ev.Tags = tags.New(tt...)
+		if _, _, err := d.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	for i := 0; i < 100; i++ {
+		save(busy[:], kind.TextNote, tag.New("t", "nostr"))
+	}
+	for i := 0; i < 3; i++ {
+		save(quiet[:], kind.TextNote, tag.New("t", "nostr"))
+	}
+	for i := 0; i < 2; i++ {
+		save(quiet[:], kind.Reaction)
+	}
+	if err = d.loadCardinality(); err != nil {
+		t.Fatal(err)
+	}
+	if d.card.total != 105 || d.card.kinds[kind.Reaction.K] != 2 {
+		t.Fatalf(
+			"counted %d events and %d reactions, expected 105 and 2",
+			d.card.total, d.card.kinds[kind.Reaction.K],
+		)
+	}
+	// many authors that have no events make the ranges of the author indexes
+	// costly, so the tag index drives the query and the authors are checked.
+	authors := tag.New(quiet[:])
+	for i := 0; i < 50; i++ {
+		pk := sha256.Sum256([]byte("stranger " + strconv.Itoa(i)))
+		authors.Append(pk[:])
+	}
+	limit := uint(10)
+	for _, tc := range []struct {
+		name    string
+		f       *filter.F
+		index   string
+		results int
+		keys    int
+	}{
+		{
+			"authors and tag", &filter.F{
+				Authors: authors,
+				Tags:    tags.New(tag.New("#t", "nostr")),
+			}, "tc-", 3, 103,
+		},
+		{
+			"rare kind", &filter.F{
+				Kinds:   kinds.New(kind.Reaction),
+				Authors: authors,
+			}, "kc-", 2, 2,
+		},
+		{
+			"limit", &filter.F{
+				Kinds: kinds.New(kind.TextNote), Limit: &limit,
+			}, "kc-", 10, 10,
+		},
+	} {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				var plan *store.QueryPlan
+				if plan, err = d.Explain(ctx, tc.f); err != nil {
+					t.Fatal(err)
+				}
+				if plan.Index != tc.index || plan.Results != tc.results ||
+					plan.Keys != tc.keys {
+					t.Fatalf(
+						"plan %s found %d events in %d keys, expected %s, "+
+							"%d and %d", plan.Index, plan.Results, plan.Keys,
+						tc.index, tc.results, tc.keys,
+					)
+				}
+				var idPkTs []store.IdPkTs
+				if idPkTs, err = d.QueryForIds(ctx, tc.f); err != nil {
+					t.Fatal(err)
+				}
+				// the results are the newest of the events that match.
+				var n int
+				for _, ev := range evs {
+					if n == len(idPkTs) {
+						break
+					}
+					if !tc.f.Matches(ev) {
+						continue
+					}
+					if string(idPkTs[n].Id) != string(ev.ID) {
+						t.Fatalf(
+							"result %d is %0x, expected %0x", n, idPkTs[n].Id,
+							ev.ID,
+						)
+					}
+					n++
+				}
+				if n != tc.results {
+					t.Fatalf("found %d events, expected %d", n, tc.results)
+				}
+			},
+		)
+	}
+	// the counts follow the events that are deleted.
+	if err = d.DeleteEvent(ctx, eventid.NewWith(evs[104].ID)); err != nil {
+		t.Fatal(err)
+	}
+	if d.card.total != 104 || d.card.kinds[kind.Reaction.K] != 1 {
+		t.Fatalf(
+			"counted %d events and %d reactions after a deletion, expected "+
+				"104 and 1", d.card.total, d.card.kinds[kind.Reaction.K],
+		)
+	}
+}
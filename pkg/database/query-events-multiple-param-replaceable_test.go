@@ -25,6 +25,8 @@ func TestMultipleParameterizedReplaceableEvents(t *testing.T) {
 	defer os.RemoveAll(tempDir) // Clean up after the test
 	defer cancel()
 	defer db.Close()
+	// retain the superseded versions so they can still be queried by id
+	db.SetReplacePolicy(ReplacePolicy{History: true})
 
 	sign := new(p256k.Signer)
 	if err := sign.Generate(); chk.E(err) {
@@ -338,21 +338,13 @@ func TestReplaceableEventsAndDeletion(t *testing.T) {
 		t.Fatalf("Failed to query for deleted event by ID: %v", err)
 	}
 
-	// Verify we still get the original event when querying by ID
-	if len(evs) != 1 {
+	// Verify the deleted event was removed
+	if len(evs) != 0 {
 		t.Fatalf(
-			"Expected 1 event when querying for deleted event by ID, got %d",
+			"Expected 0 events when querying for deleted event by ID, got %d",
 			len(evs),
 		)
 	}
-
-	// Verify it's the original event
-	if !bytes.Equal(evs[0].ID, replaceableEvent.ID) {
-		t.Fatalf(
-			"Event ID doesn't match when querying for deleted event by ID. Got %x, expected %x",
-			evs[0].ID, replaceableEvent.ID,
-		)
-	}
 }
 
 func TestParameterizedReplaceableEventsAndDeletion(t *testing.T) {
@@ -498,21 +490,13 @@ func TestParameterizedReplaceableEventsAndDeletion(t *testing.T) {
 		)
 	}
 
-	// Verify we still get the event when querying by ID
-	if len(evs) != 1 {
+	// Verify the deleted event was removed
+	if len(evs) != 0 {
 		t.Fatalf(
-			"Expected 1 event when querying for deleted parameterized event by ID, got %d",
+			"Expected 0 events when querying for deleted parameterized event by ID, got %d",
 			len(evs),
 		)
 	}
-
-	// Verify it's the correct event
-	if !bytes.Equal(evs[0].ID, paramEvent.ID) {
-		t.Fatalf(
-			"Event ID doesn't match when querying for deleted parameterized event by ID. Got %x, expected %x",
-			evs[0].ID, paramEvent.ID,
-		)
-	}
 }
 
 func TestQueryEventsByTimeRange(t *testing.T) {
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/words"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"sort"
+)
+
+// QueryForSearch performs a NIP-50 full text search for the Search field of a
+// filter, returning a list of IdPkTs.
+//
+// Events match if they contain any of the terms of the search. The postings of
+// the terms in the Word index are intersected with the serials matching the
+// kinds, authors and tags of the filter, if any are present.
+//
+// Results are in order of relevance, being the number of distinct terms the
+// event contains, and then by recency. With the `sort:recent` extension in the
+// search, they are in reverse chronological order only. The Limit of the filter
+// is applied after sorting.
+func (d *D) QueryForSearch(c context.T, f *filter.F) (
+	idPkTs []store.IdPkTs, err error,
+) {
+	q := words.Parse(f.Search)
+	if len(q.Terms) == 0 {
+		return
+	}
+	var idxs []Range
+	if idxs, err = GetIndexesFromSearch(f, q.Terms); chk.E(err) {
+		return
+	}
+	// each event has only one Word index per word, so the number of times a
+	// serial is found is the number of terms it contains.
+	scores := make(map[uint64]int)
+	for _, idx := range idxs {
+		var sers types.Uint40s
+		if sers, err = d.GetSerialsByRange(idx); chk.E(err) {
+			return
+		}
+		for _, ser := range sers {
+			scores[ser.Get()]++
+		}
+	}
+	if (f.Kinds != nil && f.Kinds.Len() > 0) ||
+		(f.Authors != nil && f.Authors.Len() > 0) ||
+		(f.Tags != nil && f.Tags.Len() > 0) {
+		var allowed map[uint64]struct{}
+		if allowed, err = d.GetSerialsByFilter(f); chk.E(err) {
+			return
+		}
+		for ser := range scores {
+			if _, ok := allowed[ser]; !ok {
+				delete(scores, ser)
+			}
+		}
+	}
+	for s := range scores {
+		ser := new(types.Uint40)
+		if err = ser.Set(s); chk.E(err) {
+			return
+		}
+		var fidpk *store.IdPkTs
+		if fidpk, err = d.GetFullIdPubkeyBySerial(ser); chk.E(err) {
+			return
+		}
+		if fidpk == nil {
+			continue
+		}
+		idPkTs = append(idPkTs, *fidpk)
+	}
+	if q.Recent() {
+		sort.Slice(
+			idPkTs, func(i, j int) bool {
+				return idPkTs[i].Ts > idPkTs[j].Ts
+			},
+		)
+	} else {
+		sort.Slice(
+			idPkTs, func(i, j int) bool {
+				si, sj := scores[idPkTs[i].Ser], scores[idPkTs[j].Ser]
+				if si != sj {
+					return si > sj
+				}
+				return idPkTs[i].Ts > idPkTs[j].Ts
+			},
+		)
+	}
+	if f.Limit != nil && len(idPkTs) > int(*f.Limit) {
+		idPkTs = idPkTs[:*f.Limit]
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"os"
+	"testing"
+)
+
+func TestQueryForSearch(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-db-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, tempDir, "info")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	pk := sha256.Sum256([]byte("pubkey"))
+	notes := []struct {
+		content string
+		k       *kind.T
+		t       string
+	}{
+		{"Bitcoin conference in Riga", kind.TextNote, ""},
+		{"nostr relays and bitcoin", kind.TextNote, "nostr"},
+		{"the weather is nice today", kind.TextNote, ""},
+		{"a long form article about relays", kind.LongFormContent, ""},
+		{"gm conference", kind.TextNote, ""},
+	}
+	var events event.S
+	for i, n := range notes {
+		ev := event.New()
+		id := sha256.Sum256([]byte(n.content))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(int64(1700000000 + i))
+		ev.Kind = n.k
+		ev.Content = []byte(n.content)
+		ev.Tags = tags.New()
+		if n.t != "" {
+			ev.Tags.AppendTags(tag.New("t", n.t))
+		}
+		if _, _, err = db.SaveEvent(ctx, ev, false, nil); chk.E(err) {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	check := func(f *filter.F, expected ...int) {
+		t.Helper()
+		var evs event.S
+		if evs, err = db.QueryEvents(ctx, f); chk.E(err) {
+			t.Fatal(err)
+		}
+		if len(evs) != len(expected) {
+			t.Fatalf(
+				"search %s got %d results, expected %d", f.Search, len(evs),
+				len(expected),
+			)
+		}
+		for i, ev := range evs {
+			if ev.ID[0] != events[expected[i]].ID[0] {
+				t.Fatalf(
+					"search %s result %d is %s, expected %s", f.Search, i,
+					ev.Content, events[expected[i]].Content,
+				)
+			}
+		}
+	}
+	// relevance: both terms first, then by recency
+	check(&filter.F{Search: []byte("Bitcoin conference")}, 0, 4, 1)
+	// recency
+	check(&filter.F{Search: []byte("bitcoin conference sort:recent")}, 4, 1, 0)
+	// tag values are indexed
+	check(&filter.F{Search: []byte("nostr")}, 1)
+	// intersect with kinds
+	check(
+		&filter.F{
+			Search: []byte("relays"), Kinds: kinds.New(kind.LongFormContent),
+		}, 3,
+	)
+	// stop words and unknown words find nothing
+	check(&filter.F{Search: []byte("the")})
+	check(&filter.F{Search: []byte("ethereum")})
+	// limit
+	lim := uint(1)
+	check(&filter.F{Search: []byte("bitcoin"), Limit: &lim}, 1)
+	// count
+	var count int
+	if count, _, err = db.CountEvents(
+		ctx, &filter.F{Search: []byte("conference"), Limit: &lim},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("CountEvents got %d, expected 2", count)
+	}
+	// deleting the event removes its words from the index
+	if err = db.DeleteEvent(ctx, eventid.NewWith(events[1].ID)); chk.E(err) {
+		t.Fatal(err)
+	}
+	check(&filter.F{Search: []byte("nostr")})
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"time"
+)
+
+// QueryPage returns the events QueryEvents returns for a filter, and if they
+// reached the Limit of the filter, the Cursor of the last result of
+// QueryForIds that was scanned for them, so the next page starts after the
+// events that were scanned for this one, including those that were left out
+// of it.
+func (d *D) QueryPage(c context.T, f *filter.F) (
+	evs event.S, next *filter.Cursor, err error,
+) {
+	if (f.Ids != nil && f.Ids.Len() > 0) || len(f.Search) > 0 ||
+		f.Limit == nil || *f.Limit == 0 {
+		evs, err = d.QueryEvents(c, f)
+		return
+	}
+	var last *store.IdPkTs
+	if last, err = d.streamQuery(
+		c, f, time.Now().Unix(), func(ev *event.E) (more bool) {
+			evs = append(evs, ev)
+			return true
+		},
+	); err != nil {
+		return
+	}
+	if last != nil {
+		next = &filter.Cursor{Ts: last.Ts, Ser: last.Ser}
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQueryPage(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("pubkey"))
+	evs := make([]*event.E, 6)
+	for i := range evs {
+		ev := event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(i)))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - int64(i))
+		ev.Kind = kind.TextNote
+		ev.Content = []byte("note " + strconv.Itoa(i))
+		ev.Tags = tags.New()
+		if _, _, err = d.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		evs[i] = ev
+	}
+	// the deleted event is scanned for the first page but left out of it,
+	// which is still followed by the next.
+	if err = d.DeleteEvent(ctx, eventid.NewWith(evs[1].ID)); err != nil {
+		t.Fatal(err)
+	}
+	limit := uint(3)
+	f := &filter.F{Kinds: kinds.New(kind.TextNote), Limit: &limit}
+	var got []*event.E
+	for page := 0; ; page++ {
+		if page > len(evs) {
+			t.Fatal("the pages don't end")
+		}
+		var found event.S
+		var next *filter.Cursor
+		if found, next, err = d.QueryPage(ctx, f); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, found...)
+		if next == nil {
+			break
+		}
+		f.Cursor = next
+	}
+	expected := append([]*event.E{evs[0]}, evs[2:]...)
+	if len(got) != len(expected) {
+		t.Fatalf("got %d events, expected %d", len(got), len(expected))
+	}
+	for i, ev := range expected {
+		if string(got[i].ID) != string(ev.ID) {
+			t.Fatalf("event %d is %0x, expected %0x", i, got[i].ID, ev.ID)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+)
+
+// ReplacePolicy decides what is done with the versions of a replaceable or
+// parameterized replaceable event that are superseded by a newer version.
+type ReplacePolicy = store.ReplacePolicy
+
+// DefaultReplacePolicy is the policy of a new D, and the one the relay sets on
+// its store unless it keeps every version.
+var DefaultReplacePolicy = store.DefaultReplacePolicy
+
+// SetReplacePolicy sets the policy for superseded versions of replaceable and
+// parameterized replaceable events.
+func (d *D) SetReplacePolicy(p ReplacePolicy) {
+	d.replaceMx.Lock()
+	defer d.replaceMx.Unlock()
+	d.replacePolicy = p
+}
+
+// appendAddress appends the Address index of a replaceable or parameterized
+// replaceable event to idxs.
+func appendAddress(
+	idxs *[][]byte, ev *event.E, pubHash *types.PubHash,
+	createdAt *types.Uint64, ser *types.Uint40,
+) (err error) {
+	k := new(types.Uint16)
+	k.Set(ev.Kind.K)
+	_, dValue := replaceableKey(ev)
+	dTag := new(types.Ident)
+	dTag.FromIdent([]byte(dValue))
+	return appendIndexBytes(
+		idxs, indexes.AddressEnc(k, pubHash, dTag, createdAt, ser),
+	)
+}
+
+// addressPrefix returns the prefix of the Address indexes of the versions of a
+// replaceable or parameterized replaceable event.
+func addressPrefix(ev *event.E) (prf []byte, err error) {
+	_, dValue := replaceableKey(ev)
+	return addressPrefixOf(ev.Kind, ev.Pubkey, []byte(dValue))
+}
+
+// addressPrefixOf returns the prefix of the Address indexes of the versions
+// of the event with a kind, pubkey and d tag.
+func addressPrefixOf(k *kind.T, pubkey, dValue []byte) (
+	prf []byte, err error,
+) {
+	kk := new(types.Uint16)
+	kk.Set(k.K)
+	pubHash := new(types.PubHash)
+	if err = pubHash.FromPubkey(pubkey); chk.E(err) {
+		return
+	}
+	dTag := new(types.Ident)
+	dTag.FromIdent(dValue)
+	buf := new(bytes.Buffer)
+	if err = indexes.AddressEnc(
+		kk, pubHash, dTag, nil, nil,
+	).MarshalWrite(buf); chk.E(err) {
+		return
+	}
+	prf = buf.Bytes()
+	return
+}
+
+// supersedes returns true if an event is a newer version than another, by the
+// NIP-01 rule that the newest is retained, and of two with the same timestamp,
+// the one with the lowest id.
+func supersedes(ev, other *event.E) bool {
+	if ev.CreatedAt.I64() != other.CreatedAt.I64() {
+		return ev.CreatedAt.I64() > other.CreatedAt.I64()
+	}
+	return bytes.Compare(ev.ID, other.ID) < 0
+}
+
+// replace finds the stored versions of a replaceable or parameterized
+// replaceable event in a transaction, and returns the keys of the event and
+// indexes of the versions it supersedes, if the ReplacePolicy does not retain
+// them, and the changes of the log that record their deletion.
+//
+// store.ErrDupEvent is returned if the event is already stored, and
+// store.ErrSuperseded if a stored version supersedes it.
+func (d *D) replace(txn *badger.Txn, ev *event.E) (
+	keys [][]byte, gone []*store.Change, err error,
+) {
+	var prf []byte
+	if prf, err = addressPrefix(ev); chk.E(err) {
+		return
+	}
+	var sers []*types.Uint40
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+	for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+		key := it.Item().Key()
+		ser := new(types.Uint40)
+		if err = ser.UnmarshalRead(
+			bytes.NewBuffer(key[len(key)-5:]),
+		); chk.E(err) {
+			it.Close()
+			return
+		}
+		sers = append(sers, ser)
+	}
+	it.Close()
+	_, dValue := replaceableKey(ev)
+	for _, ser := range sers {
+		k := new(bytes.Buffer)
+		if err = indexes.EventEnc(ser).MarshalWrite(k); chk.E(err) {
+			return
+		}
+		var item *badger.Item
+		if item, err = txn.Get(k.Bytes()); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				err = nil
+				continue
+			}
+			return
+		}
+		var v []byte
+		if v, err = item.ValueCopy(nil); chk.E(err) {
+			return
+		}
+		old := new(event.E)
+		if err = old.UnmarshalBinary(bytes.NewBuffer(v)); chk.E(err) {
+			return
+		}
+		// the d tag index is a hash, so the value is checked exactly.
+		if _, oldDValue := replaceableKey(old); oldDValue != dValue {
+			continue
+		}
+		if bytes.Equal(old.ID, ev.ID) {
+			err = store.ErrDupEvent
+			return
+		}
+		if !supersedes(ev, old) {
+			err = store.ErrSuperseded
+			return
+		}
+		if d.replacePolicy.Retains(old.Kind) {
+			continue
+		}
+		keys = append(keys, k.Bytes())
+		var idxs [][]byte
+		if idxs, err = d.indexesForEvent(old, ser.Get()); chk.E(err) {
+			return
+		}
+		keys = append(keys, idxs...)
+		gone = append(
+			gone, deletedChange(old, ser.Get(), store.ReasonReplaced, ev.ID),
+		)
+	}
+	return
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestReplace(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-db-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, tempDir, "info")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	sign := new(p256k.Signer)
+	if err = sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	now := timestamp.Now().V
+	newVersion := func(k uint16, createdAt int64, content string) (ev *event.E) {
+		ev = event.New()
+		ev.Kind = kind.New(k)
+		ev.Pubkey = sign.Pub()
+		ev.CreatedAt = new(timestamp.T)
+		ev.CreatedAt.V = createdAt
+		ev.Content = []byte(content)
+		ev.Tags = tags.New()
+		if ev.Kind.IsParameterizedReplaceable() {
+			ev.Tags = tags.New(tag.New([]byte{'d'}, []byte("article")))
+		}
+		ev.Sign(sign)
+		return
+	}
+	stored := func(ev *event.E) bool {
+		ser, _ := db.GetSerialById(ev.ID)
+		return ser != nil
+	}
+
+	// a newer version deletes the older one
+	v1 := newVersion(30023, now-100, "v1")
+	if _, _, err = db.SaveEvent(ctx, v1, false, nil); err != nil {
+		t.Fatalf("Failed to save event: %v", err)
+	}
+	v2 := newVersion(30023, now, "v2")
+	if _, _, err = db.SaveEvent(ctx, v2, false, nil); err != nil {
+		t.Fatalf("Failed to save event: %v", err)
+	}
+	if stored(v1) || !stored(v2) {
+		t.Fatal("expected the older version to be replaced by the newer")
+	}
+
+	// an older version is rejected
+	if _, _, err = db.SaveEvent(
+		ctx, newVersion(30023, now-200, "v0"), false, nil,
+	); !errors.Is(err, store.ErrSuperseded) {
+		t.Fatalf("expected ErrSuperseded for older version, got %v", err)
+	}
+
+	// the same version is a duplicate
+	if _, _, err = db.SaveEvent(
+		ctx, v2, true, nil,
+	); !errors.Is(err, store.ErrDupEvent) {
+		t.Fatalf("expected ErrDupEvent for the same version, got %v", err)
+	}
+
+	// of two versions with the same timestamp, the lowest id is retained
+	v3 := newVersion(30023, now, "v3")
+	_, _, err = db.SaveEvent(ctx, v3, false, nil)
+	if bytes.Compare(v3.ID, v2.ID) < 0 {
+		if err != nil || stored(v2) || !stored(v3) {
+			t.Fatalf("expected the version with the lower id to win: %v", err)
+		}
+	} else {
+		if !errors.Is(err, store.ErrSuperseded) || !stored(v2) {
+			t.Fatalf("expected the version with the lower id to win: %v", err)
+		}
+	}
+
+	// superseded directory events are retained by the default policy
+	p1 := newVersion(kind.ProfileMetadata.K, now-100, "p1")
+	p2 := newVersion(kind.ProfileMetadata.K, now, "p2")
+	for _, ev := range []*event.E{p1, p2} {
+		if _, _, err = db.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatalf("Failed to save event: %v", err)
+		}
+	}
+	if !stored(p1) || !stored(p2) {
+		t.Fatal("expected superseded directory event to be retained")
+	}
+
+	// concurrent saves of versions leave only the newest
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _ = db.SaveEvent(
+				ctx, newVersion(10001, now+int64(i), "list"), false, nil,
+			)
+		}()
+	}
+	wg.Wait()
+	var evs event.S
+	if evs, err = db.QueryEvents(
+		ctx, &filter.F{
+			Kinds: kinds.New(kind.New(10001)), Authors: tag.New(sign.Pub()),
+		},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	if len(evs) != 1 || evs[0].CreatedAt.V != now+19 {
+		t.Fatalf("expected only the newest version to be stored, got %d", len(evs))
+	}
+	var found map[uint64]struct{}
+	if found, err = db.GetSerialsByFilter(
+		&filter.F{
+			Kinds: kinds.New(kind.New(10001)), Authors: tag.New(sign.Pub()),
+		},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 stored version, got %d", len(found))
+	}
+}
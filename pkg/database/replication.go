@@ -0,0 +1,185 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// replicationPrefix is the prefix of the keys of the outbound queues of peer
+// relays. Like the configuration, it is not an index prefix, so the queues
+// survive a Wipe.
+//
+//	"REPL"|32 peer pubkey|8 sequence number
+var replicationPrefix = []byte("REPL")
+
+// replicationKey returns the key of an item in the queue of a peer, or the
+// prefix of the queue if seq is nil.
+func replicationKey(peer []byte, seq *uint64) (key []byte, err error) {
+	if len(peer) != 32 {
+		err = errorf.E("invalid peer pubkey length %d", len(peer))
+		return
+	}
+	key = append(bytes.Clone(replicationPrefix), peer...)
+	if seq != nil {
+		key = binary.BigEndian.AppendUint64(key, *seq)
+	}
+	return
+}
+
+// EnqueueReplication adds an item to the end of the outbound queues of the
+// peers with the given pubkeys. The queues of all the peers are written in one
+// transaction.
+func (d *D) EnqueueReplication(
+	peers [][]byte, item *store.ReplicationItem,
+) (err error) {
+	if len(peers) == 0 {
+		return
+	}
+	if item.Seq, err = d.replSeq.Next(); chk.E(err) {
+		return
+	}
+	// the sequence starts at zero, and the first Seq is 1, as in the other
+	// stores, so that zero is before every item.
+	item.Seq++
+	var v []byte
+	if v, err = json.Marshal(item); chk.E(err) {
+		return
+	}
+	if err = d.Update(
+		func(txn *badger.Txn) (err error) {
+			for _, peer := range peers {
+				var key []byte
+				if key, err = replicationKey(peer, &item.Seq); chk.E(err) {
+					return
+				}
+				if err = txn.Set(key, v); chk.E(err) {
+					return
+				}
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ReplicationBatch returns up to max items from the front of the queue of a
+// peer, in the order they were queued.
+func (d *D) ReplicationBatch(peer []byte, max int) (
+	items []*store.ReplicationItem, err error,
+) {
+	var prf []byte
+	if prf, err = replicationKey(peer, nil); chk.E(err) {
+		return
+	}
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+			defer it.Close()
+			for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+				if len(items) >= max {
+					return
+				}
+				item := it.Item()
+				ri := new(store.ReplicationItem)
+				if err = item.Value(
+					func(val []byte) error { return json.Unmarshal(val, ri) },
+				); chk.E(err) {
+					return
+				}
+				ri.Seq = binary.BigEndian.Uint64(item.Key()[len(prf):])
+				items = append(items, ri)
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	return
+}
+
+// AckReplication removes the items of the queue of a peer up to and including
+// the one with the given Seq.
+func (d *D) AckReplication(peer []byte, seq uint64) (err error) {
+	var prf, last []byte
+	if prf, err = replicationKey(peer, nil); chk.E(err) {
+		return
+	}
+	if last, err = replicationKey(peer, &seq); chk.E(err) {
+		return
+	}
+	if err = d.Update(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: prf, PrefetchValues: false},
+			)
+			defer it.Close()
+			for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				if bytes.Compare(key, last) > 0 {
+					return
+				}
+				if err = txn.Delete(key); chk.E(err) {
+					return
+				}
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ReplicationQueueLen returns the number of items in the queue of a peer, and
+// the unix time the oldest of them was queued, which is zero if it is empty.
+func (d *D) ReplicationQueueLen(peer []byte) (n int, oldest int64, err error) {
+	var prf []byte
+	if prf, err = replicationKey(peer, nil); chk.E(err) {
+		return
+	}
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: prf, PrefetchValues: false},
+			)
+			defer it.Close()
+			for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+				if n == 0 {
+					ri := new(store.ReplicationItem)
+					if err = it.Item().Value(
+						func(val []byte) error {
+							return json.Unmarshal(val, ri)
+						},
+					); chk.E(err) {
+						return
+					}
+					oldest = ri.Queued
+				}
+				n++
+			}
+			return
+		},
+	); chk.E(err) {
+		return
+	}
+	return
+}
+
+// DropReplication removes the queue of a peer.
+func (d *D) DropReplication(peer []byte) (err error) {
+	var prf []byte
+	if prf, err = replicationKey(peer, nil); chk.E(err) {
+		return
+	}
+	if err = d.DropPrefix(prf); chk.E(err) {
+		return
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"bytes"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"os"
+	"testing"
+)
+
+func TestReplicationQueue(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-db-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, tempDir, "info")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	peer1, peer2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	for i := range 10 {
+		peers := [][]byte{peer1}
+		// only every other item goes to the second peer
+		if i%2 == 0 {
+			peers = append(peers, peer2)
+		}
+		if err = db.EnqueueReplication(
+			peers, &store.ReplicationItem{
+				Queued: int64(1000 + i), Event: []byte{byte(i)},
+			},
+		); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+	var items []*store.ReplicationItem
+	if items, err = db.ReplicationBatch(peer1, 4); err != nil {
+		t.Fatalf("Failed to get batch: %v", err)
+	}
+	if len(items) != 4 {
+		t.Fatalf("Expected 4 items, got %d", len(items))
+	}
+	for i, item := range items {
+		if item.Event[0] != byte(i) {
+			t.Fatalf("Expected item %d in order, got %d", i, item.Event[0])
+		}
+	}
+	if err = db.AckReplication(peer1, items[2].Seq); err != nil {
+		t.Fatalf("Failed to ack: %v", err)
+	}
+	var n int
+	var oldest int64
+	if n, oldest, err = db.ReplicationQueueLen(peer1); err != nil {
+		t.Fatalf("Failed to get queue length: %v", err)
+	}
+	if n != 7 || oldest != 1003 {
+		t.Fatalf("Expected 7 items from 1003, got %d from %d", n, oldest)
+	}
+	// acking one peer's queue leaves the other's unchanged
+	if n, oldest, err = db.ReplicationQueueLen(peer2); err != nil {
+		t.Fatalf("Failed to get queue length: %v", err)
+	}
+	if n != 5 || oldest != 1000 {
+		t.Fatalf("Expected 5 items from 1000, got %d from %d", n, oldest)
+	}
+	if err = db.DropReplication(peer2); err != nil {
+		t.Fatalf("Failed to drop queue: %v", err)
+	}
+	if n, _, err = db.ReplicationQueueLen(peer2); err != nil || n != 0 {
+		t.Fatalf("Expected dropped queue to be empty, got %d %v", n, err)
+	}
+	if n, _, err = db.ReplicationQueueLen(peer1); err != nil || n != 7 {
+		t.Fatalf("Expected 7 items, got %d %v", n, err)
+	}
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/log"
+)
+
+// RescanBatchSize is the number of events that are read in one transaction
+// during a Rescan.
+var RescanBatchSize = 1000
+
+// Rescan regenerates the indexes of every stored event from the binary event
+// records, so that indexes added to the database after events were saved are
+// populated without a full export and import.
+//
+// All of the indexes that are made from the events are dropped first, in one
+// call to badger's DropPrefix, so that stale keys and the keys of indexes and
+// tag rules that have been removed are deleted, and then the indexes are
+// written again from the events, in batches of RescanBatchSize so no write
+// grows too large. The relay can keep serving queries, but they find only the
+// events the rescan has reached until it is done.
+//
+// No events are saved or deleted during the rescan, as their indexes would be
+// dropped or the indexes of the events they replace or delete not found, so
+// SaveEvent, DeleteEvent and the expiry of events wait for it to finish.
+//
+// The event records and the tombstones of deleted events are kept, as they
+// can't be made again from the events.
+func (d *D) Rescan() (err error) {
+	d.rescanMx.Lock()
+	defer d.rescanMx.Unlock()
+	d.replaceMx.Lock()
+	defer d.replaceMx.Unlock()
+	log.I.F("rescanning database %s", d.dataDir)
+	var prefixes [][]byte
+	for _, p := range indexes.Prefixes() {
+		switch p {
+		case indexes.EventPrefix, indexes.TombstoneIdPrefix,
+			indexes.TombstoneAddressPrefix:
+			continue
+		}
+		prefixes = append(prefixes, []byte(p))
+	}
+	if err = d.DropPrefix(prefixes...); chk.E(err) {
+		return
+	}
+	prf := []byte(indexes.EventPrefix)
+	from := prf
+	var total int
+	for {
+		type stored struct {
+			ser uint64
+			ev  *event.E
+		}
+		var batch []stored
+		var last []byte
+		if err = d.View(
+			func(txn *badger.Txn) (err error) {
+				it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+				defer it.Close()
+				for it.Seek(from); it.ValidForPrefix(prf); it.Next() {
+					item := it.Item()
+					key := item.KeyCopy(nil)
+					ser := new(types.Uint40)
+					if err = ser.UnmarshalRead(
+						bytes.NewBuffer(key[len(prf):]),
+					); chk.E(err) {
+						return
+					}
+					var v []byte
+					if v, err = item.ValueCopy(nil); chk.E(err) {
+						return
+					}
+					ev := new(event.E)
+					if err = ev.UnmarshalBinary(bytes.NewBuffer(v)); chk.E(err) {
+						// skip events that can't be decoded rather than fail
+						// the whole rescan.
+						err = nil
+						continue
+					}
+					batch = append(batch, stored{ser.Get(), ev})
+					last = key
+					if len(batch) >= RescanBatchSize {
+						return
+					}
+				}
+				return
+			},
+		); chk.E(err) {
+			return
+		}
+		if len(batch) == 0 {
+			break
+		}
+		wb := d.NewWriteBatch()
+		for _, s := range batch {
+			var idxs [][]byte
+			if idxs, err = d.indexesForEvent(s.ev, s.ser); chk.E(err) {
+				wb.Cancel()
+				return
+			}
+			for _, k := range idxs {
+				if err = wb.Set(k, nil); chk.E(err) {
+					wb.Cancel()
+					return
+				}
+			}
+		}
+		if err = wb.Flush(); chk.E(err) {
+			return
+		}
+		total += len(batch)
+		if len(batch) < RescanBatchSize {
+			break
+		}
+		// the next batch starts at the first key after the last one read
+		from = append(last, 0)
+	}
+	log.I.F("rescanned %d events", total)
+	err = d.loadCardinality()
+	return
+}
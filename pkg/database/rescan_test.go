@@ -0,0 +1,138 @@
+package database
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/utils/chk"
+	"os"
+	"testing"
+)
+
+func TestRescan(t *testing.T) {
+	db, events, ctx, cancel, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir)
+	defer cancel()
+	defer db.Close()
+	f := &filter.F{Kinds: kinds.New(events[0].Kind)}
+	expected, _, err := db.CountEvents(ctx, f)
+	if chk.E(err) {
+		t.Fatal(err)
+	}
+	// remove an index, as though it was added after the events were stored
+	if err = db.DropPrefix([]byte(indexes.KindPrefix)); chk.E(err) {
+		t.Fatal(err)
+	}
+	var count int
+	if count, _, err = db.CountEvents(ctx, f); chk.E(err) {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("found %d events after dropping the index", count)
+	}
+	// and leave a stale key of an event that is not stored
+	stale := append([]byte(indexes.PubkeyPrefix), make([]byte, 21)...)
+	if err = db.Update(
+		func(txn *badger.Txn) error { return txn.Set(stale, nil) },
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	RescanBatchSize = 100
+	if err = db.Rescan(); chk.E(err) {
+		t.Fatal(err)
+	}
+	if count, _, err = db.CountEvents(ctx, f); chk.E(err) {
+		t.Fatal(err)
+	}
+	if count != expected {
+		t.Fatalf("found %d events after rescan, expected %d", count, expected)
+	}
+	if err = db.View(
+		func(txn *badger.Txn) (err error) {
+			if _, err = txn.Get(stale); err == nil {
+				t.Fatal("the stale index key was not deleted by the rescan")
+			}
+			return nil
+		},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestRescanExcludesWrites(t *testing.T) {
+	db, events, ctx, cancel, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir)
+	defer cancel()
+	defer db.Close()
+	before, err := db.EventCount()
+	if chk.E(err) {
+		t.Fatal(err)
+	}
+	// the events are saved again while the rescan runs, which would be
+	// stored a second time if they were saved once their Id index is dropped.
+	RescanBatchSize = 100
+	rescanned := make(chan error)
+	go func() { rescanned <- db.Rescan() }()
+	for _, ev := range events[:100] {
+		if _, _, err = db.SaveEvent(ctx, ev, false, nil); err == nil {
+			t.Fatalf("event %0x was saved again during the rescan", ev.ID)
+		}
+	}
+	if err = <-rescanned; chk.E(err) {
+		t.Fatal(err)
+	}
+	var after uint64
+	if after, err = db.EventCount(); chk.E(err) {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Fatalf("%d events after the rescan, expected %d", after, before)
+	}
+}
+
+func TestWipe(t *testing.T) {
+	db, events, ctx, cancel, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir)
+	defer cancel()
+	defer db.Close()
+	err := db.Wipe()
+	if chk.E(err) {
+		t.Fatal(err)
+	}
+	var n int
+	if err = db.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				for _, p := range indexes.Prefixes() {
+					if string(it.Item().Key()[:3]) == string(p) {
+						n++
+					}
+				}
+			}
+			return
+		},
+	); chk.E(err) {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("%d keys remain after wipe", n)
+	}
+	// the database can be used again, and serials do not restart
+	if _, _, err = db.SaveEvent(ctx, events[0], false, nil); chk.E(err) {
+		t.Fatal(err)
+	}
+	var ids []uint64
+	page, err := db.EventIdsBySerial(0, 10)
+	if chk.E(err) {
+		t.Fatal(err)
+	}
+	for _, p := range page {
+		ids = append(ids, p.Serial)
+	}
+	if len(ids) != 1 || ids[0] < uint64(len(events)) {
+		t.Fatalf("unexpected serials after wipe %v", ids)
+	}
+}
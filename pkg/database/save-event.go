@@ -6,23 +6,39 @@ import (
 	"orly.dev/pkg/database/indexes"
 	"orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/event"
-	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/kind"
-	"orly.dev/pkg/encoders/kinds"
-	"orly.dev/pkg/encoders/tag"
-	"orly.dev/pkg/encoders/tag/atag"
-	"orly.dev/pkg/encoders/tags"
 	"orly.dev/pkg/interfaces/store"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/errorf"
-	"sort"
+	"time"
 )
 
 // SaveEvent saves an event to the database, generating all the necessary indexes.
+//
+// Events that have been deleted by a NIP-09 deletion event are rejected. When
+// the event is a deletion event, the events it deletes are found and removed in
+// the same transaction, and its tombstone indexes prevent them being saved
+// again.
+//
+// Replaceable and parameterized replaceable events are rejected with
+// store.ErrSuperseded if a newer version is stored, which callers that only
+// need the newest version stored can check for with errors.Is and ignore.
+// Otherwise the versions they supersede are deleted in the same transaction,
+// unless the ReplacePolicy retains them.
+//
+// The event and the events it deletes are recorded in the change log in the
+// same transaction, and counted in the usage of their authors.
 func (d *D) SaveEvent(
 	c context.T, ev *event.E, noVerify bool, owners [][]byte,
 ) (kc, vc int, err error) {
+	d.rescanMx.RLock()
+	defer d.rescanMx.RUnlock()
+	// NIP-40: events that have already expired are not stored
+	if exp, ok := ev.Expiration(); ok && exp < time.Now().Unix() {
+		err = errorf.E("invalid: event %0x expired at %d", ev.ID, exp)
+		return
+	}
 	if !noVerify {
 		// check if the event already exists
 		var ser *types.Uint40
@@ -31,106 +47,23 @@ func (d *D) SaveEvent(
 			return
 		}
 	}
-
-	// check if an existing delete event references this event submission
-	if ev.Kind.IsParameterizedReplaceable() {
-		var idxs []Range
-		// construct a tag
-		t := ev.Tags.GetFirst(tag.New("d"))
-		a := atag.T{
-			Kind:   ev.Kind,
-			PubKey: ev.Pubkey,
-			DTag:   t.Value(),
-		}
-		at := a.Marshal(nil)
-		if idxs, err = GetIndexesFromFilter(
-			&filter.F{
-				Authors: tag.New(ev.Pubkey),
-				Kinds:   kinds.New(kind.Deletion),
-				Tags:    tags.New(tag.New([]byte("#a"), at)),
-			},
-		); chk.E(err) {
-			return
-		}
-		var sers types.Uint40s
-		for _, idx := range idxs {
-			var s types.Uint40s
-			if s, err = d.GetSerialsByRange(idx); chk.E(err) {
-				return
-			}
-			sers = append(sers, s...)
-		}
-		if len(sers) > 0 {
-			// there can be multiple of these because the author/kind/tag is a
-			// stable value but refers to any event from the author, of the
-			// kind, with the identifier. so we need to fetch the full ID index
-			// to get the timestamp and ensure that the event post-dates it.
-			// otherwise, it should be rejected.
-			var idPkTss []*store.IdPkTs
-			for _, ser := range sers {
-				var fidpk *store.IdPkTs
-				if fidpk, err = d.GetFullIdPubkeyBySerial(ser); chk.E(err) {
-					return
-				}
-				if fidpk == nil {
-					continue
-				}
-				idPkTss = append(idPkTss, fidpk)
-			}
-			// sort by timestamp, so the first is the newest
-			sort.Slice(
-				idPkTss, func(i, j int) bool {
-					return idPkTss[i].Ts > idPkTss[j].Ts
-				},
-			)
-			if ev.CreatedAt.I64() < idPkTss[0].Ts {
-				err = errorf.E(
-					"blocked: %0x was deleted by address %s because it is older than the delete: event: %d delete: %d",
-					ev.ID, at, ev.CreatedAt.I64(), idPkTss[0].Ts,
-				)
-				return
-			}
-			return
-		}
-	} else {
-		var idxs []Range
-		keys := [][]byte{ev.Pubkey}
-		for _, owner := range owners {
-			keys = append(keys, owner)
-		}
-		if idxs, err = GetIndexesFromFilter(
-			&filter.F{
-				Authors: tag.New(keys...),
-				Kinds:   kinds.New(kind.Deletion),
-				Tags:    tags.New(tag.New([]byte("#e"), ev.ID)),
-			},
-		); chk.E(err) {
-			return
-		}
-		var sers types.Uint40s
-		for _, idx := range idxs {
-			var s types.Uint40s
-			if s, err = d.GetSerialsByRange(idx); chk.E(err) {
-				return
-			}
-			sers = append(sers, s...)
-		}
-		if len(sers) > 0 {
-			// really there can only be one of these; the chances of an idhash
-			// collision are basically zero in practice, at least, one in a
-			// billion or more anyway, more than a human is going to create.
-			err = errorf.E("blocked: event %0x deleted by event ID", ev.ID)
-			return
-		}
+	// the versions of a replaceable event are read and replaced in the same
+	// transaction that it is saved in.
+	if isAddressable(ev.Kind) {
+		d.replaceMx.Lock()
+		defer d.replaceMx.Unlock()
 	}
-	// Get the next sequence number for the event
-	var serial uint64
-	if serial, err = d.seq.Next(); chk.E(err) {
+	// Get the next sequence number for the event, which is settled in the
+	// change log when it is saved or fails to be.
+	var sers []uint64
+	defer func() { d.settle(sers) }()
+	if sers, err = d.nextSerials(1); chk.E(err) {
 		return
 	}
+	serial := sers[0]
 	// Generate all indexes for the event
 	var idxs [][]byte
-	if idxs, err = GetIndexesForEvent(ev, serial); chk.E(err) {
+	if idxs, err = d.indexesForEvent(ev, serial); chk.E(err) {
 		return
 	}
 	// log.I.S(idxs)
@@ -138,8 +71,45 @@ func (d *D) SaveEvent(
 		kc += len(k)
 	}
 	// Start a transaction to save the event and all its indexes
+	var deleted, replaced [][]byte
+	m := make(metered)
+	done := d.startWrite()
 	err = d.Update(
 		func(txn *badger.Txn) (err error) {
+			// check if a deletion event has deleted this event
+			if err = d.checkTombstones(txn, ev, owners); err != nil {
+				return
+			}
+			// a deletion event deletes the events it references in the same
+			// transaction that it is saved in.
+			var deletions []*store.Change
+			if ev.Kind.Equal(kind.Deletion) {
+				if deleted, deletions, err = d.deletionTargets(
+					txn, ev, owners, m,
+				); chk.E(err) {
+					return
+				}
+			}
+			// Delete the events deleted by a deletion event
+			for _, key := range deleted {
+				if err = txn.Delete(key); chk.E(err) {
+					return
+				}
+			}
+			// Delete the versions superseded by a replaceable event
+			var replacements []*store.Change
+			if isAddressable(ev.Kind) {
+				if replaced, replacements, err = d.replace(
+					txn, ev,
+				); err != nil {
+					return
+				}
+				for _, key := range replaced {
+					if err = txn.Delete(key); chk.E(err) {
+						return
+					}
+				}
+			}
 			// Save each index
 			for _, key := range idxs {
 				if err = func() (err error) {
@@ -170,9 +140,29 @@ func (d *D) SaveEvent(
 			if err = txn.Set(kb, vb); chk.E(err) {
 				return
 			}
+			// record the event, and the events it deleted after it
+			typ := store.ChangeInsert
+			if len(replacements) > 0 {
+				typ = store.ChangeReplace
+			}
+			if err = logChange(txn, savedChange(typ, ev, serial)); chk.E(err) {
+				return
+			}
+			if err = d.logDeletions(
+				txn, append(deletions, replacements...), &sers,
+			); chk.E(err) {
+				return
+			}
 			return
 		},
 	)
 	// log.T.F("total data written: %d bytes keys %d bytes values", kc, vc)
+	m.add(ev, false)
+	done(m, err == nil)
+	if err == nil {
+		d.count(deleted, true)
+		d.count(replaced, true)
+		d.count(idxs, false)
+	}
 	return
 }
@@ -88,8 +88,9 @@ func TestSaveEvents(t *testing.T) {
 	)
 }
 
-// TestDeletionEventWithETagRejection tests that a deletion event with an "e" tag is rejected.
-func TestDeletionEventWithETagRejection(t *testing.T) {
+// TestDeletionEventWithETag tests that a deletion event with an "e" tag deletes
+// the event it references, and that the event can't be saved again.
+func TestDeletionEventWithETag(t *testing.T) {
 	// Create a temporary directory for the database
 	tempDir, err := os.MkdirTemp("", "test-db-*")
 	if err != nil {
@@ -145,18 +146,146 @@ func TestDeletionEventWithETagRejection(t *testing.T) {
 
 	deletionEvent.Sign(sign)
 
-	// Try to save the deletion event, it should be rejected
-	_, _, err = db.SaveEvent(ctx, deletionEvent, false, nil)
+	// Save the deletion event, which deletes the regular event
+	if _, _, err = db.SaveEvent(ctx, deletionEvent, false, nil); err != nil {
+		t.Fatalf("Failed to save deletion event: %v", err)
+	}
+
+	// Verify the regular event was removed
+	if ser, _ := db.GetSerialById(regularEvent.ID); ser != nil {
+		t.Fatal("Expected the deleted event to be removed from the database")
+	}
+
+	// Try to save the regular event again, it should be rejected
+	_, _, err = db.SaveEvent(ctx, regularEvent, false, nil)
 	if err == nil {
-		t.Fatal("Expected deletion event with e-tag to be rejected, but it was accepted")
+		t.Fatal("Expected deleted event to be rejected, but it was accepted")
 	}
 
 	// Verify the error message
-	expectedError := "deletion events referencing other events with 'e' tag are not allowed"
-	if err.Error() != expectedError {
+	expectedErrorPrefix := "blocked: "
+	if !bytes.HasPrefix([]byte(err.Error()), []byte(expectedErrorPrefix)) {
 		t.Fatalf(
-			"Expected error message '%s', got '%s'", expectedError, err.Error(),
+			"Expected error message to start with '%s', got '%s'",
+			expectedErrorPrefix, err.Error(),
+		)
+	}
+}
+
+// TestDeletionEventWithATag tests that a deletion event with an "a" tag
+// deletes the versions of a parameterized replaceable event that are not newer
+// than it, and that only newer versions can be saved afterwards.
+func TestDeletionEventWithATag(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-db-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+
+	db, err := New(ctx, cancel, tempDir, "info")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	sign := new(p256k.Signer)
+	if err := sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+
+	now := timestamp.Now().V
+	newVersion := func(createdAt int64) (ev *event.E) {
+		ev = event.New()
+		ev.Kind = kind.New(30023)
+		ev.Pubkey = sign.Pub()
+		ev.CreatedAt = new(timestamp.T)
+		ev.CreatedAt.V = createdAt
+		ev.Content = []byte("Article")
+		ev.Tags = tags.New(tag.New([]byte{'d'}, []byte("article")))
+		ev.Sign(sign)
+		return
+	}
+
+	// Save a version of the event from before the deletion
+	older := newVersion(now - 3600)
+	if _, _, err = db.SaveEvent(ctx, older, false, nil); err != nil {
+		t.Fatalf("Failed to save event: %v", err)
+	}
+
+	// Delete the address
+	deletionEvent := event.New()
+	deletionEvent.Kind = kind.Deletion
+	deletionEvent.Pubkey = sign.Pub()
+	deletionEvent.CreatedAt = new(timestamp.T)
+	deletionEvent.CreatedAt.V = now - 1800
+	deletionEvent.Tags = tags.New(
+		tag.New(
+			[]byte{'a'},
+			[]byte("30023:"+hex.Enc(sign.Pub())+":article"),
+		),
+	)
+	deletionEvent.Sign(sign)
+	if _, _, err = db.SaveEvent(ctx, deletionEvent, false, nil); err != nil {
+		t.Fatalf("Failed to save deletion event: %v", err)
+	}
+
+	// Verify the older version was removed
+	if ser, _ := db.GetSerialById(older.ID); ser != nil {
+		t.Fatal("Expected the deleted event to be removed from the database")
+	}
+
+	// A version that is older than the deletion is rejected
+	if _, _, err = db.SaveEvent(
+		ctx, newVersion(now-2400), false, nil,
+	); err == nil {
+		t.Fatal("Expected version older than the deletion to be rejected")
+	}
+
+	// A version that is newer than the deletion is accepted
+	if _, _, err = db.SaveEvent(ctx, newVersion(now), false, nil); err != nil {
+		t.Fatalf("Failed to save version newer than the deletion: %v", err)
+	}
+
+	// Only the author or an owner can delete the address, and the tombstone
+	// of a deletion by anyone else doesn't block the versions before it
+	deleteBy := func(signer *p256k.Signer, createdAt int64, owners [][]byte) {
+		ev := event.New()
+		ev.Kind = kind.Deletion
+		ev.Pubkey = signer.Pub()
+		ev.CreatedAt = timestamp.FromUnix(createdAt)
+		ev.Tags = tags.New(
+			tag.New(
+				[]byte{'a'},
+				[]byte("30023:"+hex.Enc(sign.Pub())+":article"),
+			),
 		)
+		ev.Sign(signer)
+		if _, _, err = db.SaveEvent(ctx, ev, false, owners); err != nil {
+			t.Fatalf("Failed to save deletion event: %v", err)
+		}
+	}
+	other, owner := new(p256k.Signer), new(p256k.Signer)
+	if err = other.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	if err = owner.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	owners := [][]byte{owner.Pub()}
+	deleteBy(other, now+20, owners)
+	if _, _, err = db.SaveEvent(
+		ctx, newVersion(now+10), false, owners,
+	); err != nil {
+		t.Fatalf("Version blocked by the deletion of another: %v", err)
+	}
+	deleteBy(owner, now+40, owners)
+	if _, _, err = db.SaveEvent(
+		ctx, newVersion(now+30), false, owners,
+	); err == nil {
+		t.Fatal("Expected version older than an owner deletion to be rejected")
 	}
 }
 
@@ -0,0 +1,6 @@
+package sqlite
+
+import (
+	// the pure Go driver, so the relay builds without cgo.
+	_ "modernc.org/sqlite"
+)
@@ -0,0 +1,181 @@
+package sqlite
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/eventidserial"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+)
+
+// EventCount returns the number of events in the store.
+func (s *S) EventCount() (count uint64, err error) {
+	err = s.QueryRowContext(
+		s.ctx, `SELECT COUNT(*) FROM events`,
+	).Scan(&count)
+	chk.E(err)
+	return
+}
+
+// GetSerialById returns the serial of the event with an id, or nil if it is
+// not in the store.
+func (s *S) GetSerialById(id []byte) (ser *types.Uint40, err error) {
+	var serial uint64
+	if err = s.QueryRowContext(
+		s.ctx, `SELECT serial FROM events WHERE id = ?`, id,
+	).Scan(&serial); err != nil {
+		if errNotFound(err) {
+			err = nil
+		}
+		return
+	}
+	ser = new(types.Uint40)
+	if err = ser.Set(serial); chk.E(err) {
+		return
+	}
+	return
+}
+
+// EventIdsBySerial returns the event Ids and serials of up to count events,
+// starting from the given serial, in the order they were stored.
+func (s *S) EventIdsBySerial(start uint64, count int) (
+	evs []eventidserial.E, err error,
+) {
+	var rows *sql.Rows
+	if rows, err = s.QueryContext(
+		s.ctx, `SELECT serial, id FROM events WHERE serial >= ?
+			ORDER BY serial LIMIT ?`, start, count,
+	); chk.E(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e eventidserial.E
+		var id []byte
+		if err = rows.Scan(&e.Serial, &id); chk.E(err) {
+			return
+		}
+		e.EventId = hex.Enc(id)
+		evs = append(evs, e)
+	}
+	err = rows.Err()
+	return
+}
+
+// DeleteEvent removes an event from the store. No tombstone is recorded for
+// the event, so it can be saved again.
+func (s *S) DeleteEvent(c context.T, eid *eventid.T) (err error) {
+	s.saveMx.Lock()
+	defer s.saveMx.Unlock()
+	var serial int64
+	if err = s.QueryRowContext(
+		c, `SELECT serial FROM events WHERE id = ?`, eid.Bytes(),
+	).Scan(&serial); err != nil {
+		if errNotFound(err) {
+			err = nil
+		}
+		return
+	}
+	err = s.transaction(
+		c, func(tx *sql.Tx) error { return deleteSerial(c, tx, serial) },
+	)
+	return
+}
+
+// Import saves the events of a stream of line structured JSON, and returns
+// when they are all saved.
+func (s *S) Import(r io.Reader) {
+	scan := bufio.NewScanner(r)
+	scan.Buffer(make([]byte, 0, 1<<20), 1<<26)
+	var count int
+	for scan.Scan() {
+		ev := event.New()
+		if _, err := ev.Unmarshal(scan.Bytes()); err != nil {
+			continue
+		}
+		if _, _, err := s.SaveEvent(s.ctx, ev, false, nil); err != nil {
+			continue
+		}
+		count++
+	}
+	chk.E(scan.Err())
+	log.I.F("saved %d events", count)
+}
+
+// Export writes all the events in the store, in the order they were stored,
+// in line structured JSON. If pubkeys are given, only the events with one of
+// them as the author or in a p tag are written.
+func (s *S) Export(c context.T, w io.Writer, pubkeys ...[]byte) {
+	query := `SELECT json FROM events ORDER BY serial`
+	var args []any
+	if len(pubkeys) > 0 {
+		var hexes []any
+		for _, pk := range pubkeys {
+			args = append(args, pk)
+			hexes = append(hexes, hex.Enc(pk))
+		}
+		query = `SELECT json FROM events
+			WHERE pubkey IN (` + placeholders(len(pubkeys)) + `)
+			OR serial IN (SELECT serial FROM tags
+				WHERE key = 'p' AND value IN (` +
+			placeholders(len(pubkeys)) + `))
+			ORDER BY serial`
+		args = append(args, hexes...)
+	}
+	rows, err := s.QueryContext(c, query, args...)
+	if chk.E(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw []byte
+		if err = rows.Scan(&raw); chk.E(err) {
+			return
+		}
+		if _, err = w.Write(append(raw, '\n')); chk.E(err) {
+			return
+		}
+	}
+	chk.E(rows.Err())
+}
+
+// GetConfiguration returns the stored configuration, or nil if none has been
+// stored.
+func (s *S) GetConfiguration() (c *store.Configuration, err error) {
+	var b []byte
+	if err = s.QueryRowContext(
+		s.ctx, `SELECT json FROM configuration WHERE id = 1`,
+	).Scan(&b); err != nil {
+		if errNotFound(err) {
+			err = nil
+		}
+		return
+	}
+	c = new(store.Configuration)
+	if err = json.Unmarshal(b, c); chk.E(err) {
+		return
+	}
+	return
+}
+
+// SetConfiguration stores the configuration.
+func (s *S) SetConfiguration(c *store.Configuration) (err error) {
+	var b []byte
+	if b, err = json.Marshal(c); chk.E(err) {
+		return
+	}
+	_, err = s.ExecContext(
+		s.ctx, `INSERT OR REPLACE INTO configuration (id, json) VALUES (1, ?)`,
+		string(b),
+	)
+	chk.E(err)
+	return
+}
@@ -0,0 +1,336 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/words"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// where returns the conditions and parameters of a query for the events that
+// match a filter, except for its search, which is matched on the events. The
+// cursor of a filter without a search selects the events after it.
+//
+// The tags table only has the first value of each tag as text, so a tag query
+// of a filter, for a value at another position or a range of values, only
+// selects the events with a tag of its name, and post is true if the events
+// must then be matched to the filter with store.Matches. The geohashes of the
+// area of a GeoQuery are selected by the prefixes of the cells that cover it.
+func where(f *filter.F) (cond string, args []any, post bool) {
+	conds := []string{"1 = 1"}
+	if f.Ids.Len() > 0 {
+		conds = append(
+			conds, "id IN ("+placeholders(f.Ids.Len())+")",
+		)
+		for _, id := range f.Ids.ToSliceOfBytes() {
+			args = append(args, id)
+		}
+	}
+	if f.Kinds.Len() > 0 {
+		conds = append(conds, "kind IN ("+placeholders(f.Kinds.Len())+")")
+		for _, k := range f.Kinds.K {
+			args = append(args, k.K)
+		}
+	}
+	if f.Authors.Len() > 0 {
+		conds = append(
+			conds, "pubkey IN ("+placeholders(f.Authors.Len())+")",
+		)
+		for _, pk := range f.Authors.ToSliceOfBytes() {
+			args = append(args, pk)
+		}
+	}
+	if f.Since != nil && f.Since.I64() != 0 {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.Since.I64())
+	}
+	if f.Until != nil && f.Until.I64() != 0 {
+		conds = append(conds, "created_at <= ?")
+		args = append(args, f.Until.I64())
+	}
+	if f.Cursor != nil && len(f.Search) == 0 {
+		conds = append(
+			conds, "(created_at < ? OR (created_at = ? AND serial < ?))",
+		)
+		args = append(args, f.Cursor.Ts, f.Cursor.Ts, int64(f.Cursor.Ser))
+	}
+	// each tag of the filter must match one of its values.
+	for _, t := range f.Tags.ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		if filter.IsTagQuery(t) {
+			name, position := filter.TagKey(t.Key())
+			c, a := tagQuery(name, position, t.ToSliceOfBytes()[1:])
+			conds = append(
+				conds, `serial IN (SELECT serial FROM tags WHERE key = ?`+c+`)`,
+			)
+			args = append(append(args, string(name)), a...)
+			post = true
+			continue
+		}
+		conds = append(
+			conds, `serial IN (SELECT serial FROM tags
+				WHERE key = ? AND value IN (`+placeholders(t.Len()-1)+`))`,
+		)
+		args = append(args, string(t.FilterKey()))
+		for _, v := range t.ToSliceOfBytes()[1:] {
+			args = append(args, string(v))
+		}
+	}
+	cond = strings.Join(conds, " AND ")
+	return
+}
+
+// tagQuery returns the conditions and parameters that select the first values
+// of tags in the tags table that may match the values of a tag query, or
+// nothing if they can't be selected by their text, as a value at another
+// position or a range of values can't.
+func tagQuery(name []byte, position int, values [][]byte) (
+	cond string, args []any,
+) {
+	if position != 1 {
+		return
+	}
+	var conds []string
+	for _, v := range values {
+		if bytes.Equal(name, filter.GeoTag) {
+			if q, ok := filter.ParseGeoQuery(v); ok {
+				for _, cell := range q.Cells() {
+					conds = append(conds, "value LIKE ?")
+					args = append(args, string(cell)+"%")
+				}
+				continue
+			}
+		}
+		if _, _, ok := filter.TagRange(v); ok {
+			return "", nil
+		}
+		conds = append(conds, "value = ?")
+		args = append(args, string(v))
+	}
+	cond = " AND (" + strings.Join(conds, " OR ") + ")"
+	return
+}
+
+// match is an event that matches a filter.
+type match struct {
+	serial int64
+	id     []byte
+	pubkey []byte
+	ts     int64
+	ev     *event.E
+	score  int
+}
+
+// matches returns the events that match a filter and the extra conditions,
+// newest first, and the limit of the filter if limit is true. The events are
+// only fetched for a search, which orders them by relevance instead, and for
+// the tag queries that are matched on the events.
+func (s *S) matches(c context.T, f *filter.F, extra string, limit bool) (
+	found []match, err error,
+) {
+	cond, args, post := where(f)
+	query := `SELECT serial, id, pubkey, created_at FROM events WHERE ` +
+		cond + extra + ` ORDER BY created_at DESC, serial DESC`
+	search := len(f.Search) > 0
+	fetch := search || post
+	if search {
+		query = `SELECT serial, id, pubkey, created_at, json FROM events
+			WHERE ` + cond + extra
+	} else if post {
+		query = `SELECT serial, id, pubkey, created_at, json FROM events
+			WHERE ` + cond + extra + ` ORDER BY created_at DESC, serial DESC`
+	} else if limit && f.Limit != nil {
+		query += ` LIMIT ?`
+		args = append(args, *f.Limit)
+	}
+	var rows *sql.Rows
+	if rows, err = s.QueryContext(c, query, args...); chk.E(err) {
+		return
+	}
+	defer rows.Close()
+	var q *words.Query
+	if search {
+		q = words.Parse(f.Search)
+	}
+	for rows.Next() {
+		var m match
+		dest := []any{&m.serial, &m.id, &m.pubkey, &m.ts}
+		var raw []byte
+		if fetch {
+			dest = append(dest, &raw)
+		}
+		if err = rows.Scan(dest...); chk.E(err) {
+			return
+		}
+		if fetch {
+			m.ev = event.New()
+			if _, err = m.ev.Unmarshal(raw); chk.E(err) {
+				err = nil
+				continue
+			}
+			if post && !store.Matches(f, m.ev) {
+				continue
+			}
+		}
+		if search {
+			if m.score = q.Score(m.ev); m.score == 0 {
+				continue
+			}
+		}
+		found = append(found, m)
+	}
+	if err = rows.Err(); chk.E(err) {
+		return
+	}
+	if search {
+		sort.Slice(
+			found, func(i, j int) bool {
+				if found[i].score != found[j].score {
+					return found[i].score > found[j].score
+				}
+				return found[i].ts > found[j].ts
+			},
+		)
+	}
+	if fetch && limit && f.Limit != nil && len(found) > int(*f.Limit) {
+		found = found[:*f.Limit]
+	}
+	return
+}
+
+// QueryForIds returns the ids, pubkeys, timestamps and serials of the events
+// that match a filter, newest first, or in order of relevance for a search,
+// including the retained superseded versions of replaceable events. A filter
+// with Ids is an error.
+func (s *S) QueryForIds(c context.T, f *filter.F) (
+	idPkTs []store.IdPkTs, err error,
+) {
+	if f.Ids != nil && f.Ids.Len() > 0 {
+		err = errorf.E("query for Ids is invalid for a filter with Ids")
+		return
+	}
+	var found []match
+	if found, err = s.matches(c, f, "", true); err != nil {
+		return
+	}
+	for _, m := range found {
+		idPkTs = append(
+			idPkTs, store.IdPkTs{
+				Id: m.id, Pub: m.pubkey, Ts: m.ts, Ser: uint64(m.serial),
+			},
+		)
+	}
+	return
+}
+
+// current is the condition that leaves out the superseded versions of
+// replaceable events, which the store.ReplacePolicy may retain.
+const current = ` AND (address IS NULL OR NOT EXISTS (
+	SELECT 1 FROM events AS newer WHERE newer.address = events.address AND (
+		newer.created_at > events.created_at OR (
+			newer.created_at = events.created_at AND newer.id < events.id
+		)
+	)
+))`
+
+// QueryEvents returns the events that match a filter, as StreamEvents yields
+// them.
+func (s *S) QueryEvents(c context.T, f *filter.F) (evs event.S, err error) {
+	err = s.StreamEvents(
+		c, f, func(ev *event.E) (more bool) {
+			evs = append(evs, ev)
+			return true
+		},
+	)
+	return
+}
+
+// StreamEvents calls fn with each event that matches a filter, newest first,
+// or in order of relevance for a search, until fn returns false or the
+// context is cancelled, in which case the error of the context is returned.
+//
+// Deletion events and the retained superseded versions of replaceable events
+// are only yielded to a query by id, and expired events are not yielded. The
+// events are fetched one at a time as they are yielded.
+func (s *S) StreamEvents(
+	c context.T, f *filter.F, fn func(ev *event.E) (more bool),
+) (err error) {
+	now := time.Now().Unix()
+	var extra string
+	if f.Ids.Len() == 0 {
+		extra = " AND kind != " + strconv.Itoa(int(kind.Deletion.K)) + current
+	}
+	var found []match
+	if found, err = s.matches(c, f, extra, false); err != nil {
+		return
+	}
+	var n uint
+	for _, m := range found {
+		if err = c.Err(); err != nil {
+			return
+		}
+		if f.Limit != nil && n >= *f.Limit {
+			return
+		}
+		ev := m.ev
+		if ev == nil {
+			var evs event.S
+			if evs, err = queryEvents(
+				c, s, `SELECT json FROM events WHERE serial = ?`, m.serial,
+			); err != nil {
+				return
+			}
+			if len(evs) == 0 {
+				// deleted since the query.
+				continue
+			}
+			ev = evs[0]
+		}
+		if ev.IsExpired(now) {
+			continue
+		}
+		n++
+		if !fn(ev) {
+			return
+		}
+	}
+	return
+}
+
+// CountEvents returns the number of events that match a filter, ignoring its
+// limit, which leaves out the retained superseded versions of replaceable
+// events unless the filter has ids. The count is never approximate.
+func (s *S) CountEvents(c context.T, f *filter.F) (
+	count int, approximate bool, err error,
+) {
+	var extra string
+	if f.Ids.Len() == 0 {
+		extra = current
+	}
+	cond, args, post := where(f)
+	if len(f.Search) > 0 || post {
+		var found []match
+		if found, err = s.matches(c, f, extra, false); err != nil {
+			return
+		}
+		count = len(found)
+		return
+	}
+	err = s.QueryRowContext(
+		c, `SELECT COUNT(*) FROM events WHERE `+cond+extra, args...,
+	).Scan(&count)
+	chk.E(err)
+	return
+}
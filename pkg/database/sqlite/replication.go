@@ -0,0 +1,112 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+)
+
+// EnqueueReplication adds an item to the end of the outbound queues of the
+// peers with the given pubkeys. The queues of all the peers are written in one
+// transaction.
+func (s *S) EnqueueReplication(
+	peers [][]byte, item *store.ReplicationItem,
+) (err error) {
+	if len(peers) == 0 {
+		return
+	}
+	err = s.transaction(
+		s.ctx, func(tx *sql.Tx) (err error) {
+			if err = tx.QueryRowContext(
+				s.ctx, `INSERT INTO sequences (name, value)
+					VALUES ('replication', 1)
+					ON CONFLICT (name) DO UPDATE SET value = value + 1
+					RETURNING value`,
+			).Scan(&item.Seq); chk.E(err) {
+				return
+			}
+			var b []byte
+			if b, err = json.Marshal(item); chk.E(err) {
+				return
+			}
+			for _, peer := range peers {
+				if _, err = tx.ExecContext(
+					s.ctx, `INSERT INTO replication (peer, seq, queued, json)
+						VALUES (?, ?, ?, ?)`,
+					peer, item.Seq, item.Queued, string(b),
+				); chk.E(err) {
+					return
+				}
+			}
+			return
+		},
+	)
+	return
+}
+
+// ReplicationBatch returns up to max items from the front of the queue of a
+// peer, in the order they were queued.
+func (s *S) ReplicationBatch(peer []byte, max int) (
+	items []*store.ReplicationItem, err error,
+) {
+	var rows *sql.Rows
+	if rows, err = s.QueryContext(
+		s.ctx, `SELECT seq, json FROM replication WHERE peer = ?
+			ORDER BY seq LIMIT ?`, peer, max,
+	); chk.E(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seq uint64
+		var b []byte
+		if err = rows.Scan(&seq, &b); chk.E(err) {
+			return
+		}
+		item := new(store.ReplicationItem)
+		if err = json.Unmarshal(b, item); chk.E(err) {
+			return
+		}
+		item.Seq = seq
+		items = append(items, item)
+	}
+	err = rows.Err()
+	return
+}
+
+// AckReplication removes the items of the queue of a peer up to and including
+// the one with the given Seq.
+func (s *S) AckReplication(peer []byte, seq uint64) (err error) {
+	_, err = s.ExecContext(
+		s.ctx, `DELETE FROM replication WHERE peer = ? AND seq <= ?`, peer,
+		seq,
+	)
+	chk.E(err)
+	return
+}
+
+// ReplicationQueueLen returns the number of items in the queue of a peer, and
+// the unix time the oldest of them was queued.
+func (s *S) ReplicationQueueLen(peer []byte) (
+	n int, oldest int64, err error,
+) {
+	var o sql.NullInt64
+	if err = s.QueryRowContext(
+		s.ctx, `SELECT COUNT(*), MIN(queued) FROM replication WHERE peer = ?`,
+		peer,
+	).Scan(&n, &o); chk.E(err) {
+		return
+	}
+	oldest = o.Int64
+	return
+}
+
+// DropReplication removes the queue of a peer.
+func (s *S) DropReplication(peer []byte) (err error) {
+	_, err = s.ExecContext(
+		s.ctx, `DELETE FROM replication WHERE peer = ?`, peer,
+	)
+	chk.E(err)
+	return
+}
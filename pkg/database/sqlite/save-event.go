@@ -0,0 +1,275 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"strings"
+	"time"
+)
+
+// SaveEvent saves an event in the store.
+//
+// Expired events, and events that a stored NIP-09 deletion event deletes, are
+// rejected. A deletion event removes the events it deletes in the transaction
+// that saves it. Replaceable and parameterized replaceable events are rejected
+// with store.ErrSuperseded if a newer version is stored, otherwise the versions
+// they supersede are removed in the same transaction, unless the
+// store.ReplacePolicy retains them.
+//
+// The byte count of the value is the length of the JSON of the event.
+func (s *S) SaveEvent(
+	c context.T, ev *event.E, noVerify bool, owners [][]byte,
+) (kc, vc int, err error) {
+	if exp, ok := ev.Expiration(); ok && exp < time.Now().Unix() {
+		err = errorf.E("invalid: event %0x expired at %d", ev.ID, exp)
+		return
+	}
+	s.saveMx.Lock()
+	defer s.saveMx.Unlock()
+	var address sql.NullString
+	if store.IsAddressable(ev.Kind) {
+		address = sql.NullString{String: store.Address(ev), Valid: true}
+	}
+	raw := ev.Serialize()
+	vc = len(raw)
+	err = s.transaction(
+		c, func(tx *sql.Tx) (err error) {
+			var n int
+			if err = tx.QueryRowContext(
+				c, `SELECT COUNT(*) FROM events WHERE id = ?`, ev.ID,
+			).Scan(&n); chk.E(err) {
+				return
+			}
+			if n > 0 {
+				return store.ErrDupEvent
+			}
+			if err = s.checkDeletions(c, tx, ev, owners); err != nil {
+				return
+			}
+			var remove []int64
+			if ev.Kind.Equal(kind.Deletion) {
+				if remove, err = s.deletionTargets(
+					c, tx, ev, owners,
+				); chk.E(err) {
+					return
+				}
+			}
+			if address.Valid {
+				var replaced []int64
+				if replaced, err = s.replace(
+					c, tx, ev, address.String,
+				); err != nil {
+					return
+				}
+				remove = append(remove, replaced...)
+			}
+			for _, serial := range remove {
+				if err = deleteSerial(c, tx, serial); chk.E(err) {
+					return
+				}
+			}
+			var res sql.Result
+			if res, err = tx.ExecContext(
+				c, `INSERT INTO events
+					(id, pubkey, kind, created_at, address, json)
+					VALUES (?, ?, ?, ?, ?, ?)`,
+				ev.ID, ev.Pubkey, ev.Kind.K, ev.CreatedAt.I64(), address,
+				string(raw),
+			); chk.E(err) {
+				return
+			}
+			var serial int64
+			if serial, err = res.LastInsertId(); chk.E(err) {
+				return
+			}
+			for _, t := range ev.Tags.ToSliceOfTags() {
+				if t.Len() < 2 {
+					continue
+				}
+				if _, err = tx.ExecContext(
+					c, `INSERT INTO tags (serial, key, value) VALUES (?, ?, ?)`,
+					serial, string(t.Key()), string(t.Value()),
+				); chk.E(err) {
+					return
+				}
+			}
+			return
+		},
+	)
+	return
+}
+
+// checkDeletions returns an error if a stored deletion event deletes an event,
+// by its id or by its address.
+func (s *S) checkDeletions(
+	c context.T, tx *sql.Tx, ev *event.E, owners [][]byte,
+) (err error) {
+	address := ""
+	if store.IsAddressable(ev.Kind) {
+		address = store.Address(ev)
+	}
+	var deletions event.S
+	if deletions, err = queryEvents(
+		c, tx, `SELECT DISTINCT events.json FROM events
+			JOIN tags ON tags.serial = events.serial
+			WHERE events.kind = ? AND (
+				(tags.key = 'e' AND tags.value = ?) OR
+				(tags.key = 'a' AND tags.value = ?)
+			)`,
+		kind.Deletion.K, hex.Enc(ev.ID), address,
+	); chk.E(err) {
+		return
+	}
+	for _, deletion := range deletions {
+		if store.DeletedBy(deletion, ev, owners) {
+			err = errorf.E("blocked: event %0x has been deleted", ev.ID)
+			return
+		}
+	}
+	return
+}
+
+// deletionTargets returns the serials of the stored events that a deletion
+// event deletes.
+func (s *S) deletionTargets(
+	c context.T, tx *sql.Tx, deletion *event.E, owners [][]byte,
+) (serials []int64, err error) {
+	var ids, addresses []any
+	for _, t := range deletion.Tags.GetAll(tag.New("e")).ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		var id []byte
+		if id, err = hex.Dec(string(t.Value())); err != nil {
+			err = nil
+			continue
+		}
+		ids = append(ids, id)
+	}
+	for _, t := range deletion.Tags.GetAll(tag.New("a")).ToSliceOfTags() {
+		if t.Len() >= 2 {
+			addresses = append(addresses, string(t.Value()))
+		}
+	}
+	if len(ids)+len(addresses) == 0 {
+		return
+	}
+	var rows *sql.Rows
+	if rows, err = tx.QueryContext(
+		c, `SELECT serial, json FROM events
+			WHERE id IN (`+placeholders(len(ids))+`)
+			OR address IN (`+placeholders(len(addresses))+`)`,
+		append(ids, addresses...)...,
+	); chk.E(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var serial int64
+		var raw []byte
+		if err = rows.Scan(&serial, &raw); chk.E(err) {
+			return
+		}
+		ev := event.New()
+		if _, err = ev.Unmarshal(raw); chk.E(err) {
+			err = nil
+			continue
+		}
+		if store.DeletedBy(deletion, ev, owners) {
+			serials = append(serials, serial)
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// replace returns the serials of the stored versions of a replaceable event
+// that it supersedes and the store.ReplacePolicy doesn't retain, or
+// store.ErrSuperseded if one of them supersedes it.
+func (s *S) replace(
+	c context.T, tx *sql.Tx, ev *event.E, address string,
+) (serials []int64, err error) {
+	var rows *sql.Rows
+	if rows, err = tx.QueryContext(
+		c, `SELECT serial, json FROM events WHERE address = ?`, address,
+	); chk.E(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var serial int64
+		var raw []byte
+		if err = rows.Scan(&serial, &raw); chk.E(err) {
+			return
+		}
+		old := event.New()
+		if _, err = old.Unmarshal(raw); chk.E(err) {
+			return
+		}
+		if !store.Supersedes(ev, old) {
+			err = store.ErrSuperseded
+			return
+		}
+		if !s.replacePolicy.Retains(old.Kind) {
+			serials = append(serials, serial)
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// deleteSerial deletes the event with a serial and its tags.
+func deleteSerial(c context.T, q querier, serial int64) (err error) {
+	if _, err = q.ExecContext(
+		c, `DELETE FROM tags WHERE serial = ?`, serial,
+	); chk.E(err) {
+		return
+	}
+	_, err = q.ExecContext(c, `DELETE FROM events WHERE serial = ?`, serial)
+	return
+}
+
+// queryEvents returns the events in the json column of the rows of a query.
+func queryEvents(
+	c context.T, q querier, query string, args ...any,
+) (evs event.S, err error) {
+	var rows *sql.Rows
+	if rows, err = q.QueryContext(c, query, args...); chk.E(err) {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw []byte
+		if err = rows.Scan(&raw); chk.E(err) {
+			return
+		}
+		ev := event.New()
+		if _, err = ev.Unmarshal(raw); chk.E(err) {
+			err = nil
+			continue
+		}
+		evs = append(evs, ev)
+	}
+	err = rows.Err()
+	return
+}
+
+// placeholders returns a list of n query parameters, or NULL if n is zero, so
+// that an IN clause matches nothing.
+func placeholders(n int) string {
+	if n == 0 {
+		return "NULL"
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// errNotFound returns true if an error is that a row was not found.
+func errNotFound(err error) bool { return errors.Is(err, sql.ErrNoRows) }
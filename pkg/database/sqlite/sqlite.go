@@ -0,0 +1,183 @@
+// Package sqlite is an implementation of store.I that keeps the events in a
+// SQLite database, so that operators can inspect the stored events with SQL.
+//
+// The events table has a row for each event, with its id, pubkey, kind,
+// created_at, the address of replaceable events, and the event in minified
+// JSON. The tags table has the key and first value of each tag of an event,
+// which queries for tags are run against. The tag queries that go beyond
+// NIP-01 are matched on the events that have a tag of their name. Deletion
+// events are kept like any other event, and are what later events are checked
+// against.
+//
+// The store uses the database/sql driver registered as "sqlite", which is
+// modernc.org/sqlite, a pure Go build of SQLite, so the relay still builds
+// without cgo.
+//
+// Superseded versions of replaceable events are deleted or retained by the
+// store.ReplacePolicy of the store, as they are by database.D.
+package sqlite
+
+import (
+	"database/sql"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DriverName is the name of the database/sql driver the store uses.
+const DriverName = "sqlite"
+
+// FileName is the name of the database file in the data directory.
+const FileName = "orly.db"
+
+// schema creates the tables of the store if they don't exist.
+var schema = []string{
+	`PRAGMA journal_mode = WAL`,
+	`CREATE TABLE IF NOT EXISTS events (
+		serial INTEGER PRIMARY KEY AUTOINCREMENT,
+		id BLOB NOT NULL UNIQUE,
+		pubkey BLOB NOT NULL,
+		kind INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		address TEXT,
+		json TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS events_pubkey
+		ON events (pubkey, created_at)`,
+	`CREATE INDEX IF NOT EXISTS events_kind ON events (kind, created_at)`,
+	`CREATE INDEX IF NOT EXISTS events_created_at ON events (created_at)`,
+	`CREATE INDEX IF NOT EXISTS events_address ON events (address)`,
+	`CREATE TABLE IF NOT EXISTS tags (
+		serial INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS tags_key_value ON tags (key, value)`,
+	`CREATE INDEX IF NOT EXISTS tags_serial ON tags (serial)`,
+	`CREATE TABLE IF NOT EXISTS configuration (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		json TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS sequences (
+		name TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS replication (
+		peer BLOB NOT NULL,
+		seq INTEGER NOT NULL,
+		queued INTEGER NOT NULL,
+		json TEXT NOT NULL,
+		PRIMARY KEY (peer, seq)
+	)`,
+}
+
+// S is an event store in a SQLite database.
+type S struct {
+	ctx     context.T
+	dataDir string
+	*sql.DB
+	// saveMx serializes the saving of events, so that the checks for
+	// deletions and newer versions see the events saved before.
+	saveMx sync.Mutex
+	// replacePolicy decides if superseded versions are retained.
+	replacePolicy store.ReplacePolicy
+}
+
+var _ store.I = (*S)(nil)
+
+// querier is a database or a transaction.
+type querier interface {
+	QueryContext(c context.T, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(c context.T, query string, args ...any) *sql.Row
+	ExecContext(c context.T, query string, args ...any) (sql.Result, error)
+}
+
+// New opens the event store in a data directory, creating it if it doesn't
+// exist. The store is closed when the context is cancelled.
+func New(c context.T, dataDir string) (s *S, err error) {
+	if err = os.MkdirAll(dataDir, 0755); chk.E(err) {
+		return
+	}
+	s = &S{
+		ctx: c, dataDir: dataDir, replacePolicy: store.DefaultReplacePolicy,
+	}
+	if s.DB, err = sql.Open(
+		DriverName, filepath.Join(dataDir, FileName),
+	); chk.E(err) {
+		return
+	}
+	// SQLite has one writer at a time, and a single connection avoids the
+	// writers waiting on each other.
+	s.DB.SetMaxOpenConns(1)
+	for _, stmt := range schema {
+		if _, err = s.ExecContext(c, stmt); chk.E(err) {
+			s.DB.Close()
+			return
+		}
+	}
+	go func() {
+		<-c.Done()
+		s.DB.Close()
+	}()
+	return
+}
+
+// SetReplacePolicy sets the policy for superseded versions of replaceable and
+// parameterized replaceable events.
+func (s *S) SetReplacePolicy(p store.ReplacePolicy) {
+	s.saveMx.Lock()
+	defer s.saveMx.Unlock()
+	s.replacePolicy = p
+}
+
+// Path returns the data directory of the store.
+func (s *S) Path() string { return s.dataDir }
+
+// Init sets the data directory of the store, which is opened by New.
+func (s *S) Init(path string) (err error) {
+	s.dataDir = path
+	return
+}
+
+// SetLogLevel does nothing, as SQLite doesn't log.
+func (s *S) SetLogLevel(level string) {}
+
+// Sync writes the changes in the write-ahead log to the database file.
+func (s *S) Sync() (err error) {
+	_, err = s.ExecContext(s.ctx, `PRAGMA wal_checkpoint(TRUNCATE)`)
+	return
+}
+
+// Rescan does nothing, as the indexes of SQLite are always up to date.
+func (s *S) Rescan() (err error) { return }
+
+// Wipe deletes all the events. The configuration and the replication queues
+// are kept, as they are by database.D.
+func (s *S) Wipe() (err error) {
+	s.saveMx.Lock()
+	defer s.saveMx.Unlock()
+	for _, stmt := range []string{`DELETE FROM tags`, `DELETE FROM events`} {
+		if _, err = s.ExecContext(s.ctx, stmt); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// transaction runs fn in a transaction, which is committed if fn returns no
+// error.
+func (s *S) transaction(c context.T, fn func(tx *sql.Tx) error) (err error) {
+	var tx *sql.Tx
+	if tx, err = s.BeginTx(c, nil); chk.E(err) {
+		return
+	}
+	if err = fn(tx); err != nil {
+		chk.E(tx.Rollback())
+		return
+	}
+	err = tx.Commit()
+	return
+}
@@ -0,0 +1,31 @@
+package sqlite
+
+import (
+	"orly.dev/pkg/database/storetest"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"testing"
+)
+
+// TestConformance runs the suite with the replace policy of the relay, and
+// TestConformanceHistory with every superseded version retained.
+func TestConformance(t *testing.T) {
+	storetest.Run(t, opener(store.DefaultReplacePolicy))
+}
+
+func TestConformanceHistory(t *testing.T) {
+	storetest.Run(t, opener(store.ReplacePolicy{History: true}))
+}
+
+func opener(p store.ReplacePolicy) storetest.Opener {
+	return func(t *testing.T) store.I {
+		ctx, cancel := context.Cancel(context.Bg())
+		t.Cleanup(cancel)
+		s, err := New(ctx, t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to open store: %v", err)
+		}
+		s.SetReplacePolicy(p)
+		return s
+	}
+}
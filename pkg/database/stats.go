@@ -0,0 +1,271 @@
+package database
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v4"
+	"io/fs"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// prefixNames are what the keys with each prefix are.
+var prefixNames = map[string]string{
+	string(indexes.EventPrefix):            "events",
+	string(indexes.IdPrefix):               "event ids",
+	string(indexes.FullIdPubkeyPrefix):     "full ids and pubkeys",
+	string(indexes.CreatedAtPrefix):        "created at",
+	string(indexes.KindPrefix):             "kinds",
+	string(indexes.PubkeyPrefix):           "pubkeys",
+	string(indexes.KindPubkeyPrefix):       "kinds and pubkeys",
+	string(indexes.TagPrefix):              "tags",
+	string(indexes.TagKindPrefix):          "tags and kinds",
+	string(indexes.TagPubkeyPrefix):        "tags and pubkeys",
+	string(indexes.TagKindPubkeyPrefix):    "tags, kinds and pubkeys",
+	string(indexes.WordPrefix):             "search words",
+	string(indexes.ExpirationPrefix):       "expirations",
+	string(indexes.TombstoneIdPrefix):      "deleted event ids",
+	string(indexes.TombstoneAddressPrefix): "deleted addresses",
+	string(indexes.AddressPrefix):          "addresses",
+	string(indexes.TagNamePrefix):          "configured tags",
+	string(indexes.TagValuePrefix):         "configured tag numbers",
+	string(indexes.GeohashPrefix):          "geohashes",
+	string(indexes.GeohashKindPrefix):      "geohashes by kind",
+	string(configurationKey[:3]):           "configuration",
+	// the replication queues and the sequence of their items.
+	string(replicationPrefix[:3]): "replication",
+	string(changePrefix[:3]):      "change log",
+	"EVE":                         "event sequence",
+}
+
+// Stats returns a report of the events in the store, the number and size of
+// the keys of each index, the sizes of the LSM tree and value log, and the
+// authors with the most events, with up to top of them.
+//
+// Every key in the store is read, but no event is decoded except one for each
+// of the top authors, to find their pubkeys. The pubkey index only has hashes
+// of them.
+func (d *D) Stats(c context.T, top int) (s *store.Stats, err error) {
+	s = &store.Stats{Time: time.Now().Unix()}
+	s.LSMSize, s.ValueLogSize = d.DB.Size()
+	if s.DiskSize, err = dirSize(d.dataDir); chk.E(err) {
+		return
+	}
+	prefixes := make(map[string]*store.IndexStats)
+	kinds := make(map[uint16]uint64)
+	authors := make(map[[8]byte]uint64)
+	kindPrf, pubkeyPrf := []byte(indexes.KindPrefix),
+		[]byte(indexes.PubkeyPrefix)
+	if err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{PrefetchValues: false},
+			)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				if err = c.Err(); err != nil {
+					return
+				}
+				item := it.Item()
+				key := item.Key()
+				prf := string(key[:min(3, len(key))])
+				p, ok := prefixes[prf]
+				if !ok {
+					p = &store.IndexStats{Prefix: prf, Name: prefixNames[prf]}
+					prefixes[prf] = p
+				}
+				p.Keys++
+				p.KeyBytes += int64(len(key))
+				p.ValueBytes += item.ValueSize()
+				switch {
+				case bytes.HasPrefix(key, kindPrf):
+					ki, ca, ser := indexes.KindVars()
+					if err = indexes.KindDec(ki, ca, ser).UnmarshalRead(
+						bytes.NewBuffer(key),
+					); chk.E(err) {
+						return
+					}
+					kinds[ki.Get()]++
+				case bytes.HasPrefix(key, pubkeyPrf) &&
+					len(key) >= len(pubkeyPrf)+8:
+					var ph [8]byte
+					copy(ph[:], key[len(pubkeyPrf):])
+					authors[ph]++
+				}
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	if p, ok := prefixes[string(indexes.EventPrefix)]; ok {
+		s.Events = p.Keys
+	}
+	for _, p := range prefixes {
+		s.Indexes = append(s.Indexes, *p)
+	}
+	sort.Slice(
+		s.Indexes, func(i, j int) bool {
+			return s.Indexes[i].KeyBytes+s.Indexes[i].ValueBytes >
+				s.Indexes[j].KeyBytes+s.Indexes[j].ValueBytes
+		},
+	)
+	for k, n := range kinds {
+		s.Kinds = append(s.Kinds, store.KindStats{Kind: k, Events: n})
+	}
+	sort.Slice(
+		s.Kinds, func(i, j int) bool {
+			if s.Kinds[i].Events != s.Kinds[j].Events {
+				return s.Kinds[i].Events > s.Kinds[j].Events
+			}
+			return s.Kinds[i].Kind < s.Kinds[j].Kind
+		},
+	)
+	if s.Authors, err = d.topAuthors(authors, top); err != nil {
+		return
+	}
+	for _, l := range d.DB.Levels() {
+		s.Levels = append(
+			s.Levels, store.LevelStats{
+				Level: l.Level, Tables: l.NumTables, Size: l.Size,
+				TargetSize: l.TargetSize, StaleBytes: l.StaleDatSize,
+			},
+		)
+	}
+	d.gcMx.Lock()
+	s.LastGC = d.lastGC
+	policy := d.gcPolicy
+	d.gcMx.Unlock()
+	s.Warnings = policy.warnings(s)
+	return
+}
+
+// topAuthors returns the number and size of the events of up to top of the
+// authors with the most events, given the number of events of each pubkey
+// hash.
+func (d *D) topAuthors(counts map[[8]byte]uint64, top int) (
+	authors []store.AuthorStats, err error,
+) {
+	hashes := make([][8]byte, 0, len(counts))
+	for ph := range counts {
+		hashes = append(hashes, ph)
+	}
+	sort.Slice(
+		hashes, func(i, j int) bool {
+			return counts[hashes[i]] > counts[hashes[j]]
+		},
+	)
+	if len(hashes) > top {
+		hashes = hashes[:max(top, 0)]
+	}
+	err = d.View(
+		func(txn *badger.Txn) (err error) {
+			for _, ph := range hashes {
+				a := store.AuthorStats{Events: counts[ph]}
+				prf := append([]byte(indexes.PubkeyPrefix), ph[:]...)
+				it := txn.NewIterator(
+					badger.IteratorOptions{Prefix: prf},
+				)
+				for it.Rewind(); it.Valid(); it.Next() {
+					key := it.Item().Key()
+					k := new(bytes.Buffer)
+					indexes.EventPrefix.Write(k)
+					k.Write(key[len(key)-5:])
+					var item *badger.Item
+					if item, err = txn.Get(k.Bytes()); err != nil {
+						// an index without its event.
+						err = nil
+						continue
+					}
+					a.Bytes += item.ValueSize()
+					if a.Pubkey == "" {
+						a.Pubkey = eventPubkey(item)
+					}
+				}
+				it.Close()
+				authors = append(authors, a)
+			}
+			return
+		},
+	)
+	return
+}
+
+// eventPubkey returns the pubkey of the event in an Event item, in hex.
+func eventPubkey(item *badger.Item) (pk string) {
+	v, err := item.ValueCopy(nil)
+	if chk.E(err) {
+		return
+	}
+	ev := new(event.E)
+	if err = ev.UnmarshalBinary(bytes.NewBuffer(v)); chk.E(err) {
+		return
+	}
+	return hex.Enc(ev.Pubkey)
+}
+
+// DiskSize returns the total size of the files in the data directory of the
+// store.
+func (d *D) DiskSize() (size int64, err error) { return dirSize(d.dataDir) }
+
+// dirSize returns the total size of the files in a directory.
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.WalkDir(
+		dir, func(path string, de fs.DirEntry, err error) error {
+			if err != nil || de.IsDir() {
+				return err
+			}
+			var fi fs.FileInfo
+			if fi, err = de.Info(); err != nil {
+				// removed since the directory was read.
+				return nil
+			}
+			size += fi.Size()
+			return nil
+		},
+	)
+	return
+}
+
+// warnings returns the problems with a store that the policy should be
+// collecting.
+func (p GCPolicy) warnings(s *store.Stats) (warnings []string) {
+	var values int64
+	for _, i := range s.Indexes {
+		values += i.ValueBytes
+	}
+	if p.DiscardRatio > 0 && s.ValueLogSize > p.MinValueLogSize &&
+		float64(values) < float64(s.ValueLogSize)*(1-p.DiscardRatio) {
+		warnings = append(
+			warnings, "the value log is "+
+				strconv.FormatInt(s.ValueLogSize/max(values, 1), 10)+
+				" times the size of the stored values, "+
+				"its garbage is not being collected",
+		)
+	}
+	var stale int64
+	for _, l := range s.Levels {
+		stale += l.StaleBytes
+	}
+	if p.CompactRatio > 0 && s.LSMSize > 0 &&
+		float64(stale) > float64(s.LSMSize)*p.CompactRatio {
+		warnings = append(
+			warnings, strconv.FormatInt(stale*100/s.LSMSize, 10)+
+				"% of the LSM tree is deleted or overwritten data "+
+				"that has not been compacted",
+		)
+	}
+	if p.Interval <= 0 {
+		warnings = append(
+			warnings, "the scheduled garbage collection is disabled",
+		)
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"bytes"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	now := time.Now().Unix()
+	alice := sha256.Sum256([]byte("alice"))
+	bob := sha256.Sum256([]byte("bob"))
+	newEvent := func(i int, pk []byte, k *kind.T) (ev *event.E) {
+		ev = event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(i)))
+		ev.ID = id[:]
+		ev.Pubkey = pk
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - int64(i))
+		ev.Kind = k
+		ev.Content = []byte("story " + strconv.Itoa(i))
+		ev.Tags = tags.New()
+		return
+	}
+	// alice has 6 notes and a reaction, bob has 2 notes.
+	for i := 0; i < 9; i++ {
+		pk, k := alice[:], kind.TextNote
+		switch {
+		case i == 6:
+			k = kind.Reaction
+		case i > 6:
+			pk = bob[:]
+		}
+		if _, _, err = db.SaveEvent(
+			ctx, newEvent(i, pk, k), false, nil,
+		); chk.E(err) {
+			t.Fatal(err)
+		}
+	}
+	s, err := db.Stats(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Events != 9 {
+		t.Fatalf("got %d events, expected 9", s.Events)
+	}
+	if len(s.Kinds) != 2 || s.Kinds[0].Kind != kind.TextNote.K ||
+		s.Kinds[0].Events != 8 || s.Kinds[1].Events != 1 {
+		t.Fatalf("got kinds %+v, expected 8 notes and 1 reaction", s.Kinds)
+	}
+	if len(s.Authors) != 1 || s.Authors[0].Pubkey != hex.Enc(alice[:]) ||
+		s.Authors[0].Events != 7 || s.Authors[0].Bytes <= 0 {
+		t.Fatalf("got authors %+v, expected alice with 7 events", s.Authors)
+	}
+	var names []string
+	for _, i := range s.Indexes {
+		if i.Prefix == "evt" && i.Keys != 9 {
+			t.Fatalf("got %d event keys, expected 9", i.Keys)
+		}
+		names = append(names, i.Name)
+	}
+	for _, name := range []string{"events", "kinds", "pubkeys"} {
+		found := false
+		for _, n := range names {
+			found = found || n == name
+		}
+		if !found {
+			t.Fatalf("no %s index in %v", name, names)
+		}
+	}
+	run := db.CollectGarbage()
+	if run.Error != "" {
+		t.Fatal(run.Error)
+	}
+	if s, err = db.Stats(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if s.LastGC != run {
+		t.Fatal("the last garbage collection is not in the report")
+	}
+	buf := new(bytes.Buffer)
+	if err = s.Print(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(hex.Enc(bob[:]))) {
+		t.Fatalf("bob is not in the report:\n%s", buf)
+	}
+}
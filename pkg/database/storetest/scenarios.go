@@ -0,0 +1,280 @@
+package storetest
+
+import (
+	"bufio"
+	"bytes"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/event/examples"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+)
+
+// The scenarios of the tests of the badger store, which are run against the
+// example events, and the other backends are held to.
+
+// fixture saves the example events in a store, and returns them in the order
+// they were saved.
+func fixture(t *testing.T, s store.I) (evs event.S) {
+	t.Helper()
+	scanner := bufio.NewScanner(bytes.NewBuffer(examples.Cache))
+	scanner.Buffer(make([]byte, 0, 1_000_000_000), 1_000_000_000)
+	for scanner.Scan() {
+		ev := event.New()
+		if _, err := ev.Unmarshal(scanner.Bytes()); err != nil {
+			t.Fatalf("Failed to unmarshal example event: %v", err)
+		}
+		evs = append(evs, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read example events: %v", err)
+	}
+	save(t, s, evs...)
+	return
+}
+
+// tagged returns the first example event with a tag with a single letter key
+// and a value, and that tag.
+func tagged(evs event.S) (ev *event.E, tg *tag.T) {
+	for _, ev = range evs {
+		for _, tg = range ev.Tags.ToSliceOfTags() {
+			if tg.Len() >= 2 && len(tg.Key()) == 1 {
+				return
+			}
+		}
+	}
+	return nil, nil
+}
+
+// current returns the events that are not superseded by a newer version.
+func current(evs event.S) (cur event.S) {
+	newest := make(map[string]*event.E)
+	for _, ev := range evs {
+		if !store.IsAddressable(ev.Kind) {
+			continue
+		}
+		a := store.Address(ev)
+		if n, ok := newest[a]; !ok || store.Supersedes(ev, n) {
+			newest[a] = ev
+		}
+	}
+	for _, ev := range evs {
+		if !store.IsAddressable(ev.Kind) || newest[store.Address(ev)] == ev {
+			cur = append(cur, ev)
+		}
+	}
+	return
+}
+
+// hasTag returns true if an event has a tag with a key and value.
+func hasTag(ev *event.E, tg *tag.T) bool {
+	for _, t := range ev.Tags.ToSliceOfTags() {
+		if t.Len() >= 2 && bytes.Equal(t.Key(), tg.Key()) &&
+			bytes.Equal(t.Value(), tg.Value()) {
+			return true
+		}
+	}
+	return false
+}
+
+func testFixture(t *testing.T, s store.I) {
+	evs := fixture(t, s)
+	cur := current(evs)
+	// the superseded versions may be retained, depending on the policy of the
+	// store.
+	n, err := s.EventCount()
+	if err != nil || n < uint64(len(cur)) || n > uint64(len(evs)) {
+		t.Fatalf(
+			"Expected %d to %d events, got %d %v", len(cur), len(evs), n, err,
+		)
+	}
+	// by id
+	var got event.S
+	for _, ev := range cur {
+		if got, err = s.QueryEvents(
+			context.Bg(), &filter.F{Ids: tag.New(ev.ID)},
+		); err != nil || len(got) != 1 || !bytes.Equal(got[0].ID, ev.ID) {
+			t.Fatalf("Expected event %0x by id, got %d %v", ev.ID, len(got), err)
+		}
+	}
+	mid := evs[len(evs)/2].CreatedAt.I64()
+	for _, tt := range []struct {
+		name  string
+		f     *filter.F
+		match func(ev *event.E) bool
+	}{
+		{
+			"kind", &filter.F{Kinds: kinds.New(kind.TextNote)},
+			func(ev *event.E) bool { return ev.Kind.Equal(kind.TextNote) },
+		},
+		{
+			"author", &filter.F{Authors: tag.New(evs[1].Pubkey)},
+			func(ev *event.E) bool {
+				return bytes.Equal(ev.Pubkey, evs[1].Pubkey)
+			},
+		},
+		{
+			"time range", &filter.F{
+				Since: timestamp.FromUnix(mid - 3600),
+				Until: timestamp.FromUnix(mid + 3600),
+			}, func(ev *event.E) bool {
+				return ev.CreatedAt.I64() >= mid-3600 &&
+					ev.CreatedAt.I64() <= mid+3600
+			},
+		},
+	} {
+		if got, err = s.QueryEvents(context.Bg(), tt.f); err != nil {
+			t.Fatalf("Failed to query events by %s: %v", tt.name, err)
+		}
+		if len(got) == 0 {
+			t.Fatalf("Expected events by %s, got none", tt.name)
+		}
+		for i, ev := range got {
+			if !tt.match(ev) {
+				t.Fatalf("Event %d does not match the %s", i, tt.name)
+			}
+		}
+	}
+	// by tag
+	ev, tg := tagged(evs)
+	if ev == nil {
+		t.Fatal("Expected an example event with a tag")
+	}
+	if got, err = s.QueryEvents(
+		context.Bg(), &filter.F{
+			Tags: tags.New(
+				tag.New(append([]byte{'#'}, tg.Key()...), tg.Value()),
+			),
+		},
+	); err != nil {
+		t.Fatalf("Failed to query events by tag: %v", err)
+	}
+	if len(got) == 0 {
+		t.Fatal("Expected events with the tag, got none")
+	}
+	for i, ev := range got {
+		if !hasTag(ev, tg) {
+			t.Fatalf(
+				"Event %d does not have the tag %s", i, tg.Marshal(nil),
+			)
+		}
+	}
+}
+
+func testFixtureCount(t *testing.T, s store.I) {
+	evs := fixture(t, s)
+	ff := []*filter.F{
+		{Kinds: kinds.New(kind.TextNote)},
+		{Authors: tag.New(evs[1].Pubkey)},
+		{Kinds: kinds.New(evs[1].Kind), Authors: tag.New(evs[1].Pubkey)},
+		{Kinds: kinds.New(kind.ProfileMetadata, kind.FollowList)},
+		{Ids: tag.New(evs[0].ID, evs[5].ID)},
+		filter.New(),
+	}
+	if _, tg := tagged(evs); tg != nil {
+		ff = append(
+			ff, &filter.F{
+				Tags: tags.New(
+					tag.New(append([]byte{'#'}, tg.Key()...), tg.Value()),
+				),
+			},
+		)
+	}
+	for _, f := range ff {
+		// the count is of the events a query returns, which leaves out the
+		// deletion events and the superseded versions of replaceable events.
+		got, err := s.QueryEvents(context.Bg(), f)
+		if err != nil {
+			t.Fatalf("Failed to query events: %v", err)
+		}
+		n, _, err := s.CountEvents(context.Bg(), f)
+		if err != nil || n != len(got) {
+			t.Fatalf(
+				"Count %s got %d %v, want %d", f.Serialize(), n, err,
+				len(got),
+			)
+		}
+	}
+	// the ids of a filter must match the rest of it too.
+	other := kind.TextNote
+	if evs[0].Kind.Equal(kind.TextNote) {
+		other = kind.ProfileMetadata
+	}
+	n, _, err := s.CountEvents(
+		context.Bg(),
+		&filter.F{Ids: tag.New(evs[0].ID), Kinds: kinds.New(other)},
+	)
+	if err != nil || n != 0 {
+		t.Fatalf(
+			"Expected no events for an id of another kind, got %d %v", n, err,
+		)
+	}
+}
+
+func testMultipleParameterizedReplace(t *testing.T, s store.I) {
+	fixture(t, s)
+	a := newAuthor(t)
+	for i, content := range []string{"base", "newer", "newest"} {
+		save(
+			t, s, a.event(
+				30000, now-7200+int64(i)*3600, content, "d", "test-d-tag",
+			),
+		)
+	}
+	// only the newest version is returned. Whether the superseded versions
+	// are still found by id depends on the policy of the store.
+	expect(
+		t, s, &filter.F{
+			Kinds: kinds.New(kind.New(30000)), Authors: tag.New(a.Pub()),
+		}, "newest",
+	)
+}
+
+func testReplaceAndDelete(t *testing.T, s store.I) {
+	fixture(t, s)
+	a := newAuthor(t)
+	f := &filter.F{
+		Kinds: kinds.New(kind.ProfileMetadata), Authors: tag.New(a.Pub()),
+	}
+	original := a.event(0, now-7200, "original")
+	save(t, s, original, a.event(0, now-3600, "updated"))
+	expect(t, s, f, "updated")
+	// the deletion of the superseded version leaves the newer one.
+	save(t, s, a.event(5, now, "", "e", hex.Enc(original.ID)))
+	expect(t, s, f, "updated")
+	if stored(s, original.ID) {
+		t.Fatal("Expected the deleted version to be gone")
+	}
+}
+
+func testParameterizedDelete(t *testing.T, s store.I) {
+	fixture(t, s)
+	a := newAuthor(t)
+	ev := a.event(30000, now-7200, "original", "d", "test-d-tag")
+	save(t, s, ev)
+	// deleted both by address and by id
+	save(
+		t, s,
+		a.event(
+			5, now, "", "a", strconv.Itoa(30000)+":"+hex.Enc(a.Pub())+
+				":test-d-tag",
+		),
+		a.event(5, now, "", "e", hex.Enc(ev.ID)),
+	)
+	expect(
+		t, s, &filter.F{
+			Kinds: kinds.New(kind.New(30000)), Authors: tag.New(a.Pub()),
+		},
+	)
+	if stored(s, ev.ID) {
+		t.Fatal("Expected the deleted event to be gone")
+	}
+}
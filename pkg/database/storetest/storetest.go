@@ -0,0 +1,778 @@
+// Package storetest is a conformance test suite for implementations of
+// store.I, which checks that an event store saves, queries, replaces and
+// deletes events the way the relay expects.
+//
+// A backend runs the suite from its tests with a function that opens a new,
+// empty store for each test:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.I { return New() })
+//	}
+package storetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/geohash"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TagRules are the tags the TagQueries test queries beyond those of NIP-01, in
+// the form of the tag rules of the configuration, for a store that only
+// answers queries for the tags it indexes.
+var TagRules = []string{"price:1:number", "imeta:2"}
+
+// Opener returns a new, empty store, which is closed by the suite.
+type Opener func(t *testing.T) store.I
+
+// Run runs the conformance suite against the stores returned by open.
+func Run(t *testing.T, open Opener) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.I)
+	}{
+		{"SaveEvent", testSaveEvent},
+		{"QueryEvents", testQueryEvents},
+		{"TagQueries", testTagQueries},
+		{"GeoQueries", testGeoQueries},
+		{"StreamEvents", testStreamEvents},
+		{"CountEvents", testCountEvents},
+		{"QueryForIds", testQueryForIds},
+		{"Cursor", testCursor},
+		{"Replace", testReplace},
+		{"ParameterizedReplace", testParameterizedReplace},
+		{"DeleteById", testDeleteById},
+		{"DeleteByAddress", testDeleteByAddress},
+		{"DeleteEvent", testDeleteEvent},
+		{"Expiration", testExpiration},
+		{"ExportImport", testExportImport},
+		{"Wipe", testWipe},
+		{"Configuration", testConfiguration},
+		{"Replication", testReplication},
+		{"Fixture", testFixture},
+		{"FixtureCount", testFixtureCount},
+		{"MultipleParameterizedReplace", testMultipleParameterizedReplace},
+		{"ReplaceAndDelete", testReplaceAndDelete},
+		{"ParameterizedDelete", testParameterizedDelete},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				s := open(t)
+				defer s.Close()
+				tt.fn(t, s)
+			},
+		)
+	}
+}
+
+// author is a key that signs test events.
+type author struct {
+	t *testing.T
+	*p256k.Signer
+}
+
+func newAuthor(t *testing.T) (a *author) {
+	a = &author{t: t, Signer: new(p256k.Signer)}
+	if err := a.Generate(); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return
+}
+
+// event returns a signed event of a kind, created at a time, with tags given
+// as key, value pairs.
+func (a *author) event(
+	k uint16, createdAt int64, content string, kv ...string,
+) (ev *event.E) {
+	ev = event.New()
+	ev.Kind = kind.New(k)
+	ev.CreatedAt = timestamp.FromUnix(createdAt)
+	ev.Content = []byte(content)
+	ev.Tags = tags.New()
+	for i := 0; i+1 < len(kv); i += 2 {
+		ev.Tags.AppendTags(tag.New(kv[i], kv[i+1]))
+	}
+	if err := ev.Sign(a); err != nil {
+		a.t.Fatalf("Failed to sign event: %v", err)
+	}
+	return
+}
+
+// save saves events in a store, failing the test if any are rejected.
+func save(t *testing.T, s store.I, evs ...*event.E) {
+	t.Helper()
+	for _, ev := range evs {
+		if _, _, err := s.SaveEvent(
+			context.Bg(), ev, false, nil,
+		); err != nil {
+			t.Fatalf("Failed to save event %0x: %v", ev.ID, err)
+		}
+	}
+}
+
+// query returns the contents of the events matching a filter, in the order
+// they are returned.
+func query(t *testing.T, s store.I, f *filter.F) (contents []string) {
+	t.Helper()
+	evs, err := s.QueryEvents(context.Bg(), f)
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	for _, ev := range evs {
+		contents = append(contents, string(ev.Content))
+	}
+	return
+}
+
+// expect fails the test if the contents of the events matching a filter are
+// not the expected ones, in order.
+func expect(t *testing.T, s store.I, f *filter.F, want ...string) {
+	t.Helper()
+	got := query(t, s, f)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Query %s got %v, want %v", f.Serialize(), got, want)
+	}
+}
+
+// stored returns true if an event with an id is in the store.
+func stored(s store.I, id []byte) bool {
+	evs, err := s.QueryEvents(context.Bg(), &filter.F{Ids: tag.New(id)})
+	return err == nil && len(evs) == 1
+}
+
+func limit(n uint) *uint { return &n }
+
+var now = time.Now().Unix()
+
+// notes saves text notes of two authors, a at even and b at odd times, with
+// content "0" to "9" from oldest to newest, and t tags of "even" or "odd".
+func notes(t *testing.T, s store.I) (a, b *author) {
+	a, b = newAuthor(t), newAuthor(t)
+	for i := range 10 {
+		au, parity := a, "even"
+		if i%2 == 1 {
+			au, parity = b, "odd"
+		}
+		save(
+			t, s, au.event(
+				1, now-100+int64(i), strconv.Itoa(i), "t", parity,
+			),
+		)
+	}
+	return
+}
+
+func testSaveEvent(t *testing.T, s store.I) {
+	a := newAuthor(t)
+	ev := a.event(1, now, "hello")
+	save(t, s, ev)
+	if _, _, err := s.SaveEvent(context.Bg(), ev, false, nil); err == nil {
+		t.Fatal("Expected a duplicate event to be rejected")
+	}
+	if !stored(s, ev.ID) {
+		t.Fatal("Expected the event to be stored")
+	}
+	ser, err := s.GetSerialById(ev.ID)
+	if err != nil || ser == nil {
+		t.Fatalf("Expected a serial for the event, got %v %v", ser, err)
+	}
+	n, err := s.EventCount()
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 event, got %d %v", n, err)
+	}
+	ids, err := s.EventIdsBySerial(0, 10)
+	if err != nil || len(ids) != 1 || ids[0].EventId != hex.Enc(ev.ID) {
+		t.Fatalf("Expected the id of the event by serial, got %v %v", ids, err)
+	}
+}
+
+func testQueryEvents(t *testing.T, s store.I) {
+	a, b := notes(t, s)
+	expect(
+		t, s, &filter.F{Kinds: kinds.New(kind.TextNote)},
+		"9", "8", "7", "6", "5", "4", "3", "2", "1", "0",
+	)
+	expect(
+		t, s, &filter.F{Authors: tag.New(a.Pub())}, "8", "6", "4", "2", "0",
+	)
+	expect(
+		t, s, &filter.F{Authors: tag.New(a.Pub(), b.Pub()), Limit: limit(3)},
+		"9", "8", "7",
+	)
+	expect(
+		t, s, &filter.F{
+			Since: timestamp.FromUnix(now - 97),
+			Until: timestamp.FromUnix(now - 94),
+		}, "6", "5", "4", "3",
+	)
+	expect(
+		t, s, &filter.F{Tags: tags.New(tag.New("#t", "odd"))},
+		"9", "7", "5", "3", "1",
+	)
+	expect(
+		t, s, &filter.F{
+			Kinds:   kinds.New(kind.TextNote),
+			Authors: tag.New(b.Pub()),
+			Tags:    tags.New(tag.New("#t", "odd")),
+			Limit:   limit(2),
+		}, "9", "7",
+	)
+	expect(
+		t, s, &filter.F{
+			Authors: tag.New(a.Pub()), Tags: tags.New(tag.New("#t", "odd")),
+		},
+	)
+	expect(t, s, &filter.F{Kinds: kinds.New(kind.ProfileMetadata)})
+}
+
+func testTagQueries(t *testing.T, s store.I) {
+	a := newAuthor(t)
+	for i, price := range []string{"10", "20", "30"} {
+		ev := a.event(
+			30402, now-100+int64(i), "listing"+price, "d", price,
+			"price", price,
+		)
+		// a tag with a value at the second position.
+		ev.Tags.AppendTags(
+			tag.New("imeta", "url https://example.com/"+price, "m image/png"),
+		)
+		if err := ev.Sign(a); err != nil {
+			t.Fatalf("Failed to sign event: %v", err)
+		}
+		save(t, s, ev)
+	}
+	save(t, s, a.event(1, now, "note", "price", "20"))
+	save(
+		t, s, a.event(1, now-200, "literal", "t", "1..5"),
+		a.event(1, now-201, "three", "t", "3"),
+	)
+	for _, tt := range []struct {
+		f    *filter.F
+		want []string
+	}{
+		// a tag with a longer name
+		{
+			&filter.F{Tags: tags.New(tag.New("#price", "20"))},
+			[]string{"note", "listing20"},
+		},
+		// a range of values
+		{
+			&filter.F{
+				Kinds: kinds.New(kind.New(30402)),
+				Tags:  tags.New(tag.New("#price", "15..30")),
+			}, []string{"listing30", "listing20"},
+		},
+		{
+			&filter.F{Tags: tags.New(tag.New("#price", "..10", "25.."))},
+			[]string{"listing30", "listing10"},
+		},
+		// a value at another position
+		{
+			&filter.F{
+				Tags:  tags.New(tag.New("#imeta:2", "m image/png")),
+				Limit: limit(2),
+			}, []string{"listing30", "listing20"},
+		},
+		{&filter.F{Tags: tags.New(tag.New("#imeta:2", "m image/gif"))}, nil},
+		// a tag query together with a tag of NIP-01
+		{
+			&filter.F{
+				Tags: tags.New(
+					tag.New("#d", "10"), tag.New("#price", "5..15"),
+				),
+			}, []string{"listing10"},
+		},
+		// the values of a NIP-01 tag are matched exactly, and are a range
+		// with the position in the key.
+		{
+			&filter.F{
+				Kinds: kinds.New(kind.TextNote),
+				Tags:  tags.New(tag.New("#t", "1..5")),
+			},
+			[]string{"literal"},
+		},
+		{
+			&filter.F{
+				Kinds: kinds.New(kind.TextNote),
+				Tags:  tags.New(tag.New("#t:1", "1..5")),
+			},
+			[]string{"three"},
+		},
+	} {
+		expect(t, s, tt.f, tt.want...)
+		f := *tt.f
+		f.Limit = nil
+		n, _, err := s.CountEvents(context.Bg(), &f)
+		if err != nil || n != len(query(t, s, &f)) {
+			t.Fatalf("Count %s got %d %v", f.Serialize(), n, err)
+		}
+	}
+}
+
+func testGeoQueries(t *testing.T, s store.I) {
+	a := newAuthor(t)
+	places := []struct {
+		name     string
+		k        uint16
+		lat, lon float64
+	}{
+		{"oslo", 1, 59.9139, 10.7522},
+		{"oslo-east", 1, 59.92, 10.77},
+		{"bergen", 1, 60.39, 5.32},
+		{"berlin", 31923, 52.52, 13.405},
+	}
+	for i, p := range places {
+		save(
+			t, s, a.event(
+				p.k, now-100+int64(i), p.name,
+				"g", string(geohash.Encode(p.lat, p.lon, 9)),
+			),
+		)
+	}
+	oslo := string(geohash.Encode(59.9139, 10.7522, 9))
+	for _, tt := range []struct {
+		f    *filter.F
+		want []string
+	}{
+		// a prefix
+		{
+			&filter.F{Tags: tags.New(tag.New("#g", oslo[:4]+"*"))},
+			[]string{"oslo-east", "oslo"},
+		},
+		// a box, alone and with the kinds
+		{
+			&filter.F{Tags: tags.New(tag.New("#g", "52,5..61,11"))},
+			[]string{"bergen", "oslo-east", "oslo"},
+		},
+		{
+			&filter.F{
+				Kinds: kinds.New(kind.New(31923)),
+				Tags:  tags.New(tag.New("#g", "50,0..61,20")),
+			}, []string{"berlin"},
+		},
+		// a circle
+		{
+			&filter.F{Tags: tags.New(tag.New("#g", "59.9139,10.7522,200"))},
+			[]string{"oslo"},
+		},
+		{
+			&filter.F{
+				Tags:  tags.New(tag.New("#g", "59.9139,10.7522,5000")),
+				Since: timestamp.FromUnix(now - 99),
+			}, []string{"oslo-east"},
+		},
+		// a geohash, which is not a query for an area
+		{&filter.F{Tags: tags.New(tag.New("#g", oslo))}, []string{"oslo"}},
+		{&filter.F{Tags: tags.New(tag.New("#g", "s0000*"))}, nil},
+	} {
+		expect(t, s, tt.f, tt.want...)
+		n, _, err := s.CountEvents(context.Bg(), tt.f)
+		if err != nil || n != len(tt.want) {
+			t.Fatalf(
+				"Count %s got %d %v, want %d", tt.f.Serialize(), n, err,
+				len(tt.want),
+			)
+		}
+	}
+}
+
+func testStreamEvents(t *testing.T, s store.I) {
+	notes(t, s)
+	var got []string
+	if err := s.StreamEvents(
+		context.Bg(), &filter.F{Kinds: kinds.New(kind.TextNote)},
+		func(ev *event.E) (more bool) {
+			got = append(got, string(ev.Content))
+			return len(got) < 3
+		},
+	); err != nil {
+		t.Fatalf("Failed to stream events: %v", err)
+	}
+	if fmt.Sprint(got) != "[9 8 7]" {
+		t.Fatalf("Expected the stream to stop after 3 events, got %v", got)
+	}
+	c, cancel := context.Cancel(context.Bg())
+	cancel()
+	if err := s.StreamEvents(
+		c, &filter.F{Kinds: kinds.New(kind.TextNote)},
+		func(ev *event.E) (more bool) {
+			t.Fatal("Expected no events after the context is cancelled")
+			return false
+		},
+	); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the error of the context, got %v", err)
+	}
+}
+
+func testCountEvents(t *testing.T, s store.I) {
+	a, _ := notes(t, s)
+	for _, tt := range []struct {
+		f    *filter.F
+		want int
+	}{
+		{&filter.F{Kinds: kinds.New(kind.TextNote)}, 10},
+		{&filter.F{Authors: tag.New(a.Pub())}, 5},
+		// the limit does not apply to a count
+		{&filter.F{Tags: tags.New(tag.New("#t", "odd")), Limit: limit(1)}, 5},
+	} {
+		n, _, err := s.CountEvents(context.Bg(), tt.f)
+		if err != nil || n != tt.want {
+			t.Fatalf(
+				"Count %s got %d %v, want %d", tt.f.Serialize(), n, err,
+				tt.want,
+			)
+		}
+	}
+}
+
+func testQueryForIds(t *testing.T, s store.I) {
+	_, b := notes(t, s)
+	idPkTs, err := s.QueryForIds(
+		context.Bg(), &filter.F{Authors: tag.New(b.Pub())},
+	)
+	if err != nil || len(idPkTs) != 5 {
+		t.Fatalf("Expected 5 results, got %d %v", len(idPkTs), err)
+	}
+	evs, err := s.QueryEvents(
+		context.Bg(), &filter.F{Authors: tag.New(b.Pub())},
+	)
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	for i, r := range idPkTs {
+		if !bytes.Equal(r.Id, evs[i].ID) || r.Ts != evs[i].CreatedAt.V {
+			t.Fatalf("Expected the id and time of event %d, got %+v", i, r)
+		}
+		if i > 0 && r.Ts > idPkTs[i-1].Ts {
+			t.Fatal("Expected the results in reverse chronological order")
+		}
+	}
+	if _, err = s.QueryForIds(
+		context.Bg(), &filter.F{Ids: tag.New(idPkTs[0].Id)},
+	); err == nil {
+		t.Fatal("Expected a query for ids with ids to be refused")
+	}
+}
+
+func testCursor(t *testing.T, s store.I) {
+	a := newAuthor(t)
+	// most of the events share a timestamp, and are in reverse order of when
+	// they were saved.
+	for i := range 7 {
+		createdAt := now - 10
+		if i == 0 {
+			createdAt = now - 20
+		} else if i == 6 {
+			createdAt = now
+		}
+		save(t, s, a.event(1, createdAt, strconv.Itoa(i), "t", "page"))
+	}
+	want := []string{"6", "5", "4", "3", "2", "1", "0"}
+	expect(t, s, &filter.F{Authors: tag.New(a.Pub())}, want...)
+	for _, f := range []*filter.F{
+		{Kinds: kinds.New(kind.TextNote)},
+		{Authors: tag.New(a.Pub())},
+		{Tags: tags.New(tag.New("#t", "page"))},
+	} {
+		var got []string
+		f.Limit = limit(2)
+		for len(got) <= len(want) {
+			evs, err := s.QueryEvents(context.Bg(), f)
+			if err != nil {
+				t.Fatalf("Failed to query events: %v", err)
+			}
+			for _, ev := range evs {
+				got = append(got, string(ev.Content))
+			}
+			if len(evs) < 2 {
+				break
+			}
+			last := evs[len(evs)-1]
+			ser, err := s.GetSerialById(last.ID)
+			if err != nil || ser == nil {
+				t.Fatalf("Expected a serial for the event, got %v %v", ser, err)
+			}
+			f.Cursor = &filter.Cursor{Ts: last.CreatedAt.I64(), Ser: ser.Get()}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("Pages of %s got %v, want %v", f.Serialize(), got, want)
+		}
+	}
+}
+
+func testReplace(t *testing.T, s store.I) {
+	a := newAuthor(t)
+	f := &filter.F{Kinds: kinds.New(kind.FollowList)}
+	v1 := a.event(3, now-100, "v1")
+	v2 := a.event(3, now, "v2")
+	save(t, s, v1, v2)
+	expect(t, s, f, "v2")
+	// an older version is rejected
+	_, _, err := s.SaveEvent(
+		context.Bg(), a.event(3, now-200, "v0"), false, nil,
+	)
+	if !errors.Is(err, store.ErrSuperseded) {
+		t.Fatalf("Expected ErrSuperseded for an older version, got %v", err)
+	}
+	expect(t, s, f, "v2")
+	// of two versions with the same timestamp, the one with the lowest id is
+	// retained, whatever order they are saved in.
+	v3 := a.event(3, now, "v3")
+	_, _, err = s.SaveEvent(context.Bg(), v3, false, nil)
+	want := "v2"
+	if bytes.Compare(v3.ID, v2.ID) < 0 {
+		want = "v3"
+		if err != nil {
+			t.Fatalf("Expected the version with the lower id to win: %v", err)
+		}
+	} else if !errors.Is(err, store.ErrSuperseded) {
+		t.Fatalf("Expected ErrSuperseded for the higher id, got %v", err)
+	}
+	expect(t, s, f, want)
+	// other authors have their own versions
+	b := newAuthor(t)
+	save(t, s, b.event(3, now-300, "b"))
+	expect(t, s, f, want, "b")
+}
+
+func testParameterizedReplace(t *testing.T, s store.I) {
+	a := newAuthor(t)
+	f := &filter.F{Kinds: kinds.New(kind.New(30023))}
+	save(
+		t, s,
+		a.event(30023, now-100, "x1", "d", "x"),
+		a.event(30023, now-90, "y1", "d", "y"),
+		a.event(30023, now-80, "x2", "d", "x"),
+	)
+	expect(t, s, f, "x2", "y1")
+	if _, _, err := s.SaveEvent(
+		context.Bg(), a.event(30023, now-95, "y0", "d", "y"), false, nil,
+	); !errors.Is(err, store.ErrSuperseded) {
+		t.Fatalf("Expected ErrSuperseded for an older version, got %v", err)
+	}
+	expect(
+		t, s, &filter.F{Tags: tags.New(tag.New("#d", "y"))}, "y1",
+	)
+}
+
+func testDeleteById(t *testing.T, s store.I) {
+	a, b, owner := newAuthor(t), newAuthor(t), newAuthor(t)
+	n1, n2, n3 := a.event(1, now-3, "n1"), a.event(1, now-2, "n2"),
+		b.event(1, now-1, "n3")
+	save(t, s, n1, n2, n3)
+	// a deletion only deletes the events of its author
+	save(
+		t, s, a.event(
+			5, now, "", "e", hex.Enc(n1.ID), "e", hex.Enc(n3.ID),
+		),
+	)
+	expect(t, s, &filter.F{Kinds: kinds.New(kind.TextNote)}, "n3", "n2")
+	// a deleted event is not saved again
+	if _, _, err := s.SaveEvent(context.Bg(), n1, false, nil); err == nil {
+		t.Fatal("Expected a deleted event to be rejected")
+	}
+	// the owners can delete the events of any author
+	del := owner.event(5, now, "", "e", hex.Enc(n3.ID))
+	if _, _, err := s.SaveEvent(
+		context.Bg(), del, false, [][]byte{owner.Pub()},
+	); err != nil {
+		t.Fatalf("Failed to save the deletion of an owner: %v", err)
+	}
+	expect(t, s, &filter.F{Kinds: kinds.New(kind.TextNote)}, "n2")
+	// deletions are not returned by queries, except by id
+	expect(t, s, &filter.F{Kinds: kinds.New(kind.EventDeletion)})
+	if !stored(s, del.ID) {
+		t.Fatal("Expected the deletion to be stored")
+	}
+}
+
+func testDeleteByAddress(t *testing.T, s store.I) {
+	a := newAuthor(t)
+	f := &filter.F{Kinds: kinds.New(kind.New(30023))}
+	save(
+		t, s,
+		a.event(30023, now-100, "x1", "d", "x"),
+		a.event(30023, now-100, "y1", "d", "y"),
+	)
+	address := "30023:" + hex.Enc(a.Pub()) + ":x"
+	save(t, s, a.event(5, now-50, "", "a", address))
+	expect(t, s, f, "y1")
+	// versions that are not newer than the deletion are rejected
+	if _, _, err := s.SaveEvent(
+		context.Bg(), a.event(30023, now-60, "x2", "d", "x"), false, nil,
+	); err == nil {
+		t.Fatal("Expected a version older than the deletion to be rejected")
+	}
+	// and newer ones are accepted
+	save(t, s, a.event(30023, now-40, "x3", "d", "x"))
+	expect(t, s, f, "x3", "y1")
+}
+
+func testDeleteEvent(t *testing.T, s store.I) {
+	a := newAuthor(t)
+	ev := a.event(1, now, "n")
+	save(t, s, ev)
+	if err := s.DeleteEvent(
+		context.Bg(), eventid.NewWith(ev.ID),
+	); err != nil {
+		t.Fatalf("Failed to delete event: %v", err)
+	}
+	if stored(s, ev.ID) {
+		t.Fatal("Expected the event to be deleted")
+	}
+}
+
+func testExpiration(t *testing.T, s store.I) {
+	a := newAuthor(t)
+	expired := a.event(
+		1, now-10, "expired", "expiration", strconv.FormatInt(now-1, 10),
+	)
+	if _, _, err := s.SaveEvent(
+		context.Bg(), expired, false, nil,
+	); err == nil {
+		t.Fatal("Expected an expired event to be rejected")
+	}
+	save(
+		t, s, a.event(
+			1, now-10, "expiring", "expiration",
+			strconv.FormatInt(now+3600, 10),
+		),
+	)
+	expect(t, s, &filter.F{Kinds: kinds.New(kind.TextNote)}, "expiring")
+}
+
+func testExportImport(t *testing.T, s store.I) {
+	notes(t, s)
+	buf := new(bytes.Buffer)
+	s.Export(context.Bg(), buf)
+	if n := bytes.Count(buf.Bytes(), []byte{'\n'}); n != 10 {
+		t.Fatalf("Expected 10 exported events, got %d", n)
+	}
+	if err := s.Wipe(); err != nil {
+		t.Fatalf("Failed to wipe: %v", err)
+	}
+	s.Import(buf)
+	// the import may be done in the background
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		n, err := s.EventCount()
+		if err != nil {
+			t.Fatalf("Failed to count events: %v", err)
+		}
+		if n == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 10 imported events, got %d", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect(
+		t, s, &filter.F{Tags: tags.New(tag.New("#t", "even"))},
+		"8", "6", "4", "2", "0",
+	)
+}
+
+func testWipe(t *testing.T, s store.I) {
+	notes(t, s)
+	conf := &store.Configuration{Owners: []string{"owner"}}
+	if err := s.SetConfiguration(conf); err != nil {
+		t.Fatalf("Failed to set configuration: %v", err)
+	}
+	if err := s.Wipe(); err != nil {
+		t.Fatalf("Failed to wipe: %v", err)
+	}
+	expect(t, s, &filter.F{Kinds: kinds.New(kind.TextNote)})
+	if n, err := s.EventCount(); err != nil || n != 0 {
+		t.Fatalf("Expected no events after a wipe, got %d %v", n, err)
+	}
+	// the configuration is kept
+	if got, err := s.GetConfiguration(); err != nil || got == nil ||
+		len(got.Owners) != 1 {
+		t.Fatalf("Expected the configuration to be kept, got %v %v", got, err)
+	}
+}
+
+func testConfiguration(t *testing.T, s store.I) {
+	conf, err := s.GetConfiguration()
+	if err != nil || conf != nil {
+		t.Fatalf("Expected no configuration, got %v %v", conf, err)
+	}
+	conf = &store.Configuration{
+		Owners: []string{"owner"}, PeerRelays: []string{"pk@url"},
+		MaxLimit: 100,
+	}
+	if err = s.SetConfiguration(conf); err != nil {
+		t.Fatalf("Failed to set configuration: %v", err)
+	}
+	var got *store.Configuration
+	if got, err = s.GetConfiguration(); err != nil {
+		t.Fatalf("Failed to get configuration: %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(conf) {
+		t.Fatalf("Expected %v, got %v", conf, got)
+	}
+}
+
+func testReplication(t *testing.T, s store.I) {
+	peer1, peer2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	for i := range 10 {
+		peers := [][]byte{peer1}
+		if i%2 == 0 {
+			peers = append(peers, peer2)
+		}
+		if err := s.EnqueueReplication(
+			peers, &store.ReplicationItem{
+				Queued: int64(1000 + i), Event: []byte{byte(i)},
+			},
+		); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+	items, err := s.ReplicationBatch(peer1, 4)
+	if err != nil || len(items) != 4 {
+		t.Fatalf("Expected 4 items, got %d %v", len(items), err)
+	}
+	for i, item := range items {
+		if item.Event[0] != byte(i) {
+			t.Fatalf("Expected item %d in order, got %d", i, item.Event[0])
+		}
+	}
+	// zero is before every item, as it is the Seq of nothing acked.
+	if items[0].Seq == 0 {
+		t.Fatal("Expected the first Seq to be 1, got 0")
+	}
+	if err = s.AckReplication(peer1, items[2].Seq); err != nil {
+		t.Fatalf("Failed to ack: %v", err)
+	}
+	n, oldest, err := s.ReplicationQueueLen(peer1)
+	if err != nil || n != 7 || oldest != 1003 {
+		t.Fatalf("Expected 7 items from 1003, got %d from %d", n, oldest)
+	}
+	if n, oldest, err = s.ReplicationQueueLen(peer2); err != nil || n != 5 ||
+		oldest != 1000 {
+		t.Fatalf("Expected 5 items from 1000, got %d from %d", n, oldest)
+	}
+	if err = s.DropReplication(peer2); err != nil {
+		t.Fatalf("Failed to drop queue: %v", err)
+	}
+	if n, _, err = s.ReplicationQueueLen(peer2); err != nil || n != 0 {
+		t.Fatalf("Expected the dropped queue to be empty, got %d %v", n, err)
+	}
+}
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"orly.dev/pkg/database/storetest"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"testing"
+)
+
+// TestConformance runs the suite with the replace policy of the relay, and
+// TestConformanceHistory with every superseded version retained.
+func TestConformance(t *testing.T) {
+	storetest.Run(t, opener(DefaultReplacePolicy))
+}
+
+func TestConformanceHistory(t *testing.T) {
+	storetest.Run(t, opener(ReplacePolicy{History: true}))
+}
+
+func opener(p ReplacePolicy) storetest.Opener {
+	return func(t *testing.T) store.I {
+		ctx, cancel := context.Cancel(context.Bg())
+		t.Cleanup(cancel)
+		db, err := New(ctx, cancel, t.TempDir(), "error")
+		if err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		db.SetReplacePolicy(p)
+		db.SetTagRules(ParseTagRules(storetest.TagRules))
+		return db
+	}
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// StreamEvents runs a query for a filter and calls fn with each matching event
+// as it is fetched, instead of collecting the results in memory.
+//
+// Events are yielded in reverse chronological order (or in order of relevance
+// for a search), up to the Limit of the filter, and only the newest
+// version of a replaceable or parameterized replaceable event is yielded.
+// Deletion events and expired events are not yielded. The events of a filter
+// with Ids are those with the ids that also match the rest of the filter.
+//
+// The query stops when fn returns false, or when the context is cancelled, in
+// which case the error of the context is returned.
+func (d *D) StreamEvents(
+	c context.T, f *filter.F, fn func(ev *event.E) (more bool),
+) (err error) {
+	// events that have expired (NIP-40) are not returned, even if they have not
+	// yet been deleted.
+	now := time.Now().Unix()
+	// if there is Ids in the query, the events are found by them, and the
+	// rest of the filter is checked on each.
+	if f.Ids != nil && f.Ids.Len() > 0 {
+		var evs event.S
+		for _, idx := range f.Ids.ToSliceOfBytes() {
+			var ser *types.Uint40
+			if ser, err = d.GetSerialById(idx); chk.E(err) {
+				continue
+			}
+			var ev *event.E
+			if ev, err = d.FetchEventBySerial(ser); err != nil {
+				continue
+			}
+			if ev.IsExpired(now) || !f.Matches(ev) {
+				continue
+			}
+			evs = append(evs, ev)
+		}
+		err = nil
+		// sort the events by timestamp
+		sort.Slice(
+			evs, func(i, j int) bool {
+				return evs[i].CreatedAt.I64() > evs[j].CreatedAt.I64()
+			},
+		)
+		for _, ev := range evs {
+			if err = c.Err(); err != nil {
+				return
+			}
+			if !fn(ev) {
+				return
+			}
+		}
+		return
+	}
+	// the ids, pubkeys and timestamps are small, the events are only fetched
+	// one at a time as they are yielded.
+	_, err = d.streamQuery(c, f, now, fn)
+	return
+}
+
+// streamQuery runs QueryForIds for a filter without Ids and calls fn with the
+// events of the results that streamIds yields.
+//
+// The deletion events, expired events and older versions that streamIds
+// leaves out would make the results shorter than the Limit of the filter, so
+// the query is run again from a cursor after the last of the results, until
+// Limit events have been yielded or the results run out. The results of a
+// search are in order of relevance instead, which a cursor doesn't apply to,
+// so they are all found and the Limit is applied to the events yielded.
+//
+// If Limit events were yielded, last is the last result that was scanned for
+// them, after which the results may go on.
+func (d *D) streamQuery(
+	c context.T, f *filter.F, now int64, fn func(ev *event.E) (more bool),
+) (last *store.IdPkTs, err error) {
+	seen := make(map[string]struct{})
+	var idPkTs []store.IdPkTs
+	if f.Limit == nil || len(f.Search) > 0 {
+		ff := *f
+		ff.Limit = nil
+		if idPkTs, err = d.QueryForIds(c, &ff); chk.E(err) {
+			return
+		}
+		limit := -1
+		if f.Limit != nil {
+			limit = int(*f.Limit)
+		}
+		_, _, _, err = d.streamIds(c, idPkTs, now, seen, limit, fn)
+		return
+	}
+	ff := *f
+	for remaining := int(*f.Limit); remaining > 0; {
+		limit := uint(remaining)
+		ff.Limit = &limit
+		if idPkTs, err = d.QueryForIds(c, &ff); chk.E(err) {
+			return
+		}
+		var scanned, yielded int
+		var more bool
+		if scanned, yielded, more, err = d.streamIds(
+			c, idPkTs, now, seen, remaining, fn,
+		); err != nil || !more || scanned == 0 {
+			return
+		}
+		l := idPkTs[scanned-1]
+		last = &l
+		if remaining -= yielded; remaining <= 0 {
+			return
+		}
+		if len(idPkTs) < int(limit) {
+			// the results ran out.
+			last = nil
+			return
+		}
+		ff.Cursor = &filter.Cursor{Ts: last.Ts, Ser: last.Ser}
+	}
+	return
+}
+
+// streamIds fetches the events of the results of QueryForIds and calls fn with
+// each that is not a deletion event, expired at now, or an older version of a
+// replaceable event of the results, until limit events have been yielded, if
+// it isn't negative.
+//
+// The replaceable events yielded are recorded in seen, so that older versions
+// in the results of a later query from a cursor are left out too. It returns
+// the number of results that were scanned and of events yielded, and false if
+// fn returned false.
+func (d *D) streamIds(
+	c context.T, idPkTs []store.IdPkTs, now int64, seen map[string]struct{},
+	limit int, fn func(ev *event.E) (more bool),
+) (scanned, yielded int, more bool, err error) {
+	// the results are newest first, so the first version of a replaceable
+	// event that is found is the one to keep.
+	for _, idpk := range idPkTs {
+		if limit >= 0 && yielded >= limit {
+			break
+		}
+		if err = c.Err(); err != nil {
+			return
+		}
+		scanned++
+		var ev *event.E
+		ser := new(types.Uint40)
+		if err = ser.Set(idpk.Ser); chk.E(err) {
+			continue
+		}
+		if ev, err = d.FetchEventBySerial(ser); err != nil {
+			continue
+		}
+		// Skip events with kind 5 (Deletion)
+		if ev.Kind.Equal(kind.Deletion) {
+			continue
+		}
+		// Skip expired events
+		if ev.IsExpired(now) {
+			continue
+		}
+		if ev.Kind.IsReplaceable() || ev.Kind.IsParameterizedReplaceable() {
+			key, dValue := replaceableKey(ev)
+			k := key + ":" + dValue
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+		}
+		yielded++
+		if !fn(ev) {
+			err = nil
+			return
+		}
+	}
+	err = nil
+	more = true
+	return
+}
+
+// replaceableKey returns the pubkey and kind key of a replaceable event, and
+// the value of its d tag, which is empty if it has none.
+func replaceableKey(ev *event.E) (key, dValue string) {
+	key = string(ev.Pubkey) + ":" + strconv.Itoa(int(ev.Kind.K))
+	if ev.Kind.IsParameterizedReplaceable() {
+		dTag := ev.Tags.GetFirst(tag.New([]byte{'d'}))
+		if dTag != nil && dTag.Len() > 1 {
+			dValue = string(dTag.Value())
+		}
+	}
+	return
+}
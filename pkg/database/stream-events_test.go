@@ -0,0 +1,135 @@
+package database
+
+import (
+	"errors"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStreamEvents(t *testing.T) {
+	db, _, ctx, cancel, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir) // Clean up after the test
+	defer cancel()
+	defer db.Close()
+
+	limit := uint(20)
+	f := &filter.F{Kinds: kinds.New(kind.New(1)), Limit: &limit}
+
+	// the streamed events are the same as the queried events, newest first.
+	evs, err := db.QueryEvents(ctx, f)
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	var streamed event.S
+	if err = db.StreamEvents(
+		ctx, f, func(ev *event.E) bool {
+			streamed = append(streamed, ev)
+			return true
+		},
+	); err != nil {
+		t.Fatalf("Failed to stream events: %v", err)
+	}
+	if len(streamed) != len(evs) || len(streamed) > int(limit) {
+		t.Fatalf("Expected %d events, got %d", len(evs), len(streamed))
+	}
+	for i := range streamed {
+		if i > 0 && streamed[i].CreatedAt.I64() > streamed[i-1].CreatedAt.I64() {
+			t.Fatalf("Event %d is newer than the event before it", i)
+		}
+	}
+
+	// returning false stops the query.
+	var n int
+	if err = db.StreamEvents(
+		ctx, f, func(ev *event.E) bool {
+			n++
+			return n < 3
+		},
+	); err != nil {
+		t.Fatalf("Failed to stream events: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("Expected the query to stop after 3 events, got %d", n)
+	}
+
+	// cancelling the context stops the query and returns the error.
+	c, stop := context.Cancel(ctx)
+	n = 0
+	err = db.StreamEvents(
+		c, f, func(ev *event.E) bool {
+			n++
+			stop()
+			return true
+		},
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected the query to stop after 1 event, got %d", n)
+	}
+}
+
+func TestStreamEventsLimit(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	d.SetReplacePolicy(ReplacePolicy{History: true})
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("pubkey"))
+	// the newest results are the versions of a profile, the older of which
+	// are left out, then the notes. They are saved oldest first, as an older
+	// version is refused after a newer one.
+	notes := make([]*event.E, 2)
+	for i := 5; i >= 0; i-- {
+		ev := event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(i)))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - int64(i))
+		ev.Kind = kind.ProfileMetadata
+		if i >= 4 {
+			ev.Kind = kind.TextNote
+			notes[i-4] = ev
+		}
+		ev.Tags = tags.New()
+		if _, _, err = d.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limit := uint(3)
+	f := &filter.F{Authors: tag.New(pk[:]), Limit: &limit}
+	var streamed event.S
+	if err = d.StreamEvents(
+		ctx, f, func(ev *event.E) bool {
+			streamed = append(streamed, ev)
+			return true
+		},
+	); err != nil {
+		t.Fatalf("Failed to stream events: %v", err)
+	}
+	if len(streamed) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(streamed))
+	}
+	if !streamed[0].Kind.Equal(kind.ProfileMetadata) ||
+		string(streamed[1].ID) != string(notes[0].ID) ||
+		string(streamed[2].ID) != string(notes[1].ID) {
+		t.Fatal("Expected the newest profile and the notes")
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+)
+
+// StreamOldest calls fn with each stored event, oldest first in the order of
+// the CreatedAt index, until fn returns false or the context is cancelled, in
+// which case the error of the context is returned.
+//
+// Unlike StreamEvents, every stored event is yielded, including deletion
+// events, expired events and superseded versions of replaceable events, as
+// they all take up space.
+func (d *D) StreamOldest(
+	c context.T, fn func(ev *event.E) (more bool),
+) (err error) {
+	prf := []byte(indexes.CreatedAtPrefix)
+	err = d.View(
+		func(txn *badger.Txn) (err error) {
+			it := txn.NewIterator(
+				badger.IteratorOptions{Prefix: prf, PrefetchValues: false},
+			)
+			defer it.Close()
+			for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+				if err = c.Err(); err != nil {
+					return
+				}
+				key := it.Item().Key()
+				ser := new(types.Uint40)
+				if err = ser.UnmarshalRead(
+					bytes.NewBuffer(key[len(key)-5:]),
+				); chk.E(err) {
+					return
+				}
+				var ev *event.E
+				if ev, err = fetchEventBySerial(txn, ser); err != nil {
+					// the event was deleted after its index was read.
+					err = nil
+					continue
+				}
+				if !fn(ev) {
+					return
+				}
+			}
+			return
+		},
+	)
+	return
+}
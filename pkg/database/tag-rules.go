@@ -0,0 +1,245 @@
+package database
+
+import (
+	"bytes"
+	"math"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/log"
+	"strconv"
+	"strings"
+)
+
+// TagType is how the values of a tag of a TagRule are indexed.
+type TagType int
+
+const (
+	// TagText values are indexed by their hash, so they are matched exactly.
+	TagText TagType = iota
+	// TagNumber values are indexed in numeric order, so they can be matched
+	// by a range as well.
+	TagNumber
+	// TagTimestamp values are unix timestamps, or dates, which are indexed
+	// as the numbers of their unix timestamps, like TagNumber.
+	TagTimestamp
+)
+
+// tagTypes are the names of the TagType values in the configuration.
+var tagTypes = map[string]TagType{
+	"text": TagText, "number": TagNumber, "timestamp": TagTimestamp,
+}
+
+// TagRule is a tag that is indexed in addition to the first value of the
+// tags with a one letter key, which are always indexed: a tag with a longer
+// name, a value at another position, or values indexed in order.
+type TagRule struct {
+	Name     string
+	Position int
+	Type     TagType
+}
+
+// ParseTagRules parses the tag rules of the configuration. Each is the name
+// of a tag, optionally followed by a colon and the position of the value in
+// the tag, 1 if it is left out, and a colon and the type of the value, text,
+// number or timestamp, text if it is left out. For example "imeta", "p:3" or
+// "price:1:number".
+//
+// Invalid rules are logged and skipped, as are text rules for the first value
+// of a tag with a one letter key, which is already indexed.
+func ParseTagRules(specs []string) (rules []TagRule) {
+	for _, spec := range specs {
+		if spec == "" {
+			continue
+		}
+		fields := strings.Split(spec, ":")
+		rule := TagRule{Name: fields[0], Position: 1}
+		var err error
+		if len(fields) > 1 && fields[1] != "" {
+			if rule.Position, err = strconv.Atoi(fields[1]); err != nil ||
+				rule.Position < 1 || rule.Position > math.MaxUint16 {
+				log.W.F("invalid position in tag index rule %q", spec)
+				continue
+			}
+		}
+		if len(fields) > 2 {
+			var ok bool
+			if rule.Type, ok = tagTypes[fields[2]]; !ok {
+				log.W.F("invalid type in tag index rule %q", spec)
+				continue
+			}
+		}
+		if rule.Name == "" || len(fields) > 3 {
+			log.W.F("invalid tag index rule %q", spec)
+			continue
+		}
+		if len(rule.Name) == 1 && rule.Position == 1 && rule.Type == TagText {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
+
+// SetTagRules sets the rules of the tags that are indexed in addition to the
+// first value of the tags with a one letter key.
+//
+// The store must be rescanned after the rules change: the events that were
+// saved before a rule was added are only indexed by it then, and the keys of
+// a rule that was removed are only dropped then, as an event that is deleted
+// only has the keys of the rules it is deleted under removed, and the rest
+// would otherwise be left behind.
+func (d *D) SetTagRules(rules []TagRule) {
+	d.tagRulesMx.Lock()
+	defer d.tagRulesMx.Unlock()
+	d.tagRules = rules
+}
+
+// tagRule returns the rule for the values at a position of the tags with a
+// name, or nil if they are not indexed by a rule. A typed rule is preferred,
+// as it also matches exact values.
+func (d *D) tagRule(name []byte, position int) (rule *TagRule) {
+	d.tagRulesMx.RLock()
+	defer d.tagRulesMx.RUnlock()
+	for i, r := range d.tagRules {
+		if r.Name != string(name) || r.Position != position {
+			continue
+		}
+		if rule == nil || r.Type != TagText {
+			rule = &d.tagRules[i]
+		}
+	}
+	return
+}
+
+// indexesForEvent returns the keys of all the indexes of an event, those of
+// GetIndexesForEvent and those of the current tag rules, which are not those
+// it was saved with if the rules changed without a rescan since.
+func (d *D) indexesForEvent(ev *event.E, serial uint64) (
+	idxs [][]byte, err error,
+) {
+	if idxs, err = GetIndexesForEvent(ev, serial); chk.E(err) {
+		return
+	}
+	d.tagRulesMx.RLock()
+	defer d.tagRulesMx.RUnlock()
+	if len(d.tagRules) == 0 || ev.Tags == nil {
+		return
+	}
+	ser := new(types.Uint40)
+	if err = ser.Set(serial); chk.E(err) {
+		return
+	}
+	ca := new(types.Uint64)
+	ca.Set(uint64(ev.CreatedAt.V))
+	for _, rule := range d.tagRules {
+		name, pos := ruleKey(rule)
+		for _, t := range ev.Tags.ToSliceOfTags() {
+			if t.Len() <= rule.Position || string(t.Key()) != rule.Name {
+				continue
+			}
+			v := t.B(rule.Position)
+			var idx *indexes.T
+			if rule.Type == TagText {
+				vh := new(types.Ident)
+				vh.FromIdent(v)
+				idx = indexes.TagNameEnc(name, pos, vh, ca, ser)
+			} else {
+				n, ok := filter.TagNumber(v)
+				if !ok {
+					continue
+				}
+				ov := new(types.Uint64)
+				ov.Set(orderedNumber(n))
+				idx = indexes.TagValueEnc(name, pos, ov, ca, ser)
+			}
+			buf := new(bytes.Buffer)
+			if err = idx.MarshalWrite(buf); chk.E(err) {
+				return
+			}
+			idxs = append(idxs, buf.Bytes())
+		}
+	}
+	return
+}
+
+// ruleKey returns the hash of the name and the position of a rule, that its
+// keys start with.
+func ruleKey(rule TagRule) (name *types.Ident, pos *types.Uint16) {
+	name, pos = new(types.Ident), new(types.Uint16)
+	name.FromIdent([]byte(rule.Name))
+	pos.Set(uint16(rule.Position))
+	return
+}
+
+// orderedNumber encodes a number so that the encodings of numbers are in the
+// same order as the numbers: the sign bit of a positive number is flipped so
+// it sorts after the negative numbers, and all the bits of a negative number
+// are flipped so that the more negative numbers sort first. Negative zero is
+// encoded as zero.
+func orderedNumber(n float64) (u uint64) {
+	if n == 0 {
+		n = 0
+	}
+	u = math.Float64bits(n)
+	if n >= 0 {
+		return u ^ 1<<63
+	}
+	return ^u
+}
+
+// tagRuleRanges returns the ranges of the index of a rule that match the
+// values of a tag of a filter, and the timestamps of the filter. The ranges of
+// a typed rule are of values, not timestamps, so the timestamps of the events
+// found in them have to be checked.
+func tagRuleRanges(rule TagRule, t *tag.T, f *filter.F) (
+	ranges []Range, err error,
+) {
+	name, pos := ruleKey(rule)
+	caStart, caEnd := new(types.Uint64), new(types.Uint64)
+	caEnd.Set(math.MaxInt64)
+	if f.Since != nil && f.Since.V != 0 {
+		caStart.Set(uint64(f.Since.V))
+	}
+	if f.Until != nil && f.Until.V != 0 {
+		caEnd.Set(uint64(f.Until.V + 1))
+	}
+	for _, v := range t.ToSliceOfBytes()[1:] {
+		var start, end *indexes.T
+		if rule.Type == TagText {
+			vh := new(types.Ident)
+			vh.FromIdent(v)
+			start = indexes.TagNameEnc(name, pos, vh, caStart, nil)
+			end = indexes.TagNameEnc(name, pos, vh, caEnd, nil)
+		} else {
+			min, max, ok := filter.TagRange(v)
+			if !ok {
+				if min, ok = filter.TagNumber(v); !ok {
+					continue
+				}
+				max = min
+			}
+			lo, hi := new(types.Uint64), new(types.Uint64)
+			lo.Set(orderedNumber(min))
+			hi.Set(orderedNumber(max))
+			// the whole of the last value is in the range, whatever its
+			// timestamp.
+			maxTs := new(types.Uint64)
+			maxTs.Set(math.MaxUint64)
+			start = indexes.TagValueEnc(name, pos, lo, nil, nil)
+			end = indexes.TagValueEnc(name, pos, hi, maxTs, nil)
+		}
+		s, e := new(bytes.Buffer), new(bytes.Buffer)
+		if err = start.MarshalWrite(s); chk.E(err) {
+			return
+		}
+		if err = end.MarshalWrite(e); chk.E(err) {
+			return
+		}
+		ranges = append(ranges, Range{s.Bytes(), e.Bytes()})
+	}
+	return
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseTagRules(t *testing.T) {
+	rules := ParseTagRules(
+		[]string{
+			"imeta:2", "price::number", "start:1:timestamp", "t", "p:0",
+			"x:1:float", "", "e:2",
+		},
+	)
+	expected := []TagRule{
+		{"imeta", 2, TagText}, {"price", 1, TagNumber},
+		{"start", 1, TagTimestamp}, {"e", 2, TagText},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("parsed %v, expected %v", rules, expected)
+	}
+	for i := range rules {
+		if rules[i] != expected[i] {
+			t.Fatalf("parsed %v, expected %v", rules, expected)
+		}
+	}
+}
+
+func TestOrderedNumber(t *testing.T) {
+	numbers := []float64{-1e9, -2.5, -1, 0, 0.5, 1, 3, 1e12}
+	for i := 1; i < len(numbers); i++ {
+		if orderedNumber(numbers[i-1]) >= orderedNumber(numbers[i]) {
+			t.Fatalf("%f doesn't sort before %f", numbers[i-1], numbers[i])
+		}
+	}
+}
+
+func TestTagRules(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	d.SetTagRules(
+		ParseTagRules(
+			[]string{"imeta:2", "price:1:number", "start:1:timestamp"},
+		),
+	)
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("seller"))
+	var evs []*event.E
+	save := func(tt ...*tag.T) {
+		ev := event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(len(evs))))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - int64(len(evs)))
+		ev.Kind = kind.TextNote
+		ev.Content = []byte("listing " + strconv.Itoa(len(evs)))
+		ev.Tags = tags.New(tt...)
+		if _, _, err := d.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	for i := 0; i < 20; i++ {
+		mime := "m image/png"
+		if i%4 == 0 {
+			mime = "m video/mp4"
+		}
+		save(
+			tag.New("imeta", "url https://example.com/"+strconv.Itoa(i), mime),
+			tag.New("price", strconv.Itoa(i*5)),
+			tag.New("start", "2024-01-"+strconv.Itoa(10+i)),
+		)
+	}
+	if err = d.loadCardinality(); err != nil {
+		t.Fatal(err)
+	}
+	limit := uint(2)
+	since := timestamp.FromUnix(now - 3)
+	for _, tc := range []struct {
+		name    string
+		f       *filter.F
+		index   string
+		results int
+	}{
+		{
+			"second value", &filter.F{
+				Tags: tags.New(tag.New("#imeta:2", "m video/mp4")),
+			}, "tgn", 5,
+		},
+		{
+			"number range", &filter.F{
+				Tags: tags.New(tag.New("#price", "10..30")),
+			}, "tgv", 5,
+		},
+		{
+			"numbers and ranges", &filter.F{
+				Tags: tags.New(tag.New("#price", "0", "90..")),
+			}, "tgv", 3,
+		},
+		{
+			"dates with a limit", &filter.F{
+				Tags:  tags.New(tag.New("#start", "2024-01-15..")),
+				Limit: &limit,
+			}, "tgv", 2,
+		},
+		{
+			"dates since", &filter.F{
+				Tags:  tags.New(tag.New("#start", "..2024-01-15")),
+				Since: since,
+			}, "tgv", 4,
+		},
+		{
+			"not indexed", &filter.F{
+				Kinds: kinds.New(kind.TextNote),
+				Tags:  tags.New(tag.New("#imeta:3", "x")),
+			}, "kc-", 0,
+		},
+	} {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				var plan *store.QueryPlan
+				if plan, err = d.Explain(ctx, tc.f); err != nil {
+					t.Fatal(err)
+				}
+				if plan.Index != tc.index || plan.Results != tc.results {
+					t.Fatalf(
+						"plan %s found %d events, expected %s and %d",
+						plan.Index, plan.Results, tc.index, tc.results,
+					)
+				}
+				var idPkTs []store.IdPkTs
+				if idPkTs, err = d.QueryForIds(ctx, tc.f); err != nil {
+					t.Fatal(err)
+				}
+				// the results are the newest of the events that match.
+				var n int
+				for _, ev := range evs {
+					if n == len(idPkTs) {
+						break
+					}
+					if !tc.f.Matches(ev) {
+						continue
+					}
+					if string(idPkTs[n].Id) != string(ev.ID) {
+						t.Fatalf(
+							"result %d is %0x, expected %0x", n, idPkTs[n].Id,
+							ev.ID,
+						)
+					}
+					n++
+				}
+				if n != tc.results {
+					t.Fatalf("found %d events, expected %d", n, tc.results)
+				}
+				var count int
+				if count, _, err = d.CountEvents(ctx, tc.f); err != nil {
+					t.Fatal(err)
+				}
+				if tc.f.Limit == nil && count != tc.results {
+					t.Fatalf(
+						"counted %d events, expected %d", count, tc.results,
+					)
+				}
+			},
+		)
+	}
+	// the tag of a rule that is removed is checked on the events the other
+	// fields find instead, and its keys are dropped by a rescan.
+	d.SetTagRules(nil)
+	if err = d.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+	f := &filter.F{Tags: tags.New(tag.New("#price", "10..30"))}
+	if _, err = d.QueryForIds(ctx, f); err == nil {
+		t.Fatal("a tag query no index covers was not refused")
+	}
+	f.Kinds = kinds.New(kind.TextNote)
+	var plan *store.QueryPlan
+	if plan, err = d.Explain(ctx, f); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Index != "kc-" || plan.Results != 5 {
+		t.Fatalf(
+			"plan %s found %d events after the rule was removed, expected "+
+				"kc- and 5", plan.Index, plan.Results,
+		)
+	}
+}
@@ -0,0 +1,329 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/database/indexes/types"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tag/atag"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// appendTombstones appends the tombstone indexes of a NIP-09 deletion event to
+// idxs: a TombstoneId for each event id in an e tag, and a TombstoneAddress for
+// each address in an a tag.
+//
+// Because the tombstones are indexes of the deletion event, they are written,
+// regenerated and removed along with it, so they can't depend on the owners of
+// the relay, which can change. A tombstone of an address is written whoever
+// its author is, and like the tombstone of an id, checkTombstones only applies
+// it if the deletion is by the author or one of the owners, the same rule
+// deletionTargets applies to the events it deletes.
+func appendTombstones(
+	idxs *[][]byte, ev *event.E, pubHash *types.PubHash,
+	createdAt *types.Uint64, ser *types.Uint40,
+) (err error) {
+	for _, t := range ev.Tags.GetAll(tag.New([]byte{'e'})).ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		id := make([]byte, sha256.Size)
+		if _, err = hex.DecBytes(id, t.Value()); err != nil {
+			err = nil
+			continue
+		}
+		idHash := new(types.IdHash)
+		if err = idHash.FromId(id); chk.E(err) {
+			return
+		}
+		if err = appendIndexBytes(
+			idxs, indexes.TombstoneIdEnc(idHash, pubHash, ser),
+		); chk.E(err) {
+			return
+		}
+	}
+	for _, t := range ev.Tags.GetAll(tag.New([]byte{'a'})).ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		a := new(atag.T)
+		if _, err = a.Unmarshal(t.Value()); err != nil || a.Kind == nil {
+			err = nil
+			continue
+		}
+		// only replaceable events have an address.
+		if !isAddressable(a.Kind) {
+			continue
+		}
+		k := new(types.Uint16)
+		k.Set(a.Kind.K)
+		author := new(types.PubHash)
+		if err = author.FromPubkey(a.PubKey); err != nil {
+			err = nil
+			continue
+		}
+		dTag := new(types.Ident)
+		dTag.FromIdent(a.DTag)
+		if err = appendIndexBytes(
+			idxs,
+			indexes.TombstoneAddressEnc(k, author, dTag, createdAt, ser),
+		); chk.E(err) {
+			return
+		}
+	}
+	return
+}
+
+// isAddressable returns true if events of a kind are identified by an address
+// of their kind, pubkey and d tag.
+func isAddressable(k *kind.T) bool {
+	return k.IsReplaceable() || k.IsParameterizedReplaceable()
+}
+
+// mayDelete returns true if a deletion event by pubkey may delete the events
+// of author, which is if it is the author, or one of the owners.
+func mayDelete(pubkey, author []byte, owners [][]byte) bool {
+	if bytes.Equal(pubkey, author) {
+		return true
+	}
+	for _, owner := range owners {
+		if bytes.Equal(owner, pubkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTombstones returns an error if an event has been deleted by a NIP-09
+// deletion event, either by its id, by its author or one of the owners, or by
+// its address, with a deletion that is not older than the event.
+//
+// It is run in the transaction that saves the event, so a deletion that is
+// saved at the same time conflicts with it.
+func (d *D) checkTombstones(
+	txn *badger.Txn, ev *event.E, owners [][]byte,
+) (err error) {
+	idHash := new(types.IdHash)
+	if err = idHash.FromId(ev.ID); chk.E(err) {
+		return
+	}
+	for _, pk := range append([][]byte{ev.Pubkey}, owners...) {
+		pubHash := new(types.PubHash)
+		if err = pubHash.FromPubkey(pk); chk.E(err) {
+			return
+		}
+		prf := new(bytes.Buffer)
+		if err = indexes.TombstoneIdEnc(
+			idHash, pubHash, nil,
+		).MarshalWrite(prf); chk.E(err) {
+			return
+		}
+		it := txn.NewIterator(
+			badger.IteratorOptions{Prefix: prf.Bytes()},
+		)
+		it.Seek(prf.Bytes())
+		found := it.ValidForPrefix(prf.Bytes())
+		it.Close()
+		if found {
+			err = errorf.E("blocked: event %0x deleted by event ID", ev.ID)
+			return
+		}
+	}
+	if !isAddressable(ev.Kind) {
+		return
+	}
+	k := new(types.Uint16)
+	k.Set(ev.Kind.K)
+	pubHash := new(types.PubHash)
+	if err = pubHash.FromPubkey(ev.Pubkey); chk.E(err) {
+		return
+	}
+	_, dValue := replaceableKey(ev)
+	dTag := new(types.Ident)
+	dTag.FromIdent([]byte(dValue))
+	prf := new(bytes.Buffer)
+	if err = indexes.TombstoneAddressEnc(
+		k, pubHash, dTag, nil, nil,
+	).MarshalWrite(prf); chk.E(err) {
+		return
+	}
+	// the timestamps of the tombstones of an address are in ascending order,
+	// so any key at or after the timestamp of the event is a deletion that is
+	// not older than it.
+	ca := new(types.Uint64)
+	ca.Set(uint64(ev.CreatedAt.I64()))
+	from := bytes.NewBuffer(bytes.Clone(prf.Bytes()))
+	if err = ca.MarshalWrite(from); chk.E(err) {
+		return
+	}
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prf.Bytes()})
+	defer it.Close()
+	for it.Seek(from.Bytes()); it.ValidForPrefix(prf.Bytes()); it.Next() {
+		key := it.Item().Key()
+		ser := new(types.Uint40)
+		if err = ser.UnmarshalRead(
+			bytes.NewBuffer(key[len(key)-5:]),
+		); chk.E(err) {
+			return
+		}
+		var deletion *event.E
+		if deletion, err = fetchEventBySerial(txn, ser); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				err = nil
+				continue
+			}
+			return
+		}
+		if mayDelete(deletion.Pubkey, ev.Pubkey, owners) {
+			err = errorf.E(
+				"blocked: %0x was deleted by address because it is not newer than the delete",
+				ev.ID,
+			)
+			return
+		}
+	}
+	return
+}
+
+// deletionTargets returns the keys of the events that a NIP-09 deletion event
+// deletes, and of all their indexes, found in the transaction that saves the
+// deletion so they are removed in it, and the changes of the log that record
+// them.
+//
+// An event referenced by an e tag is deleted if it was published by the author
+// of the deletion, or the author is one of the owners. Only that version of a
+// replaceable event is deleted, the others of its address are only deleted by
+// an a tag, which deletes all versions of the address that are not newer than
+// the deletion, with the same rule for the author. Deletion events are never
+// deleted. The events are metered in m.
+func (d *D) deletionTargets(
+	txn *badger.Txn, ev *event.E, owners [][]byte, m metered,
+) (keys [][]byte, gone []*store.Change, err error) {
+	targets := make(map[uint64]*event.E)
+	for _, t := range ev.Tags.GetAll(tag.New([]byte{'e'})).ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		id := make([]byte, sha256.Size)
+		if _, err = hex.DecBytes(id, t.Value()); err != nil {
+			err = nil
+			continue
+		}
+		var ser *types.Uint40
+		if ser, err = getSerialById(txn, id); chk.E(err) {
+			return
+		}
+		if ser == nil {
+			continue
+		}
+		var target *event.E
+		if target, err = fetchEventBySerial(txn, ser); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				err = nil
+				continue
+			}
+			return
+		}
+		if target.Kind.Equal(kind.Deletion) ||
+			!mayDelete(ev.Pubkey, target.Pubkey, owners) {
+			continue
+		}
+		targets[ser.Get()] = target
+	}
+	for _, t := range ev.Tags.GetAll(tag.New([]byte{'a'})).ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		a := new(atag.T)
+		if _, err = a.Unmarshal(t.Value()); err != nil || a.Kind == nil {
+			err = nil
+			continue
+		}
+		if !mayDelete(ev.Pubkey, a.PubKey, owners) ||
+			!isAddressable(a.Kind) {
+			continue
+		}
+		if err = addressVersions(
+			txn, targets, a.Kind, a.PubKey, a.DTag, ev.CreatedAt.I64(),
+		); chk.E(err) {
+			return
+		}
+	}
+	for serial, target := range targets {
+		ser := new(types.Uint40)
+		if err = ser.Set(serial); chk.E(err) {
+			return
+		}
+		k := new(bytes.Buffer)
+		if err = indexes.EventEnc(ser).MarshalWrite(k); chk.E(err) {
+			return
+		}
+		keys = append(keys, k.Bytes())
+		var idxs [][]byte
+		if idxs, err = d.indexesForEvent(target, serial); chk.E(err) {
+			return
+		}
+		keys = append(keys, idxs...)
+		gone = append(
+			gone, deletedChange(target, serial, store.ReasonDeletion, ev.ID),
+		)
+		m.add(target, true)
+	}
+	return
+}
+
+// addressVersions adds the versions of the event with a kind, pubkey and d
+// tag stored in a transaction that are not newer than until to targets.
+func addressVersions(
+	txn *badger.Txn, targets map[uint64]*event.E, k *kind.T,
+	pubkey, dTag []byte, until int64,
+) (err error) {
+	var prf []byte
+	if prf, err = addressPrefixOf(k, pubkey, dTag); err != nil {
+		// not a valid pubkey, so there are no versions.
+		err = nil
+		return
+	}
+	var sers []*types.Uint40
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+	for it.Seek(prf); it.ValidForPrefix(prf); it.Next() {
+		key := it.Item().Key()
+		ser := new(types.Uint40)
+		if err = ser.UnmarshalRead(
+			bytes.NewBuffer(key[len(key)-5:]),
+		); chk.E(err) {
+			it.Close()
+			return
+		}
+		sers = append(sers, ser)
+	}
+	it.Close()
+	for _, ser := range sers {
+		if _, ok := targets[ser.Get()]; ok {
+			continue
+		}
+		var ev *event.E
+		if ev, err = fetchEventBySerial(txn, ser); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				err = nil
+				continue
+			}
+			return
+		}
+		// the d tag index is a hash, so the value is checked exactly.
+		if _, dValue := replaceableKey(ev); dValue != string(dTag) ||
+			ev.CreatedAt.I64() > until {
+			continue
+		}
+		targets[ser.Get()] = ev
+	}
+	return
+}
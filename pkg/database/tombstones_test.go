@@ -0,0 +1,75 @@
+package database
+
+import (
+	"orly.dev/pkg/crypto/p256k"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+)
+
+func TestDeletionTargets(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	db, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	// the older versions are retained, so that there are several to delete.
+	db.SetReplacePolicy(ReplacePolicy{History: true})
+	sign := new(p256k.Signer)
+	if err = sign.Generate(); chk.E(err) {
+		t.Fatal(err)
+	}
+	now := timestamp.Now().V
+	newEvent := func(
+		k uint16, createdAt int64, content string, tg ...*tag.T,
+	) (ev *event.E) {
+		ev = event.New()
+		ev.Kind = kind.New(k)
+		ev.Pubkey = sign.Pub()
+		ev.CreatedAt = timestamp.FromUnix(createdAt)
+		ev.Content = []byte(content)
+		ev.Tags = tags.New(tg...)
+		if err := ev.Sign(sign); chk.E(err) {
+			t.Fatal(err)
+		}
+		if _, _, err := db.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatalf("Failed to save event: %v", err)
+		}
+		return
+	}
+	stored := func(ev *event.E) bool {
+		ser, _ := db.GetSerialById(ev.ID)
+		return ser != nil
+	}
+	d := tag.New("d", "article")
+	var versions []*event.E
+	for i := range 3 {
+		versions = append(
+			versions,
+			newEvent(30023, now-300+int64(i)*10, "v"+strconv.Itoa(i), d),
+		)
+	}
+	// an e tag deletes only the version it references, not the older ones.
+	newEvent(5, now-200, "", tag.New("e", hex.Enc(versions[1].ID)))
+	if !stored(versions[0]) || stored(versions[1]) || !stored(versions[2]) {
+		t.Fatal("expected only the version in the e tag to be deleted")
+	}
+	// an a tag deletes all the versions that are not newer than the deletion.
+	newEvent(
+		5, now-100, "", tag.New(
+			"a", "30023:"+hex.Enc(sign.Pub())+":article",
+		),
+	)
+	if stored(versions[0]) || stored(versions[2]) {
+		t.Fatal("expected the versions of the address to be deleted")
+	}
+}
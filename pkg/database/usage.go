@@ -0,0 +1,169 @@
+package database
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/log"
+	"sync"
+)
+
+// meter is the store.Usage of each author, which the quotas of the relay are
+// checked against.
+//
+// The usage is counted by a scan of the events when the store is opened,
+// restored or wiped, and kept up to date by the writes that save and delete
+// events in between. Each write holds writes for reading from before its
+// transaction until it is metered, and a scan holds it for writing while it
+// takes its snapshot, so every write is either in the snapshot or metered
+// after it, and none is counted twice or missed.
+type meter struct {
+	writes sync.RWMutex
+	// scanMx serializes the scans.
+	scanMx sync.Mutex
+	sync.Mutex
+	loaded  bool
+	authors metered
+	// pending are the writes metered during a scan, which are added to its
+	// count when it is done.
+	pending metered
+}
+
+// metered is the change of the usage of the authors of the events saved and
+// deleted by a write.
+type metered map[string]*store.Usage
+
+// add meters an event that has been saved, or deleted, if it is
+// store.Metered.
+func (m metered) add(ev *event.E, deleted bool) {
+	if !store.Metered(ev) {
+		return
+	}
+	u, ok := m[string(ev.Pubkey)]
+	if !ok {
+		u = &store.Usage{}
+		m[string(ev.Pubkey)] = u
+	}
+	n, size := 1, store.Size(ev)
+	if deleted {
+		n, size = -n, -size
+	}
+	u.Events += n
+	u.Bytes += size
+}
+
+// merge adds the usage of another set of authors to this one, removing the
+// authors that are left with no events.
+func (m metered) merge(o metered) {
+	for pk, ou := range o {
+		u, ok := m[pk]
+		if !ok {
+			u = &store.Usage{}
+			m[pk] = u
+		}
+		u.Events += ou.Events
+		u.Bytes += ou.Bytes
+		if u.Events <= 0 {
+			delete(m, pk)
+		}
+	}
+}
+
+// newMeter returns a meter that has not counted the usage yet.
+func newMeter() *meter { return &meter{} }
+
+// Usage returns the store.Usage of an author, and false if the usage of the
+// authors has not been counted yet.
+func (d *D) Usage(pubkey []byte) (u store.Usage, ok bool) {
+	d.meter.Lock()
+	defer d.meter.Unlock()
+	if !d.meter.loaded {
+		return
+	}
+	if au, found := d.meter.authors[string(pubkey)]; found {
+		u = *au
+	}
+	return u, true
+}
+
+// Usages returns the store.Usage of each author with events, and false if it
+// has not been counted yet.
+func (d *D) Usages() (usages map[string]store.Usage, ok bool) {
+	d.meter.Lock()
+	defer d.meter.Unlock()
+	if !d.meter.loaded {
+		return
+	}
+	usages = make(map[string]store.Usage, len(d.meter.authors))
+	for pk, u := range d.meter.authors {
+		usages[pk] = *u
+	}
+	return usages, true
+}
+
+// startWrite is called before a transaction that saves or deletes events, and
+// the function it returns with the change of the usage of the authors once it
+// is done, which is only metered if it was committed.
+func (d *D) startWrite() (done func(m metered, committed bool)) {
+	d.meter.writes.RLock()
+	return func(m metered, committed bool) {
+		defer d.meter.writes.RUnlock()
+		if !committed || len(m) == 0 {
+			return
+		}
+		d.meter.Lock()
+		defer d.meter.Unlock()
+		if d.meter.pending != nil {
+			d.meter.pending.merge(m)
+		}
+		if d.meter.loaded {
+			d.meter.authors.merge(m)
+		}
+	}
+}
+
+// loadUsage counts the store.Usage of each author from the events in the
+// store, and replaces the usage with it.
+func (d *D) loadUsage() (err error) {
+	d.meter.scanMx.Lock()
+	defer d.meter.scanMx.Unlock()
+	d.meter.writes.Lock()
+	txn := d.NewTransaction(false)
+	d.meter.Lock()
+	d.meter.loaded, d.meter.authors = false, nil
+	d.meter.pending = make(metered)
+	d.meter.Unlock()
+	d.meter.writes.Unlock()
+	defer txn.Discard()
+	authors := make(metered)
+	prf := []byte(indexes.EventPrefix)
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prf})
+	for it.Rewind(); it.Valid(); it.Next() {
+		var v []byte
+		if v, err = it.Item().ValueCopy(nil); chk.E(err) {
+			break
+		}
+		ev := new(event.E)
+		if err = ev.UnmarshalBinary(bytes.NewBuffer(v)); err != nil {
+			// events that can't be decoded can't be counted.
+			err = nil
+			continue
+		}
+		authors.add(ev, false)
+	}
+	it.Close()
+	d.meter.Lock()
+	defer d.meter.Unlock()
+	if err != nil {
+		d.meter.pending = nil
+		return
+	}
+	authors.merge(d.meter.pending)
+	d.meter.loaded, d.meter.authors = true, authors
+	d.meter.pending = nil
+	log.D.F("counted the usage of %d authors in %s", len(authors), d.dataDir)
+	return
+}
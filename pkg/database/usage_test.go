@@ -0,0 +1,92 @@
+package database
+
+import (
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/eventid"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUsage(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	if err = d.loadUsage(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("pubkey"))
+	var n int
+	newEvent := func(k *kind.T, kv ...string) (ev *event.E) {
+		n++
+		ev = event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(n)))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - int64(n))
+		ev.Kind = k
+		ev.Content = []byte("story " + strconv.Itoa(n))
+		ev.Tags = tags.New()
+		for i := 0; i+1 < len(kv); i += 2 {
+			ev.Tags.AppendTags(tag.New(kv[i], kv[i+1]))
+		}
+		return
+	}
+	var want store.Usage
+	notes := make([]*event.E, 4)
+	for i := range notes {
+		notes[i] = newEvent(kind.TextNote)
+		want.Events++
+		want.Bytes += store.Size(notes[i])
+	}
+	profile := newEvent(kind.ProfileMetadata)
+	for _, ev := range append(notes, profile) {
+		if _, _, err = d.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(when string) {
+		u, ok := d.Usage(pk[:])
+		if !ok || u != want {
+			t.Fatalf("usage %s is %+v, expected %+v", when, u, want)
+		}
+	}
+	check("after saving")
+	// a deletion event and the deletion of an event free their usage.
+	deletion := newEvent(kind.Deletion, "e", hex.Enc(notes[0].ID))
+	if _, _, err = d.SaveEvent(ctx, deletion, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.DeleteEvent(ctx, eventid.NewWith(notes[1].ID)); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range notes[:2] {
+		want.Events--
+		want.Bytes -= store.Size(ev)
+	}
+	check("after deleting")
+	// a count from the store is the same.
+	if err = d.loadUsage(); err != nil {
+		t.Fatal(err)
+	}
+	check("when counted")
+	if err = d.Wipe(); err != nil {
+		t.Fatal(err)
+	}
+	want = store.Usage{}
+	check("after wiping")
+}
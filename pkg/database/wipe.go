@@ -0,0 +1,63 @@
+package database
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/database/indexes"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"time"
+)
+
+// Wipe deletes all events and indexes in the database.
+//
+// The index prefixes are all dropped in one call to badger's DropPrefix,
+// which blocks writes while it runs, so this is safe to call while the relay
+// is serving requests: an event saved during the wipe is either dropped with
+// all of its indexes or kept with all of them. The event sequence is not
+// dropped, so serials of events saved after the wipe never collide with
+// serials handed out before it.
+//
+// The change log is kept, and the wipe is recorded in it, so that those
+// following it know to drop what they have of the events before it. The
+// record of the backups made of and restored into the database is dropped, so
+// only a full backup can be restored after it.
+//
+// The queues of events to replicate to peer relays (REPL) and the runtime
+// configuration (CONFIGURATION) are kept, as they are not events. The
+// configuration is the settings of the relay rather than its data, and the
+// queued events are owed to the peers regardless of what this relay stores.
+func (d *D) Wipe() (err error) {
+	d.Logger.Warningf("wiping database %s", d.dataDir)
+	var prefixes [][]byte
+	for _, p := range indexes.Prefixes() {
+		prefixes = append(prefixes, []byte(p))
+	}
+	if err = d.DropPrefix(prefixes...); chk.E(err) {
+		return
+	}
+	var sers []uint64
+	defer func() { d.settle(sers) }()
+	if sers, err = d.nextSerials(1); chk.E(err) {
+		return
+	}
+	if err = d.Update(
+		func(txn *badger.Txn) (err error) {
+			if err = txn.Delete(backupKey); err != nil {
+				return
+			}
+			return logChange(
+				txn, &store.Change{
+					Seq: sers[0], Type: store.ChangeWipe,
+					Time: time.Now().Unix(),
+				},
+			)
+		},
+	); chk.E(err) {
+		return
+	}
+	if err = d.loadCardinality(); chk.E(err) {
+		return
+	}
+	err = d.loadUsage()
+	return
+}
@@ -149,8 +149,13 @@ func (en *Response) Write(w io.Writer) (err error) {
 
 // Marshal a countenvelope.Response envelope in minified JSON, appending to a
 // provided destination slice.
+//
+// The result is an object as specified in NIP-45:
+//
+//	["COUNT","<subscription id>",{"count":<integer>,"approximate":true}]
+//
+// where the approximate field is omitted when it is false.
 func (en *Response) Marshal(dst []byte) (b []byte) {
-	var err error
 	b = dst
 	b = envelopes.Marshal(
 		b, L,
@@ -158,76 +163,97 @@ func (en *Response) Marshal(dst []byte) (b []byte) {
 			o = bst
 			o = en.ID.Marshal(o)
 			o = append(o, ',')
+			o = append(o, `{"count":`...)
 			c := ints.New(en.Count)
 			o = c.Marshal(o)
 			if en.Approximate {
-				o = append(dst, ',')
-				o = append(o, "true"...)
+				o = append(o, `,"approximate":true`...)
 			}
+			o = append(o, '}')
 			return
 		},
 	)
-	_ = err
 	return
 }
 
 // Unmarshal a COUNT Response from minified JSON, returning the remainder after
 // the end of the envelope.
+//
+// Both the NIP-45 object form and the older form with a bare count and
+// optional approximate flag following the subscription.Id are accepted.
 func (en *Response) Unmarshal(b []byte) (r []byte, err error) {
 	r = b
-	var inID, inCount bool
+	if en.ID, err = subscription.NewId([]byte{0}); chk.E(err) {
+		return
+	}
+	if r, err = en.ID.Unmarshal(r); chk.E(err) {
+		return
+	}
+	// skip to the start of the count
 	for ; len(r) > 0; r = r[1:] {
-		// first we should be finding a subscription ID
-		if !inID && r[0] == '"' {
-			r = r[1:]
-			// so we don't do this twice
-			inID = true
-			for i := range r {
-				if r[i] == '\\' {
-					continue
-				} else if r[i] == '"' {
-					// skip escaped quotes
-					if i > 0 {
-						if r[i-1] != '\\' {
-							continue
-						}
-					}
-					if en.ID, err = subscription.NewId(text.NostrUnescape(r[:i])); chk.E(err) {
-
-						return
-					}
-					// trim the rest
-					r = r[i:]
+		if r[0] != ',' && r[0] != ' ' {
+			break
+		}
+	}
+	if len(r) == 0 {
+		err = io.EOF
+		return
+	}
+	if r[0] != '{' {
+		// older form: ["COUNT","<id>",<count>,<approximate>]
+		n := ints.New(0)
+		if r, err = n.Unmarshal(r); chk.E(err) {
+			return
+		}
+		en.Count = int(n.Uint64())
+		for i := range r {
+			if r[i] == ']' {
+				if bytes.Contains(r[:i], []byte("true")) {
+					en.Approximate = true
 				}
+				break
 			}
-		} else {
-			// pass the comma
-			if r[0] == ',' {
-				continue
-			} else if !inCount {
-				inCount = true
-				n := ints.New(0)
-				if r, err = n.Unmarshal(r); chk.E(err) {
-					return
-				}
-				en.Count = int(n.Uint64())
-			} else {
-				// can only be either the end or optional approx
-				if r[0] == ']' {
-					return
-				} else {
-					for i := range r {
-						if r[i] == ']' {
-							if bytes.Contains(r[:i], []byte("true")) {
-								en.Approximate = true
-							}
-							return
-						}
-					}
-				}
+		}
+		if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+			return
+		}
+		return
+	}
+	var end int
+	if end = bytes.IndexByte(r, '}'); end < 0 {
+		err = errorf.E("unterminated count object: %s", r)
+		return
+	}
+	obj := r[1:end]
+	r = r[end+1:]
+	for bytes.IndexByte(obj, '"') >= 0 {
+		var key []byte
+		if key, obj, err = text.UnmarshalQuoted(obj); chk.E(err) {
+			return
+		}
+		for len(obj) > 0 && (obj[0] == ':' || obj[0] == ' ') {
+			obj = obj[1:]
+		}
+		switch string(key) {
+		case "count":
+			n := ints.New(0)
+			if obj, err = n.Unmarshal(obj); chk.E(err) {
+				return
 			}
+			en.Count = int(n.Uint64())
+		case "approximate":
+			en.Approximate = bytes.HasPrefix(obj, []byte("true"))
+		}
+		// move past the value to the next key, if any.
+		if next := bytes.IndexByte(obj, ','); next >= 0 {
+			obj = obj[next+1:]
+		} else {
+			obj = obj[:0]
 		}
 	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
 	return
 }
 
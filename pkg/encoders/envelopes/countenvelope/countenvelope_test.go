@@ -72,5 +72,64 @@ func TestRequest(t *testing.T) {
 }
 
 func TestResponse(t *testing.T) {
+	var err error
+	rb, rb1, rb2 := make([]byte, 0, 65535), make([]byte, 0, 65535), make(
+		[]byte, 0, 65535,
+	)
+	for i := range 1000 {
+		var res *Response
+		if res, err = NewResponseFrom(
+			subscription.NewStd().T, i*7, i%2 == 0,
+		); chk.E(err) {
+			t.Fatal(err)
+		}
+		rb = res.Marshal(rb)
+		rb1 = rb1[:len(rb)]
+		copy(rb1, rb)
+		var rem []byte
+		var l string
+		if l, rb, err = envelopes.Identify(rb); chk.E(err) {
+			t.Fatal(err)
+		}
+		if l != L {
+			t.Fatalf("invalid sentinel %s, expect %s", l, L)
+		}
+		res2 := NewResponse()
+		if rem, err = res2.Unmarshal(rb); chk.E(err) {
+			t.Fatal(err)
+		}
+		if len(rem) > 0 {
+			t.Fatalf(
+				"unmarshal failed, remainder\n%d %s",
+				len(rem), rem,
+			)
+		}
+		if res2.Count != res.Count || res2.Approximate != res.Approximate {
+			t.Fatalf(
+				"unmarshal failed, got count %d approximate %v, "+
+					"expected %d %v\n%s",
+				res2.Count, res2.Approximate, res.Count, res.Approximate,
+				rb1,
+			)
+		}
+		rb2 = res2.Marshal(rb2)
+		if !bytes.Equal(rb1, rb2) {
+			t.Fatalf(
+				"unmarshal failed\n%d %s\n%d %s\n",
+				len(rb1), rb1, len(rb2), rb2,
+			)
+		}
+		rb, rb1, rb2 = rb[:0], rb1[:0], rb2[:0]
+	}
+}
 
+func TestResponseLegacy(t *testing.T) {
+	var err error
+	res := NewResponse()
+	if _, err = res.Unmarshal([]byte(`"sub1",42,true]`)); chk.E(err) {
+		t.Fatal(err)
+	}
+	if res.ID.String() != "sub1" || res.Count != 42 || !res.Approximate {
+		t.Fatalf("failed to decode legacy count response %s", res.Marshal(nil))
+	}
 }
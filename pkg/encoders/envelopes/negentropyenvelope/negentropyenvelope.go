@@ -0,0 +1,309 @@
+// Package negentropyenvelope is an encoder for the NIP-77 negentropy sync
+// message types NEG-OPEN, NEG-MSG and NEG-CLOSE sent by a client and NEG-MSG
+// and NEG-ERR sent by a relay.
+package negentropyenvelope
+
+import (
+	"io"
+	"orly.dev/pkg/encoders/envelopes"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/subscription"
+	"orly.dev/pkg/encoders/text"
+	"orly.dev/pkg/interfaces/codec"
+	"orly.dev/pkg/utils/chk"
+)
+
+// The labels associated with the negentropy codec.Envelope types.
+const (
+	LOpen  = "NEG-OPEN"
+	LMsg   = "NEG-MSG"
+	LClose = "NEG-CLOSE"
+	LErr   = "NEG-ERR"
+)
+
+// Open is a NEG-OPEN envelope sent by a client to start a negentropy sync of
+// the events matching a filter, with the first message of the reconciliation.
+type Open struct {
+	Subscription *subscription.Id
+	Filter       *filter.F
+	Message      []byte
+}
+
+var _ codec.Envelope = (*Open)(nil)
+
+// NewOpen creates an empty new Open.
+func NewOpen() *Open {
+	return &Open{Subscription: subscription.NewStd(), Filter: filter.New()}
+}
+
+// NewOpenWith creates a new Open populated with a subscription.Id, filter.F and
+// the initial message.
+func NewOpenWith(id *subscription.Id, f *filter.F, msg []byte) *Open {
+	return &Open{Subscription: id, Filter: f, Message: msg}
+}
+
+// Label returns the label of an Open.
+func (en *Open) Label() string { return LOpen }
+
+// Write the Open to a provided io.Writer.
+func (en *Open) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal an Open in minified JSON, appending to a provided destination slice.
+// The message is encoded as hex.
+func (en *Open) Marshal(dst []byte) (b []byte) {
+	b = envelopes.Marshal(
+		dst, LOpen,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = en.Subscription.Marshal(o)
+			o = append(o, ',')
+			o = en.Filter.Marshal(o)
+			o = append(o, ',')
+			o = text.AppendHexFromBinary(o, en.Message, true)
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal an Open from minified JSON, returning the remainder after the end
+// of the envelope.
+func (en *Open) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	if en.Subscription, err = subscription.NewId([]byte{0}); chk.E(err) {
+		return
+	}
+	if r, err = en.Subscription.Unmarshal(r); chk.E(err) {
+		return
+	}
+	en.Filter = filter.New()
+	if r, err = en.Filter.Unmarshal(r); chk.E(err) {
+		return
+	}
+	if en.Message, r, err = text.UnmarshalHex(r); chk.E(err) {
+		return
+	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ParseOpen reads an Open in minified JSON into a newly allocated Open.
+func ParseOpen(b []byte) (t *Open, rem []byte, err error) {
+	t = NewOpen()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Msg is a NEG-MSG envelope carrying a message of a reconciliation, in either
+// direction.
+type Msg struct {
+	Subscription *subscription.Id
+	Message      []byte
+}
+
+var _ codec.Envelope = (*Msg)(nil)
+
+// NewMsg creates an empty new Msg.
+func NewMsg() *Msg { return &Msg{Subscription: subscription.NewStd()} }
+
+// NewMsgWith creates a new Msg populated with a subscription.Id and message.
+func NewMsgWith(id *subscription.Id, msg []byte) *Msg {
+	return &Msg{Subscription: id, Message: msg}
+}
+
+// Label returns the label of a Msg.
+func (en *Msg) Label() string { return LMsg }
+
+// Write the Msg to a provided io.Writer.
+func (en *Msg) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal a Msg in minified JSON, appending to a provided destination slice.
+// The message is encoded as hex.
+func (en *Msg) Marshal(dst []byte) (b []byte) {
+	b = envelopes.Marshal(
+		dst, LMsg,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = en.Subscription.Marshal(o)
+			o = append(o, ',')
+			o = text.AppendHexFromBinary(o, en.Message, true)
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal a Msg from minified JSON, returning the remainder after the end of
+// the envelope.
+func (en *Msg) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	if en.Subscription, err = subscription.NewId([]byte{0}); chk.E(err) {
+		return
+	}
+	if r, err = en.Subscription.Unmarshal(r); chk.E(err) {
+		return
+	}
+	if en.Message, r, err = text.UnmarshalHex(r); chk.E(err) {
+		return
+	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ParseMsg reads a Msg in minified JSON into a newly allocated Msg.
+func ParseMsg(b []byte) (t *Msg, rem []byte, err error) {
+	t = NewMsg()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Close is a NEG-CLOSE envelope sent by a client to end a negentropy sync and
+// release its state on the relay.
+type Close struct {
+	Subscription *subscription.Id
+}
+
+var _ codec.Envelope = (*Close)(nil)
+
+// NewClose creates an empty new Close.
+func NewClose() *Close { return &Close{Subscription: subscription.NewStd()} }
+
+// NewCloseWith creates a new Close populated with a subscription.Id.
+func NewCloseWith(id *subscription.Id) *Close {
+	return &Close{Subscription: id}
+}
+
+// Label returns the label of a Close.
+func (en *Close) Label() string { return LClose }
+
+// Write the Close to a provided io.Writer.
+func (en *Close) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal a Close in minified JSON, appending to a provided destination slice.
+func (en *Close) Marshal(dst []byte) (b []byte) {
+	b = envelopes.Marshal(
+		dst, LClose,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = en.Subscription.Marshal(o)
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal a Close from minified JSON, returning the remainder after the end
+// of the envelope.
+func (en *Close) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	if en.Subscription, err = subscription.NewId([]byte{0}); chk.E(err) {
+		return
+	}
+	if r, err = en.Subscription.Unmarshal(r); chk.E(err) {
+		return
+	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ParseClose reads a Close in minified JSON into a newly allocated Close.
+func ParseClose(b []byte) (t *Close, rem []byte, err error) {
+	t = NewClose()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
+
+// Err is a NEG-ERR envelope sent by a relay to indicate that a negentropy sync
+// has failed or been refused, after which the relay has discarded its state.
+// The Reason has a machine-readable prefix, as in CLOSED messages, such as
+// "blocked: " when the query is too big.
+type Err struct {
+	Subscription *subscription.Id
+	Reason       []byte
+}
+
+var _ codec.Envelope = (*Err)(nil)
+
+// NewErr creates an empty new Err.
+func NewErr() *Err { return &Err{Subscription: subscription.NewStd()} }
+
+// NewErrWith creates a new Err populated with a subscription.Id and Reason.
+func NewErrWith(id *subscription.Id, reason []byte) *Err {
+	return &Err{Subscription: id, Reason: reason}
+}
+
+// Label returns the label of an Err.
+func (en *Err) Label() string { return LErr }
+
+// Write the Err to a provided io.Writer.
+func (en *Err) Write(w io.Writer) (err error) {
+	_, err = w.Write(en.Marshal(nil))
+	return
+}
+
+// Marshal an Err in minified JSON, appending to a provided destination slice.
+// Note that this ensures correct string escaping on the Reason field.
+func (en *Err) Marshal(dst []byte) (b []byte) {
+	b = envelopes.Marshal(
+		dst, LErr,
+		func(bst []byte) (o []byte) {
+			o = bst
+			o = en.Subscription.Marshal(o)
+			o = append(o, ',')
+			o = append(o, '"')
+			o = text.NostrEscape(o, en.Reason)
+			o = append(o, '"')
+			return
+		},
+	)
+	return
+}
+
+// Unmarshal an Err from minified JSON, returning the remainder after the end of
+// the envelope.
+func (en *Err) Unmarshal(b []byte) (r []byte, err error) {
+	r = b
+	if en.Subscription, err = subscription.NewId([]byte{0}); chk.E(err) {
+		return
+	}
+	if r, err = en.Subscription.Unmarshal(r); chk.E(err) {
+		return
+	}
+	if en.Reason, r, err = text.UnmarshalQuoted(r); chk.E(err) {
+		return
+	}
+	if r, err = envelopes.SkipToTheEnd(r); chk.E(err) {
+		return
+	}
+	return
+}
+
+// ParseErr reads an Err in minified JSON into a newly allocated Err.
+func ParseErr(b []byte) (t *Err, rem []byte, err error) {
+	t = NewErr()
+	if rem, err = t.Unmarshal(b); chk.E(err) {
+		return
+	}
+	return
+}
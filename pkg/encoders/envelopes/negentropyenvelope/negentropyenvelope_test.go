@@ -0,0 +1,60 @@
+package negentropyenvelope
+
+import (
+	"bytes"
+	"orly.dev/pkg/encoders/envelopes"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/subscription"
+	"orly.dev/pkg/interfaces/codec"
+	"orly.dev/pkg/utils/chk"
+	"testing"
+
+	"lukechampine.com/frand"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+	var err error
+	for range 100 {
+		var f *filter.F
+		if f, err = filter.GenFilter(); chk.E(err) {
+			t.Fatal(err)
+		}
+		s := subscription.NewStd()
+		msg := frand.Bytes(frand.Intn(4096) + 1)
+		for _, tc := range []struct {
+			env codec.Envelope
+			new func() codec.Envelope
+		}{
+			{NewOpenWith(s, f, msg), func() codec.Envelope { return NewOpen() }},
+			{NewMsgWith(s, msg), func() codec.Envelope { return NewMsg() }},
+			{NewCloseWith(s), func() codec.Envelope { return NewClose() }},
+			{
+				NewErrWith(s, []byte("blocked: \"too\" many records")),
+				func() codec.Envelope { return NewErr() },
+			},
+		} {
+			b := tc.env.Marshal(nil)
+			b1 := bytes.Clone(b)
+			var l string
+			var rem []byte
+			if l, rem, err = envelopes.Identify(b); chk.E(err) {
+				t.Fatal(err)
+			}
+			if l != tc.env.Label() {
+				t.Fatalf("invalid sentinel %s, expect %s", l, tc.env.Label())
+			}
+			env2 := tc.new()
+			if rem, err = env2.Unmarshal(rem); chk.E(err) {
+				t.Fatal(err)
+			}
+			if len(rem) > 0 {
+				t.Fatalf("unmarshal failed, remainder\n%d %s", len(rem), rem)
+			}
+			if b2 := env2.Marshal(nil); !bytes.Equal(b1, b2) {
+				t.Fatalf(
+					"unmarshal failed\n%d %s\n%d %s\n", len(b1), b1, len(b2), b2,
+				)
+			}
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	_ "embed"
 	"orly.dev/pkg/crypto/p256k"
 	"orly.dev/pkg/encoders/event/examples"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
 	"orly.dev/pkg/utils/chk"
 	"testing"
 )
@@ -218,3 +220,49 @@ func BenchmarkUnmarshal(bb *testing.B) {
 //		}
 //	}
 // }
+
+func TestExpiration(t *testing.T) {
+	ev := New()
+	if _, ok := ev.Expiration(); ok || ev.IsExpired(1000) {
+		t.Fatal("event without tags should not have an expiration")
+	}
+	ev.Tags = tags.New(tag.New("expiration", "1000"))
+	if exp, ok := ev.Expiration(); !ok || exp != 1000 {
+		t.Fatalf("got expiration %d %v, expected 1000", exp, ok)
+	}
+	if ev.IsExpired(1000) || !ev.IsExpired(1001) {
+		t.Fatal("event should expire after the expiration timestamp")
+	}
+	ev.Tags = tags.New(tag.New("expiration", "soon"))
+	if _, ok := ev.Expiration(); ok {
+		t.Fatal("invalid expiration should be ignored")
+	}
+}
+
+func TestDifficulty(t *testing.T) {
+	for _, tt := range []struct {
+		id []byte
+		d  int
+	}{
+		{[]byte{0xff, 0}, 0},
+		{[]byte{0x00, 0x0f}, 12},
+		{[]byte{0x00, 0x00, 0x01}, 23},
+		{make([]byte, 32), 256},
+	} {
+		if d := Difficulty(tt.id); d != tt.d {
+			t.Fatalf("difficulty of %0x is %d, expected %d", tt.id, d, tt.d)
+		}
+	}
+	ev := New()
+	if _, ok := ev.PowTarget(); ok {
+		t.Fatal("event without tags should not have a target")
+	}
+	ev.Tags = tags.New(tag.New("nonce", "776797", "20"))
+	if target, ok := ev.PowTarget(); !ok || target != 20 {
+		t.Fatalf("got target %d %v, expected 20", target, ok)
+	}
+	ev.Tags = tags.New(tag.New("nonce", "776797"))
+	if _, ok := ev.PowTarget(); ok {
+		t.Fatal("nonce without a target should not have a target")
+	}
+}
@@ -0,0 +1,31 @@
+package event
+
+import (
+	"orly.dev/pkg/encoders/ints"
+	"orly.dev/pkg/encoders/tag"
+)
+
+// Expiration returns the NIP-40 expiration timestamp of the event, found in the
+// first `expiration` tag. If there is no such tag, or its value is not a valid
+// integer, ok is false.
+func (ev *E) Expiration() (exp int64, ok bool) {
+	if ev.Tags == nil {
+		return
+	}
+	t := ev.Tags.GetFirst(tag.New("expiration"))
+	if t == nil || t.Len() < 2 {
+		return
+	}
+	n := ints.New(0)
+	if _, err := n.Unmarshal(t.Value()); err != nil {
+		return
+	}
+	return n.Int64(), true
+}
+
+// IsExpired returns true if the event has an expiration timestamp that is
+// before the given unix timestamp.
+func (ev *E) IsExpired(now int64) bool {
+	exp, ok := ev.Expiration()
+	return ok && exp < now
+}
@@ -0,0 +1,41 @@
+package event
+
+import (
+	"math/bits"
+	"orly.dev/pkg/encoders/ints"
+	"orly.dev/pkg/encoders/tag"
+)
+
+// Difficulty returns the NIP-13 proof of work difficulty of the event, the
+// number of leading zero bits of its ID.
+func (ev *E) Difficulty() int { return Difficulty(ev.ID) }
+
+// Difficulty counts the leading zero bits of an event ID.
+func Difficulty(id []byte) (d int) {
+	for _, b := range id {
+		if b != 0 {
+			d += bits.LeadingZeros8(b)
+			return
+		}
+		d += 8
+	}
+	return
+}
+
+// PowTarget returns the target difficulty committed to in the third field of
+// the NIP-13 `nonce` tag of the event. If there is no such tag, or the target
+// is not a valid integer, ok is false.
+func (ev *E) PowTarget() (target int, ok bool) {
+	if ev.Tags == nil {
+		return
+	}
+	t := ev.Tags.GetFirst(tag.New("nonce"))
+	if t == nil || t.Len() < 3 {
+		return
+	}
+	n := ints.New(0)
+	if _, err := n.Unmarshal(t.B(2)); err != nil {
+		return
+	}
+	return int(n.Int64()), true
+}
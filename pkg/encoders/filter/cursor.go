@@ -0,0 +1,64 @@
+package filter
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// Cursor is the position of an event in the results of a query, which are in
+// reverse chronological order, and in reverse order of the serials the store
+// gave the events for events with the same timestamp, so that the order is
+// stable. A filter with a Cursor resumes the query after that event, so pages
+// of results neither skip nor repeat events that share a timestamp.
+//
+// It is given to clients as an opaque token, that is only meaningful to the
+// store that made it.
+type Cursor struct {
+	Ts  int64
+	Ser uint64
+}
+
+// cursorLen is the length of the binary form of a Cursor, the timestamp and
+// the serial in big endian order.
+const cursorLen = 16
+
+// After returns true if an event with a timestamp and serial comes after the
+// cursor in the results of a query.
+func (c *Cursor) After(ts int64, ser uint64) bool {
+	return ts < c.Ts || (ts == c.Ts && ser < c.Ser)
+}
+
+// Marshal appends the token of the cursor to dst.
+func (c *Cursor) Marshal(dst []byte) (b []byte) {
+	var raw [cursorLen]byte
+	binary.BigEndian.PutUint64(raw[:8], uint64(c.Ts))
+	binary.BigEndian.PutUint64(raw[8:], c.Ser)
+	return base64.RawURLEncoding.AppendEncode(dst, raw[:])
+}
+
+// String returns the token of the cursor.
+func (c *Cursor) String() string { return string(c.Marshal(nil)) }
+
+// Unmarshal decodes the token of a cursor.
+func (c *Cursor) Unmarshal(token []byte) (err error) {
+	var raw []byte
+	if raw, err = base64.RawURLEncoding.AppendDecode(
+		nil, token,
+	); err != nil || len(raw) != cursorLen {
+		err = errorf.E("invalid cursor '%s'", token)
+		return
+	}
+	c.Ts = int64(binary.BigEndian.Uint64(raw[:8]))
+	c.Ser = binary.BigEndian.Uint64(raw[8:])
+	return
+}
+
+// ParseCursor decodes the token of a cursor into a new Cursor.
+func ParseCursor(token []byte) (c *Cursor, err error) {
+	c = new(Cursor)
+	if err = c.Unmarshal(token); err != nil {
+		c = nil
+	}
+	return
+}
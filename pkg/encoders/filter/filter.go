@@ -19,6 +19,7 @@ import (
 	"orly.dev/pkg/encoders/tags"
 	text2 "orly.dev/pkg/encoders/text"
 	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/encoders/words"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/errorf"
 	"orly.dev/pkg/utils/pointers"
@@ -46,6 +47,10 @@ type F struct {
 	Until   *timestamp.T `json:"until,omitempty"`
 	Search  []byte       `json:"search,omitempty"`
 	Limit   *uint        `json:"limit,omitempty"`
+	// Cursor resumes a query after the last event of a previous page of its
+	// results. It is an extension of the protocol, and is ignored by Matches,
+	// as events that arrive later are newer than any cursor.
+	Cursor *Cursor `json:"cursor,omitempty"`
 }
 
 // New creates a new, reasonably initialized filter that will be ready for most uses without
@@ -76,7 +81,11 @@ func (f *F) Clone() (clone *F) {
 	_Since := *f.Since
 	_Until := *f.Until
 	_Search := make([]byte, len(f.Search))
-	copy(Search, f.Search)
+	copy(_Search, f.Search)
+	var _Cursor *Cursor
+	if f.Cursor != nil {
+		_Cursor = &Cursor{Ts: f.Cursor.Ts, Ser: f.Cursor.Ser}
+	}
 	return &F{
 		Ids:     &_IDs,
 		Kinds:   &_Kinds,
@@ -86,6 +95,7 @@ func (f *F) Clone() (clone *F) {
 		Until:   &_Until,
 		Search:  _Search,
 		Limit:   lim,
+		Cursor:  _Cursor,
 	}
 }
 
@@ -105,6 +115,8 @@ var (
 	Limit = []byte("limit")
 	// Search is the JSON object key for Search.
 	Search = []byte("search")
+	// CursorKey is the JSON object key for Cursor.
+	CursorKey = []byte("cursor")
 )
 
 // Marshal a filter into raw JSON bytes, minified. The field ordering and sort of fields is
@@ -157,8 +169,9 @@ func (f *F) Marshal(dst []byte) (b []byte) {
 				// nothing here
 				continue
 			}
-			if tg.Len() < 1 || len(tg.Key()) != 2 {
-				// if there is no values, skip; the "key" field must be 2 characters long,
+			if tg.Len() < 1 || len(tg.Key()) < 2 {
+				// if there is no values, skip; the "key" field must be at
+				// least 2 characters long,
 				continue
 			}
 			tKey := tg.ToSliceOfBytes()[0]
@@ -177,11 +190,13 @@ func (f *F) Marshal(dst []byte) (b []byte) {
 				first = true
 			}
 			// append the key
-			dst = append(dst, '"', tg.B(0)[0], tg.B(0)[1], '"', ':')
+			dst = append(dst, '"')
+			dst = append(dst, tKey...)
+			dst = append(dst, '"', ':')
 			dst = append(dst, '[')
 			for i, value := range values {
 				dst = append(dst, '"')
-				if tKey[1] == 'e' || tKey[1] == 'p' {
+				if len(tKey) == 2 && (tKey[1] == 'e' || tKey[1] == 'p') {
 					// event and pubkey tags are binary 32 bytes
 					dst = hex.EncAppend(dst, value)
 				} else {
@@ -231,6 +246,17 @@ func (f *F) Marshal(dst []byte) (b []byte) {
 		dst = text2.JSONKey(dst, Limit)
 		dst = ints.New(*f.Limit).Marshal(dst)
 	}
+	if f.Cursor != nil {
+		if first {
+			dst = append(dst, ',')
+		} else {
+			first = true
+		}
+		dst = text2.JSONKey(dst, CursorKey)
+		dst = append(dst, '"')
+		dst = f.Cursor.Marshal(dst)
+		dst = append(dst, '"')
+	}
 	// close parentheses
 	dst = append(dst, '}')
 	b = dst
@@ -289,19 +315,20 @@ func (f *F) Unmarshal(b []byte) (r []byte, err error) {
 			}
 			switch key[0] {
 			case '#':
-				// tags start with # and have 1 letter
+				// tags start with # and have 1 letter, or a longer name and
+				// a position for the tags indexed by the relay.
 				l := len(key)
-				if l != 2 {
+				if l < 2 {
 					err = errorf.E(
-						"filter tag keys can only be # and one alpha character: '%s'\n%s",
+						"filter tag keys must be # and a tag name: '%s'\n%s",
 						key, b,
 					)
 					return
 				}
 				k := make([]byte, len(key))
 				copy(k, key)
-				switch key[1] {
-				case 'e', 'p':
+				switch {
+				case l == 2 && (key[1] == 'e' || key[1] == 'p'):
 					// the tags must all be 64 character hexadecimal
 					var ff [][]byte
 					if ff, r, err = text2.UnmarshalHexArray(
@@ -378,6 +405,18 @@ func (f *F) Unmarshal(b []byte) (r []byte, err error) {
 				u := uint(l.N)
 				f.Limit = &u
 				state = betweenKV
+			case CursorKey[0]:
+				if len(key) < len(CursorKey) {
+					goto invalid
+				}
+				var token []byte
+				if token, r, err = text2.UnmarshalQuoted(r); chk.E(err) {
+					return
+				}
+				if f.Cursor, err = ParseCursor(token); chk.E(err) {
+					return
+				}
+				state = betweenKV
 			case Search[0]:
 				if len(key) < len(Since) {
 					goto invalid
@@ -458,8 +497,20 @@ func (f *F) Matches(ev *event.E) bool {
 		// log.F.ToSliceOfBytes("no matching authors in filter\nEVENT %s\nFILTER %s", ev.ToObject().String(), f.ToObject().String())
 		return false
 	}
-	if f.Tags.Len() > 0 && !ev.Tags.Intersects(f.Tags) {
-		return false
+	if f.Tags.Len() > 0 {
+		// the tags that NIP-01 defines are matched together, the tag queries
+		// that go beyond it each on their own.
+		nip01 := tags.New()
+		for _, t := range f.Tags.ToSliceOfTags() {
+			if !IsTagQuery(t) {
+				nip01.AppendTags(t)
+			} else if !MatchesTag(ev.Tags, t) {
+				return false
+			}
+		}
+		if nip01.Len() > 0 && !ev.Tags.Intersects(nip01) {
+			return false
+		}
 	}
 	// if f.Tags.Len() > 0 {
 	//	for _, v := range f.Tags.ToSliceOfTags() {
@@ -478,6 +529,10 @@ func (f *F) Matches(ev *event.E) bool {
 		// log.F.ToSliceOfBytes("event is newer than until\nEVENT %s\nFILTER %s", ev.ToObject().String(), f.ToObject().String())
 		return false
 	}
+	// NIP-50 search matches events containing any of the search terms
+	if len(f.Search) > 0 && words.Parse(f.Search).Score(ev) == 0 {
+		return false
+	}
 	return true
 }
 
@@ -537,6 +592,7 @@ func (f *F) Equal(b *F) bool {
 		!arePointerValuesEqual(f.Since, b.Since) ||
 		!arePointerValuesEqual(f.Until, b.Until) ||
 		!bytes.Equal(f.Search, b.Search) ||
+		!arePointerValuesEqual(f.Cursor, b.Cursor) ||
 		!f.Tags.Equal(b.Tags) {
 		return false
 	}
@@ -32,3 +32,25 @@ func TestT_MarshalUnmarshal(t *testing.T) {
 		dst, dst1, dst2 = dst[:0], dst1[:0], dst2[:0]
 	}
 }
+
+func TestCursor(t *testing.T) {
+	var err error
+	limit := uint(10)
+	f := &F{Limit: &limit, Cursor: &Cursor{Ts: 1700000000, Ser: 42}}
+	b := f.Marshal(nil)
+	fa := New()
+	if _, err = fa.Unmarshal(b); chk.E(err) {
+		t.Fatalf("unmarshal error: %v\n%s", err, b)
+	}
+	if fa.Cursor == nil || *fa.Cursor != *f.Cursor {
+		t.Fatalf("cursor of %s is %v, expected %v", b, fa.Cursor, f.Cursor)
+	}
+	// the events after the cursor are older, or as old with a lower serial.
+	if !f.Cursor.After(1699999999, 100) || !f.Cursor.After(1700000000, 41) ||
+		f.Cursor.After(1700000000, 42) || f.Cursor.After(1700000001, 0) {
+		t.Fatal("events are not after the cursor in the order of results")
+	}
+	if _, err = New().Unmarshal([]byte(`{"cursor":"nope"}`)); err == nil {
+		t.Fatal("unmarshaled an invalid cursor")
+	}
+}
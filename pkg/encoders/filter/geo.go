@@ -0,0 +1,144 @@
+package filter
+
+import (
+	"bytes"
+	"orly.dev/pkg/encoders/geohash"
+	"strconv"
+)
+
+// GeoTag is the name of the tags of the geohashes of the location of an
+// event, that NIP-52 defines, which can be queried by area.
+var GeoTag = []byte("g")
+
+// GeoMaxCells is the most geohash cells that the area of a GeoQuery is
+// covered with, to be scanned in an index.
+var GeoMaxCells = 32
+
+// GeoQuery is a value of a g tag of a filter that matches the geohashes of an
+// area instead of one geohash. It is one of:
+//
+//   - a geohash followed by a *, such as u4pr*, that matches the geohashes it
+//     is a prefix of;
+//
+//   - the south west and north east corners of a box, as latitude,longitude
+//     pairs in degrees, separated by .., such as 59.9,10.7..60.0,10.8;
+//
+//   - a point and a radius in meters, as latitude,longitude,radius, such as
+//     59.91,10.75,5000.
+//
+// A box or a circle matches a geohash if the center of its cell is inside
+// it.
+type GeoQuery struct {
+	// Prefix is the geohash the matching geohashes start with, if this is a
+	// query for a prefix.
+	Prefix []byte
+	// Box is the box, or the box around the circle, the matching geohashes
+	// are in.
+	Box geohash.Box
+	// Lat, Lon and Radius are the center and radius of the circle, if this
+	// is a query for a circle.
+	Lat, Lon, Radius float64
+}
+
+// ParseGeoQuery parses a value of a g tag of a filter as a GeoQuery, and
+// returns false if it is a geohash, or not a query for an area.
+func ParseGeoQuery(v []byte) (q *GeoQuery, ok bool) {
+	if prefix, found := bytes.CutSuffix(v, []byte{'*'}); found {
+		if !geohash.Valid(prefix) {
+			return
+		}
+		q = &GeoQuery{Prefix: prefix}
+		q.Box, _ = geohash.Decode(prefix)
+		return q, true
+	}
+	if sw, ne, found := bytes.Cut(v, []byte("..")); found {
+		var minLat, minLon, maxLat, maxLon float64
+		if minLat, minLon, ok = parsePoint(sw); !ok {
+			return
+		}
+		if maxLat, maxLon, ok = parsePoint(ne); !ok {
+			return
+		}
+		if minLat > maxLat || minLon > maxLon {
+			return nil, false
+		}
+		return &GeoQuery{
+			Box: geohash.Box{
+				MinLat: minLat, MinLon: minLon, MaxLat: maxLat,
+				MaxLon: maxLon,
+			},
+		}, true
+	}
+	i := bytes.LastIndexByte(v, ',')
+	if i < 0 {
+		return
+	}
+	q = new(GeoQuery)
+	var err error
+	if q.Radius, err = strconv.ParseFloat(string(v[i+1:]), 64); err != nil ||
+		!(q.Radius > 0) {
+		return nil, false
+	}
+	if q.Lat, q.Lon, ok = parsePoint(v[:i]); !ok {
+		return nil, false
+	}
+	q.Box = geohash.Around(q.Lat, q.Lon, q.Radius)
+	return
+}
+
+// parsePoint parses a latitude and a longitude in degrees, separated by a
+// comma.
+func parsePoint(v []byte) (lat, lon float64, ok bool) {
+	a, b, found := bytes.Cut(v, []byte{','})
+	if !found {
+		return
+	}
+	var err error
+	if lat, err = strconv.ParseFloat(string(a), 64); err != nil ||
+		lat < -90 || lat > 90 {
+		return
+	}
+	if lon, err = strconv.ParseFloat(string(b), 64); err != nil ||
+		lon < -180 || lon > 180 {
+		return
+	}
+	return lat, lon, true
+}
+
+// Matches returns true if a geohash is in the area of the query.
+func (q *GeoQuery) Matches(g []byte) bool {
+	if q.Prefix != nil {
+		return geohash.Valid(g) && bytes.HasPrefix(g, q.Prefix)
+	}
+	box, ok := geohash.Decode(g)
+	if !ok {
+		return false
+	}
+	lat, lon := box.Center()
+	if !q.Box.Contains(lat, lon) {
+		return false
+	}
+	return q.Radius == 0 ||
+		geohash.Distance(q.Lat, q.Lon, lat, lon) <= q.Radius
+}
+
+// Cells returns the geohashes whose prefixes cover the area of the query.
+func (q *GeoQuery) Cells() (cells [][]byte) {
+	if q.Prefix != nil {
+		return [][]byte{q.Prefix}
+	}
+	return geohash.Cover(q.Box, GeoMaxCells)
+}
+
+// Exact returns true if the geohashes that the cells of the query are the
+// prefixes of are all in its area, so they don't have to be checked.
+func (q *GeoQuery) Exact() bool { return q.Prefix != nil }
+
+// isGeoQuery returns true if a value of a tag of a filter is a GeoQuery,
+// which it only is for the first value of g tags.
+func isGeoQuery(name []byte, position int, v []byte) (q *GeoQuery, ok bool) {
+	if position != 1 || !bytes.Equal(name, GeoTag) {
+		return
+	}
+	return ParseGeoQuery(v)
+}
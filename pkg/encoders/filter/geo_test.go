@@ -0,0 +1,58 @@
+package filter
+
+import (
+	"orly.dev/pkg/encoders/geohash"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"testing"
+)
+
+func TestParseGeoQuery(t *testing.T) {
+	for _, tc := range []struct {
+		v  string
+		ok bool
+	}{
+		{"u4pr*", true},
+		{"u4pa*", false},
+		{"u4pr", false},
+		{"59.9,10.7..60.0,10.8", true},
+		{"60.0,10.7..59.9,10.8", false},
+		{"59.9..60.0", false},
+		{"59.91,10.75,5000", true},
+		{"59.91,10.75,-1", false},
+		{"91,10.75,5000", false},
+		{"nostr", false},
+	} {
+		if _, ok := ParseGeoQuery([]byte(tc.v)); ok != tc.ok {
+			t.Fatalf("%s parsed is %v, expected %v", tc.v, ok, tc.ok)
+		}
+	}
+}
+
+func TestMatchesGeoTag(t *testing.T) {
+	// the center of the cell of the geohash is about 59.9139,10.7522.
+	g := geohash.Encode(59.9139, 10.7522, 9)
+	evTags := tags.New(tag.New("g", string(g)), tag.New("t", "u4*"))
+	for _, tc := range []struct {
+		t       *tag.T
+		matches bool
+	}{
+		{tag.New("#g", string(g[:4])+"*"), true},
+		{tag.New("#g", "u5*"), false},
+		{tag.New("#g", string(g[:4])), false},
+		{tag.New("#g", "59.9,10.7..60.0,10.8"), true},
+		{tag.New("#g", "59.95,10.7..60.0,10.8"), false},
+		{tag.New("#g", "59.91,10.75,500"), true},
+		{tag.New("#g", "59.91,10.75,100"), false},
+		{tag.New("#g", "u5*", "59.91,10.75,500"), true},
+		// other tags are matched exactly.
+		{tag.New("#t", "u4*"), true},
+	} {
+		if MatchesTag(evTags, tc.t) != tc.matches {
+			t.Fatalf("%s matching is not %v", tc.t.ToSliceOfBytes(), tc.matches)
+		}
+	}
+	if IsTagQuery(tag.New("#t", "u4*")) || !IsTagQuery(tag.New("#g", "u4*")) {
+		t.Fatal("only the values of g tags are geo queries")
+	}
+}
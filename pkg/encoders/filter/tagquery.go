@@ -0,0 +1,135 @@
+package filter
+
+import (
+	"bytes"
+	"math"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"strconv"
+	"time"
+)
+
+// TagKey splits the key of a tag of a filter into the name of the tag and the
+// position of the value it matches. The # the key starts with is removed, and
+// a key that ends with a colon and a number matches the value at that
+// position of the tags instead of the first value, at position 1.
+//
+// For example "#t" matches the first value of t tags, and "#imeta:2" matches
+// the second value of imeta tags.
+func TagKey(key []byte) (name []byte, position int) {
+	name, position = bytes.TrimPrefix(key, []byte{'#'}), 1
+	if i := bytes.LastIndexByte(name, ':'); i > 0 {
+		if p, err := strconv.Atoi(string(name[i+1:])); err == nil && p > 0 {
+			name, position = name[:i], p
+		}
+	}
+	return
+}
+
+// TagNumber parses the value of a tag as a number, or as the unix timestamp
+// of a date in the form 2006-01-02, or of a time in RFC3339 form.
+func TagNumber(v []byte) (n float64, ok bool) {
+	var err error
+	if n, err = strconv.ParseFloat(string(v), 64); err == nil {
+		ok = !math.IsNaN(n) && !math.IsInf(n, 0)
+		return
+	}
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		var t time.Time
+		if t, err = time.Parse(layout, string(v)); err == nil {
+			return float64(t.Unix()), true
+		}
+	}
+	return
+}
+
+// TagRange parses a value of a tag of a filter that is a range of numbers,
+// written as min..max, where either bound may be left out. The bounds are
+// parsed by TagNumber, and are inclusive.
+//
+// A value is only a range in a tag whose key is not that of a NIP-01 tag, #
+// and one letter, as NIP-01 matches those values exactly. A one letter tag is
+// queried for a range with its position in the key, as in "#t:1".
+func TagRange(v []byte) (min, max float64, ok bool) {
+	lo, hi, found := bytes.Cut(v, []byte(".."))
+	if !found || len(lo)+len(hi) == 0 {
+		return
+	}
+	min, max = math.Inf(-1), math.Inf(1)
+	if len(lo) > 0 {
+		if min, ok = TagNumber(lo); !ok {
+			return
+		}
+	}
+	if len(hi) > 0 {
+		if max, ok = TagNumber(hi); !ok {
+			return
+		}
+	}
+	ok = min <= max
+	return
+}
+
+// rangeKey returns true if the values of a tag of a filter with a key may be
+// ranges, which they are not for the key of a NIP-01 tag, # and one letter.
+func rangeKey(key []byte) bool {
+	return len(bytes.TrimPrefix(key, []byte{'#'})) != 1
+}
+
+// IsTagQuery returns true if a tag of a filter needs more than the matching
+// of the first value of tags with a one letter key, that NIP-01 defines: its
+// key has a longer name or a position, or it has a range of values in a key
+// that has a position, or an area of a GeoQuery.
+func IsTagQuery(t *tag.T) bool {
+	if t == nil || t.Len() < 1 {
+		return false
+	}
+	name, position := TagKey(t.B(0))
+	if len(name) != 1 || position != 1 {
+		return true
+	}
+	ranges := rangeKey(t.B(0))
+	for _, v := range t.ToSliceOfBytes()[1:] {
+		if _, _, ok := TagRange(v); ok && ranges {
+			return true
+		}
+		if _, ok := isGeoQuery(name, position, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesTag returns true if the tags of an event have a tag that matches a
+// tag of a filter, one with its name, that has one of its values, a number in
+// one of its ranges, or a geohash in one of its areas, at the position of the
+// filter. The values of a NIP-01 tag are not ranges, as TagRange describes.
+func MatchesTag(evTags *tags.T, t *tag.T) bool {
+	if evTags == nil || t == nil || t.Len() < 2 {
+		return false
+	}
+	name, position := TagKey(t.B(0))
+	ranges := rangeKey(t.B(0))
+	values := t.ToSliceOfBytes()[1:]
+	for _, et := range evTags.ToSliceOfTags() {
+		if et.Len() <= position || !bytes.Equal(et.Key(), name) {
+			continue
+		}
+		ev := et.B(position)
+		for _, v := range values {
+			if min, max, ok := TagRange(v); ok && ranges {
+				if n, ok := TagNumber(ev); ok && n >= min && n <= max {
+					return true
+				}
+				continue
+			}
+			if bytes.Equal(ev, v) {
+				return true
+			}
+			if q, ok := isGeoQuery(name, position, v); ok && q.Matches(ev) {
+				return true
+			}
+		}
+	}
+	return false
+}
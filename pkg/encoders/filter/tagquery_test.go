@@ -0,0 +1,133 @@
+package filter
+
+import (
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/utils/chk"
+	"testing"
+)
+
+func TestTagKey(t *testing.T) {
+	for _, tc := range []struct {
+		key      string
+		name     string
+		position int
+	}{
+		{"#t", "t", 1},
+		{"#imeta", "imeta", 1},
+		{"#imeta:2", "imeta", 2},
+		{"#a:b", "a:b", 1},
+		{"#x:0", "x:0", 1},
+		{"e", "e", 1},
+	} {
+		name, position := TagKey([]byte(tc.key))
+		if string(name) != tc.name || position != tc.position {
+			t.Fatalf(
+				"%s is %s at %d, expected %s at %d", tc.key, name, position,
+				tc.name, tc.position,
+			)
+		}
+	}
+}
+
+func TestTagRange(t *testing.T) {
+	for _, tc := range []struct {
+		v        string
+		min, max float64
+		ok       bool
+	}{
+		{"1..2", 1, 2, true},
+		{"-1.5..", -1.5, 0, true},
+		{"..2024-01-02", 0, 1704153600, true},
+		{"2..1", 0, 0, false},
+		{"..", 0, 0, false},
+		{"a..1", 0, 0, false},
+		{"12", 0, 0, false},
+	} {
+		min, max, ok := TagRange([]byte(tc.v))
+		if ok != tc.ok {
+			t.Fatalf("%s parsed is %v, expected %v", tc.v, ok, tc.ok)
+		}
+		if !ok {
+			continue
+		}
+		if (tc.min != 0 && min != tc.min) || (tc.max != 0 && max != tc.max) {
+			t.Fatalf(
+				"%s is %f..%f, expected %f..%f", tc.v, min, max, tc.min,
+				tc.max,
+			)
+		}
+	}
+}
+
+func TestMatchesTag(t *testing.T) {
+	evTags := tags.New(
+		tag.New("imeta", "url https://example.com/a.png", "m image/png"),
+		tag.New("price", "12.5", "USD"),
+		tag.New("start", "2024-06-01"),
+		tag.New("t", "nostr"),
+		tag.New("n", "3"),
+	)
+	for _, tc := range []struct {
+		t       *tag.T
+		matches bool
+	}{
+		{tag.New("#imeta:2", "m image/png"), true},
+		{tag.New("#imeta", "m image/png"), false},
+		{tag.New("#price", "10..20"), true},
+		{tag.New("#price", "13.."), false},
+		{tag.New("#price", "1..2", "12.5"), true},
+		{tag.New("#price:2", "USD"), true},
+		{tag.New("#start", "2024-01-01..2024-12-31"), true},
+		{tag.New("#start", "..2024-05-31"), false},
+		{tag.New("#t", "nostr"), true},
+		{tag.New("#missing", "nostr"), false},
+		// the values of a NIP-01 tag are matched exactly, a range needs the
+		// position in the key.
+		{tag.New("#n", "1..5"), false},
+		{tag.New("#n:1", "1..5"), true},
+	} {
+		if MatchesTag(evTags, tc.t) != tc.matches {
+			t.Fatalf("%s matching is not %v", tc.t.ToSliceOfBytes(), tc.matches)
+		}
+	}
+}
+
+func TestTagQueryMarshalUnmarshal(t *testing.T) {
+	var err error
+	f := New()
+	f.Tags = tags.New(
+		tag.New("#imeta:2", "m image/png"), tag.New("#price", "10..20"),
+	)
+	b := f.Marshal(nil)
+	fa := New()
+	if _, err = fa.Unmarshal(b); chk.E(err) {
+		t.Fatalf("unmarshal error: %v\n%s", err, b)
+	}
+	if string(fa.Marshal(nil)) != string(b) {
+		t.Fatalf("marshal error:\n%s\n%s", b, fa.Marshal(nil))
+	}
+	if !fa.Tags.Equal(f.Tags) {
+		t.Fatalf("tags are %s, expected %s", fa.Tags.Marshal(nil), b)
+	}
+}
+
+func TestIsTagQuery(t *testing.T) {
+	for _, tc := range []struct {
+		t       *tag.T
+		isQuery bool
+	}{
+		{tag.New("#t", "nostr"), false},
+		{tag.New("#t", "1..5"), false},
+		{tag.New("#t:1", "1..5"), true},
+		{tag.New("#t:2", "nostr"), true},
+		{tag.New("#price", "1..5"), true},
+	} {
+		if IsTagQuery(tc.t) != tc.isQuery {
+			t.Fatalf(
+				"%s is a tag query is not %v", tc.t.ToSliceOfBytes(),
+				tc.isQuery,
+			)
+		}
+	}
+}
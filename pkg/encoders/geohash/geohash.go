@@ -0,0 +1,239 @@
+// Package geohash encodes and decodes geohashes, the base32 strings that name
+// the cells of a grid of the surface of the earth, where each character
+// divides a cell into 32 smaller cells, so that the cells inside a cell have
+// its geohash as a prefix.
+package geohash
+
+import (
+	"math"
+)
+
+const (
+	// MaxPrecision is the number of characters of the longest geohash, about
+	// 4cm by 2cm at the equator, whose 60 bits fit in a uint64.
+	MaxPrecision = 12
+	// EarthRadius is the mean radius of the earth, in meters.
+	EarthRadius = 6371008.8
+)
+
+// alphabet is the base32 alphabet of geohashes, which is in the same order as
+// the values of the characters, so geohashes sort in the order of their bits.
+const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// values are the 5 bit values of the characters of the alphabet, or -1.
+var values [256]int8
+
+func init() {
+	for i := range values {
+		values[i] = -1
+	}
+	for i := 0; i < len(alphabet); i++ {
+		values[alphabet[i]] = int8(i)
+	}
+}
+
+// Box is an area between two latitudes and two longitudes, in degrees.
+type Box struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+}
+
+// Contains returns true if a point is inside the box, or on its edge.
+func (b Box) Contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon &&
+		lon <= b.MaxLon
+}
+
+// Center returns the point in the middle of the box.
+func (b Box) Center() (lat, lon float64) {
+	return (b.MinLat + b.MaxLat) / 2, (b.MinLon + b.MaxLon) / 2
+}
+
+// Valid returns true if a geohash is between 1 and MaxPrecision characters of
+// the geohash alphabet.
+func Valid(g []byte) bool {
+	if len(g) == 0 || len(g) > MaxPrecision {
+		return false
+	}
+	for _, c := range g {
+		if values[c] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode returns the geohash of a point with a number of characters, which is
+// limited to MaxPrecision.
+func Encode(lat, lon float64, precision int) (g []byte) {
+	precision = max(1, min(precision, MaxPrecision))
+	box := Box{-90, -180, 90, 180}
+	g = make([]byte, precision)
+	even := true
+	for i := range g {
+		var v byte
+		for bit := 4; bit >= 0; bit-- {
+			if even {
+				mid := (box.MinLon + box.MaxLon) / 2
+				if lon >= mid {
+					v |= 1 << bit
+					box.MinLon = mid
+				} else {
+					box.MaxLon = mid
+				}
+			} else {
+				mid := (box.MinLat + box.MaxLat) / 2
+				if lat >= mid {
+					v |= 1 << bit
+					box.MinLat = mid
+				} else {
+					box.MaxLat = mid
+				}
+			}
+			even = !even
+		}
+		g[i] = alphabet[v]
+	}
+	return
+}
+
+// Decode returns the cell of a geohash, or false if it isn't valid.
+func Decode(g []byte) (box Box, ok bool) {
+	if !Valid(g) {
+		return
+	}
+	box = Box{-90, -180, 90, 180}
+	even := true
+	for _, c := range g {
+		v := values[c]
+		for bit := 4; bit >= 0; bit-- {
+			if even {
+				mid := (box.MinLon + box.MaxLon) / 2
+				if v&(1<<bit) != 0 {
+					box.MinLon = mid
+				} else {
+					box.MaxLon = mid
+				}
+			} else {
+				mid := (box.MinLat + box.MaxLat) / 2
+				if v&(1<<bit) != 0 {
+					box.MinLat = mid
+				} else {
+					box.MaxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return box, true
+}
+
+// Uint64 returns the bits of a geohash, from the most significant bit of the
+// top 60 bits, followed by its length in the lowest 4 bits, so that the
+// numbers of geohashes are in the order of the geohashes, and a geohash comes
+// before the longer geohashes it is a prefix of. It returns false if the
+// geohash isn't valid.
+func Uint64(g []byte) (u uint64, ok bool) {
+	if !Valid(g) {
+		return
+	}
+	for i, c := range g {
+		u |= uint64(values[c]) << (59 - 5*uint(i))
+	}
+	return u | uint64(len(g)), true
+}
+
+// PrefixRange returns the first and the last of the numbers of Uint64 that
+// are of the geohashes that start with a prefix, which are all those in
+// between. It returns false if the prefix isn't valid.
+func PrefixRange(prefix []byte) (first, last uint64, ok bool) {
+	var u uint64
+	if u, ok = Uint64(prefix); !ok {
+		return
+	}
+	bits := u &^ 0xf
+	// the geohashes that start with the prefix are those with the same top
+	// bits, that are at least as long.
+	first = bits | uint64(len(prefix))
+	last = bits | (1<<(64-5*uint(len(prefix))) - 1)
+	return
+}
+
+// Distance returns the distance in meters along the surface of the earth
+// between two points.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rlat1, rlat2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dlat, dlon := rlat2-rlat1, (lon2-lon1)*math.Pi/180
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(rlat1)*math.Cos(rlat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Around returns the box that contains the circle with a radius in meters
+// around a point.
+func Around(lat, lon, radius float64) (box Box) {
+	dlat := radius / EarthRadius * 180 / math.Pi
+	box.MinLat, box.MaxLat = max(-90, lat-dlat), min(90, lat+dlat)
+	// the circle reaches a pole, so it is all the longitudes.
+	if box.MinLat == -90 || box.MaxLat == 90 {
+		box.MinLon, box.MaxLon = -180, 180
+		return
+	}
+	dlon := dlat / math.Cos(lat*math.Pi/180)
+	box.MinLon, box.MaxLon = max(-180, lon-dlon), min(180, lon+dlon)
+	return
+}
+
+// Cover returns the geohashes of the cells that cover a box, of the longest
+// length that needs no more than maxCells of them, and at least one
+// character long.
+func Cover(box Box, maxCells int) (cells [][]byte) {
+	box.MinLat, box.MaxLat = max(-90, box.MinLat), min(90, box.MaxLat)
+	box.MinLon, box.MaxLon = max(-180, box.MinLon), min(180, box.MaxLon)
+	if box.MinLat > box.MaxLat || box.MinLon > box.MaxLon {
+		return
+	}
+	precision := MaxPrecision
+	var height, width float64
+	for ; precision > 1; precision-- {
+		height, width = cellSize(precision)
+		rows := math.Floor(box.MaxLat/height) -
+			math.Floor(box.MinLat/height) + 1
+		cols := math.Floor(box.MaxLon/width) -
+			math.Floor(box.MinLon/width) + 1
+		if rows*cols <= float64(maxCells) {
+			break
+		}
+	}
+	height, width = cellSize(precision)
+	seen := make(map[string]bool)
+	// the points of a grid of the size of the cells, from the corner of the
+	// box, are in each of the cells it covers, as are its other edges.
+	for lat := box.MinLat; ; lat += height {
+		lat = min(lat, box.MaxLat)
+		for lon := box.MinLon; ; lon += width {
+			lon = min(lon, box.MaxLon)
+			g := Encode(lat, lon, precision)
+			if !seen[string(g)] {
+				seen[string(g)] = true
+				cells = append(cells, g)
+			}
+			if lon == box.MaxLon {
+				break
+			}
+		}
+		if lat == box.MaxLat {
+			break
+		}
+	}
+	return
+}
+
+// cellSize returns the height and width in degrees of the cells of geohashes
+// of a length, whose bits alternate between longitude and latitude, starting
+// with longitude.
+func cellSize(precision int) (height, width float64) {
+	bits := 5 * precision
+	height = 180 / math.Exp2(float64(bits/2))
+	width = 360 / math.Exp2(float64(bits-bits/2))
+	return
+}
@@ -0,0 +1,91 @@
+package geohash
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	g := Encode(57.64911, 10.40744, 11)
+	if string(g) != "u4pruydqqvj" {
+		t.Fatalf("encoded %s, expected u4pruydqqvj", g)
+	}
+	box, ok := Decode(g)
+	if !ok {
+		t.Fatal("failed to decode")
+	}
+	if !box.Contains(57.64911, 10.40744) {
+		t.Fatalf("%+v doesn't contain the point", box)
+	}
+	if _, ok = Decode([]byte("u4pa")); ok {
+		t.Fatal("decoded a geohash with a letter not in the alphabet")
+	}
+}
+
+func TestUint64(t *testing.T) {
+	// the numbers of geohashes sort like the geohashes, with the prefixes
+	// first.
+	hashes := []string{"0", "00", "s", "u", "u4", "u40", "u4pr", "u4pruy", "z"}
+	var numbers []uint64
+	for _, g := range hashes {
+		u, ok := Uint64([]byte(g))
+		if !ok {
+			t.Fatalf("failed to encode %s", g)
+		}
+		numbers = append(numbers, u)
+	}
+	if !sort.SliceIsSorted(
+		numbers, func(i, j int) bool { return numbers[i] < numbers[j] },
+	) {
+		t.Fatalf("numbers of %v are not in order: %x", hashes, numbers)
+	}
+	first, last, ok := PrefixRange([]byte("u4"))
+	if !ok {
+		t.Fatal("failed to get the range of u4")
+	}
+	for i, g := range hashes {
+		in := numbers[i] >= first && numbers[i] <= last
+		if in != bytes.HasPrefix([]byte(g), []byte("u4")) {
+			t.Fatalf("%s being in the range of u4 is %v", g, in)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// one degree of latitude is about 111km.
+	d := Distance(0, 0, 1, 0)
+	if math.Abs(d-111195) > 10 {
+		t.Fatalf("a degree of latitude is %fm", d)
+	}
+	box := Around(60, 10, 1000)
+	if !box.Contains(60.0089, 10) || box.Contains(60.01, 10) ||
+		!box.Contains(60, 10.0179) || box.Contains(60, 10.02) {
+		t.Fatalf("%+v is not the box around 1km from 60,10", box)
+	}
+}
+
+func TestCover(t *testing.T) {
+	box := Box{59.9, 10.7, 60.0, 10.8}
+	cells := Cover(box, 32)
+	if len(cells) == 0 || len(cells) > 32 {
+		t.Fatalf("covered with %d cells", len(cells))
+	}
+	// every point of the box is in one of the cells.
+	for lat := box.MinLat; lat <= box.MaxLat; lat += 0.01 {
+		for lon := box.MinLon; lon <= box.MaxLon; lon += 0.01 {
+			g := Encode(lat, lon, MaxPrecision)
+			var found bool
+			for _, cell := range cells {
+				if bytes.HasPrefix(g, cell) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Fatalf("%f,%f is not in the cells %s", lat, lon, cells)
+			}
+		}
+	}
+}
@@ -281,6 +281,9 @@ var (
 	// NostrConnect is an event type that...
 	NostrConnect = &T{24133}
 	HTTPAuth     = &T{27235}
+	// ClusterAnnouncement is an event type that a relay cluster node sends to
+	// the other nodes to announce its presence and its view of the membership.
+	ClusterAnnouncement = &T{28334}
 	// EphemeralEnd is an event type that...
 	EphemeralEnd = &T{30000}
 	// ParameterizedReplaceableStart is an event type that...
@@ -388,6 +391,7 @@ var Map = map[uint16]string{
 	WalletNotification.K:          "WalletNotification",
 	NostrConnect.K:                "NostrConnect",
 	HTTPAuth.K:                    "HTTPAuth",
+	ClusterAnnouncement.K:         "ClusterAnnouncement",
 	FollowSets.K:                  "FollowSets",
 	GenericLists.K:                "GenericLists",
 	RelaySets.K:                   "RelaySets",
@@ -116,3 +116,10 @@ func (t *T) Unmarshal(b []byte) (r []byte, err error) {
 func (t *T) MarshalJSON() ([]byte, error) {
 	return ints.New(t.U64()).Marshal(nil), nil
 }
+
+// UnmarshalJSON unmarshals a timestamp.T using the json UnmarshalJSON
+// interface.
+func (t *T) UnmarshalJSON(b []byte) (err error) {
+	_, err = t.Unmarshal(b)
+	return
+}
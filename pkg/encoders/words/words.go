@@ -0,0 +1,153 @@
+// Package words is a tokenizer for the full text search index of NIP-50,
+// converting event content and search queries into normalized words.
+package words
+
+import (
+	"bytes"
+	"orly.dev/pkg/encoders/event"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MinLength is the shortest word, in bytes, that is indexed.
+	MinLength = 2
+	// MaxLength is the longest word, in bytes, that is indexed. Longer strings
+	// are almost always hashes, keys or encoded data, not words.
+	MaxLength = 32
+)
+
+// Tags are the keys of the tags whose values are indexed along with the
+// content of an event.
+var Tags = [][]byte{
+	[]byte("t"),
+	[]byte("title"),
+	[]byte("subject"),
+	[]byte("summary"),
+	[]byte("alt"),
+	[]byte("name"),
+}
+
+// stop is a list of very common english words that are not indexed, as they
+// would have enormous postings lists and contribute nothing to relevance.
+var stop = map[string]struct{}{
+	"an": {}, "and": {}, "are": {}, "as": {}, "at": {}, "be": {}, "but": {},
+	"by": {}, "for": {}, "if": {}, "in": {}, "into": {}, "is": {}, "it": {},
+	"no": {}, "not": {}, "of": {}, "on": {}, "or": {}, "so": {}, "such": {},
+	"that": {}, "the": {}, "their": {}, "then": {}, "there": {}, "these": {},
+	"they": {}, "this": {}, "to": {}, "was": {}, "will": {}, "with": {},
+}
+
+// Tokenize splits a text into lower case words at every character that is not
+// a letter or a number, and adds the words that are eligible for indexing to
+// the given set.
+func Tokenize(text []byte, set map[string]struct{}) {
+	for len(text) > 0 {
+		// skip to the start of the next word
+		i := bytes.IndexFunc(text, isWordRune)
+		if i < 0 {
+			return
+		}
+		text = text[i:]
+		// find the end of the word
+		end := bytes.IndexFunc(text, func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(text)
+		}
+		word := bytes.ToLower(text[:end])
+		text = text[end:]
+		if len(word) < MinLength || len(word) > MaxLength ||
+			!utf8.Valid(word) {
+			continue
+		}
+		if _, ok := stop[string(word)]; ok {
+			continue
+		}
+		set[string(word)] = struct{}{}
+	}
+}
+
+func isWordRune(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }
+
+// FromEvent returns the distinct words of the content and the selected Tags of
+// an event, in sorted order.
+//
+// Privileged kinds, such as direct messages, are not indexed, their content is
+// encrypted and is only visible to the parties of the conversation anyway.
+func FromEvent(ev *event.E) (w [][]byte) {
+	if ev.Kind.IsPrivileged() {
+		return
+	}
+	set := make(map[string]struct{})
+	Tokenize(ev.Content, set)
+	if ev.Tags != nil {
+		for _, t := range ev.Tags.ToSliceOfTags() {
+			if t.Len() < 2 {
+				continue
+			}
+			for _, k := range Tags {
+				if bytes.Equal(t.Key(), k) {
+					Tokenize(t.Value(), set)
+					break
+				}
+			}
+		}
+	}
+	return sorted(set)
+}
+
+// Query is a parsed NIP-50 search string.
+type Query struct {
+	// Terms are the distinct words to search for.
+	Terms [][]byte
+	// Extensions are the key:value pairs found in the search string, such as
+	// `sort:recent`. Unsupported extensions are ignored.
+	Extensions map[string]string
+}
+
+// Recent returns true if the search requested results in order of recency
+// rather than relevance, with the `sort:recent` extension.
+func (q *Query) Recent() bool { return q.Extensions["sort"] == "recent" }
+
+// Parse reads a NIP-50 search string into its terms and extensions.
+func Parse(search []byte) (q *Query) {
+	q = &Query{Extensions: make(map[string]string)}
+	set := make(map[string]struct{})
+	for _, field := range bytes.Fields(search) {
+		if k, v, found := bytes.Cut(field, []byte(":")); found &&
+			len(k) > 0 && len(v) > 0 && bytes.IndexFunc(
+			k, func(r rune) bool { return !unicode.IsLetter(r) },
+		) < 0 {
+			q.Extensions[string(bytes.ToLower(k))] = string(v)
+			continue
+		}
+		Tokenize(field, set)
+	}
+	q.Terms = sorted(set)
+	return
+}
+
+// Score returns the number of the terms of the Query found in an event. Zero
+// means the event does not match.
+func (q *Query) Score(ev *event.E) (score int) {
+	found := make(map[string]struct{})
+	for _, w := range FromEvent(ev) {
+		found[string(w)] = struct{}{}
+	}
+	for _, t := range q.Terms {
+		if _, ok := found[string(t)]; ok {
+			score++
+		}
+	}
+	return
+}
+
+func sorted(set map[string]struct{}) (w [][]byte) {
+	w = make([][]byte, 0, len(set))
+	for s := range set {
+		w = append(w, []byte(s))
+	}
+	sort.Slice(w, func(i, j int) bool { return bytes.Compare(w[i], w[j]) < 0 })
+	return
+}
@@ -0,0 +1,53 @@
+package words
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"testing"
+)
+
+func TestFromEvent(t *testing.T) {
+	ev := event.New()
+	ev.Kind = kind.TextNote
+	ev.Content = []byte("The Quick, brown fox jumps over the lazy dog! Fox 2024 x")
+	ev.Tags = tags.New(
+		tag.New("t", "Nostr"),
+		tag.New("e", "abcdef"),
+	)
+	got := FromEvent(ev)
+	expected := []string{
+		"2024", "brown", "dog", "fox", "jumps", "lazy", "nostr", "over",
+		"quick",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("got %d words %s, expected %v", len(got), got, expected)
+	}
+	for i := range got {
+		if string(got[i]) != expected[i] {
+			t.Fatalf("got word %s at %d, expected %s", got[i], i, expected[i])
+		}
+	}
+	ev.Kind = kind.EncryptedDirectMessage
+	if got = FromEvent(ev); len(got) != 0 {
+		t.Fatalf("privileged event content should not be indexed, got %s", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	q := Parse([]byte("Brown FOX sort:recent language:en"))
+	if len(q.Terms) != 2 || string(q.Terms[0]) != "brown" ||
+		string(q.Terms[1]) != "fox" {
+		t.Fatalf("unexpected terms %s", q.Terms)
+	}
+	if !q.Recent() || q.Extensions["language"] != "en" {
+		t.Fatalf("unexpected extensions %v", q.Extensions)
+	}
+	ev := event.New()
+	ev.Kind = kind.TextNote
+	ev.Content = []byte("a brown dog")
+	if s := q.Score(ev); s != 1 {
+		t.Fatalf("got score %d, expected 1", s)
+	}
+}
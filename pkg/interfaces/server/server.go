@@ -3,11 +3,14 @@ package server
 import (
 	"net/http"
 	"orly.dev/pkg/app/config"
+	"orly.dev/pkg/app/relay/cluster"
 	"orly.dev/pkg/app/relay/publish"
+	"orly.dev/pkg/app/relay/replicate"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/filters"
 	"orly.dev/pkg/interfaces/relay"
 	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/relayinfo"
 	"orly.dev/pkg/utils/context"
 	"time"
 )
@@ -25,6 +28,9 @@ type I interface {
 		c context.T, rl relay.I, ev *event.E, hr *http.Request, origin string,
 		pubkeys [][]byte,
 	) (accepted bool, message []byte)
+	AllowEvent(remote string, authedPubkey []byte) bool
+	AllowReq(remote string, authedPubkey []byte) bool
+	AllowBytes(remote string, authedPubkey []byte, n int) bool
 	AdminAuth(
 		r *http.Request, remote string, tolerance ...time.Duration,
 	) (authed bool, pubkey []byte)
@@ -42,4 +48,13 @@ type I interface {
 	ServiceURL(req *http.Request) (s string)
 	OwnersPubkeys() (pks [][]byte)
 	Config() *config.C
+	Configuration() *store.Configuration
+	SetConfiguration(conf *store.Configuration) (err error)
+	Limits() relayinfo.Limits
+	Replication() (peers []replicate.PeerState)
+	DropReplication(pubkey []byte) (err error)
+	ClusterAnnounce(ev *event.E) (reply *event.E, err error)
+	Cluster() (members []cluster.MemberState, err error)
+	ClusterAdd(pubkey []byte, address string) (err error)
+	ClusterRemove(pubkey []byte) (err error)
 }
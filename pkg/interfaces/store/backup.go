@@ -0,0 +1,36 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"orly.dev/pkg/utils/context"
+)
+
+// BackupInfo describes a backup of an event store.
+type BackupInfo struct {
+	Format  int    `json:"format" doc:"version of the format of the backup"`
+	Created int64  `json:"created" doc:"unix time the backup was made"`
+	Since   uint64 `json:"since" doc:"the oldest version of the store in the backup, 0 for a full backup"`
+	Upto    uint64 `json:"upto" doc:"the newest version of the store in the backup"`
+	Next    uint64 `json:"next" doc:"the since of the next incremental backup"`
+	Sha256  string `json:"sha256" doc:"checksum of the backup in hex"`
+	Bytes   int64  `json:"bytes" doc:"size of the backup in bytes"`
+}
+
+// ErrInvalidBackup is the error of a backup that is corrupt, truncated or not
+// a backup, which nothing is restored from.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Backuper is an event store that makes consistent backups of itself while it
+// is in use, and restores them with the serials of the events and their
+// indexes as they were.
+type Backuper interface {
+	// Backup writes a backup of the changes to the store since a version, or
+	// all of it if since is 0.
+	Backup(c context.T, w io.Writer, since uint64) (info *BackupInfo, err error)
+	// Restore loads a backup into the store. A full backup can only be
+	// restored into a store with no events, and incremental backups must be
+	// restored in order after it, the others are refused. Nothing is loaded
+	// from a backup that is not intact, which is an ErrInvalidBackup.
+	Restore(c context.T, r io.Reader) (info *BackupInfo, err error)
+}
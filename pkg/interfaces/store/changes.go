@@ -0,0 +1,74 @@
+package store
+
+import (
+	"errors"
+	"iter"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/context"
+)
+
+// The types of the changes in the change log of an event store.
+const (
+	// ChangeInsert is an event that was saved.
+	ChangeInsert = "insert"
+	// ChangeReplace is a replaceable event that was saved and superseded the
+	// versions that the ChangeDelete entries that follow it delete.
+	ChangeReplace = "replace"
+	// ChangeDelete is an event that was deleted.
+	ChangeDelete = "delete"
+	// ChangeWipe is the deletion of all the events of the store.
+	ChangeWipe = "wipe"
+)
+
+// The reasons an event is deleted.
+const (
+	// ReasonDeletion is a deletion by a NIP-09 deletion event.
+	ReasonDeletion = "deletion"
+	// ReasonReplaced is a version of a replaceable event superseded by a
+	// newer one.
+	ReasonReplaced = "replaced"
+	// ReasonExpired is an event with a NIP-40 expiration that has passed.
+	ReasonExpired = "expired"
+	// ReasonRemoved is an event deleted by the relay, such as by an admin or
+	// the retention rules.
+	ReasonRemoved = "removed"
+)
+
+// Change is an entry of the change log of an event store.
+type Change struct {
+	Seq    uint64   `json:"seq" doc:"position of the change in the log, the changes after it are from seq+1"`
+	Type   string   `json:"type" doc:"insert, replace, delete or wipe"`
+	Time   int64    `json:"time" doc:"unix time of the change"`
+	Serial uint64   `json:"serial,omitempty" doc:"serial of the event in the store"`
+	Id     string   `json:"id,omitempty" doc:"id of the event in hex"`
+	Pubkey string   `json:"pubkey,omitempty" doc:"pubkey of the author of the event in hex"`
+	Kind   uint16   `json:"kind" doc:"kind of the event"`
+	Reason string   `json:"reason,omitempty" doc:"why the event was deleted: deletion, replaced, expired or removed"`
+	By     string   `json:"by,omitempty" doc:"id in hex of the deletion or newer version that deleted the event"`
+	Event  *event.J `json:"event,omitempty" doc:"the event that was saved, if it is still stored"`
+}
+
+// ErrChangesTrimmed is the error of a request for the changes from a Seq
+// whose entries have been trimmed from the log, as the changes after it would
+// be missed.
+var ErrChangesTrimmed = errors.New("the changes have been trimmed from the log")
+
+// Changer is an event store that records the events it saves and deletes in
+// a change log, in the order of their serials, so that another system can
+// follow it from where it left off.
+type Changer interface {
+	// Changes returns up to max of the changes from a Seq, in order, and the
+	// Seq to get the next changes from, which can be past the last of them
+	// when there are gaps in the log. A Seq other than 0 that is before the
+	// first entry the log keeps is refused with ErrChangesTrimmed.
+	Changes(c context.T, from uint64, max int) (
+		changes []*Change, next uint64, err error,
+	)
+	// WaitChanges blocks until there may be changes from a Seq, or the
+	// context is cancelled, in which case its error is returned.
+	WaitChanges(c context.T, from uint64) (err error)
+	// Follow yields the changes from a Seq as they are made, until the
+	// iteration is stopped or the context is cancelled, which is yielded as
+	// an error.
+	Follow(c context.T, from uint64) iter.Seq2[*Change, error]
+}
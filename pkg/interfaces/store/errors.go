@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrDupEvent       = errors.New("duplicate: event already exists")
 	ErrEventNotExists = errors.New("unknown: event not known by any source of this realy")
+	ErrSuperseded     = errors.New("invalid: not replacing newer replaceable event")
 )
@@ -0,0 +1,17 @@
+package store
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/context"
+)
+
+// Evicter is an event store on disk whose oldest events can be deleted to keep
+// it within a budget for its size.
+type Evicter interface {
+	// DiskSize returns the size in bytes of the files of the store.
+	DiskSize() (size int64, err error)
+	// StreamOldest calls fn with each stored event, oldest first by its
+	// created_at, until fn returns false or the context is cancelled, in which
+	// case the error of the context is returned.
+	StreamOldest(c context.T, fn func(ev *event.E) (more bool)) (err error)
+}
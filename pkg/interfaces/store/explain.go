@@ -0,0 +1,47 @@
+package store
+
+import (
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/utils/context"
+)
+
+// QueryPlan is the plan chosen for the query of a filter, and what was done
+// when the query was run.
+type QueryPlan struct {
+	Index      string          `json:"index" doc:"the prefix of the index that drives the query"`
+	Estimate   uint64          `json:"estimate" doc:"the estimated number of index keys that match the driving index"`
+	Cost       uint64          `json:"cost" doc:"the estimated cost of the plan"`
+	Residual   []string        `json:"residual,omitempty" doc:"the fields of the filter checked on each event found, that the driving index doesn't cover"`
+	Limit      int             `json:"limit,omitempty" doc:"the number of matching events after which the scan of each range stops"`
+	Since      int64           `json:"since,omitempty" doc:"the oldest timestamp of the ranges"`
+	Until      int64           `json:"until,omitempty" doc:"the newest timestamp of the ranges"`
+	Candidates []PlanCandidate `json:"candidates,omitempty" doc:"the plans that were considered, cheapest first"`
+	Ranges     []PlanRange     `json:"ranges" doc:"the ranges of the driving index that were scanned"`
+	Keys       int             `json:"keys" doc:"the number of index keys touched"`
+	Fetched    int             `json:"fetched" doc:"the number of events fetched to check the residual fields"`
+	Results    int             `json:"results" doc:"the number of events found"`
+	Micros     int64           `json:"micros" doc:"the time the query took, in microseconds"`
+}
+
+// PlanCandidate is a plan considered for a query.
+type PlanCandidate struct {
+	Index    string `json:"index" doc:"the prefix of the index that drives the plan"`
+	Ranges   int    `json:"ranges" doc:"the number of ranges of the index to scan"`
+	Estimate uint64 `json:"estimate" doc:"the estimated number of index keys that match"`
+	Cost     uint64 `json:"cost" doc:"the estimated cost of the plan"`
+}
+
+// PlanRange is a range of an index scanned by a query.
+type PlanRange struct {
+	Start   string `json:"start" doc:"the first key of the range, in hex"`
+	End     string `json:"end" doc:"the last key of the range, in hex"`
+	Keys    int    `json:"keys" doc:"the number of keys of the range touched"`
+	Matched int    `json:"matched" doc:"the number of keys that matched the filter"`
+}
+
+// Explainer is an event store that can explain how it queries a filter.
+type Explainer interface {
+	// Explain runs the query for a filter and returns the plan that was
+	// chosen for it, the ranges scanned and the keys touched.
+	Explain(c context.T, f *filter.F) (plan *QueryPlan, err error)
+}
@@ -0,0 +1,20 @@
+package store
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/utils/context"
+)
+
+// Pager is an event store that returns the results of a query a page at a
+// time.
+type Pager interface {
+	// QueryPage returns the events QueryEvents returns for a filter, and if
+	// the query reached the Limit of the filter, the Cursor of the last event
+	// it scanned, which gets the next page even when some of the events of
+	// this one were left out as deleted, expired or superseded. A filter with
+	// Ids or a search has no next page.
+	QueryPage(c context.T, f *filter.F) (
+		evs event.S, next *filter.Cursor, err error,
+	)
+}
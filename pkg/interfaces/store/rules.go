@@ -0,0 +1,143 @@
+package store
+
+import (
+	"bytes"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tag/atag"
+	"strconv"
+)
+
+// The helpers here are the NIP-01 replacement and NIP-09 deletion rules for
+// implementations of I that check them on whole events rather than with
+// indexes.
+
+// IsAddressable returns true if events of a kind are identified by an address
+// of their kind, pubkey and d tag.
+func IsAddressable(k *kind.T) bool {
+	return k.IsReplaceable() || k.IsParameterizedReplaceable()
+}
+
+// Address returns the address of a replaceable or parameterized replaceable
+// event in the form of an a tag, kind:pubkey:d. The d tag is only part of the
+// address of parameterized replaceable events.
+func Address(ev *event.E) string {
+	var dValue []byte
+	if ev.Kind.IsParameterizedReplaceable() {
+		if dTag := ev.Tags.GetFirst(tag.New("d")); dTag != nil &&
+			dTag.Len() > 1 {
+			dValue = dTag.Value()
+		}
+	}
+	return strconv.Itoa(int(ev.Kind.K)) + ":" + hex.Enc(ev.Pubkey) + ":" +
+		string(dValue)
+}
+
+// Supersedes returns true if an event is a newer version than another, by the
+// NIP-01 rule that the newest is retained, and of two with the same timestamp,
+// the one with the lowest id.
+func Supersedes(ev, other *event.E) bool {
+	if ev.CreatedAt.I64() != other.CreatedAt.I64() {
+		return ev.CreatedAt.I64() > other.CreatedAt.I64()
+	}
+	return bytes.Compare(ev.ID, other.ID) < 0
+}
+
+// ReplacePolicy decides what is done with the versions of a replaceable or
+// parameterized replaceable event that are superseded by a newer version.
+type ReplacePolicy struct {
+	// History retains all superseded versions instead of deleting them. Only
+	// the newest version is returned by queries, except by id.
+	History bool
+	// Retain returns true for kinds whose superseded versions are always
+	// retained.
+	Retain func(k *kind.T) bool
+}
+
+// DefaultReplacePolicy deletes superseded versions, except of directory
+// events, as some clients query for them in ways that pull in older versions,
+// and a backup can recover the data of old ones.
+var DefaultReplacePolicy = ReplacePolicy{
+	Retain: func(k *kind.T) bool { return k.IsDirectoryEvent() },
+}
+
+// Retains returns true if superseded versions of a kind are not deleted.
+func (p ReplacePolicy) Retains(k *kind.T) bool {
+	return p.History || (p.Retain != nil && p.Retain(k))
+}
+
+// DeletedBy returns true if a NIP-09 deletion event deletes an event.
+//
+// An event in an e tag is deleted if the deletion is by its author or one of
+// the owners. All versions of an address in an a tag that are not newer than
+// the deletion are deleted, by the same rule. Deletion events are never
+// deleted.
+func DeletedBy(deletion, ev *event.E, owners [][]byte) bool {
+	if !deletion.Kind.Equal(kind.Deletion) || ev.Kind.Equal(kind.Deletion) {
+		return false
+	}
+	allowed := bytes.Equal(deletion.Pubkey, ev.Pubkey)
+	for _, owner := range owners {
+		if bytes.Equal(owner, deletion.Pubkey) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+	for _, t := range deletion.Tags.GetAll(tag.New("e")).ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		id := make([]byte, sha256.Size)
+		if _, err := hex.DecBytes(id, t.Value()); err != nil {
+			continue
+		}
+		if bytes.Equal(id, ev.ID) {
+			return true
+		}
+	}
+	if !IsAddressable(ev.Kind) ||
+		ev.CreatedAt.I64() > deletion.CreatedAt.I64() {
+		return false
+	}
+	address := Address(ev)
+	for _, t := range deletion.Tags.GetAll(tag.New("a")).ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		a := new(atag.T)
+		if _, err := a.Unmarshal(t.Value()); err != nil || a.Kind == nil {
+			continue
+		}
+		if string(a.Marshal(nil)) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if an event matches a filter. Unlike filter.F Matches,
+// each tag of the filter must match one of its values, as in NIP-01, however
+// many values of the event match.
+func Matches(f *filter.F, ev *event.E) bool {
+	ff := *f
+	ff.Tags = nil
+	if !ff.Matches(ev) {
+		return false
+	}
+	for _, t := range f.Tags.ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		if !filter.MatchesTag(ev.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"orly.dev/pkg/utils/context"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Stats is a report of what an event store contains and the space it uses on
+// disk.
+type Stats struct {
+	Time         int64         `json:"time" doc:"unix time the report was made"`
+	Events       uint64        `json:"events" doc:"number of events in the store"`
+	DiskSize     int64         `json:"disk_size" doc:"size in bytes of all the files in the data directory"`
+	LSMSize      int64         `json:"lsm_size" doc:"size in bytes of the LSM tree, which holds the keys and small values"`
+	ValueLogSize int64         `json:"value_log_size" doc:"size in bytes of the value log, which holds the large values"`
+	Kinds        []KindStats   `json:"kinds" doc:"number of events of each kind, most first"`
+	Authors      []AuthorStats `json:"authors" doc:"the authors with the most events, most first"`
+	Indexes      []IndexStats  `json:"indexes" doc:"number and size of the keys with each prefix, largest first"`
+	Levels       []LevelStats  `json:"levels" doc:"size of each level of the LSM tree"`
+	LastGC       *GCRun        `json:"last_gc,omitempty" doc:"the last garbage collection of the store"`
+	Warnings     []string      `json:"warnings,omitempty" doc:"problems found with the store"`
+}
+
+// KindStats is the number of events of a kind.
+type KindStats struct {
+	Kind   uint16 `json:"kind" doc:"event kind"`
+	Events uint64 `json:"events" doc:"number of events"`
+}
+
+// AuthorStats is the number and size of the events of an author.
+type AuthorStats struct {
+	Pubkey string `json:"pubkey" doc:"pubkey of the author in hex"`
+	Events uint64 `json:"events" doc:"number of events"`
+	Bytes  int64  `json:"bytes" doc:"size in bytes of the stored events"`
+}
+
+// IndexStats is the number and size of the keys with a prefix.
+type IndexStats struct {
+	Prefix     string `json:"prefix" doc:"key prefix"`
+	Name       string `json:"name" doc:"what the keys with the prefix are"`
+	Keys       uint64 `json:"keys" doc:"number of keys"`
+	KeyBytes   int64  `json:"key_bytes" doc:"size in bytes of the keys"`
+	ValueBytes int64  `json:"value_bytes" doc:"size in bytes of the values"`
+}
+
+// LevelStats is the size of a level of the LSM tree.
+type LevelStats struct {
+	Level      int   `json:"level" doc:"level number"`
+	Tables     int   `json:"tables" doc:"number of tables"`
+	Size       int64 `json:"size" doc:"size in bytes"`
+	TargetSize int64 `json:"target_size" doc:"size in bytes the level is compacted at"`
+	StaleBytes int64 `json:"stale_bytes" doc:"size in bytes of the deleted and overwritten data in the level"`
+}
+
+// GCRun is the result of a garbage collection of a store.
+type GCRun struct {
+	Time      int64  `json:"time" doc:"unix time the collection started"`
+	Duration  string `json:"duration" doc:"how long the collection took"`
+	Rewritten int    `json:"rewritten" doc:"number of value log files rewritten"`
+	Flattened bool   `json:"flattened" doc:"true if the LSM tree was compacted"`
+	Freed     int64  `json:"freed" doc:"bytes freed on disk, negative if the store grew"`
+	Error     string `json:"error,omitempty" doc:"error of the collection, if it failed"`
+}
+
+// Statser is an event store that reports on its contents and disk usage.
+type Statser interface {
+	// Stats returns a report of the store, with up to top authors.
+	Stats(c context.T, top int) (s *Stats, err error)
+}
+
+// Print writes the report in tables for reading in a terminal.
+func (s *Stats) Print(w io.Writer) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	p := func(format string, a ...any) { _, _ = fmt.Fprintf(tw, format, a...) }
+	p("report of %s\n\n", time.Unix(s.Time, 0).Format(time.RFC3339))
+	p("events\t%d\n", s.Events)
+	p("on disk\t%s\n", bytesize(s.DiskSize))
+	p("LSM tree\t%s\n", bytesize(s.LSMSize))
+	p("value log\t%s\n", bytesize(s.ValueLogSize))
+	p("\nkind\tevents\n")
+	for _, k := range s.Kinds {
+		p("%d\t%d\n", k.Kind, k.Events)
+	}
+	p("\nauthor\tevents\tsize\n")
+	for _, a := range s.Authors {
+		p("%s\t%d\t%s\n", a.Pubkey, a.Events, bytesize(a.Bytes))
+	}
+	p("\nprefix\tkeys\tkey size\tvalue size\t\n")
+	for _, i := range s.Indexes {
+		p(
+			"%s\t%d\t%s\t%s\t%s\n", i.Prefix, i.Keys, bytesize(i.KeyBytes),
+			bytesize(i.ValueBytes), i.Name,
+		)
+	}
+	p("\nlevel\ttables\tsize\ttarget size\tstale\n")
+	for _, l := range s.Levels {
+		p(
+			"%d\t%d\t%s\t%s\t%s\n", l.Level, l.Tables, bytesize(l.Size),
+			bytesize(l.TargetSize), bytesize(l.StaleBytes),
+		)
+	}
+	if s.LastGC != nil {
+		g := s.LastGC
+		p(
+			"\nlast garbage collection at %s took %s, rewrote %d value log "+
+				"files, freed %s", time.Unix(g.Time, 0).Format(time.RFC3339),
+			g.Duration, g.Rewritten, bytesize(g.Freed),
+		)
+		if g.Flattened {
+			p(" and compacted the LSM tree")
+		}
+		if g.Error != "" {
+			p(", failed: %s", g.Error)
+		}
+		p("\n")
+	}
+	if len(s.Warnings) > 0 {
+		p("\nwarnings:\n\n%s\n", strings.Join(s.Warnings, "\n"))
+	}
+	err = tw.Flush()
+	return
+}
+
+// bytesize formats a size in bytes with a unit of a power of 1000.
+func bytesize(n int64) string {
+	units := []string{"b", "kb", "Mb", "Gb", "Tb"}
+	f, u := float64(n), 0
+	for (f >= 1000 || f <= -1000) && u < len(units)-1 {
+		f /= 1000
+		u++
+	}
+	if u == 0 {
+		return fmt.Sprintf("%d%s", n, units[u])
+	}
+	return fmt.Sprintf("%.1f%s", f, units[u])
+}
+
+// GarbageCollector is an event store that reclaims the space of deleted and
+// overwritten data on demand.
+type GarbageCollector interface {
+	// CollectGarbage collects the garbage of the store and returns the result.
+	CollectGarbage() (run *GCRun)
+}
@@ -7,8 +7,8 @@
 package store
 
 import (
+	"errors"
 	"io"
-	"orly.dev/pkg/app/config"
 	"orly.dev/pkg/database/indexes/types"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/eventid"
@@ -26,6 +26,7 @@ type I interface {
 	Wiper
 	Querier
 	Querent
+	Streamer
 	Deleter
 	Saver
 	Importer
@@ -35,6 +36,11 @@ type I interface {
 	EventIdSerialer
 	Initer
 	SerialByIder
+	Counter
+	Accountant
+	Rescanner
+	Configurationer
+	Replicator
 }
 
 type Initer interface {
@@ -57,7 +63,25 @@ type Querent interface {
 	QueryEvents(c context.T, f *filter.F) (evs event.S, err error)
 }
 
+type Streamer interface {
+	// StreamEvents is invoked upon a client's REQ as described in NIP-01. It
+	// calls fn with each matching event in reverse chronological order as it
+	// is fetched, and stops when fn returns false or the context is cancelled.
+	StreamEvents(
+		c context.T, f *filter.F, fn func(ev *event.E) (more bool),
+	) (err error)
+}
+
+type Counter interface {
+	// CountEvents is invoked upon a client's COUNT as described in NIP-45. It
+	// returns the number of events matching the filter without fetching them.
+	CountEvents(c context.T, f *filter.F) (
+		count int, approximate bool, err error,
+	)
+}
+
 type Accountant interface {
+	// EventCount returns the total number of events in the store.
 	EventCount() (count uint64, err error)
 }
 
@@ -116,13 +140,64 @@ type Syncer interface {
 	Sync() (err error)
 }
 
+// Configuration is the part of the relay configuration that can be changed
+// while the relay is running. It is stored in the database, and overrides the
+// values from the environment and .env file when the relay starts.
 type Configuration struct {
-	BlockList []string `json:"block_list" doc:"list of IP addresses that will be ignored"`
-}
+	BlockList        []string `json:"block_list,omitempty" doc:"list of IP addresses and CIDR ranges, such as 10.0.0.0/8, that will be ignored"`
+	Owners           []string `json:"owners,omitempty" doc:"list of users whose follow lists designate whitelisted users, in npub or hex format"`
+	Whitelist        []string `json:"whitelist,omitempty" doc:"only allow connections from this list of IP addresses"`
+	SpiderSeeds      []string `json:"spider_seeds,omitempty" doc:"relays that are looked up initially to find owner relay lists"`
+	PeerRelays       []string `json:"peer_relays,omitempty" doc:"peer relays that new events are pushed to in format <pubkey>@<url>"`
+	LogLevel         string   `json:"log_level,omitempty" doc:"log level: fatal error warn info debug trace"`
+	DbLogLevel       string   `json:"db_log_level,omitempty" doc:"database log level: fatal error warn info debug trace"`
+	MaxMessageLength int      `json:"max_message_length,omitempty" doc:"maximum size in bytes of a websocket message from a client (0 or left out keeps the current value)"`
+	MaxSubscriptions int      `json:"max_subscriptions,omitempty" doc:"maximum number of open subscriptions on a websocket connection (0 or left out keeps the current value)"`
+	MaxFilters       int      `json:"max_filters,omitempty" doc:"maximum number of filters in a subscription (0 or left out keeps the current value)"`
+	MaxLimit         int      `json:"max_limit,omitempty" doc:"maximum number of events returned for each filter (0 or left out keeps the current value)"`
+	MaxSubidLength   int      `json:"max_subid_length,omitempty" doc:"maximum length of a subscription id (0 or left out keeps the current value)"`
+}
+
+// ErrInvalidConfiguration is the error of a Configuration with invalid values.
+var ErrInvalidConfiguration = errors.New("invalid configuration")
 
 type Configurationer interface {
-	GetConfiguration() (c config.C, err error)
-	SetConfiguration(c config.C) (err error)
+	// GetConfiguration returns the stored configuration, or nil if none has
+	// been stored.
+	GetConfiguration() (c *Configuration, err error)
+	// SetConfiguration stores the configuration.
+	SetConfiguration(c *Configuration) (err error)
+}
+
+// ReplicationItem is an event in the outbound queue of a peer relay.
+type ReplicationItem struct {
+	// Seq is the position of the item in the queue, assigned when it is
+	// queued, starting from 1.
+	Seq uint64 `json:"-"`
+	// Queued is the unix time the item was queued.
+	Queued int64 `json:"queued"`
+	// Via are the pubkeys of the relays the event has already passed through,
+	// which the peer does not send it back to.
+	Via [][]byte `json:"via"`
+	// Event is the event in minified JSON.
+	Event []byte `json:"event"`
+}
+
+type Replicator interface {
+	// EnqueueReplication adds an item to the end of the outbound queues of
+	// the peers with the given pubkeys.
+	EnqueueReplication(peers [][]byte, item *ReplicationItem) (err error)
+	// ReplicationBatch returns up to max items from the front of the queue of
+	// a peer, in the order they were queued.
+	ReplicationBatch(peer []byte, max int) (items []*ReplicationItem, err error)
+	// AckReplication removes the items of the queue of a peer up to and
+	// including the one with the given Seq.
+	AckReplication(peer []byte, seq uint64) (err error)
+	// ReplicationQueueLen returns the number of items in the queue of a peer,
+	// and the unix time the oldest of them was queued.
+	ReplicationQueueLen(peer []byte) (n int, oldest int64, err error)
+	// DropReplication removes the queue of a peer.
+	DropReplication(peer []byte) (err error)
 }
 
 type LogLeveler interface {
@@ -130,6 +205,9 @@ type LogLeveler interface {
 }
 
 type EventIdSerialer interface {
+	// EventIdsBySerial returns the ids and serials of up to count events
+	// from a serial, in order. The serials have gaps, so the page after one
+	// starts after the last of its serials, and the last is shorter.
 	EventIdsBySerial(start uint64, count int) (
 		evs []eventidserial.E,
 		err error,
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+)
+
+// Usage is the number and size of the Metered events an author has stored.
+type Usage struct {
+	// Events is the number of events.
+	Events int
+	// Bytes is the Size of the events.
+	Bytes int64
+}
+
+// Size returns the size of an event in the binary encoding of
+// event.E.MarshalBinary, which unlike its JSON is the same for the event as it
+// is received and as it is read back from a store.
+func Size(ev *event.E) (n int64) {
+	var w counter
+	ev.MarshalBinary(&w)
+	return int64(w)
+}
+
+// counter is an io.Writer that counts the bytes written to it.
+type counter int64
+
+func (c *counter) Write(p []byte) (n int, err error) {
+	*c += counter(len(p))
+	return len(p), nil
+}
+
+// Metered returns true if an event counts toward the Usage of its author.
+// Replaceable and parameterized replaceable events, which replace their older
+// versions, and deletion events are not counted, nor are ephemeral events,
+// which are not stored.
+func Metered(ev *event.E) bool {
+	return !IsAddressable(ev.Kind) && !ev.Kind.Equal(kind.Deletion) &&
+		!ev.Kind.IsEphemeral()
+}
+
+// Meter is an event store that keeps the Usage of each author up to date as
+// it saves and deletes their events, so it is known without reading them.
+type Meter interface {
+	// Usage returns the Usage of an author, and false if the usage of the
+	// authors has not been counted yet since the store was opened.
+	Usage(pubkey []byte) (u Usage, ok bool)
+	// Usages returns the Usage of each author with events, by pubkey, and
+	// false if it has not been counted yet.
+	Usages() (usages map[string]Usage, ok bool)
+}
@@ -0,0 +1,158 @@
+package negentropy
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// FingerprintSize is the size of the fingerprint of a range of items.
+const FingerprintSize = 16
+
+// accumulator is the sum of ids as 256-bit little-endian unsigned integers,
+// modulo 2^256.
+type accumulator struct{ sum [IdSize]byte }
+
+func (a *accumulator) add(id []byte) {
+	var carry uint64
+	for i := 0; i < IdSize; i += 8 {
+		x := binary.LittleEndian.Uint64(a.sum[i:])
+		y := binary.LittleEndian.Uint64(id[i:])
+		var s uint64
+		s, carry = bits.Add64(x, y, carry)
+		binary.LittleEndian.PutUint64(a.sum[i:], s)
+	}
+}
+
+// fingerprint returns the hash of the sum and the number of items in it.
+func (a *accumulator) fingerprint(n int) (fp [FingerprintSize]byte) {
+	h := sha256.Sum256(append(a.sum[:], encodeVarint(nil, uint64(n))...))
+	copy(fp[:], h[:FingerprintSize])
+	return
+}
+
+// encodeVarint appends an unsigned integer as a base-128 varint with the most
+// significant group first, and the high bit set on all but the last byte.
+func encodeVarint(dst []byte, n uint64) []byte {
+	if n == 0 {
+		return append(dst, 0)
+	}
+	var buf [10]byte
+	i := len(buf)
+	for n != 0 {
+		i--
+		buf[i] = byte(n & 0x7f)
+		n >>= 7
+	}
+	for j := i; j < len(buf)-1; j++ {
+		buf[j] |= 0x80
+	}
+	return append(dst, buf[i:]...)
+}
+
+// reader decodes the fields of a message.
+type reader struct {
+	b []byte
+	// last is the last timestamp decoded, timestamps are encoded as the
+	// difference from the previous one in a message.
+	last uint64
+}
+
+func (r *reader) empty() bool { return len(r.b) == 0 }
+
+func (r *reader) byte() (b byte, err error) {
+	if len(r.b) == 0 {
+		err = errorf.E("negentropy: message ended unexpectedly")
+		return
+	}
+	b, r.b = r.b[0], r.b[1:]
+	return
+}
+
+func (r *reader) bytes(n int) (b []byte, err error) {
+	if len(r.b) < n {
+		err = errorf.E("negentropy: message ended unexpectedly")
+		return
+	}
+	b, r.b = r.b[:n], r.b[n:]
+	return
+}
+
+func (r *reader) varint() (n uint64, err error) {
+	for i := 0; ; i++ {
+		if i > 9 {
+			err = errorf.E("negentropy: varint too long")
+			return
+		}
+		var b byte
+		if b, err = r.byte(); err != nil {
+			return
+		}
+		n = n<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			return
+		}
+	}
+}
+
+func (r *reader) timestamp() (ts uint64, err error) {
+	if ts, err = r.varint(); err != nil {
+		return
+	}
+	if ts == 0 || r.last == math.MaxUint64 {
+		r.last = math.MaxUint64
+		return r.last, nil
+	}
+	ts--
+	if ts > math.MaxUint64-r.last {
+		r.last = math.MaxUint64
+		return r.last, nil
+	}
+	ts += r.last
+	r.last = ts
+	return
+}
+
+func (r *reader) bound() (b bound, err error) {
+	if b.timestamp, err = r.timestamp(); err != nil {
+		return
+	}
+	var n uint64
+	if n, err = r.varint(); err != nil {
+		return
+	}
+	if n > IdSize {
+		err = errorf.E("negentropy: bound id prefix is too long")
+		return
+	}
+	var id []byte
+	if id, err = r.bytes(int(n)); err != nil {
+		return
+	}
+	b.id = append([]byte(nil), id...)
+	return
+}
+
+// writer encodes the fields of a message.
+type writer struct {
+	// last is the last timestamp encoded.
+	last uint64
+}
+
+func (w *writer) timestamp(dst []byte, ts uint64) []byte {
+	if ts == math.MaxUint64 {
+		w.last = math.MaxUint64
+		return encodeVarint(dst, 0)
+	}
+	delta := ts - w.last
+	w.last = ts
+	return encodeVarint(dst, delta+1)
+}
+
+func (w *writer) bound(dst []byte, b bound) []byte {
+	dst = w.timestamp(dst, b.timestamp)
+	dst = encodeVarint(dst, uint64(len(b.id)))
+	return append(dst, b.id...)
+}
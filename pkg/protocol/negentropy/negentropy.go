@@ -0,0 +1,278 @@
+// Package negentropy implements the negentropy set reconciliation protocol
+// (version 1) used by NIP-77, with which two parties find the event ids that
+// one of them has and the other does not, exchanging fingerprints of ranges of
+// their sets instead of the ids themselves, except where the ranges differ.
+//
+// Each party has a Vector of the (created_at, id) items of its events that
+// match a filter. The client calls Initiate and sends the message to the relay,
+// which calls Reconcile with it and sends back the response, and the client
+// calls ReconcileWithIds with each response, collecting the ids it has and
+// needs, until it returns no message.
+package negentropy
+
+import (
+	"bytes"
+	"orly.dev/pkg/utils/errorf"
+)
+
+// ProtocolVersion is the version of the negentropy protocol implemented.
+const ProtocolVersion = 0x61
+
+// the modes of a range in a message.
+const (
+	modeSkip        = 0
+	modeFingerprint = 1
+	modeIdList      = 2
+)
+
+// buckets is the number of ranges a range that differs is split into.
+const buckets = 16
+
+// N is one side of a negentropy reconciliation.
+type N struct {
+	storage        *Vector
+	frameSizeLimit int
+	isInitiator    bool
+	lastOut        writer
+}
+
+// New creates a new N for a sealed Vector of items.
+//
+// The frameSizeLimit is the maximum size of the messages that are produced,
+// which must be at least 4096 bytes, or 0 for no limit. When a message would be
+// larger, the rest of the ranges are deferred to a later round.
+func New(storage *Vector, frameSizeLimit int) (n *N, err error) {
+	if !storage.sealed {
+		err = errorf.E("negentropy: vector is not sealed")
+		return
+	}
+	if frameSizeLimit != 0 && frameSizeLimit < 4096 {
+		err = errorf.E("negentropy: frame size limit must be at least 4096")
+		return
+	}
+	n = &N{storage: storage, frameSizeLimit: frameSizeLimit}
+	return
+}
+
+// Initiate returns the first message of a reconciliation, which is sent by the
+// client.
+func (n *N) Initiate() (msg []byte, err error) {
+	if n.isInitiator {
+		err = errorf.E("negentropy: already initiated")
+		return
+	}
+	n.isInitiator = true
+	n.lastOut = writer{}
+	msg = []byte{ProtocolVersion}
+	msg = n.splitRange(msg, 0, n.storage.Size(), infinity)
+	return
+}
+
+// Reconcile processes a message from the client and returns the response to
+// send back to it. It is used by the relay.
+func (n *N) Reconcile(query []byte) (msg []byte, err error) {
+	if n.isInitiator {
+		err = errorf.E("negentropy: initiator must use ReconcileWithIds")
+		return
+	}
+	msg, err = n.reconcile(query, nil, nil)
+	return
+}
+
+// ReconcileWithIds processes a message from the relay, and returns the next
+// message to send to it, along with the ids found to be only held locally
+// (have) and only held by the relay (need). When the returned message is nil,
+// the reconciliation is complete. It is used by the client.
+func (n *N) ReconcileWithIds(query []byte) (
+	msg []byte, have, need [][]byte, err error,
+) {
+	if !n.isInitiator {
+		err = errorf.E("negentropy: non-initiator must use Reconcile")
+		return
+	}
+	if msg, err = n.reconcile(query, &have, &need); err != nil {
+		return
+	}
+	if len(msg) == 1 {
+		msg = nil
+	}
+	return
+}
+
+func (n *N) reconcile(query []byte, have, need *[][]byte) (
+	out []byte, err error,
+) {
+	r := &reader{b: query}
+	n.lastOut = writer{}
+	out = []byte{ProtocolVersion}
+	var version byte
+	if version, err = r.byte(); err != nil {
+		return
+	}
+	if version < 0x60 || version > 0x6f {
+		err = errorf.E("negentropy: invalid protocol version %x", version)
+		return
+	}
+	if version != ProtocolVersion {
+		if n.isInitiator {
+			err = errorf.E(
+				"negentropy: unsupported protocol version %x", version,
+			)
+			return
+		}
+		// reply with the version that is supported.
+		return
+	}
+	size := n.storage.Size()
+	var prevBound bound
+	var prevIndex int
+	var skip bool
+	for !r.empty() {
+		var o []byte
+		doSkip := func() {
+			if skip {
+				skip = false
+				o = n.lastOut.bound(o, prevBound)
+				o = encodeVarint(o, modeSkip)
+			}
+		}
+		var currBound bound
+		if currBound, err = r.bound(); err != nil {
+			return
+		}
+		var mode uint64
+		if mode, err = r.varint(); err != nil {
+			return
+		}
+		lower := prevIndex
+		upper := n.storage.findLowerBound(lower, size, currBound)
+		switch mode {
+		case modeSkip:
+			skip = true
+		case modeFingerprint:
+			var theirs []byte
+			if theirs, err = r.bytes(FingerprintSize); err != nil {
+				return
+			}
+			ours := n.storage.fingerprint(lower, upper)
+			if !bytes.Equal(theirs, ours[:]) {
+				doSkip()
+				o = n.splitRange(o, lower, upper, currBound)
+			} else {
+				skip = true
+			}
+		case modeIdList:
+			var count uint64
+			if count, err = r.varint(); err != nil {
+				return
+			}
+			theirs := make(map[[IdSize]byte]struct{}, min(count, 1<<16))
+			for range count {
+				var id []byte
+				if id, err = r.bytes(IdSize); err != nil {
+					return
+				}
+				theirs[[IdSize]byte(id)] = struct{}{}
+			}
+			for _, it := range n.storage.items[lower:upper] {
+				if _, ok := theirs[it.Id]; !ok {
+					if n.isInitiator {
+						*have = append(*have, bytes.Clone(it.Id[:]))
+					}
+				} else {
+					delete(theirs, it.Id)
+				}
+			}
+			if n.isInitiator {
+				skip = true
+				for id := range theirs {
+					*need = append(*need, bytes.Clone(id[:]))
+				}
+				break
+			}
+			doSkip()
+			var ids []byte
+			var numIds int
+			endBound := currBound
+			for i := lower; i < upper; i++ {
+				if n.exceeded(len(out) + len(o) + len(ids)) {
+					endBound = bound{
+						timestamp: n.storage.items[i].Timestamp,
+						id:        bytes.Clone(n.storage.items[i].Id[:]),
+					}
+					upper = i
+					break
+				}
+				ids = append(ids, n.storage.items[i].Id[:]...)
+				numIds++
+			}
+			o = n.lastOut.bound(o, endBound)
+			o = encodeVarint(o, modeIdList)
+			o = encodeVarint(o, uint64(numIds))
+			o = append(o, ids...)
+			// the ids are sent even if the limit is reached, as the range
+			// was shrunk to fit them.
+			out = append(out, o...)
+			o = o[:0]
+		default:
+			err = errorf.E("negentropy: unknown mode %d", mode)
+			return
+		}
+		if n.exceeded(len(out) + len(o)) {
+			// the rest of the ranges are replaced by a fingerprint of all of
+			// them, which the other side will respond to in the next round.
+			fp := n.storage.fingerprint(upper, size)
+			out = n.lastOut.bound(out, infinity)
+			out = encodeVarint(out, modeFingerprint)
+			out = append(out, fp[:]...)
+			break
+		}
+		out = append(out, o...)
+		prevIndex = upper
+		prevBound = currBound
+	}
+	return
+}
+
+// splitRange appends the ranges a range of items is split into to a message: a
+// list of the ids if there are few, or otherwise the fingerprints of buckets of
+// them.
+func (n *N) splitRange(dst []byte, lower, upper int, upperBound bound) []byte {
+	count := upper - lower
+	if count < buckets*2 {
+		dst = n.lastOut.bound(dst, upperBound)
+		dst = encodeVarint(dst, modeIdList)
+		dst = encodeVarint(dst, uint64(count))
+		for _, it := range n.storage.items[lower:upper] {
+			dst = append(dst, it.Id[:]...)
+		}
+		return dst
+	}
+	perBucket := count / buckets
+	withExtra := count % buckets
+	curr := lower
+	for i := range buckets {
+		size := perBucket
+		if i < withExtra {
+			size++
+		}
+		fp := n.storage.fingerprint(curr, curr+size)
+		curr += size
+		next := upperBound
+		if curr != upper {
+			next = minimalBound(
+				n.storage.items[curr-1], n.storage.items[curr],
+			)
+		}
+		dst = n.lastOut.bound(dst, next)
+		dst = encodeVarint(dst, modeFingerprint)
+		dst = append(dst, fp[:]...)
+	}
+	return dst
+}
+
+// exceeded returns true if a message of a size is too close to the frame size
+// limit to add more to it.
+func (n *N) exceeded(size int) bool {
+	return n.frameSizeLimit != 0 && size > n.frameSizeLimit-200
+}
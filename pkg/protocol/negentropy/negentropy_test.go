@@ -0,0 +1,139 @@
+package negentropy
+
+import (
+	"encoding/hex"
+	"lukechampine.com/frand"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		shared, client, relay int
+		frameSizeLimit        int
+	}{
+		{"empty", 0, 0, 0, 0},
+		{"same", 1000, 0, 0, 0},
+		{"client only", 0, 50, 0, 0},
+		{"relay only", 0, 0, 50, 0},
+		{"small", 10, 5, 7, 0},
+		{"large", 10000, 300, 200, 0},
+		{"frame size limit", 10000, 500, 500, 4096},
+	} {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				client, relay := NewVector(), NewVector()
+				have, need := make(map[string]struct{}), make(map[string]struct{})
+				add := func(v *Vector, ids map[string]struct{}) {
+					id := frand.Bytes(IdSize)
+					// few timestamps so that ids often decide the order.
+					ts := uint64(1700000000 + frand.Intn(1000))
+					if err := v.Insert(ts, id); err != nil {
+						t.Fatal(err)
+					}
+					if ids != nil {
+						ids[hex.EncodeToString(id)] = struct{}{}
+					}
+				}
+				for range tc.shared {
+					id := frand.Bytes(IdSize)
+					ts := uint64(1700000000 + frand.Intn(1000))
+					if err := client.Insert(ts, id); err != nil {
+						t.Fatal(err)
+					}
+					if err := relay.Insert(ts, id); err != nil {
+						t.Fatal(err)
+					}
+				}
+				for range tc.client {
+					add(client, have)
+				}
+				for range tc.relay {
+					add(relay, need)
+				}
+				client.Seal()
+				relay.Seal()
+				c, err := New(client, tc.frameSizeLimit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r, err := New(relay, tc.frameSizeLimit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var msg []byte
+				if msg, err = c.Initiate(); err != nil {
+					t.Fatal(err)
+				}
+				var rounds int
+				for msg != nil {
+					if tc.frameSizeLimit != 0 && len(msg) > tc.frameSizeLimit {
+						t.Fatalf("message of %d bytes exceeds limit", len(msg))
+					}
+					if msg, err = r.Reconcile(msg); err != nil {
+						t.Fatal(err)
+					}
+					if tc.frameSizeLimit != 0 && len(msg) > tc.frameSizeLimit {
+						t.Fatalf("message of %d bytes exceeds limit", len(msg))
+					}
+					var h, n [][]byte
+					if msg, h, n, err = c.ReconcileWithIds(msg); err != nil {
+						t.Fatal(err)
+					}
+					for _, id := range h {
+						k := hex.EncodeToString(id)
+						if _, ok := have[k]; !ok {
+							t.Fatalf("unexpected have id %s", k)
+						}
+						delete(have, k)
+					}
+					for _, id := range n {
+						k := hex.EncodeToString(id)
+						if _, ok := need[k]; !ok {
+							t.Fatalf("unexpected need id %s", k)
+						}
+						delete(need, k)
+					}
+					if rounds++; rounds > 1000 {
+						t.Fatal("reconciliation did not finish")
+					}
+				}
+				if len(have) != 0 || len(need) != 0 {
+					t.Fatalf(
+						"%d have and %d need ids were not found",
+						len(have), len(need),
+					)
+				}
+			},
+		)
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	v := NewVector()
+	v.Seal()
+	r, err := New(v, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg []byte
+	if msg, err = r.Reconcile([]byte{0x62}); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg) != 1 || msg[0] != ProtocolVersion {
+		t.Fatalf("expected the supported version in reply, got %x", msg)
+	}
+}
+
+func TestVarint(t *testing.T) {
+	for _, n := range []uint64{0, 1, 127, 128, 16383, 16384, 1 << 63} {
+		r := &reader{b: encodeVarint(nil, n)}
+		m, err := r.varint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != n || !r.empty() {
+			t.Fatalf("varint %d decoded as %d", n, m)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package negentropy
+
+import (
+	"bytes"
+	"math"
+	"orly.dev/pkg/utils/errorf"
+	"sort"
+)
+
+// IdSize is the size of the ids of the items that are reconciled.
+const IdSize = 32
+
+// Item is an element of a set that is reconciled, an event id and its
+// created_at timestamp.
+type Item struct {
+	Timestamp uint64
+	Id        [IdSize]byte
+}
+
+// Less returns true if an Item sorts before another, by timestamp and then by
+// id.
+func (it Item) Less(other Item) bool {
+	if it.Timestamp != other.Timestamp {
+		return it.Timestamp < other.Timestamp
+	}
+	return bytes.Compare(it.Id[:], other.Id[:]) < 0
+}
+
+// Vector is a set of items sorted by timestamp and id.
+type Vector struct {
+	items  []Item
+	sealed bool
+}
+
+// NewVector creates a new empty Vector.
+func NewVector() (v *Vector) { return &Vector{} }
+
+// Insert adds an item to the Vector. Items can't be inserted once the Vector is
+// sealed.
+func (v *Vector) Insert(timestamp uint64, id []byte) (err error) {
+	if v.sealed {
+		return errorf.E("vector is sealed")
+	}
+	if len(id) != IdSize {
+		return errorf.E("id must be %d bytes, got %d", IdSize, len(id))
+	}
+	it := Item{Timestamp: timestamp}
+	copy(it.Id[:], id)
+	v.items = append(v.items, it)
+	return
+}
+
+// Seal sorts the items of the Vector and removes duplicates. It must be called
+// before the Vector is reconciled.
+func (v *Vector) Seal() {
+	if v.sealed {
+		return
+	}
+	sort.Slice(
+		v.items, func(i, j int) bool { return v.items[i].Less(v.items[j]) },
+	)
+	items := v.items[:0]
+	for _, it := range v.items {
+		if len(items) > 0 && it == items[len(items)-1] {
+			continue
+		}
+		items = append(items, it)
+	}
+	v.items = items
+	v.sealed = true
+}
+
+// Size returns the number of items in the Vector.
+func (v *Vector) Size() int { return len(v.items) }
+
+// fingerprint returns the fingerprint of the items from lower up to but not
+// including upper.
+func (v *Vector) fingerprint(lower, upper int) (fp [FingerprintSize]byte) {
+	var acc accumulator
+	for _, it := range v.items[lower:upper] {
+		acc.add(it.Id[:])
+	}
+	return acc.fingerprint(upper - lower)
+}
+
+// findLowerBound returns the index of the first item from first up to last that
+// is not less than a bound, or last if there is none.
+func (v *Vector) findLowerBound(first, last int, b bound) int {
+	return first + sort.Search(
+		last-first, func(i int) bool {
+			return !b.after(v.items[first+i])
+		},
+	)
+}
+
+// bound is the upper bound of a range of items. The id is a prefix, that is
+// compared as though it is padded with zeroes.
+type bound struct {
+	timestamp uint64
+	id        []byte
+}
+
+// infinity is the bound after all items.
+var infinity = bound{timestamp: math.MaxUint64}
+
+// after returns true if the bound sorts after an item.
+func (b bound) after(it Item) bool {
+	if b.timestamp != it.Timestamp {
+		return b.timestamp > it.Timestamp
+	}
+	var id [IdSize]byte
+	copy(id[:], b.id)
+	return bytes.Compare(id[:], it.Id[:]) > 0
+}
+
+// minimalBound returns the shortest bound that is after prev and not after
+// curr.
+func minimalBound(prev, curr Item) (b bound) {
+	if curr.Timestamp != prev.Timestamp {
+		return bound{timestamp: curr.Timestamp}
+	}
+	var shared int
+	for shared < IdSize && curr.Id[shared] == prev.Id[shared] {
+		shared++
+	}
+	return bound{
+		timestamp: curr.Timestamp,
+		id:        bytes.Clone(curr.Id[:min(shared+1, IdSize)]),
+	}
+}
@@ -0,0 +1,402 @@
+package openapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"orly.dev/pkg/app/relay/cluster"
+	"orly.dev/pkg/app/relay/replicate"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/database/memory"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/event/examples"
+	"orly.dev/pkg/encoders/eventidserial"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/servemux"
+	ctx "orly.dev/pkg/utils/context"
+)
+
+// testStore opens a badger event store in a temporary directory with the
+// first of the example events saved in it.
+func testStore(t *testing.T) (db *database.D, ev *event.E) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "test-openapi-*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	c, cancel := ctx.Cancel(ctx.Bg())
+	if db, err = database.New(c, cancel, dir, "error"); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(
+		func() {
+			db.Close()
+			cancel()
+			os.RemoveAll(dir)
+		},
+	)
+	scanner := bufio.NewScanner(bytes.NewBuffer(examples.Cache))
+	scanner.Buffer(make([]byte, 0, 1_000_000), 1_000_000)
+	if !scanner.Scan() {
+		t.Fatal("Expected an example event")
+	}
+	ev = event.New()
+	if _, err = ev.Unmarshal(scanner.Bytes()); err != nil {
+		t.Fatalf("Failed to unmarshal example event: %v", err)
+	}
+	if _, _, err = db.SaveEvent(c, ev, false, nil); err != nil {
+		t.Fatalf("Failed to save example event: %v", err)
+	}
+	return
+}
+
+// testAPI registers the HTTP API methods of a mock server.
+func testAPI(m *mockServer) (sm *servemux.S) {
+	if m.context == nil {
+		m.context = ctx.Bg()
+	}
+	sm = servemux.NewServeMux()
+	New(m, "test", "v0.0.0", "test", "/api", sm)
+	return
+}
+
+// call makes a request of the HTTP API with an Authorization header, which the
+// mock server accepts or refuses depending on its admin flag.
+func call(
+	t *testing.T, sm *servemux.S, method, path string, body []byte,
+) (w *httptest.ResponseRecorder) {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set("Authorization", "Nostr test")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w = httptest.NewRecorder()
+	sm.ServeHTTP(w, req)
+	return
+}
+
+// decode decodes the JSON body of a response.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", w.Body.String(), err)
+	}
+}
+
+// expectStatus fails the test if a response doesn't have a status.
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf(
+			"Expected status %d, got %d %s", status, w.Code, w.Body.String(),
+		)
+	}
+}
+
+func TestAdminUnauthorized(t *testing.T) {
+	db, _ := testStore(t)
+	sm := testAPI(&mockServer{storage: db, members: []cluster.MemberState{}})
+	pk := strings.Repeat("00", 32)
+	for _, tt := range []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/api/wipe", ""},
+		{http.MethodPost, "/api/rescan", ""},
+		{http.MethodGet, "/api/eventidsbyserial", ""},
+		{http.MethodGet, "/api/configuration", ""},
+		{http.MethodPut, "/api/configuration", `{}`},
+		{http.MethodGet, "/api/replication", ""},
+		{http.MethodDelete, "/api/replication/" + pk, ""},
+		{http.MethodGet, "/api/cluster", ""},
+		{
+			http.MethodPost, "/api/cluster/members",
+			`{"pubkey":"` + pk + `","address":"https://example.com"}`,
+		},
+		{http.MethodDelete, "/api/cluster/members/" + pk, ""},
+		{http.MethodGet, "/api/stats", ""},
+		{http.MethodPost, "/api/stats/gc", ""},
+		{http.MethodGet, "/api/backup", ""},
+		{http.MethodPost, "/api/restore", ""},
+		{http.MethodPost, "/api/explain", `{}`},
+		{http.MethodGet, "/api/changes?wait=0", ""},
+		{http.MethodGet, "/api/changes/stream", ""},
+	} {
+		var body []byte
+		if tt.body != "" {
+			body = []byte(tt.body)
+		}
+		w := call(t, sm, tt.method, tt.path, body)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf(
+				"%s %s: expected status %d, got %d %s", tt.method, tt.path,
+				http.StatusUnauthorized, w.Code, w.Body.String(),
+			)
+		}
+	}
+	if n, err := db.EventCount(); err != nil || n != 1 {
+		t.Fatalf("Expected the event store to be unchanged, got %d %v", n, err)
+	}
+}
+
+func TestWipe(t *testing.T) {
+	db, _ := testStore(t)
+	sm := testAPI(&mockServer{admin: true, storage: db})
+	expectStatus(
+		t, call(t, sm, http.MethodPost, "/api/wipe", nil), http.StatusNoContent,
+	)
+	if n, err := db.EventCount(); err != nil || n != 0 {
+		t.Fatalf("Expected no events after the wipe, got %d %v", n, err)
+	}
+}
+
+func TestRescan(t *testing.T) {
+	db, ev := testStore(t)
+	sm := testAPI(&mockServer{admin: true, storage: db})
+	expectStatus(
+		t, call(t, sm, http.MethodPost, "/api/rescan", nil),
+		http.StatusNoContent,
+	)
+	if ser, err := db.GetSerialById(ev.ID); err != nil || ser == nil {
+		t.Fatalf("Expected the event to be found after the rescan: %v", err)
+	}
+}
+
+func TestEventIdsBySerial(t *testing.T) {
+	db, ev := testStore(t)
+	sm := testAPI(&mockServer{admin: true, storage: db})
+	w := call(
+		t, sm, http.MethodGet, "/api/eventidsbyserial?start=0&count=10", nil,
+	)
+	expectStatus(t, w, http.StatusOK)
+	var evs []eventidserial.E
+	decode(t, w, &evs)
+	if len(evs) != 1 || evs[0].EventId != hex.EncodeToString(ev.ID) {
+		t.Fatalf("Expected the id of the event, got %v", evs)
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	m := &mockServer{
+		admin: true,
+		conf:  &store.Configuration{LogLevel: "info", MaxLimit: 500},
+	}
+	sm := testAPI(m)
+	w := call(t, sm, http.MethodGet, "/api/configuration", nil)
+	expectStatus(t, w, http.StatusOK)
+	var conf store.Configuration
+	decode(t, w, &conf)
+	if conf.LogLevel != "info" || conf.MaxLimit != 500 {
+		t.Fatalf("Expected the configuration of the relay, got %+v", conf)
+	}
+	expectStatus(
+		t, call(
+			t, sm, http.MethodPut, "/api/configuration",
+			[]byte(`{"log_level":"debug","max_limit":100}`),
+		), http.StatusNoContent,
+	)
+	if m.conf.LogLevel != "debug" || m.conf.MaxLimit != 100 {
+		t.Fatalf("Expected the configuration to be set, got %+v", m.conf)
+	}
+	expectStatus(
+		t, call(
+			t, sm, http.MethodPut, "/api/configuration",
+			[]byte(`{"max_limit":-1}`),
+		), http.StatusBadRequest,
+	)
+	if m.conf.MaxLimit != 100 {
+		t.Fatalf(
+			"Expected an invalid configuration to be refused, got %+v", m.conf,
+		)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	pk := strings.Repeat("ab", 32)
+	m := &mockServer{
+		admin: true, peers: []replicate.PeerState{
+			{
+				Address: "https://example.com", Pubkey: pk, Healthy: true,
+				Queued: 3,
+			},
+		},
+	}
+	sm := testAPI(m)
+	w := call(t, sm, http.MethodGet, "/api/replication", nil)
+	expectStatus(t, w, http.StatusOK)
+	var peers []replicate.PeerState
+	decode(t, w, &peers)
+	if len(peers) != 1 || peers[0].Pubkey != pk || peers[0].Queued != 3 {
+		t.Fatalf("Expected the state of the peer, got %+v", peers)
+	}
+	expectStatus(
+		t, call(t, sm, http.MethodDelete, "/api/replication/"+pk, nil),
+		http.StatusNoContent,
+	)
+	if len(m.peers) != 0 {
+		t.Fatalf(
+			"Expected the queue of the peer to be dropped, got %+v", m.peers,
+		)
+	}
+	expectStatus(
+		t, call(t, sm, http.MethodDelete, "/api/replication/invalid", nil),
+		http.StatusBadRequest,
+	)
+}
+
+func TestCluster(t *testing.T) {
+	m := &mockServer{admin: true}
+	sm := testAPI(m)
+	expectStatus(
+		t, call(t, sm, http.MethodGet, "/api/cluster", nil),
+		http.StatusNotFound,
+	)
+	m.members = []cluster.MemberState{}
+	pk := strings.Repeat("cd", 32)
+	expectStatus(
+		t, call(
+			t, sm, http.MethodPost, "/api/cluster/members",
+			[]byte(`{"pubkey":"`+pk+`","address":"https://example.com"}`),
+		), http.StatusNoContent,
+	)
+	w := call(t, sm, http.MethodGet, "/api/cluster", nil)
+	expectStatus(t, w, http.StatusOK)
+	var members []cluster.MemberState
+	decode(t, w, &members)
+	if len(members) != 1 || members[0].Pubkey != pk ||
+		members[0].Address != "https://example.com" {
+		t.Fatalf("Expected the added member, got %+v", members)
+	}
+	expectStatus(
+		t, call(t, sm, http.MethodDelete, "/api/cluster/members/"+pk, nil),
+		http.StatusNoContent,
+	)
+	expectStatus(
+		t, call(t, sm, http.MethodDelete, "/api/cluster/members/"+pk, nil),
+		http.StatusForbidden,
+	)
+}
+
+func TestStats(t *testing.T) {
+	db, ev := testStore(t)
+	sm := testAPI(&mockServer{admin: true, storage: db})
+	w := call(t, sm, http.MethodGet, "/api/stats?top=5", nil)
+	expectStatus(t, w, http.StatusOK)
+	var s store.Stats
+	decode(t, w, &s)
+	if s.Events != 1 || len(s.Authors) != 1 ||
+		s.Authors[0].Pubkey != hex.EncodeToString(ev.Pubkey) {
+		t.Fatalf("Expected the stats of the event, got %+v", s)
+	}
+	w = call(t, sm, http.MethodPost, "/api/stats/gc", nil)
+	expectStatus(t, w, http.StatusOK)
+	var run store.GCRun
+	decode(t, w, &run)
+	// the memory store doesn't report on its contents.
+	sm = testAPI(&mockServer{admin: true, storage: memory.New()})
+	expectStatus(
+		t, call(t, sm, http.MethodGet, "/api/stats", nil),
+		http.StatusNotImplemented,
+	)
+}
+
+func TestBackup(t *testing.T) {
+	db, ev := testStore(t)
+	sm := testAPI(&mockServer{admin: true, storage: db})
+	w := call(t, sm, http.MethodGet, "/api/backup", nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Body.Len() == 0 {
+		t.Fatal("Expected a backup")
+	}
+	backup := w.Body.Bytes()
+	// restored into an empty store.
+	restored, _ := testStore(t)
+	if err := restored.Wipe(); err != nil {
+		t.Fatalf("Failed to wipe the database: %v", err)
+	}
+	sm = testAPI(&mockServer{admin: true, storage: restored})
+	w = call(t, sm, http.MethodPost, "/api/restore", backup)
+	expectStatus(t, w, http.StatusOK)
+	var info store.BackupInfo
+	decode(t, w, &info)
+	if info.Upto == 0 || info.Sha256 == "" {
+		t.Fatalf("Expected the info of the backup, got %+v", info)
+	}
+	if ser, err := restored.GetSerialById(ev.ID); err != nil || ser == nil {
+		t.Fatalf("Expected the event to be restored: %v", err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	db, ev := testStore(t)
+	sm := testAPI(&mockServer{admin: true, storage: db})
+	w := call(
+		t, sm, http.MethodPost, "/api/explain",
+		[]byte(`{"authors":["`+hex.EncodeToString(ev.Pubkey)+`"]}`),
+	)
+	expectStatus(t, w, http.StatusOK)
+	var plan store.QueryPlan
+	decode(t, w, &plan)
+	if plan.Index == "" || plan.Results != 1 {
+		t.Fatalf("Expected the plan of the query, got %+v", plan)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	db, ev := testStore(t)
+	sm := testAPI(&mockServer{admin: true, storage: db})
+	w := call(t, sm, http.MethodGet, "/api/changes?from=0&wait=0", nil)
+	expectStatus(t, w, http.StatusOK)
+	var page struct {
+		Changes []*store.Change `json:"changes"`
+		Next    uint64          `json:"next"`
+	}
+	decode(t, w, &page)
+	if len(page.Changes) != 1 ||
+		page.Changes[0].Id != hex.EncodeToString(ev.ID) ||
+		page.Next <= page.Changes[0].Seq {
+		t.Fatalf(
+			"Expected the change of the saved event, got %s", w.Body.String(),
+		)
+	}
+	// there are no changes after the last.
+	w = call(
+		t, sm, http.MethodGet,
+		"/api/changes?wait=0&from="+strconv.FormatUint(page.Next, 10), nil,
+	)
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &page)
+	if len(page.Changes) != 0 {
+		t.Fatalf("Expected no changes after the last, got %s", w.Body.String())
+	}
+	// the stream sends the changes from the start of the log until the client
+	// goes away.
+	c, cancel := ctx.Timeout(ctx.Bg(), 500*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequestWithContext(
+		c, http.MethodGet, "/api/changes/stream", nil,
+	)
+	req.Header.Set("Authorization", "Nostr test")
+	w = httptest.NewRecorder()
+	sm.ServeHTTP(w, req)
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), hex.EncodeToString(ev.ID)) {
+		t.Fatalf(
+			"Expected the change of the saved event, got %s", w.Body.String(),
+		)
+	}
+}
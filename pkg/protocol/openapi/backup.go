@@ -0,0 +1,156 @@
+package openapi
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"time"
+)
+
+// BackupInput is the parameters for the HTTP API Backup method.
+type BackupInput struct {
+	Auth  string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Since uint64 `query:"since" doc:"the version to back up the changes since, which is the next version in the trailer of the backup before, or 0 for a full backup" default:"0"`
+}
+
+// RegisterBackup implements the Backup HTTP API method.
+func (x *Operations) RegisterBackup(api huma.API) {
+	name := "Backup"
+	description := `Back up the event store (only works with NIP-98 capable client, will not work with UI)
+
+Returns a backup of a snapshot of the event store, which the relay keeps serving while it is made. It holds the keys of the store as they are, so a restore keeps the serials of the events and doesn't rebuild their indexes. A backup since a version holds only the changes since then, including deletions.
+
+The backup ends with a line of JSON with the newest version in it, the version to make the next incremental backup since, and a SHA-256 checksum of the backup.`
+	path := x.path + "/backup"
+	scopes := []string{"admin", "read"}
+	method := http.MethodGet
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *BackupInput) (
+			resp *huma.StreamResponse, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, pubkey := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			sto, ok := x.Storage().(store.Backuper)
+			if !ok {
+				err = huma.Error501NotImplemented(
+					"the event store doesn't make backups",
+				)
+				return
+			}
+			log.I.F(
+				"%s backup of the event store since %d requested by pubkey %0x",
+				remote, input.Since, pubkey,
+			)
+			resp = &huma.StreamResponse{
+				Body: func(ctx huma.Context) {
+					ctx.SetHeader("Content-Type", "application/octet-stream")
+					ctx.SetHeader(
+						"Content-Disposition", fmt.Sprintf(
+							"attachment; filename=\"orly-%s-%d.backup\"",
+							time.Now().UTC().Format("20060102T150405Z"),
+							input.Since,
+						),
+					)
+					w := bufio.NewWriter(ctx.BodyWriter())
+					info, err := sto.Backup(x.Context(), w, input.Since)
+					if chk.E(err) {
+						return
+					}
+					if chk.E(w.Flush()) {
+						return
+					}
+					log.I.F(
+						"backed up versions %d to %d of the event store, %d "+
+							"bytes, to %s", info.Since, info.Upto, info.Bytes,
+						remote,
+					)
+				},
+			}
+			return
+		},
+	)
+}
+
+// RestoreInput is the parameters for the HTTP API Restore method.
+type RestoreInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant) token for authentication" required:"true"`
+}
+
+// RestoreOutput is the info of the restored backup.
+type RestoreOutput struct {
+	Body *store.BackupInfo
+}
+
+// RegisterRestore implements the Restore HTTP API method.
+func (x *Operations) RegisterRestore(api huma.API) {
+	name := "Restore"
+	description := `Restore a backup of the event store made by Backup (only works with NIP-98 capable client, will not work with UI)
+
+The backup is the body of the request. Its checksum is checked before anything is restored. A full backup can only be restored into an event store with no events, and each incremental backup must be restored in order after the backup before it, which the event store records, so one that is out of order is refused.`
+	path := x.path + "/restore"
+	scopes := []string{"admin", "write"}
+	method := http.MethodPost
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *RestoreInput) (
+			output *RestoreOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, pubkey := x.AdminAuth(r, remote, 10*time.Minute)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			sto, ok := x.Storage().(store.Backuper)
+			if !ok {
+				err = huma.Error501NotImplemented(
+					"the event store doesn't restore backups",
+				)
+				return
+			}
+			log.I.F(
+				"%s restore of the event store requested by pubkey %0x",
+				remote, pubkey,
+			)
+			output = &RestoreOutput{}
+			if output.Body, err = sto.Restore(x.Context(), r.Body); err != nil {
+				if errors.Is(err, store.ErrInvalidBackup) {
+					err = huma.Error400BadRequest(err.Error())
+					return
+				}
+				chk.E(err)
+				err = huma.Error409Conflict(err.Error())
+				return
+			}
+			return
+		},
+	)
+}
@@ -0,0 +1,190 @@
+package openapi
+
+import (
+	"errors"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/sse"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"strconv"
+	"time"
+)
+
+// ChangesInput is the parameters for the HTTP API Changes method.
+type ChangesInput struct {
+	Auth  string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	From  uint64 `query:"from" doc:"the seq of the first change to return, which is the next of the response before, or 0 for the whole log" default:"0"`
+	Limit int    `query:"limit" doc:"the maximum number of changes to return" default:"100" minimum:"1" maximum:"10000"`
+	Wait  int    `query:"wait" doc:"the number of seconds to wait for changes if there are none yet" default:"30" minimum:"0" maximum:"300"`
+}
+
+// ChangesOutput is a page of the change log.
+type ChangesOutput struct {
+	Body struct {
+		Changes []*store.Change `json:"changes" doc:"the changes in the order they were made"`
+		Next    uint64          `json:"next" doc:"the from of the request for the next changes"`
+	}
+}
+
+// RegisterChanges implements the Changes HTTP API method.
+func (x *Operations) RegisterChanges(api huma.API) {
+	name := "Changes"
+	description := `Get the changes of the event store after a position in its change log, waiting for them if there are none yet (only works with NIP-98 capable client, will not work with UI)
+
+Every event that is saved, and every event that is deleted, whether by a deletion event, a newer version of a replaceable event, an expiration, an admin or the retention rules, is recorded in the change log in order, with the serial of the change as its seq. The changes of saved events include the event, if it has not been deleted since.
+
+Follow the log by requesting the changes from the next of each response, which survives restarts of the relay, so nothing is missed while the log keeps it (ORLY_CHANGE_LOG_RETENTION). A from whose changes are no longer kept is refused with 410 Gone, as changes after it are lost, and the follower has to resync and follow the log again from 0, which starts at the first change it keeps.
+
+The seqs are taken from the same sequence as the serials of the events, so neither is dense.`
+	path := x.path + "/changes"
+	scopes := []string{"admin", "read"}
+	method := http.MethodGet
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ChangesInput) (
+			output *ChangesOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			sto, ok := x.Storage().(store.Changer)
+			if !ok {
+				err = huma.Error501NotImplemented(
+					"the event store doesn't keep a change log",
+				)
+				return
+			}
+			wc, cancel := context.Timeout(
+				ctx, time.Duration(input.Wait)*time.Second,
+			)
+			defer cancel()
+			output = &ChangesOutput{}
+			output.Body.Changes = []*store.Change{}
+			output.Body.Next = input.From
+			for {
+				var changes []*store.Change
+				if changes, output.Body.Next, err = sto.Changes(
+					ctx, output.Body.Next, input.Limit,
+				); chk.E(err) {
+					if errors.Is(err, store.ErrChangesTrimmed) {
+						err = huma.Error410Gone(err.Error())
+						return
+					}
+					err = huma.Error500InternalServerError(err.Error())
+					return
+				}
+				if len(changes) > 0 {
+					output.Body.Changes = changes
+					return
+				}
+				// there can be changes to wait for after gaps in the log.
+				if input.Wait == 0 ||
+					sto.WaitChanges(wc, output.Body.Next) != nil {
+					return
+				}
+			}
+		},
+	)
+}
+
+// ChangesStreamInput is the parameters for the HTTP API ChangesStream method.
+type ChangesStreamInput struct {
+	Auth        string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Accept      string `header:"Accept" default:"text/event-stream" enum:"text/event-stream" required:"true"`
+	LastEventId string `header:"Last-Event-ID" doc:"the id of the last message received, to resume the stream after it" required:"false"`
+	From        uint64 `query:"from" doc:"the seq of the first change to send, or 0 for the whole log" default:"0"`
+}
+
+// RegisterChangesStream implements the ChangesStream HTTP API method.
+func (x *Operations) RegisterChangesStream(api huma.API) {
+	name := "ChangesStream"
+	description := `Opens a HTTP SSE stream of the changes of the event store from a position in its change log, as they are made (only works with NIP-98 capable client, will not work with UI)
+
+The changes are those of the Changes method, and the id of each message is the seq of the change, so a client that reconnects with the Last-Event-ID header resumes after the last change it received. The stream ends at once if the changes from its position are no longer kept.`
+	path := x.path + "/changes/stream"
+	scopes := []string{"admin", "read"}
+	method := http.MethodGet
+	sse.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		},
+		map[string]any{
+			"change": &store.Change{},
+		},
+		func(ctx context.T, input *ChangesStreamInput, send sse.Sender) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			// nothing has been sent yet, so an error can still be the
+			// response.
+			w := ctx.Value("http-response").(http.ResponseWriter)
+			authed, pubkey := x.AdminAuth(r, remote)
+			if !authed {
+				log.W.F("%s not authorized to stream the changes", remote)
+				http.Error(w, "Not Authorized", http.StatusUnauthorized)
+				return
+			}
+			sto, ok := x.Storage().(store.Changer)
+			if !ok {
+				log.W.F("the event store doesn't keep a change log")
+				http.Error(
+					w, "the event store doesn't keep a change log",
+					http.StatusNotImplemented,
+				)
+				return
+			}
+			from := input.From
+			if input.LastEventId != "" {
+				last, err := strconv.ParseUint(input.LastEventId, 10, 64)
+				if chk.E(err) {
+					return
+				}
+				from = last + 1
+			}
+			log.I.F(
+				"%s streaming the changes from %d to pubkey %0x", remote, from,
+				pubkey,
+			)
+			c, cancel := context.Cancel(r.Context())
+			defer cancel()
+			go func() {
+				select {
+				case <-x.Context().Done():
+					// server shutdown
+					cancel()
+				case <-c.Done():
+				}
+			}()
+			for ch, err := range sto.Follow(c, from) {
+				if err != nil {
+					return
+				}
+				if err = send(
+					sse.Message{ID: int(ch.Seq), Data: ch},
+				); chk.E(err) {
+					return
+				}
+			}
+		},
+	)
+}
@@ -0,0 +1,232 @@
+package openapi
+
+import (
+	"errors"
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/cluster"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/keys"
+)
+
+// ClusterAnnounceInput is the parameters for the HTTP API ClusterAnnounce
+// method.
+type ClusterAnnounceInput struct {
+	Body *event.J `doc:"announcement event JSON"`
+}
+
+// ClusterAnnounceOutput is the announcement of the relay that responded.
+type ClusterAnnounceOutput struct {
+	Body *event.J
+}
+
+// RegisterClusterAnnounce implements the ClusterAnnounce HTTP API method.
+func (x *Operations) RegisterClusterAnnounce(api huma.API) {
+	name := "ClusterAnnounce"
+	description := `Announce a node of the relay cluster to the relay, which responds with its own announcement
+
+The announcement is an event of kind 28334 signed by the node, with a cluster tag with the name of the cluster, an r tag with the address of the node, and a member tag with the pubkey, address, time added and time removed of each member the node knows of. It is only accepted from members of the cluster.`
+	path := x.path + "/cluster/announce"
+	scopes := []string{"cluster"}
+	method := http.MethodPost
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"cluster"},
+			RequestBody: EventBody,
+			Description: helpers.GenerateDescription(description, scopes),
+		}, func(ctx context.T, input *ClusterAnnounceInput) (
+			output *ClusterAnnounceOutput, err error,
+		) {
+			var ev *event.E
+			if ev, err = input.Body.ToEvent(); chk.E(err) {
+				err = huma.Error422UnprocessableEntity(
+					"Failed to convert event", err,
+				)
+				return
+			}
+			var reply *event.E
+			if reply, err = x.I.ClusterAnnounce(ev); err != nil {
+				err = clusterError(err)
+				return
+			}
+			output = &ClusterAnnounceOutput{Body: reply.ToEventJ()}
+			return
+		},
+	)
+}
+
+// ClusterInput is the parameters for the HTTP API Cluster method.
+type ClusterInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+}
+
+// ClusterOutput is the state of the members of the cluster.
+type ClusterOutput struct {
+	Body []cluster.MemberState
+}
+
+// RegisterCluster implements the Cluster HTTP API method.
+func (x *Operations) RegisterCluster(api huma.API) {
+	name := "Cluster"
+	description := `Get the members of the relay cluster, and whether they are up (only works with NIP-98 capable client, will not work with UI)`
+	path := x.path + "/cluster"
+	scopes := []string{"admin", "read"}
+	method := http.MethodGet
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ClusterInput) (
+			output *ClusterOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			var members []cluster.MemberState
+			if members, err = x.I.Cluster(); err != nil {
+				err = clusterError(err)
+				return
+			}
+			output = &ClusterOutput{Body: members}
+			return
+		},
+	)
+}
+
+// ClusterAddInput is the parameters for the HTTP API ClusterAdd method.
+type ClusterAddInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Body struct {
+		Pubkey  string `json:"pubkey" doc:"pubkey of the node in hex or npub format, which is the pubkey of its ORLY_SECRET_KEY"`
+		Address string `json:"address" doc:"base URL of the node"`
+	}
+}
+
+// ClusterAddOutput is nothing, basically; a 204 or 200 status is expected.
+type ClusterAddOutput struct{}
+
+// RegisterClusterAdd implements the ClusterAdd HTTP API method.
+func (x *Operations) RegisterClusterAdd(api huma.API) {
+	name := "ClusterAdd"
+	description := `Add a node to the relay cluster (only works with NIP-98 capable client, will not work with UI)
+
+The node only needs to be added on one member, the other members learn of it from the announcements of that member. The new node needs the cluster name and at least one member as a peer relay in its configuration.`
+	path := x.path + "/cluster/members"
+	scopes := []string{"admin", "write"}
+	method := http.MethodPost
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ClusterAddInput) (
+			output *ClusterAddOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			var pk []byte
+			if pk, err = keys.DecodeNpubOrHex(input.Body.Pubkey); err != nil {
+				err = huma.Error400BadRequest(err.Error())
+				return
+			}
+			if input.Body.Address == "" {
+				err = huma.Error400BadRequest("address is required")
+				return
+			}
+			if err = x.I.ClusterAdd(pk, input.Body.Address); err != nil {
+				err = clusterError(err)
+				return
+			}
+			return
+		},
+	)
+}
+
+// ClusterRemoveInput is the parameters for the HTTP API ClusterRemove method.
+type ClusterRemoveInput struct {
+	Auth   string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Pubkey string `path:"pubkey" doc:"pubkey of the node in hex or npub format"`
+}
+
+// ClusterRemoveOutput is nothing, basically; a 204 or 200 status is expected.
+type ClusterRemoveOutput struct{}
+
+// RegisterClusterRemove implements the ClusterRemove HTTP API method.
+func (x *Operations) RegisterClusterRemove(api huma.API) {
+	name := "ClusterRemove"
+	description := `Remove a node from the relay cluster (only works with NIP-98 capable client, will not work with UI)
+
+The other members learn of the removal from the announcements of this relay. A relay that removes itself leaves the cluster.`
+	path := x.path + "/cluster/members/{pubkey}"
+	scopes := []string{"admin", "write"}
+	method := http.MethodDelete
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ClusterRemoveInput) (
+			output *ClusterRemoveOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			var pk []byte
+			if pk, err = keys.DecodeNpubOrHex(input.Pubkey); err != nil {
+				err = huma.Error400BadRequest(err.Error())
+				return
+			}
+			if err = x.I.ClusterRemove(pk); err != nil {
+				err = clusterError(err)
+				return
+			}
+			return
+		},
+	)
+}
+
+// clusterError returns the HTTP error for an error of a cluster method.
+func clusterError(err error) error {
+	switch {
+	case errors.Is(err, cluster.ErrNotMember):
+		return huma.Error403Forbidden(err.Error())
+	case errors.Is(err, cluster.ErrInvalid):
+		return huma.Error400BadRequest(err.Error())
+	case errors.Is(err, cluster.ErrDisabled):
+		return huma.Error404NotFound(err.Error())
+	}
+	return huma.Error500InternalServerError(err.Error())
+}
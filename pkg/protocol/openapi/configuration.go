@@ -0,0 +1,112 @@
+package openapi
+
+import (
+	"errors"
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+)
+
+// ConfigurationGetInput is the parameters for the HTTP API ConfigurationGet
+// method.
+type ConfigurationGetInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+}
+
+// ConfigurationGetOutput is the current runtime configuration of the relay.
+type ConfigurationGetOutput struct {
+	Body *store.Configuration
+}
+
+// RegisterConfigurationGet implements the ConfigurationGet HTTP API method.
+func (x *Operations) RegisterConfigurationGet(api huma.API) {
+	name := "ConfigurationGet"
+	description := `Get the parts of the relay configuration that can be changed while it is running (only works with NIP-98 capable client, will not work with UI)`
+	path := x.path + "/configuration"
+	scopes := []string{"admin", "read"}
+	method := http.MethodGet
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ConfigurationGetInput) (
+			output *ConfigurationGetOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			output = &ConfigurationGetOutput{Body: x.Configuration()}
+			return
+		},
+	)
+}
+
+// ConfigurationSetInput is the parameters for the HTTP API ConfigurationSet
+// method.
+type ConfigurationSetInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Body store.Configuration
+}
+
+// ConfigurationSetOutput is nothing, basically; a 204 or 200 status is
+// expected.
+type ConfigurationSetOutput struct{}
+
+// RegisterConfigurationSet implements the ConfigurationSet HTTP API method.
+func (x *Operations) RegisterConfigurationSet(api huma.API) {
+	name := "ConfigurationSet"
+	description := `Replace the parts of the relay configuration that can be changed while it is running (only works with NIP-98 capable client, will not work with UI)
+
+The configuration is stored in the database and applied without a restart, and overrides the environment and .env file when the relay starts. Lists that are left out are cleared, so get the configuration first and change the fields that need to be changed. Empty log levels and limits that are left out or zero leave the current values unchanged.`
+	path := x.path + "/configuration"
+	scopes := []string{"admin", "write"}
+	method := http.MethodPut
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ConfigurationSetInput) (
+			output *ConfigurationSetOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, pubkey := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			log.I.F(
+				"%s configuration change requested on admin port pubkey %0x",
+				remote, pubkey,
+			)
+			if err = x.SetConfiguration(&input.Body); chk.E(err) {
+				if errors.Is(err, store.ErrInvalidConfiguration) {
+					err = huma.Error400BadRequest(err.Error())
+				} else {
+					err = huma.Error500InternalServerError(err.Error())
+				}
+				return
+			}
+			output = &ConfigurationSetOutput{}
+			return
+		},
+	)
+}
@@ -6,10 +6,7 @@ import (
 	"github.com/danielgtaylor/huma/v2"
 	"net/http"
 	"orly.dev/pkg/app/relay/helpers"
-	"orly.dev/pkg/crypto/sha256"
 	"orly.dev/pkg/encoders/event"
-	"orly.dev/pkg/encoders/eventid"
-	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/hex"
 	"orly.dev/pkg/encoders/ints"
 	"orly.dev/pkg/encoders/kind"
@@ -157,6 +154,11 @@ func (x *Operations) RegisterEvent(api huma.API) {
 			a := x
 			env := ev
 			c := x.Context()
+			if !super && (!x.I.AllowEvent(remote, pubkey) ||
+				!x.I.AllowBytes(remote, pubkey, BodyRead(ctx))) {
+				err = Ok.RateLimited(a, env, "too many events, slow down")
+				return
+			}
 			calculatedId := ev.GetIDBytes()
 			if !bytes.Equal(calculatedId, ev.ID) {
 				err = huma.Error422UnprocessableEntity(
@@ -190,7 +192,7 @@ func (x *Operations) RegisterEvent(api huma.API) {
 			// check that relay policy allows this event
 			accept, notice, _ := x.I.AcceptEvent(c, env, r, pubkey, remote)
 			if !accept && !super {
-				if err = Ok.Blocked(
+				if err = Ok.Reject(
 					a, env, notice,
 				); chk.E(err) {
 					return
@@ -213,246 +215,66 @@ func (x *Operations) RegisterEvent(api huma.API) {
 					return
 				}
 			}
-			// check and process delete
-			sto := x.I.Storage()
+			// check the a tags of a delete, the deletion of the events it
+			// references is done by the store when it is saved.
 			if ev.Kind.K == kind.Deletion.K {
 				log.I.F("delete event\n%s", ev.Serialize())
 				for _, t := range ev.Tags.ToSliceOfTags() {
-					var res []*event.E
-					if t.Len() >= 2 {
-						switch {
-						case bytes.Equal(t.Key(), []byte("e")):
-							// Process 'e' tag (event reference)
-							eventId := make([]byte, sha256.Size)
-							if _, err = hex.DecBytes(
-								eventId, t.Value(),
-							); chk.E(err) {
-								return
-							}
-
-							// Create a filter to find the referenced event
-							f := filter.New()
-							f.Ids = f.Ids.Append(eventId)
-
-							// Query for the referenced event
-							var referencedEvents []*event.E
-							referencedEvents, err = sto.QueryEvents(c, f)
-							if chk.E(err) {
-								if err = Ok.Error(
-									a, env,
-									"failed to query for referenced event",
-								); chk.E(err) {
-									return
-								}
-								return
-							}
-
-							// If we found the referenced event, check if the
-							// author matches
-							if len(referencedEvents) > 0 {
-								referencedEvent := referencedEvents[0]
-
-								// Check if the author of the deletion event
-								// matches the author of the referenced event
-								if !bytes.Equal(
-									referencedEvent.Pubkey, env.Pubkey,
-								) {
-									if err = Ok.Blocked(
-										a, env,
-										"blocked: cannot delete events from other authors",
-									); chk.E(err) {
-										return
-									}
-									return
-								}
-
-								// Create eventid.T from the event ID bytes
-								var eid *eventid.T
-								if eid, err = eventid.NewFromBytes(eventId); chk.E(err) {
-									if err = Ok.Error(
-										a, env, "failed to create event ID",
-									); chk.E(err) {
-										return
-									}
-									return
-								}
-
-								// Use DeleteEvent to actually delete the
-								// referenced event
-								if err = sto.DeleteEvent(c, eid); chk.E(err) {
-									if err = Ok.Error(
-										a, env,
-										"failed to delete referenced event",
-									); chk.E(err) {
-										return
-									}
-									return
-								}
-
-								log.I.F(
-									"successfully deleted event %x", eventId,
-								)
-							}
-						case bytes.Equal(t.Key(), []byte("a")):
-							split := bytes.Split(t.Value(), []byte{':'})
-							if len(split) != 3 {
-								continue
-							}
-							var pk []byte
-							if pk, err = hex.DecAppend(
-								nil, split[1],
-							); chk.E(err) {
-								if err = Ok.Invalid(
-									a, env,
-									"delete event a tag pubkey value invalid: %s",
-									t.Value(),
-								); chk.E(err) {
-									return
-								}
-								return
-							}
-							kin := ints.New(uint16(0))
-							if _, err = kin.Unmarshal(split[0]); chk.E(err) {
-								if err = Ok.Invalid(
-									a, env, "delete event a tag kind value "+
-										"invalid: %s",
-									t.Value(),
-								); chk.E(err) {
-									return
-								}
-								return
-							}
-							kk := kind.New(kin.Uint16())
-							if kk.Equal(kind.Deletion) {
-								if err = Ok.Blocked(
-									a, env, "delete event kind may not be "+
-										"deleted",
-								); chk.E(err) {
-									return
-								}
-								return
-							}
-							if !kk.IsParameterizedReplaceable() {
-								if err = Ok.Error(
-									a, env,
-									"delete tags with a tags containing "+
-										"non-parameterized-replaceable events can't be processed",
-								); chk.E(err) {
-									return
-								}
-								return
-							}
-							if !bytes.Equal(pk, ev.Pubkey) {
-								if err = Ok.Blocked(
-									a, env,
-									"can't delete other users' events (delete by a tag)",
-								); chk.E(err) {
-									return
-								}
-								return
-							}
-							f := filter.New()
-							f.Kinds.K = []*kind.T{kk}
-							f.Authors.Append(pk)
-							f.Tags.AppendTags(
-								tag.New(
-									[]byte{'#', 'd'}, split[2],
-								),
-							)
-							res, err = sto.QueryEvents(c, f)
-							if chk.E(err) {
-								if err = Ok.Error(
-									a, env, "failed to query for target event",
-								); chk.E(err) {
-									return
-								}
-								return
-							}
-						}
+					if t.Len() < 2 || !bytes.Equal(t.Key(), []byte("a")) {
+						continue
 					}
-					if len(res) < 1 {
+					split := bytes.Split(t.Value(), []byte{':'})
+					if len(split) != 3 {
 						continue
 					}
-					var resTmp []*event.E
-					for _, v := range res {
-						if ev.CreatedAt.U64() >= v.CreatedAt.U64() {
-							resTmp = append(resTmp, v)
+					var pk []byte
+					if pk, err = hex.DecAppend(
+						nil, split[1],
+					); chk.E(err) {
+						if err = Ok.Invalid(
+							a, env,
+							"delete event a tag pubkey value invalid: %s",
+							t.Value(),
+						); chk.E(err) {
+							return
 						}
+						return
 					}
-					res = resTmp
-					for _, target := range res {
-						if target.Kind.K == kind.Deletion.K {
-							if err = Ok.Error(
-								a, env, "cannot delete delete event %s", ev.ID,
-							); chk.E(err) {
-								return
-							}
-						}
-						if target.CreatedAt.Int() > ev.CreatedAt.Int() {
-							log.I.F(
-								"not deleting\n%d%\nbecause delete event is older\n%d",
-								target.CreatedAt.Int(), ev.CreatedAt.Int(),
-							)
-							continue
-						}
-						if !bytes.Equal(target.Pubkey, env.Pubkey) {
-							if err = Ok.Error(
-								a, env, "only author can delete event",
-							); chk.E(err) {
-								return
-							}
+					kin := ints.New(uint16(0))
+					if _, err = kin.Unmarshal(split[0]); chk.E(err) {
+						if err = Ok.Invalid(
+							a, env, "delete event a tag kind value "+
+								"invalid: %s",
+							t.Value(),
+						); chk.E(err) {
 							return
 						}
-
-						// Create eventid.T from the target event ID bytes
-						var eid *eventid.T
-						eid, err = eventid.NewFromBytes(target.EventId().Bytes())
-						if chk.E(err) {
-							if err = Ok.Error(
-								a, env, "failed to create event ID",
-							); chk.E(err) {
-								return
-							}
+						return
+					}
+					kk := kind.New(kin.Uint16())
+					if kk.Equal(kind.Deletion) {
+						if err = Ok.Blocked(
+							a, env, "delete event kind may not be "+
+								"deleted",
+						); chk.E(err) {
 							return
 						}
-
-						// Use DeleteEvent to actually delete the target event
-						if err = sto.DeleteEvent(c, eid); chk.E(err) {
-							if err = Ok.Error(
-								a, env, "failed to delete target event",
-							); chk.E(err) {
-								return
-							}
+						return
+					}
+					if !kk.IsReplaceable() && !kk.IsParameterizedReplaceable() {
+						if err = Ok.Error(
+							a, env,
+							"delete tags with a tags containing "+
+								"non-replaceable events can't be processed",
+						); chk.E(err) {
 							return
 						}
-
-						log.I.F(
-							"successfully deleted event %x",
-							target.EventId().Bytes(),
-						)
+						return
 					}
-					res = nil
-				}
-				// Send a success response after processing all deletions
-				if err = Ok.Ok(a, env, ""); chk.E(err) {
-					return
-				}
-				// Check if this event has been deleted before
-				if ev.Kind.K != kind.Deletion.K {
-					// Create a filter to check for deletion events that
-					// reference this event ID
-					f := filter.New()
-					f.Kinds.K = []*kind.T{kind.Deletion}
-					f.Tags.AppendTags(tag.New([]byte{'e'}, ev.ID))
-
-					// Query for deletion events
-					var deletionEvents []*event.E
-					deletionEvents, err = sto.QueryEvents(c, f)
-					if err == nil && len(deletionEvents) > 0 {
-						// Found deletion events for this ID, don't save it
+					if !bytes.Equal(pk, ev.Pubkey) {
 						if err = Ok.Blocked(
 							a, env,
-							"the event was deleted, not storing it again",
+							"can't delete other users' events (delete by a tag)",
 						); chk.E(err) {
 							return
 						}
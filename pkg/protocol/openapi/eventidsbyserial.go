@@ -0,0 +1,63 @@
+package openapi
+
+import (
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/encoders/eventidserial"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+)
+
+// EventIdsBySerialInput is the parameters for the HTTP API EventIdsBySerial
+// method.
+type EventIdsBySerialInput struct {
+	Auth  string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Start uint64 `query:"start" doc:"the first serial to return" default:"0"`
+	Count int    `query:"count" doc:"the maximum number of event ids to return" default:"1000" minimum:"1" maximum:"10000"`
+}
+
+// EventIdsBySerialOutput is the list of serials and event ids.
+type EventIdsBySerialOutput struct {
+	Body []eventidserial.E
+}
+
+// RegisterEventIdsBySerial implements the EventIdsBySerial HTTP API method.
+func (x *Operations) RegisterEventIdsBySerial(api huma.API) {
+	name := "EventIdsBySerial"
+	description := `List the event ids stored in the relay in the order they were received (only works with NIP-98 capable client, will not work with UI)
+
+To page through the whole database, pass the last serial returned plus one as the start of the next request, until fewer than the count are returned. The serials have gaps, as the change log takes its seqs from the same sequence, so a gap is not a missing event.`
+	path := x.path + "/eventidsbyserial"
+	scopes := []string{"admin", "read"}
+	method := http.MethodGet
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *EventIdsBySerialInput) (
+			output *EventIdsBySerialOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			output = &EventIdsBySerialOutput{}
+			if output.Body, err = x.Storage().EventIdsBySerial(
+				input.Start, input.Count,
+			); chk.E(err) {
+				err = huma.Error500InternalServerError(err.Error())
+				return
+			}
+			return
+		},
+	)
+}
@@ -10,9 +10,11 @@ import (
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/hex"
 	"orly.dev/pkg/encoders/kind"
 	"orly.dev/pkg/encoders/tag"
 	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/store"
 	"orly.dev/pkg/protocol/auth"
 	"orly.dev/pkg/utils/context"
 	"orly.dev/pkg/utils/log"
@@ -79,15 +81,17 @@ type Filter struct {
 	Until   *int64   `json:"until,omitempty"`
 	Search  *string  `json:"search,omitempty"`
 	Limit   *int     `json:"limit,omitempty"`
+	Cursor  *string  `json:"cursor,omitempty"`
 }
 
 func (f *Filter) ToFilter() (ff *filter.F) {
 	ff = filter.New()
 
-	// Convert Ids
+	// Convert Ids, which are hex; ones that aren't are left as they are, for
+	// the store to reject.
 	if f.Ids != nil && len(f.Ids) > 0 {
 		for _, id := range f.Ids {
-			ff.Ids.Append([]byte(id))
+			ff.Ids.Append(fromHex(id))
 		}
 	}
 	if f.Kinds != nil && len(f.Kinds) > 0 {
@@ -97,7 +101,7 @@ func (f *Filter) ToFilter() (ff *filter.F) {
 	}
 	if f.Authors != nil && len(f.Authors) > 0 {
 		for _, author := range f.Authors {
-			ff.Authors.Append([]byte(author))
+			ff.Authors.Append(fromHex(author))
 		}
 	}
 	if f.Since != nil {
@@ -115,6 +119,10 @@ func (f *Filter) ToFilter() (ff *filter.F) {
 		u := uint(*f.Limit)
 		ff.Limit = &u
 	}
+	if f.Cursor != nil {
+		// an invalid cursor is left out, for the caller to reject.
+		ff.Cursor, _ = filter.ParseCursor([]byte(*f.Cursor))
+	}
 	if f.Tag_a != nil && len(f.Tag_a) > 0 {
 		t := tag.New("#a")
 		for _, v := range f.Tag_a {
@@ -482,6 +490,16 @@ func (f *Filter) ToFilter() (ff *filter.F) {
 	return
 }
 
+// fromHex decodes a hex value of a Filter, or returns it as it is if it isn't
+// hex.
+func fromHex(s string) (b []byte) {
+	var err error
+	if b, err = hex.Dec(s); err != nil {
+		b = []byte(s)
+	}
+	return
+}
+
 var exampleSince int64 = 1753432853
 var exampleUntil int64 = 1753462853
 var exampleLimit int = 20
@@ -519,6 +537,11 @@ var EventsBody = &huma.RequestBody{
 							"deadbeefcafe8008deadbeefcafe8008deadbeefcafe8008deadbeefcafe8008",
 						},
 					},
+					Filter{
+						Kinds: []int{31923},
+						Tag_g: []string{"u4pr*", "59.91,10.75,5000"},
+						Since: &exampleSince,
+					},
 				},
 				Properties: map[string]*huma.Schema{
 					"ids": {
@@ -542,6 +565,13 @@ var EventsBody = &huma.RequestBody{
 							Type: huma.TypeString,
 						},
 					},
+					"#g": {
+						Type:        huma.TypeArray,
+						Description: "list of geohashes to search for, or areas: a geohash prefix followed by * (u4pr*), a box of south west and north east corners (59.9,10.7..60.0,10.8), or a point and a radius in meters (59.91,10.75,5000)",
+						Items: &huma.Schema{
+							Type: huma.TypeString,
+						},
+					},
 					"^#[a-zA-Z]$": {
 						Type:        huma.TypeArray,
 						Description: "list of tag values to search for",
@@ -567,6 +597,10 @@ var EventsBody = &huma.RequestBody{
 						Minimum:     &limitMinimum,
 						Maximum:     &limitMaximum,
 					},
+					"cursor": {
+						Type:        huma.TypeString,
+						Description: "the Cursor of the last page of results, to get the next page of the same filter",
+					},
 				},
 			},
 		},
@@ -580,18 +614,20 @@ type EventsInput struct {
 }
 
 type EventsOutput struct {
-	Body []event.J
+	Cursor string `header:"Cursor" doc:"cursor of the next page of results, if the limit of the filter was reached"`
+	Body   []event.J
 }
 
 // RegisterEvents is the implementation of the HTTP API Events method.
 //
 // This method returns the results of a single filter query, filtered by
-// privilege.
+// privilege, and when the limit of the filter is reached, the cursor that
+// resumes the query after the last of them.
 func (x *Operations) RegisterEvents(api huma.API) {
 	name := "Events"
 	description := `Query for events using a standard NIP-01 filter (only allows one filter)
 
-Returns events as a JSON array of event objects.`
+Returns events as a JSON array of event objects, newest first, and if the limit of the filter was reached, a Cursor header with a token that gets the next page when it is the cursor field of the same filter.`
 	path := x.path + "/events"
 	scopes := []string{"user", "read"}
 	method := http.MethodPost
@@ -621,11 +657,17 @@ Returns events as a JSON array of event objects.`
 					return
 				}
 			}
+			if !super && !x.I.AllowReq(remote, pubkey) {
+				err = Ok.RateLimited(x, nil, "too many requests, slow down")
+				return
+			}
 			f := filter.New()
-			var rem []byte
-			log.I.S(input)
-			if len(rem) > 0 {
-				log.I.F("extra '%s'", rem)
+			if input.Body != nil {
+				f = input.Body.ToFilter()
+				if input.Body.Cursor != nil && f.Cursor == nil {
+					err = huma.Error400BadRequest("invalid cursor")
+					return
+				}
 			}
 			var accept bool
 			allowed, accept, _ := x.AcceptReq(
@@ -636,6 +678,7 @@ Returns events as a JSON array of event objects.`
 				return
 			}
 			var events event.S
+			var cursor *filter.Cursor
 			for _, ff := range allowed.F {
 				// var i uint
 				if pointers.Present(ff.Limit) {
@@ -643,14 +686,24 @@ Returns events as a JSON array of event objects.`
 						continue
 					}
 				}
-				if events, err = x.Storage().QueryEvents(
-					x.Context(), ff,
-				); err != nil {
+				// the cursor is of the last event the store scanned for the
+				// page, so the next page starts after it even if some of the
+				// events of this one were left out.
+				var next *filter.Cursor
+				if sto, ok := x.Storage().(store.Pager); ok {
+					events, next, err = sto.QueryPage(x.Context(), ff)
+				} else {
+					events, err = x.Storage().QueryEvents(x.Context(), ff)
+				}
+				if err != nil {
 					if errors.Is(err, badger.ErrDBClosed) {
 						return
 					}
 					continue
 				}
+				if next != nil {
+					cursor = next
+				}
 				// filter events the authed pubkey is not privileged to fetch.
 				// relay replicas don't have this limitation.
 				if x.AuthRequired() && len(pubkey) > 0 && !super {
@@ -670,8 +723,12 @@ Returns events as a JSON array of event objects.`
 					events = tmp
 				}
 			}
+			output = &EventsOutput{Body: make([]event.J, 0, len(events))}
 			for _, ev := range events {
-				_ = ev
+				output.Body = append(output.Body, *ev.ToEventJ())
+			}
+			if cursor != nil {
+				output.Cursor = cursor.String()
 			}
 			return
 		},
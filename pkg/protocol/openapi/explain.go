@@ -0,0 +1,72 @@
+package openapi
+
+import (
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+)
+
+// ExplainInput is the parameters for the HTTP API Explain method.
+type ExplainInput struct {
+	Auth string  `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Body *Filter `doc:"filter JSON (standard NIP-01 filter syntax)"`
+}
+
+// ExplainOutput is the plan of the query of the filter.
+type ExplainOutput struct {
+	Body *store.QueryPlan
+}
+
+// RegisterExplain implements the Explain HTTP API method.
+func (x *Operations) RegisterExplain(api huma.API) {
+	name := "Explain"
+	description := `Explain how the event store queries a filter (only works with NIP-98 capable client, will not work with UI)
+
+The query is run, and the index chosen to drive it is returned with the plans that were considered and their estimated costs, the fields of the filter that are checked on the events found, the ranges of the index that were scanned, and the number of keys touched and events fetched.`
+	path := x.path + "/explain"
+	scopes := []string{"admin", "read"}
+	method := http.MethodPost
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			RequestBody: EventsBody,
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ExplainInput) (
+			output *ExplainOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			sto, ok := x.Storage().(store.Explainer)
+			if !ok {
+				err = huma.Error501NotImplemented(
+					"the event store doesn't explain its queries",
+				)
+				return
+			}
+			f := filter.New()
+			if input.Body != nil {
+				f = input.Body.ToFilter()
+			}
+			output = &ExplainOutput{}
+			if output.Body, err = sto.Explain(ctx, f); chk.E(err) {
+				err = huma.Error500InternalServerError(err.Error())
+				return
+			}
+			return
+		},
+	)
+}
@@ -1,24 +1,55 @@
 package openapi
 
 import (
+	"io"
 	"net/http"
 
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humago"
 
 	"orly.dev/pkg/protocol/servemux"
+	"orly.dev/pkg/utils/context"
 )
 
 // ExposeMiddleware adds the http.Request and http.ResponseWriter to the context
-// for the Operations handler.
+// for the Operations handler, and a BodyCounter of the bytes read from the
+// body of the request.
 func ExposeMiddleware(ctx huma.Context, next func(huma.Context)) {
 	// Unwrap the request and response objects.
 	r, w := humago.Unwrap(ctx)
+	if r.Body != nil {
+		bc := &BodyCounter{ReadCloser: r.Body}
+		r.Body = bc
+		ctx = huma.WithValue(ctx, "http-body", bc)
+	}
 	ctx = huma.WithValue(ctx, "http-request", r)
 	ctx = huma.WithValue(ctx, "http-response", w)
 	next(ctx)
 }
 
+// BodyCounter counts the bytes read from the body of a request, which, unlike
+// its ContentLength, is known for a chunked body too.
+type BodyCounter struct {
+	io.ReadCloser
+	N int
+}
+
+// Read reads from the body and counts the bytes read.
+func (b *BodyCounter) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.N += n
+	return
+}
+
+// BodyRead returns the number of bytes read from the body of the request of
+// a handler context.
+func BodyRead(ctx context.T) (n int) {
+	if bc, ok := ctx.Value("http-body").(*BodyCounter); ok {
+		n = bc.N
+	}
+	return
+}
+
 // NewHuma creates a new huma.API with a Scalar docs UI, and a middleware that allows methods to
 // access the http.Request and http.ResponseWriter.
 func NewHuma(
@@ -4,6 +4,7 @@ import (
 	"github.com/danielgtaylor/huma/v2"
 	"orly.dev/pkg/encoders/reason"
 	"orly.dev/pkg/interfaces/eventId"
+	"strings"
 )
 
 // OK represents a function that processes events or operations, using provided
@@ -79,7 +80,7 @@ var Ok = OKs{
 	RateLimited: func(
 		a *Operations, _ eventId.Ider, format string, params ...any,
 	) (err error) {
-		return huma.Error400BadRequest(
+		return huma.Error429TooManyRequests(
 			string(
 				reason.RateLimited.F(format, params...),
 			),
@@ -122,3 +123,28 @@ var Ok = OKs{
 		)
 	},
 }
+
+// Reject returns the error of the OK matching the machine-readable prefix of a
+// message, such as the notice of a rejected event from AcceptEvent. Messages
+// without a known prefix are treated as Blocked.
+func (o OKs) Reject(a *Operations, env eventId.Ider, msg string) (err error) {
+	for _, r := range []struct {
+		reason.R
+		OK
+	}{
+		{reason.AuthRequired, o.AuthRequired},
+		{reason.PoW, o.PoW},
+		{reason.Duplicate, o.Duplicate},
+		{reason.Blocked, o.Blocked},
+		{reason.RateLimited, o.RateLimited},
+		{reason.Invalid, o.Invalid},
+		{reason.Error, o.Error},
+		{reason.Unsupported, o.Unsupported},
+		{reason.Restricted, o.Restricted},
+	} {
+		if prefix := r.R.S() + ": "; strings.HasPrefix(msg, prefix) {
+			return r.OK(a, env, "%s", strings.TrimPrefix(msg, prefix))
+		}
+	}
+	return o.Blocked(a, env, "%s", msg)
+}
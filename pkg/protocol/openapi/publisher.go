@@ -7,6 +7,7 @@ import (
 	"orly.dev/pkg/interfaces/server"
 	"orly.dev/pkg/interfaces/typer"
 	"orly.dev/pkg/protocol/auth"
+	"orly.dev/pkg/protocol/subindex"
 	"orly.dev/pkg/utils/log"
 	"reflect"
 	"sync"
@@ -46,6 +47,9 @@ type H struct {
 
 func (h *H) Type() (typeName string) { return Type }
 
+// sub identifies a subscription of a listener in the index.
+type sub struct{ listenerId, subId string }
+
 type Publisher struct {
 	sync.Mutex
 
@@ -54,6 +58,10 @@ type Publisher struct {
 
 	// Server is an interface to the server
 	Server server.I
+
+	// index is the inverted index of the subscription filters, so delivering
+	// an event only matches it against the subscriptions that can match it.
+	index *subindex.Index[sub]
 }
 
 var _ publisher.I = &Publisher{}
@@ -64,6 +72,7 @@ func NewPublisher(s server.I) (p *Publisher) {
 	return &Publisher{
 		ListenMap: make(map[string]*H),
 		Server:    s,
+		index:     subindex.New[sub](),
 	}
 }
 
@@ -123,6 +132,7 @@ func (p *Publisher) Receive(msg typer.T) {
 				if m.FilterMap != nil {
 					for id, f := range m.FilterMap {
 						listener.FilterMap[id] = f
+						p.index.Add(sub{m.Id, id}, f)
 						log.T.F("added subscription %s for new listener %s", id, m.Id)
 					}
 				}
@@ -140,6 +150,7 @@ func (p *Publisher) Receive(msg typer.T) {
 			if m.FilterMap != nil {
 				for id, f := range m.FilterMap {
 					listener.FilterMap[id] = f
+					p.index.Add(sub{m.Id, id}, f)
 					log.T.F("added subscription %s for %s", id, m.Id)
 				}
 			}
@@ -156,54 +167,55 @@ func (p *Publisher) Receive(msg typer.T) {
 //
 // # Expected behaviour
 //
-// Delivers the event to all subscribers whose filters match the event. It
-// applies authentication checks if required by the server, and skips delivery
-// for unauthenticated users when events are privileged.
+// Delivers the event to all subscribers whose filters match the event, found
+// with the index of the subscriptions. It applies authentication checks if
+// required by the server, and skips delivery for unauthenticated users when
+// events are privileged.
 func (p *Publisher) Deliver(ev *event.E) {
 	log.T.F("delivering event %0x to HTTP subscribers", ev.ID)
+	matches := p.index.Match(ev)
 	p.Lock()
 	defer p.Unlock()
-	for listenerId, listener := range p.ListenMap {
-		for subId, filter := range listener.FilterMap {
-			if !filter.Matches(ev) {
-				log.I.F(
-					"listener %s, subscription id %s event\n%s\ndoes not match filter\n%s",
-					listenerId, subId, ev.Marshal(nil),
-					filter.Marshal(nil),
-				)
-				continue
-			}
-			if p.Server.AuthRequired() {
-				if !auth.CheckPrivilege(listener.Pubkey, ev) {
-					log.W.F(
-						"not privileged %0x ev pubkey %0x listener pubkey %0x kind %s privileged: %v",
-						listener.Pubkey, ev.Pubkey,
-						listener.Pubkey, ev.Kind.Name(),
-						ev.Kind.IsPrivileged(),
-					)
-					continue
-				}
-			}
-			// Send the event to the listener's receiver channel
-			select {
-			case listener.Receiver <- &Delivery{SubId: subId, Event: ev}:
-				log.T.F(
-					"dispatched event %0x to subscription %s for listener %s",
-					ev.ID, subId, listenerId,
-				)
-			default:
+	for _, m := range matches {
+		listener, ok := p.ListenMap[m.listenerId]
+		if !ok {
+			continue
+		}
+		if p.Server.AuthRequired() {
+			if !auth.CheckPrivilege(listener.Pubkey, ev) {
 				log.W.F(
-					"failed to dispatch event %0x to subscription %s for listener %s: channel full",
-					ev.ID, subId, listenerId,
+					"not privileged %0x ev pubkey %0x listener pubkey %0x kind %s privileged: %v",
+					listener.Pubkey, ev.Pubkey,
+					listener.Pubkey, ev.Kind.Name(),
+					ev.Kind.IsPrivileged(),
 				)
+				continue
 			}
 		}
+		// Send the event to the listener's receiver channel
+		select {
+		case listener.Receiver <- &Delivery{SubId: m.subId, Event: ev}:
+			log.T.F(
+				"dispatched event %0x to subscription %s for listener %s",
+				ev.ID, m.subId, m.listenerId,
+			)
+		default:
+			log.W.F(
+				"failed to dispatch event %0x to subscription %s for listener %s: channel full",
+				ev.ID, m.subId, m.listenerId,
+			)
+		}
 	}
 }
 
 // removeListener removes a listener from the Publisher collection.
 func (p *Publisher) removeListener(id string) {
 	p.Lock()
+	if listener, ok := p.ListenMap[id]; ok {
+		for subId := range listener.FilterMap {
+			p.index.Remove(sub{id, subId})
+		}
+	}
 	delete(p.ListenMap, id)
 	p.Unlock()
 }
@@ -217,6 +229,7 @@ func (p *Publisher) removeSubscription(
 	if listener, ok := p.ListenMap[listenerId]; ok {
 		for id := range filterMap {
 			delete(listener.FilterMap, id)
+			p.index.Remove(sub{listenerId, id})
 		}
 		// We no longer delete the listener when all subscriptions are removed
 		// This allows the listener to remain active for future subscriptions
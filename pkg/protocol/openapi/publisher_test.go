@@ -1,12 +1,15 @@
 package openapi
 
 import (
+	"encoding/hex"
 	"net/http"
 	"orly.dev/pkg/app/config"
 	"testing"
 	"time"
 
+	"orly.dev/pkg/app/relay/cluster"
 	"orly.dev/pkg/app/relay/publish"
+	"orly.dev/pkg/app/relay/replicate"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/filter"
 	"orly.dev/pkg/encoders/filters"
@@ -15,13 +18,20 @@ import (
 	"orly.dev/pkg/encoders/tags"
 	"orly.dev/pkg/interfaces/relay"
 	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/relayinfo"
 	ctx "orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
 )
 
 // mockServer implements the server.I interface for testing
 type mockServer struct {
 	authRequired bool
 	context      ctx.T
+	admin        bool
+	storage      store.I
+	conf         *store.Configuration
+	peers        []replicate.PeerState
+	members      []cluster.MemberState
 }
 
 // Implement the methods needed for our tests
@@ -54,7 +64,7 @@ func (m *mockServer) AcceptReq(
 
 func (m *mockServer) AddEvent(
 	c ctx.T, rl relay.I, ev *event.E, hr *http.Request, origin string,
-	pubkey []byte,
+	pubkeys [][]byte,
 ) (accepted bool, message []byte) {
 	return true, nil
 }
@@ -62,7 +72,25 @@ func (m *mockServer) AddEvent(
 func (m *mockServer) AdminAuth(
 	r *http.Request, remote string, tolerance ...time.Duration,
 ) (authed bool, pubkey []byte) {
-	return false, nil
+	return m.admin, nil
+}
+
+func (m *mockServer) AllowEvent(remote string, authedPubkey []byte) bool {
+	return true
+}
+
+func (m *mockServer) AllowReq(remote string, authedPubkey []byte) bool {
+	return true
+}
+
+func (m *mockServer) AllowBytes(
+	remote string, authedPubkey []byte, n int,
+) bool {
+	return true
+}
+
+func (m *mockServer) Limits() relayinfo.Limits {
+	return relayinfo.Limits{}
 }
 
 func (m *mockServer) UserAuth(
@@ -82,7 +110,7 @@ func (m *mockServer) Relay() relay.I {
 func (m *mockServer) Shutdown() {}
 
 func (m *mockServer) Storage() store.I {
-	return nil
+	return m.storage
 }
 
 func (m *mockServer) PublicReadable() bool {
@@ -101,6 +129,70 @@ func (m *mockServer) Config() (c *config.C) {
 	return
 }
 
+func (m *mockServer) Configuration() (conf *store.Configuration) {
+	return m.conf
+}
+
+func (m *mockServer) SetConfiguration(conf *store.Configuration) (err error) {
+	if conf.MaxLimit < 0 {
+		return errorf.E(
+			"%w: limits cannot be negative", store.ErrInvalidConfiguration,
+		)
+	}
+	m.conf = conf
+	return
+}
+
+func (m *mockServer) Replication() (peers []replicate.PeerState) {
+	return m.peers
+}
+
+func (m *mockServer) DropReplication(pubkey []byte) (err error) {
+	for i, p := range m.peers {
+		if p.Pubkey == hex.EncodeToString(pubkey) {
+			m.peers = append(m.peers[:i], m.peers[i+1:]...)
+			return
+		}
+	}
+	return
+}
+
+func (m *mockServer) ClusterAnnounce(ev *event.E) (reply *event.E, err error) {
+	return nil, cluster.ErrDisabled
+}
+
+func (m *mockServer) Cluster() (members []cluster.MemberState, err error) {
+	if m.members == nil {
+		return nil, cluster.ErrDisabled
+	}
+	return m.members, nil
+}
+
+func (m *mockServer) ClusterAdd(pubkey []byte, address string) (err error) {
+	if m.members == nil {
+		return cluster.ErrDisabled
+	}
+	m.members = append(
+		m.members, cluster.MemberState{
+			Pubkey: hex.EncodeToString(pubkey), Address: address,
+		},
+	)
+	return
+}
+
+func (m *mockServer) ClusterRemove(pubkey []byte) (err error) {
+	if m.members == nil {
+		return cluster.ErrDisabled
+	}
+	for i, mb := range m.members {
+		if mb.Pubkey == hex.EncodeToString(pubkey) {
+			m.members = append(m.members[:i], m.members[i+1:]...)
+			return
+		}
+	}
+	return cluster.ErrNotMember
+}
+
 // TestPublisherFunctionality tests the listen/subscribe/unsubscribe and publisher functionality
 func TestPublisherFunctionality(t *testing.T) {
 	// Create a context with cancel function
@@ -120,11 +212,12 @@ func TestPublisherFunctionality(t *testing.T) {
 	t.Run(
 		"RegisterListener", func(t *testing.T) {
 			// Create a receiver channel
-			receiver := make(event.C, 32)
+			receiver := make(DeliverChan, 32)
 
 			// Create a listener
 			listener := &H{
 				Id:        "test-listener",
+				New:       true,
 				Receiver:  receiver,
 				FilterMap: make(map[string]*filter.F),
 			}
@@ -190,7 +283,7 @@ func TestPublisherFunctionality(t *testing.T) {
 			// Verify the event was received
 			select {
 			case receivedEv := <-listener.Receiver:
-				if receivedEv != ev {
+				if receivedEv.Event != ev {
 					t.Errorf("Received event does not match delivered event")
 				}
 			case <-time.After(100 * time.Millisecond):
@@ -203,9 +296,10 @@ func TestPublisherFunctionality(t *testing.T) {
 	t.Run(
 		"Unsubscribe", func(t *testing.T) {
 			// Create a new listener first since the previous one was removed
-			receiver := make(event.C, 32)
+			receiver := make(DeliverChan, 32)
 			listener := &H{
 				Id:        "test-listener",
+				New:       true,
 				Receiver:  receiver,
 				FilterMap: make(map[string]*filter.F),
 			}
@@ -232,9 +326,15 @@ func TestPublisherFunctionality(t *testing.T) {
 			// Unsubscribe
 			publisher.Receive(unsubscribe)
 
-			// Verify the listener was removed (since it had no more subscriptions)
-			if _, ok := publisher.ListenMap["test-listener"]; ok {
-				t.Errorf("Listener was not removed, but should be removed when all subscriptions are gone")
+			// Verify the subscription was removed, and the listener remains
+			// for future subscriptions
+			listener, ok := publisher.ListenMap["test-listener"]
+			if !ok {
+				t.Errorf("Listener was removed, but should remain when all subscriptions are gone")
+				return
+			}
+			if _, ok := listener.FilterMap["test-subscription"]; ok {
+				t.Errorf("Subscription was not removed")
 			}
 		},
 	)
@@ -262,9 +362,10 @@ func TestPublisherFunctionality(t *testing.T) {
 	t.Run(
 		"UnsubscribeNonExistentSubscription", func(t *testing.T) {
 			// Create a new listener first
-			receiver := make(event.C, 32)
+			receiver := make(DeliverChan, 32)
 			listener := &H{
 				Id:        "test-listener-2",
+				New:       true,
 				Receiver:  receiver,
 				FilterMap: make(map[string]*filter.F),
 			}
@@ -315,9 +416,10 @@ func TestPublisherFunctionality(t *testing.T) {
 			mockServer.authRequired = true
 
 			// Create a new listener with pubkey
-			receiver := make(event.C, 32)
+			receiver := make(DeliverChan, 32)
 			listener := &H{
 				Id:        "test-listener-3",
+				New:       true,
 				Receiver:  receiver,
 				FilterMap: make(map[string]*filter.F),
 				Pubkey:    []byte("test-pubkey"),
@@ -360,17 +462,19 @@ func TestPublisherFunctionality(t *testing.T) {
 	t.Run(
 		"FilterMatching", func(t *testing.T) {
 			// Create two listeners with different filters
-			receiver1 := make(event.C, 32)
+			receiver1 := make(DeliverChan, 32)
 			listener1 := &H{
 				Id:        "test-listener-filter-1",
+				New:       true,
 				Receiver:  receiver1,
 				FilterMap: make(map[string]*filter.F),
 			}
 			publisher.Receive(listener1)
 
-			receiver2 := make(event.C, 32)
+			receiver2 := make(DeliverChan, 32)
 			listener2 := &H{
 				Id:        "test-listener-filter-2",
+				New:       true,
 				Receiver:  receiver2,
 				FilterMap: make(map[string]*filter.F),
 			}
@@ -413,7 +517,7 @@ func TestPublisherFunctionality(t *testing.T) {
 			// Verify the event was received by the first listener
 			select {
 			case receivedEv := <-receiver1:
-				if receivedEv != ev {
+				if receivedEv.Event != ev {
 					t.Errorf("Received event does not match delivered event")
 				}
 			case <-time.After(100 * time.Millisecond):
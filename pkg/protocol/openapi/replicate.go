@@ -0,0 +1,117 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/app/relay/replicate"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+)
+
+// ReplicateInput is the parameters for the HTTP API Replicate method.
+type ReplicateInput struct {
+	Auth    string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	RawBody []byte `contentType:"application/jsonl" doc:"the events as JSONL, each line an object with the event and the pubkeys of the relays it passed through"`
+}
+
+// ReplicateOutput is the result of the batch of events.
+type ReplicateOutput struct {
+	Body *replicate.Result
+}
+
+// RegisterReplicate implements the Replicate HTTP API method.
+func (x *Operations) RegisterReplicate(api huma.API) {
+	name := "Replicate"
+	description := `Add a batch of events replicated by a peer relay (only works with NIP-98 capable client, will not work with UI)
+
+The events are added in order, as if each was submitted with the Event method by the peer, and the response counts those that were accepted and gives the reason for each that was refused. Only the peer relays and the members of the cluster can use it.`
+	path := x.path + "/replicate"
+	scopes := []string{"peer", "write"}
+	method := http.MethodPost
+	huma.Register(
+		api, huma.Operation{
+			OperationID:  name,
+			Summary:      name,
+			Path:         path,
+			Method:       method,
+			Tags:         []string{"admin"},
+			Description:  helpers.GenerateDescription(description, scopes),
+			Security:     []map[string][]string{{"auth": scopes}},
+			MaxBodyBytes: 2 * replicate.MaxBatchBytes,
+		}, func(ctx context.T, input *ReplicateInput) (
+			output *ReplicateOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, pubkey, super := x.UserAuth(r, remote)
+			if !authed || !super {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			res := &replicate.Result{}
+			output = &ReplicateOutput{Body: res}
+			reject := func(id []byte, reason string) {
+				res.Rejected = append(
+					res.Rejected, replicate.Rejection{
+						Id: hex.Enc(id), Reason: reason,
+					},
+				)
+			}
+			c := x.Context()
+			dec := json.NewDecoder(bytes.NewReader(input.RawBody))
+			for dec.More() {
+				var item replicate.Item
+				if err = dec.Decode(&item); chk.E(err) {
+					err = huma.Error400BadRequest("invalid item", err)
+					return
+				}
+				ev := event.New()
+				if _, err = ev.Unmarshal(item.Event); chk.E(err) {
+					reject(nil, "invalid: "+err.Error())
+					err = nil
+					continue
+				}
+				if !bytes.Equal(ev.GetIDBytes(), ev.ID) {
+					reject(ev.ID, "invalid: event id is computed incorrectly")
+					continue
+				}
+				var ok bool
+				if ok, err = ev.Verify(); err != nil || !ok {
+					reject(ev.ID, "invalid: signature is invalid")
+					err = nil
+					continue
+				}
+				// the relays the event passed through, which it is not sent
+				// back to.
+				pubkeys := [][]byte{pubkey}
+				for _, pk := range item.Via {
+					var pkb []byte
+					if pkb, err = hex.Dec(pk); chk.E(err) {
+						err = nil
+						continue
+					}
+					pubkeys = append(pubkeys, pkb)
+				}
+				var reason []byte
+				if ok, reason = x.I.AddEvent(
+					c, x.Relay(), ev, r, remote, pubkeys,
+				); !ok {
+					reject(ev.ID, string(reason))
+					continue
+				}
+				res.Accepted++
+			}
+			log.I.F(
+				"replicated %d events from %s pubkey %0x, %d refused",
+				res.Accepted, remote, pubkey, len(res.Rejected),
+			)
+			return
+		},
+	)
+}
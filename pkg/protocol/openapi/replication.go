@@ -0,0 +1,118 @@
+package openapi
+
+import (
+	"errors"
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/app/relay/replicate"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/keys"
+	"orly.dev/pkg/utils/log"
+)
+
+// ReplicationInput is the parameters for the HTTP API Replication method.
+type ReplicationInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+}
+
+// ReplicationOutput is the state of the replication to each peer relay.
+type ReplicationOutput struct {
+	Body []replicate.PeerState
+}
+
+// RegisterReplication implements the Replication HTTP API method.
+func (x *Operations) RegisterReplication(api huma.API) {
+	name := "Replication"
+	description := `Get the state of the replication of events to the peer relays (only works with NIP-98 capable client, will not work with UI)
+
+Events accepted by the relay are queued in the database for each peer, and delivered in order, with retries while a peer can't be reached. For each peer this shows whether the last delivery succeeded, the number of queued events, how long the oldest of them has waited, and the counts of delivered and rejected events since the relay started.`
+	path := x.path + "/replication"
+	scopes := []string{"admin", "read"}
+	method := http.MethodGet
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ReplicationInput) (
+			output *ReplicationOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			output = &ReplicationOutput{Body: x.Replication()}
+			return
+		},
+	)
+}
+
+// ReplicationDropInput is the parameters for the HTTP API ReplicationDrop
+// method.
+type ReplicationDropInput struct {
+	Auth   string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Pubkey string `path:"pubkey" doc:"pubkey of the peer relay in hex or npub format"`
+}
+
+// ReplicationDropOutput is nothing, basically; a 204 or 200 status is
+// expected.
+type ReplicationDropOutput struct{}
+
+// RegisterReplicationDrop implements the ReplicationDrop HTTP API method.
+func (x *Operations) RegisterReplicationDrop(api huma.API) {
+	name := "ReplicationDrop"
+	description := `Drop the queue of events of a removed peer relay (only works with NIP-98 capable client, will not work with UI)
+
+The queue of a peer relay that is removed from the configuration or the cluster is kept, and delivered if the peer is added again, until it is dropped with this method. The queue of a peer that is still configured can't be dropped.`
+	path := x.path + "/replication/{pubkey}"
+	scopes := []string{"admin", "write"}
+	method := http.MethodDelete
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *ReplicationDropInput) (
+			output *ReplicationDropOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, pubkey := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			var pk []byte
+			if pk, err = keys.DecodeNpubOrHex(input.Pubkey); err != nil {
+				err = huma.Error400BadRequest(err.Error())
+				return
+			}
+			log.I.F(
+				"%s replication queue drop of %0x requested on admin port "+
+					"pubkey %0x", remote, pk, pubkey,
+			)
+			if err = x.DropReplication(pk); err != nil {
+				if errors.Is(err, replicate.ErrConfigured) {
+					err = huma.Error409Conflict(err.Error())
+				} else {
+					err = huma.Error500InternalServerError(err.Error())
+				}
+				return
+			}
+			output = &ReplicationDropOutput{}
+			return
+		},
+	)
+}
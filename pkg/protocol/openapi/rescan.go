@@ -0,0 +1,60 @@
+package openapi
+
+import (
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+)
+
+// RescanInput is the parameters for the HTTP API Rescan method.
+type RescanInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+}
+
+// RescanOutput is nothing, basically; a 204 or 200 status is expected.
+type RescanOutput struct{}
+
+// RegisterRescan implements the Rescan HTTP API method.
+func (x *Operations) RegisterRescan(api huma.API) {
+	name := "Rescan"
+	description := `Regenerate the indexes of all stored events (only works with NIP-98 capable client, will not work with UI)
+
+This is needed after an upgrade adds new indexes, so that events stored before the upgrade can be found with them. The relay keeps serving requests while the rescan runs.`
+	path := x.path + "/rescan"
+	scopes := []string{"admin", "write"}
+	method := http.MethodPost
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *RescanInput) (
+			output *RescanOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, pubkey := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			log.I.F(
+				"%s rescan of indexes requested on admin port pubkey %0x",
+				remote, pubkey,
+			)
+			if err = x.Storage().Rescan(); chk.E(err) {
+				err = huma.Error500InternalServerError(err.Error())
+				return
+			}
+			output = &RescanOutput{}
+			return
+		},
+	)
+}
@@ -0,0 +1,118 @@
+package openapi
+
+import (
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+)
+
+// StatsInput is the parameters for the HTTP API Stats method.
+type StatsInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+	Top  int    `query:"top" doc:"the number of authors with the most events to list" default:"20" minimum:"0" maximum:"1000"`
+}
+
+// StatsOutput is the report of the event store.
+type StatsOutput struct {
+	Body *store.Stats
+}
+
+// RegisterStats implements the Stats HTTP API method.
+func (x *Operations) RegisterStats(api huma.API) {
+	name := "Stats"
+	description := `Get a report of the event store (only works with NIP-98 capable client, will not work with UI)
+
+The report has the number of events of each kind, the authors with the most events, the number and size of the keys of each index, the sizes of the LSM tree and value log and of each level of the tree, the last garbage collection, and warnings of problems found with the store. Every key in the store is read, so this can take some time on a large store.`
+	path := x.path + "/stats"
+	scopes := []string{"admin", "read"}
+	method := http.MethodGet
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *StatsInput) (
+			output *StatsOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			sto, ok := x.Storage().(store.Statser)
+			if !ok {
+				err = huma.Error501NotImplemented(
+					"the event store doesn't report stats",
+				)
+				return
+			}
+			output = &StatsOutput{}
+			if output.Body, err = sto.Stats(ctx, input.Top); chk.E(err) {
+				err = huma.Error500InternalServerError(err.Error())
+				return
+			}
+			return
+		},
+	)
+}
+
+// CollectGarbageInput is the parameters for the HTTP API CollectGarbage
+// method.
+type CollectGarbageInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+}
+
+// CollectGarbageOutput is the result of the garbage collection.
+type CollectGarbageOutput struct {
+	Body *store.GCRun
+}
+
+// RegisterCollectGarbage implements the CollectGarbage HTTP API method.
+func (x *Operations) RegisterCollectGarbage(api huma.API) {
+	name := "CollectGarbage"
+	description := `Collect the garbage of the event store now (only works with NIP-98 capable client, will not work with UI)
+
+The value log files with more garbage than the configured discard percentage are rewritten, and the LSM tree is compacted if more of it is stale than the configured percentage. This also runs on the interval set by ORLY_DB_GC_INTERVAL.`
+	path := x.path + "/stats/gc"
+	scopes := []string{"admin", "write"}
+	method := http.MethodPost
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *CollectGarbageInput) (
+			output *CollectGarbageOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, _ := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			sto, ok := x.Storage().(store.GarbageCollector)
+			if !ok {
+				err = huma.Error501NotImplemented(
+					"the event store doesn't collect garbage",
+				)
+				return
+			}
+			output = &CollectGarbageOutput{Body: sto.CollectGarbage()}
+			return
+		},
+	)
+}
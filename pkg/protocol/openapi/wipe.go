@@ -0,0 +1,60 @@
+package openapi
+
+import (
+	"github.com/danielgtaylor/huma/v2"
+	"net/http"
+	"orly.dev/pkg/app/relay/helpers"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+)
+
+// WipeInput is the parameters for the HTTP API Wipe method.
+type WipeInput struct {
+	Auth string `header:"Authorization" doc:"nostr nip-98 (and expiring variant)" required:"true"`
+}
+
+// WipeOutput is nothing, basically; a 204 or 200 status is expected.
+type WipeOutput struct{}
+
+// RegisterWipe implements the Wipe HTTP API method.
+func (x *Operations) RegisterWipe(api huma.API) {
+	name := "Wipe"
+	description := `Wipe all events and indexes from the database (only works with NIP-98 capable client, will not work with UI)
+
+This cannot be undone, make an export first if the events may be needed again.`
+	path := x.path + "/wipe"
+	scopes := []string{"admin", "write"}
+	method := http.MethodPost
+	huma.Register(
+		api, huma.Operation{
+			OperationID: name,
+			Summary:     name,
+			Path:        path,
+			Method:      method,
+			Tags:        []string{"admin"},
+			Description: helpers.GenerateDescription(description, scopes),
+			Security:    []map[string][]string{{"auth": scopes}},
+		}, func(ctx context.T, input *WipeInput) (
+			output *WipeOutput, err error,
+		) {
+			r := ctx.Value("http-request").(*http.Request)
+			remote := helpers.GetRemoteFromReq(r)
+			authed, pubkey := x.AdminAuth(r, remote)
+			if !authed {
+				err = huma.Error401Unauthorized("Not Authorized")
+				return
+			}
+			log.W.F(
+				"%s wipe of event data requested on admin port pubkey %0x",
+				remote, pubkey,
+			)
+			if err = x.Storage().Wipe(); chk.E(err) {
+				err = huma.Error500InternalServerError(err.Error())
+				return
+			}
+			output = &WipeOutput{}
+			return
+		},
+	)
+}
@@ -0,0 +1,141 @@
+// Package pow is a multi-threaded miner for NIP-13 proof of work, that stamps
+// an event with a nonce tag giving its ID a minimum number of leading zero
+// bits.
+package pow
+
+import (
+	"bytes"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/utils/atomic"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/qu"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// marker is the start of the nonce tag in the canonical encoding of an event.
+// Quotes in other tags and the content are escaped, and any other nonce tags
+// are removed, so it can only appear once.
+var marker = []byte(`["nonce","`)
+
+type result struct {
+	nonce uint64
+	id    []byte
+}
+
+// Mine searches for a nonce that gives the event an ID with at least target
+// leading zero bits.
+//
+// # Parameters
+//
+//   - c: a context that stops the mining when it is canceled
+//
+//   - ev: the event to mine, which must have its pubkey, created_at, kind,
+//     tags and content set
+//
+//   - target: the difficulty to reach
+//
+//   - threads: the number of workers to mine with, if zero, one for each CPU
+//
+// # Return Values
+//
+//   - attempts: the number of hashes computed
+//
+//   - err: an error if the context was canceled before the target was found
+//
+// # Expected Behaviour
+//
+// Any existing nonce tag of the event is replaced with one that commits to the
+// target, as described in NIP-13, and the ID is set to the mined hash. The
+// signature is cleared as it is no longer valid, so the event must be signed
+// after it is mined.
+func Mine(c context.T, ev *event.E, target, threads int) (
+	attempts int64, err error,
+) {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	if ev.Tags == nil {
+		ev.Tags = tags.New()
+	}
+	others := ev.Tags.FilterOut([][]byte{[]byte("nonce")})
+	t := strconv.Itoa(target)
+	ev.Tags = others.Clone().AppendTags(tag.New("nonce", "", t))
+	// the canonical encoding only changes at the nonce, so it is split there
+	// once, and the workers only need to write the number in between.
+	canonical := ev.ToCanonical(nil)
+	ev.Tags = others
+	i := bytes.Index(canonical, marker)
+	if i < 0 {
+		err = errorf.E("failed to find nonce in canonical event")
+		return
+	}
+	prefix := canonical[:i+len(marker)]
+	suffix := canonical[i+len(marker):]
+	quit := qu.T()
+	resC := make(chan result, threads)
+	var wg sync.WaitGroup
+	counter := atomic.NewInt64(0)
+	for w := 0; w < threads; w++ {
+		log.D.F("starting up worker %d", w)
+		wg.Add(1)
+		go mine(
+			prefix, suffix, target, uint64(w), uint64(threads), quit, resC,
+			&wg, counter,
+		)
+	}
+	var res result
+	select {
+	case res = <-resC:
+	case <-c.Done():
+		err = errorf.E("mining canceled after %d attempts", counter.Load())
+	}
+	// tell the other workers to stop and wait for them
+	quit.Q()
+	wg.Wait()
+	attempts = counter.Load()
+	if err != nil {
+		return
+	}
+	ev.Tags = others.Clone().AppendTags(
+		tag.New("nonce", strconv.FormatUint(res.nonce, 10), t),
+	)
+	ev.ID = res.id
+	ev.Sig = nil
+	return
+}
+
+// mine is a worker that tries every step'th nonce from start, until it finds
+// one that reaches the target or quit is closed.
+func mine(
+	prefix, suffix []byte, target int, start, step uint64, quit qu.C,
+	resC chan result, wg *sync.WaitGroup, counter *atomic.Int64,
+) {
+	defer wg.Done()
+	buf := make([]byte, 0, len(prefix)+len(suffix)+20)
+	for nonce := start; ; nonce += step {
+		// checking the channel every time would slow the loop down a lot
+		if nonce%(step*1024) == start {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+		}
+		counter.Inc()
+		buf = append(buf[:0], prefix...)
+		buf = strconv.AppendUint(buf, nonce, 10)
+		buf = append(buf, suffix...)
+		id := sha256.Sum256(buf)
+		if event.Difficulty(id[:]) >= target {
+			resC <- result{nonce, id[:]}
+			return
+		}
+	}
+}
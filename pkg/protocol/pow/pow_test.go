@@ -0,0 +1,58 @@
+package pow
+
+import (
+	"bytes"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/utils/context"
+	"testing"
+	"time"
+)
+
+func TestMine(t *testing.T) {
+	pk := sha256.Sum256([]byte("pubkey"))
+	ev := event.New()
+	ev.Pubkey = pk[:]
+	ev.CreatedAt = timestamp.Now()
+	ev.Kind = kind.TextNote
+	ev.Content = []byte("it's \"quoted\"\nand has a nonce tag")
+	ev.Tags = tags.New(tag.New("t", "pow"), tag.New("nonce", "1", "2"))
+	attempts, err := Mine(context.Bg(), ev, 12, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ev.ID, ev.GetIDBytes()) {
+		t.Fatalf("mined id %0x does not match the event", ev.ID)
+	}
+	if d := ev.Difficulty(); d < 12 {
+		t.Fatalf("difficulty is %d after %d attempts", d, attempts)
+	}
+	if target, ok := ev.PowTarget(); !ok || target != 12 {
+		t.Fatalf("committed target is %d", target)
+	}
+	if n := ev.Tags.GetAll(tag.New("nonce")).Len(); n != 1 {
+		t.Fatalf("event has %d nonce tags", n)
+	}
+	if ev.Tags.Len() != 2 {
+		t.Fatalf("event has %d tags", ev.Tags.Len())
+	}
+}
+
+func TestMineCanceled(t *testing.T) {
+	ev := event.New()
+	ev.Pubkey = make([]byte, 32)
+	ev.CreatedAt = timestamp.Now()
+	ev.Kind = kind.TextNote
+	c, cancel := context.Timeout(context.Bg(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Mine(c, ev, 256, 2); err == nil {
+		t.Fatal("mining an impossible difficulty did not fail")
+	}
+	if ev.Tags.Len() != 0 {
+		t.Fatalf("event has %d tags after canceling", ev.Tags.Len())
+	}
+}
@@ -61,6 +61,8 @@ var (
 	NIP11                    = RelayInformationDocument
 	GenericTagQueries        = NIP{"Generic Tag Queries", 12}
 	NIP12                    = GenericTagQueries
+	ProofOfWork              = NIP{"Proof of Work", 13}
+	NIP13                    = ProofOfWork
 	SubjectTag               = NIP{"Subject tag in text events", 14}
 	NIP14                    = SubjectTag
 	NostrMarketplace         = NIP{
@@ -141,6 +143,8 @@ var (
 	NIP72                          = ModeratedCommunities
 	ZapGoals                       = NIP{"Zap Goals", 75}
 	NIP75                          = ZapGoals
+	NegentropySyncing              = NIP{"Negentropy Syncing", 77}
+	NIP77                          = NegentropySyncing
 	ApplicationSpecificData        = NIP{"Application-specific data", 78}
 	NIP78                          = ApplicationSpecificData
 	Highlights                     = NIP{"Highlights", 84}
@@ -170,7 +174,7 @@ var NIPMap = map[int]NIP{
 	44: NIP44, 45: NIP45, 46: NIP46, 47: NIP47, 48: NIP48, 50: NIP50, 51: NIP51,
 	52: NIP52,
 	53: NIP53, 56: NIP56, 57: NIP57, 58: NIP58, 65: NIP65, 72: NIP72, 75: NIP75,
-	78: NIP78,
+	77: NIP77, 78: NIP78,
 	84: NIP84, 89: NIP89, 90: NIP90, 94: NIP94, 96: NIP96, 98: NIP98, 99: NIP99,
 }
 
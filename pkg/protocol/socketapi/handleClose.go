@@ -27,8 +27,9 @@ import (
 //
 // Processes the CLOSE envelope by unmarshalling it into a structured
 // format, checks for remaining data after unmarshalling, verifies the
-// presence of a non-empty <id> field, and sends a cancellation signal to
-// the publisher with the associated listener and ID. Returns an error
+// presence of a non-empty <id> field, stops the query of the subscription if
+// it is still running, and sends a cancellation signal to the publisher with
+// the associated listener and ID. Returns an error
 // message if the envelope lacks a valid <id>.
 func (a *A) HandleClose(
 	req []byte,
@@ -46,6 +47,7 @@ func (a *A) HandleClose(
 	if env.ID.String() == "" {
 		return []byte("CLOSE has no <id>")
 	}
+	a.stopQuery(env.ID.String())
 	srv.Publisher().Receive(
 		&W{
 			Cancel:   true,
@@ -0,0 +1,197 @@
+package socketapi
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/encoders/envelopes/authenvelope"
+	"orly.dev/pkg/encoders/envelopes/closedenvelope"
+	"orly.dev/pkg/encoders/envelopes/countenvelope"
+	"orly.dev/pkg/encoders/envelopes/reqenvelope"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/reason"
+	"orly.dev/pkg/encoders/subscription"
+	"orly.dev/pkg/interfaces/server"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/auth"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/normalize"
+)
+
+// HandleCount processes a NIP-45 COUNT request, counting the events matching
+// the filters of the request and writing a COUNT response to the client.
+//
+// # Parameters
+//
+//   - c: a context object used for managing deadlines, cancellation signals,
+//     and other request-scoped values.
+//
+//   - req: a byte slice representing the raw request data to be processed.
+//
+//   - srv: An interface representing the server, providing access to storage
+//     and the filter acceptance policy.
+//
+// # Return Values
+//
+//   - r: a byte slice containing a notice or error message generated during
+//     processing.
+//
+// # Expected behaviour
+//
+// The method parses the COUNT envelope and applies the same auth, NIP-11
+// limit, rate limit and filter acceptance rules as a REQ. The count is that of
+// the union of the events matching the filters, returned in a COUNT response
+// with the subscription Id of the request. A single filter is counted from the
+// indexes of the store without fetching the events.
+//
+// Several filters are counted by streaming their events, so that an event
+// matching more than one is counted once, and so is a filter that may match
+// privileged kinds when auth is required, as the events must be fetched to
+// check the authed pubkey is party to them. The limit of a REQ doesn't apply
+// to the count.
+//
+// If the store fails to count any of the filters, the request is answered
+// with a CLOSED carrying the error instead of a COUNT of the other filters.
+func (a *A) HandleCount(c context.T, req []byte, srv server.I) (r []byte) {
+	var err error
+	log.T.F("COUNT:\n%s", req)
+	sto := srv.Storage()
+	var rem []byte
+	env := countenvelope.New()
+	if rem, err = env.Unmarshal(req); chk.E(err) {
+		return normalize.Error.F(err.Error())
+	}
+	if len(rem) > 0 {
+		log.D.F("extra '%s'", rem)
+	}
+	if a.I.AuthRequired() && !a.Listener.IsAuthed() {
+		a.Listener.RequestAuth()
+		if err = authenvelope.NewChallengeWith(a.Listener.Challenge()).
+			Write(a.Listener); chk.E(err) {
+			return
+		}
+		if !a.I.PublicReadable() {
+			if err = closedenvelope.NewFrom(
+				env.Subscription, reason.AuthRequired.F("auth enabled"),
+			).Write(a.Listener); chk.E(err) {
+				return
+			}
+			return
+		}
+	}
+	// the limits of a REQ apply to its filters, the MaxLimit is set on them
+	// but a count ignores it.
+	if msg := a.CheckLimits(
+		reqenvelope.NewFrom(env.Subscription, env.Filters), srv,
+	); msg != nil {
+		if err = closedenvelope.NewFrom(
+			env.Subscription, msg,
+		).Write(a.Listener); chk.E(err) {
+			return
+		}
+		return
+	}
+	if !srv.AllowReq(a.Listener.RealRemote(), a.Listener.AuthedPubkey()) {
+		if err = closedenvelope.NewFrom(
+			env.Subscription,
+			reason.RateLimited.F("too many subscriptions, slow down"),
+		).Write(a.Listener); chk.E(err) {
+			return
+		}
+		return
+	}
+	allowed, accept, _ := srv.AcceptReq(
+		c, a.Request, env.Filters, a.Listener.AuthedPubkey(),
+		a.Listener.RealRemote(),
+	)
+	if !accept {
+		if err = closedenvelope.NewFrom(
+			env.Subscription, []byte("filters aren't permitted for client"),
+		).Write(a.Listener); chk.E(err) {
+			return
+		}
+		return
+	}
+	var total int
+	var approx bool
+	privileged := func(f *filter.F) bool {
+		return srv.AuthRequired() && mayBePrivileged(f)
+	}
+	if len(allowed.F) == 1 && !privileged(allowed.F[0]) {
+		if total, approx, err = sto.CountEvents(c, allowed.F[0]); err != nil {
+			if errors.Is(err, badger.ErrDBClosed) {
+				return
+			}
+			a.countErr(env.Subscription, err)
+			return
+		}
+	} else if total, err = a.countUnion(
+		c, sto, allowed.F, privileged,
+	); err != nil {
+		if errors.Is(err, badger.ErrDBClosed) {
+			return
+		}
+		a.countErr(env.Subscription, err)
+		return
+	}
+	var res *countenvelope.Response
+	if res, err = countenvelope.NewResponseFrom(
+		env.Subscription.T, total, approx,
+	); chk.E(err) {
+		return
+	}
+	if err = res.Write(a.Listener); chk.E(err) {
+		return
+	}
+	return
+}
+
+// countUnion counts the distinct events matching any of the filters, so that
+// an event matching several of them is counted once. The events are streamed
+// without the limit that CheckLimits set on the filters, as a count has none,
+// and only their ids are kept. Those of the filters for which privileged
+// returns true are only counted if the authed pubkey is party to them.
+func (a *A) countUnion(
+	c context.T, sto store.I, filters []*filter.F,
+	privileged func(f *filter.F) bool,
+) (count int, err error) {
+	authed := a.Listener.AuthedPubkey()
+	found := make(map[string]struct{})
+	for _, f := range filters {
+		ff := *f
+		ff.Limit = nil
+		check := privileged(f)
+		if err = sto.StreamEvents(
+			c, &ff, func(ev *event.E) bool {
+				if !check || auth.CheckPrivilege(authed, ev) {
+					found[string(ev.ID)] = struct{}{}
+				}
+				return true
+			},
+		); err != nil {
+			return
+		}
+	}
+	count = len(found)
+	return
+}
+
+// countErr closes a COUNT with the error of the store, as a partial sum
+// would be reported to the client as if it was the count of the filters.
+func (a *A) countErr(id *subscription.Id, err error) {
+	log.E.F("count failed: %v", err)
+	if err = closedenvelope.NewFrom(
+		id, reason.Error.F(err.Error()),
+	).Write(a.Listener); chk.E(err) {
+		return
+	}
+}
+
+// mayBePrivileged returns true if a filter can match events of privileged
+// kinds, which is the case if it has no kinds or any of its kinds are
+// privileged.
+func mayBePrivileged(f *filter.F) bool {
+	return f.Kinds == nil || f.Kinds.Len() == 0 || f.Kinds.IsPrivileged()
+}
@@ -0,0 +1,87 @@
+package socketapi
+
+import (
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCountUnion(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("pubkey"))
+	other := sha256.Sum256([]byte("other"))
+	// notes and direct messages of an author, and a direct message of another
+	// author to someone else.
+	const notes, dms = 30, 5
+	for i := range notes + dms + 1 {
+		ev := event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(i)))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - int64(i))
+		ev.Kind = kind.TextNote
+		ev.Tags = tags.New()
+		if i >= notes {
+			ev.Kind = kind.EncryptedDirectMessage
+		}
+		if i == notes+dms {
+			ev.Pubkey = other[:]
+		}
+		if _, _, err = d.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the filters have the limit that CheckLimits sets, which is lower than
+	// the number of matches, and they overlap.
+	limit := uint(10)
+	all := &filter.F{Limit: &limit}
+	noteFilter := &filter.F{Kinds: kinds.New(kind.TextNote), Limit: &limit}
+	a := &A{Ctx: ctx, Listener: &ws.Listener{}}
+	never := func(f *filter.F) bool { return false }
+	var count int
+	if count, err = a.countUnion(
+		ctx, d, []*filter.F{all, noteFilter}, never,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if count != notes+dms+1 {
+		t.Fatalf("Expected %d events, got %d", notes+dms+1, count)
+	}
+	if count, err = a.countUnion(
+		ctx, d, []*filter.F{noteFilter, noteFilter}, never,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if count != notes {
+		t.Fatalf("Expected %d notes, got %d", notes, count)
+	}
+	// with auth required, the direct message of the other author is left
+	// out for the author of the other events.
+	a.Listener.SetAuthedPubkey(pk[:])
+	if count, err = a.countUnion(
+		ctx, d, []*filter.F{all}, mayBePrivileged,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if count != notes+dms {
+		t.Fatalf("Expected %d events, got %d", notes+dms, count)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"orly.dev/pkg/encoders/envelopes"
 	"orly.dev/pkg/encoders/envelopes/authenvelope"
 	"orly.dev/pkg/encoders/envelopes/closeenvelope"
+	"orly.dev/pkg/encoders/envelopes/countenvelope"
 	"orly.dev/pkg/encoders/envelopes/eventenvelope"
+	"orly.dev/pkg/encoders/envelopes/negentropyenvelope"
 	"orly.dev/pkg/encoders/envelopes/noticeenvelope"
 	"orly.dev/pkg/encoders/envelopes/reqenvelope"
 	"orly.dev/pkg/utils/chk"
@@ -40,10 +42,18 @@ func (a *A) HandleMessage(msg, authedPubkey []byte) {
 		notice = a.HandleEvent(a.Context(), rem, a.I)
 	case reqenvelope.L:
 		notice = a.HandleReq(a.Context(), rem, a.I)
+	case countenvelope.L:
+		notice = a.HandleCount(a.Context(), rem, a.I)
 	case closeenvelope.L:
 		notice = a.HandleClose(rem, a.I)
 	case authenvelope.L:
 		notice = a.HandleAuth(rem, a.I)
+	case negentropyenvelope.LOpen:
+		notice = a.HandleNegOpen(a.Context(), rem, a.I)
+	case negentropyenvelope.LMsg:
+		notice = a.HandleNegMsg(rem)
+	case negentropyenvelope.LClose:
+		notice = a.HandleNegClose(rem)
 	default:
 		notice = []byte(fmt.Sprintf("unknown envelope type %s\n%s", t, rem))
 	}
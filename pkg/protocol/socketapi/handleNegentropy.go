@@ -0,0 +1,352 @@
+package socketapi
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"orly.dev/pkg/encoders/envelopes/authenvelope"
+	"orly.dev/pkg/encoders/envelopes/negentropyenvelope"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/reason"
+	"orly.dev/pkg/encoders/subscription"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/interfaces/server"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/auth"
+	"orly.dev/pkg/protocol/negentropy"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/log"
+	"orly.dev/pkg/utils/normalize"
+	"sync"
+)
+
+// MaxNegentropyItems is the maximum number of events a negentropy sync can
+// reconcile, larger queries are refused so the client can split them into
+// smaller ranges.
+const MaxNegentropyItems = 1000000
+
+// errTooManyItems is the reason a negentropy sync of more than
+// MaxNegentropyItems events is refused.
+var errTooManyItems = errors.New("too many records, use a narrower filter")
+
+// negSession is the state of a negentropy sync of a socket.
+type negSession struct {
+	// the messages of a sync are processed in the order they arrive, but
+	// HandleMessage is run concurrently, so they are serialized by mx.
+	mx sync.Mutex
+	n  *negentropy.N
+}
+
+// HandleNegOpen processes a NIP-77 NEG-OPEN message, building the set of the
+// (created_at, id) of the events matching the filter of the request and
+// responding with the first NEG-MSG of the reconciliation.
+//
+// # Parameters
+//
+//   - c: a context object used for managing deadlines, cancellation signals,
+//     and other request-scoped values.
+//
+//   - req: a byte slice representing the raw request data to be processed.
+//
+//   - srv: An interface representing the server, providing access to storage
+//     and the filter acceptance policy.
+//
+// # Return Values
+//
+//   - r: a byte slice containing a notice or error message generated during
+//     processing.
+//
+// # Expected behaviour
+//
+// The method applies the same auth, rate limit, subscription limit and filter
+// acceptance rules as a REQ, and refuses the sync with a NEG-ERR if they fail.
+// The items are all the events the store keeps that match the filter, as
+// QueryForIds finds them, including deletion events and retained older
+// versions of replaceable events, which a REQ by id fetches, so that both
+// sides of a sync build their sets the same way. When auth is required and
+// the filter may match privileged kinds, the events are filtered by the
+// privilege of the authed pubkey.
+//
+// A NEG-OPEN with the subscription Id of a sync that is open replaces it.
+func (a *A) HandleNegOpen(c context.T, req []byte, srv server.I) (r []byte) {
+	var err error
+	var rem []byte
+	env := negentropyenvelope.NewOpen()
+	if rem, err = env.Unmarshal(req); chk.E(err) {
+		return normalize.Error.F(err.Error())
+	}
+	if len(rem) > 0 {
+		log.I.F("extra '%s'", rem)
+	}
+	id := env.Subscription.String()
+	a.closeNegSession(id)
+	if a.I.AuthRequired() && !a.Listener.IsAuthed() {
+		a.Listener.RequestAuth()
+		if err = authenvelope.NewChallengeWith(a.Listener.Challenge()).
+			Write(a.Listener); chk.E(err) {
+			return
+		}
+		if !a.I.PublicReadable() {
+			a.negErr(env.Subscription, reason.AuthRequired.F("auth enabled"))
+			return
+		}
+	}
+	// a sync counts as a subscription, and one with the id of an open sync
+	// replaces it, which has been closed.
+	if l := srv.Limits(); l.MaxSubscriptions > 0 {
+		if n, _ := a.subscriptions(srv, ""); n >= l.MaxSubscriptions {
+			a.negErr(
+				env.Subscription, reason.Blocked.F(
+					"too many subscriptions, maximum is %d",
+					l.MaxSubscriptions,
+				),
+			)
+			return
+		}
+	}
+	if !srv.AllowReq(a.Listener.RealRemote(), a.Listener.AuthedPubkey()) {
+		a.negErr(
+			env.Subscription,
+			reason.RateLimited.F("too many subscriptions, slow down"),
+		)
+		return
+	}
+	allowed, accept, _ := srv.AcceptReq(
+		c, a.Request, filters.New(env.Filter), a.Listener.AuthedPubkey(),
+		a.Listener.RealRemote(),
+	)
+	if !accept || allowed.Len() == 0 {
+		a.negErr(
+			env.Subscription,
+			reason.Blocked.F("filter isn't permitted for client"),
+		)
+		return
+	}
+	var v *negentropy.Vector
+	if v, err = a.negVector(c, allowed.F[0], srv); err != nil {
+		if errors.Is(err, badger.ErrDBClosed) {
+			return
+		}
+		if errors.Is(err, errTooManyItems) {
+			a.negErr(env.Subscription, reason.Blocked.F(err.Error()))
+		} else {
+			a.negErr(env.Subscription, reason.Error.F(err.Error()))
+		}
+		return
+	}
+	s := &negSession{}
+	if s.n, err = negentropy.New(
+		v, negFrameSizeLimit(srv.Limits().MaxMessageLength),
+	); chk.E(err) {
+		a.negErr(env.Subscription, reason.Error.F(err.Error()))
+		return
+	}
+	a.negentropyMx.Lock()
+	if a.negentropy == nil {
+		a.negentropy = make(map[string]*negSession)
+	}
+	a.negentropy[id] = s
+	a.negentropyMx.Unlock()
+	a.negReconcile(env.Subscription, s, env.Message)
+	return
+}
+
+// HandleNegMsg processes a NIP-77 NEG-MSG message of an open negentropy sync,
+// and responds with the next NEG-MSG of the reconciliation, or a NEG-ERR if
+// the sync is not open or the message is invalid.
+func (a *A) HandleNegMsg(req []byte) (r []byte) {
+	var err error
+	var rem []byte
+	env := negentropyenvelope.NewMsg()
+	if rem, err = env.Unmarshal(req); chk.E(err) {
+		return normalize.Error.F(err.Error())
+	}
+	if len(rem) > 0 {
+		log.I.F("extra '%s'", rem)
+	}
+	a.negentropyMx.Lock()
+	s, ok := a.negentropy[env.Subscription.String()]
+	a.negentropyMx.Unlock()
+	if !ok {
+		a.negErr(env.Subscription, []byte("closed: no open sync"))
+		return
+	}
+	a.negReconcile(env.Subscription, s, env.Message)
+	return
+}
+
+// HandleNegClose processes a NIP-77 NEG-CLOSE message, discarding the state of
+// the negentropy sync. No response is sent.
+func (a *A) HandleNegClose(req []byte) (r []byte) {
+	var err error
+	var rem []byte
+	env := negentropyenvelope.NewClose()
+	if rem, err = env.Unmarshal(req); chk.E(err) {
+		return normalize.Error.F(err.Error())
+	}
+	if len(rem) > 0 {
+		log.I.F("extra '%s'", rem)
+	}
+	a.closeNegSession(env.Subscription.String())
+	return
+}
+
+// negVector builds the sealed negentropy.Vector of the (created_at, id) of the
+// events matching a filter, which are read from the fpc index by QueryForIds,
+// as the websocket client does for its side of a sync. The items are
+// therefore all the events the store keeps, including the deletion events and
+// the retained older versions of replaceable events, so that they are
+// reconciled too, which a REQ by id fetches. A filter with ids has its events
+// fetched by them instead.
+//
+// The query is limited to one more than MaxNegentropyItems, or the limit of
+// the filter if it is lower, so that a filter that matches too many events is
+// refused.
+//
+// When auth is required and the filter may match privileged kinds, the events
+// of other authors than the authed pubkey are fetched to check the privilege
+// of the authed pubkey, in batches of negPrivilegeBatch.
+func (a *A) negVector(c context.T, f *filter.F, srv server.I) (
+	v *negentropy.Vector, err error,
+) {
+	sto := srv.Storage()
+	ff := *f
+	if ff.Limit == nil || *ff.Limit > MaxNegentropyItems {
+		limit := uint(MaxNegentropyItems + 1)
+		ff.Limit = &limit
+	}
+	f = &ff
+	var idPkTs []store.IdPkTs
+	if f.Ids != nil && f.Ids.Len() > 0 {
+		var evs event.S
+		if evs, err = sto.QueryEvents(c, f); err != nil {
+			return
+		}
+		for _, ev := range evs {
+			idPkTs = append(
+				idPkTs, store.IdPkTs{
+					Id: ev.ID, Pub: ev.Pubkey, Ts: ev.CreatedAt.I64(),
+				},
+			)
+		}
+	} else if idPkTs, err = sto.QueryForIds(c, f); err != nil {
+		return
+	}
+	if len(idPkTs) > MaxNegentropyItems {
+		err = errTooManyItems
+		return
+	}
+	if srv.AuthRequired() && mayBePrivileged(f) {
+		if idPkTs, err = a.negPrivileged(c, sto, idPkTs); err != nil {
+			return
+		}
+	}
+	v = negentropy.NewVector()
+	for _, idpk := range idPkTs {
+		if err = v.Insert(uint64(max(idpk.Ts, 0)), idpk.Id); chk.E(err) {
+			return
+		}
+	}
+	v.Seal()
+	return
+}
+
+// negPrivilegeBatch is the number of events negPrivileged fetches at a time.
+const negPrivilegeBatch = 500
+
+// negPrivileged returns the items of a sync that the authed pubkey is
+// privileged to fetch. The items of its own events are kept without fetching
+// them, and the events of the others are fetched by id to check them.
+func (a *A) negPrivileged(
+	c context.T, sto store.I, idPkTs []store.IdPkTs,
+) (allowed []store.IdPkTs, err error) {
+	authed := a.Listener.AuthedPubkey()
+	var others []store.IdPkTs
+	for _, idpk := range idPkTs {
+		if len(authed) > 0 && bytes.Equal(idpk.Pub, authed) {
+			allowed = append(allowed, idpk)
+			continue
+		}
+		others = append(others, idpk)
+	}
+	for len(others) > 0 {
+		batch := others[:min(len(others), negPrivilegeBatch)]
+		others = others[len(batch):]
+		ids := make([][]byte, len(batch))
+		for i, idpk := range batch {
+			ids[i] = idpk.Id
+		}
+		var evs event.S
+		if evs, err = sto.QueryEvents(
+			c, &filter.F{Ids: tag.New(ids...)},
+		); err != nil {
+			return
+		}
+		privileged := make(map[string]struct{}, len(evs))
+		for _, ev := range evs {
+			if auth.CheckPrivilege(authed, ev) {
+				privileged[string(ev.ID)] = struct{}{}
+			}
+		}
+		for _, idpk := range batch {
+			if _, ok := privileged[string(idpk.Id)]; ok {
+				allowed = append(allowed, idpk)
+			}
+		}
+	}
+	return
+}
+
+// negReconcile processes a message of a negentropy sync and writes the
+// response, or a NEG-ERR that closes the sync if it fails.
+func (a *A) negReconcile(id *subscription.Id, s *negSession, msg []byte) {
+	s.mx.Lock()
+	res, err := s.n.Reconcile(msg)
+	s.mx.Unlock()
+	if err != nil {
+		a.closeNegSession(id.String())
+		a.negErr(id, reason.Error.F(err.Error()))
+		return
+	}
+	if err = negentropyenvelope.NewMsgWith(id, res).
+		Write(a.Listener); chk.E(err) {
+		return
+	}
+}
+
+// negErr writes a NEG-ERR for a subscription.
+func (a *A) negErr(id *subscription.Id, msg []byte) {
+	if err := negentropyenvelope.NewErrWith(id, msg).
+		Write(a.Listener); chk.E(err) {
+		return
+	}
+}
+
+// closeNegSession discards a negentropy sync, if one is open.
+func (a *A) closeNegSession(id string) {
+	a.negentropyMx.Lock()
+	delete(a.negentropy, id)
+	a.negentropyMx.Unlock()
+}
+
+// closeNegSessions discards all the negentropy syncs of the socket.
+func (a *A) closeNegSessions() {
+	a.negentropyMx.Lock()
+	a.negentropy = nil
+	a.negentropyMx.Unlock()
+}
+
+// negFrameSizeLimit returns the limit of the size of negentropy messages, such
+// that their hex encoding in an envelope fits in a message of the maximum
+// length, or 0 for no limit.
+func negFrameSizeLimit(maxMessageLength int) (limit int) {
+	if maxMessageLength <= 0 {
+		return
+	}
+	// leave room for the envelope and subscription id.
+	limit = max((maxMessageLength-256)/2, 4096)
+	return
+}
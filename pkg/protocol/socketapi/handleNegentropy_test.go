@@ -0,0 +1,89 @@
+package socketapi
+
+import (
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/database"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/hex"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNegVector(t *testing.T) {
+	ctx, cancel := context.Cancel(context.Bg())
+	defer cancel()
+	d, err := database.New(ctx, cancel, t.TempDir(), "error")
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer d.Close()
+	d.SetReplacePolicy(database.ReplacePolicy{History: true})
+	now := time.Now().Unix()
+	pk := sha256.Sum256([]byte("pubkey"))
+	other := sha256.Sum256([]byte("other"))
+	// three versions of a profile, a note, a deletion event and a direct
+	// message of another author to someone else, all of which are kept by the
+	// store.
+	ks := []*kind.T{
+		kind.ProfileMetadata, kind.ProfileMetadata, kind.ProfileMetadata,
+		kind.TextNote, kind.Deletion, kind.EncryptedDirectMessage,
+	}
+	for i, k := range ks {
+		ev := event.New()
+		id := sha256.Sum256([]byte(strconv.Itoa(i)))
+		ev.ID = id[:]
+		ev.Pubkey = pk[:]
+		ev.Sig = make([]byte, 64)
+		ev.CreatedAt = timestamp.FromUnix(now - int64(len(ks)-i))
+		ev.Kind = k
+		ev.Tags = tags.New()
+		if k.Equal(kind.Deletion) {
+			deleted := sha256.Sum256([]byte("deleted"))
+			ev.Tags = tags.New(
+				tag.New([]byte("e"), hex.EncAppend(nil, deleted[:])),
+			)
+		}
+		if k.Equal(kind.EncryptedDirectMessage) {
+			ev.Pubkey = other[:]
+		}
+		if _, _, err = d.SaveEvent(ctx, ev, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the items are those of QueryForIds, as the client builds its set, which
+	// includes the deletion event and the older versions.
+	a := &A{Ctx: ctx, Listener: &ws.Listener{}}
+	v, err := a.negVector(ctx, filter.New(), testServer{sto: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idPkTs, err := d.QueryForIds(ctx, filter.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Size() != len(ks) || len(idPkTs) != len(ks) {
+		t.Fatalf(
+			"Expected %d items, got %d and %d from QueryForIds", len(ks),
+			v.Size(), len(idPkTs),
+		)
+	}
+	// with auth required, the direct message is left out for the author of
+	// the other events.
+	a.Listener.SetAuthedPubkey(pk[:])
+	if v, err = a.negVector(
+		ctx, filter.New(), testServer{sto: d, auth: true},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if v.Size() != len(ks)-1 {
+		t.Fatalf("Expected %d items, got %d", len(ks)-1, v.Size())
+	}
+}
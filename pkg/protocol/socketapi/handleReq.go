@@ -43,8 +43,9 @@ import (
 // # Expected behaviour
 //
 // The method parses and validates the incoming request envelope, querying
-// events from the server storage based on filters provided. It sends results
-// through the associated subscription or writes error messages to the listener.
+// events from the server storage based on filters provided. Results are
+// written to the listener as they are fetched, and the query stops early if the
+// subscription is closed. Error messages are also written to the listener.
 // If the subscription should be cancelled due to completed query results, it
 // generates and sends a closure envelope.
 func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
@@ -102,6 +103,23 @@ func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
 			return
 		}
 	}
+	if msg := a.CheckLimits(env, srv); msg != nil {
+		if err = closedenvelope.NewFrom(
+			env.Subscription, msg,
+		).Write(a.Listener); chk.E(err) {
+			return
+		}
+		return
+	}
+	if !srv.AllowReq(a.Listener.RealRemote(), a.Listener.AuthedPubkey()) {
+		if err = closedenvelope.NewFrom(
+			env.Subscription,
+			reason.RateLimited.F("too many subscriptions, slow down"),
+		).Write(a.Listener); chk.E(err) {
+			return
+		}
+		return
+	}
 	var accept bool
 	allowed, accept, _ := srv.AcceptReq(
 		c, a.Request, env.Filters, a.Listener.AuthedPubkey(),
@@ -115,49 +133,55 @@ func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
 		}
 		return
 	}
-	var events event.S
-	for _, f := range allowed.F {
-		// var i uint
+	// the query is cancelled if the subscription is closed or replaced while
+	// the stored events are still being sent.
+	c, done := a.startQuery(c, env.Subscription.String())
+	defer done()
+	// the number of events sent for each filter, to tell if its limit was
+	// reached.
+	sent := make([]int, len(allowed.F))
+	for i, f := range allowed.F {
 		if pointers.Present(f.Limit) {
 			if *f.Limit == 0 {
 				continue
 			}
 		}
-		if events, err = sto.QueryEvents(c, f); err != nil {
-			if errors.Is(err, badger.ErrDBClosed) {
-				return
-			}
-			continue
-		}
-		// filter events the authed pubkey is not privileged to fetch.
-		if srv.AuthRequired() {
-			var tmp event.S
-			for _, ev := range events {
-				if !auth.CheckPrivilege(a.Listener.AuthedPubkey(), ev) {
+		// write out the events to the socket as they are fetched.
+		var werr error
+		if err = sto.StreamEvents(
+			c, f, func(ev *event.E) (more bool) {
+				// skip events the authed pubkey is not privileged to fetch.
+				if srv.AuthRequired() &&
+					!auth.CheckPrivilege(a.Listener.AuthedPubkey(), ev) {
 					log.W.F(
 						"not privileged: client pubkey '%0x' event "+
 							"pubkey '%0x' kind %s privileged: %v",
 						a.Listener.AuthedPubkey(), ev.Pubkey, ev.Kind.Name(),
 						ev.Kind.IsPrivileged(),
 					)
-					continue
+					return true
 				}
-				tmp = append(tmp, ev)
-			}
-			events = tmp
-		}
-		// write out the events to the socket
-		for _, ev := range events {
-			var res *eventenvelope.Result
-			if res, err = eventenvelope.NewResultWith(
-				env.Subscription.T,
-				ev,
-			); chk.E(err) {
-				return
-			}
-			if err = res.Write(a.Listener); chk.E(err) {
+				var res *eventenvelope.Result
+				if res, werr = eventenvelope.NewResultWith(
+					env.Subscription.T,
+					ev,
+				); chk.E(werr) {
+					return false
+				}
+				if werr = res.Write(a.Listener); chk.E(werr) {
+					return false
+				}
+				sent[i]++
+				return true
+			},
+		); err != nil {
+			if errors.Is(err, badger.ErrDBClosed) || c.Err() != nil {
 				return
 			}
+			continue
+		}
+		if werr != nil {
+			return
 		}
 	}
 	if err = eoseenvelope.NewFrom(env.Subscription).
@@ -168,14 +192,14 @@ func (a *A) HandleReq(c context.T, req []byte, srv server.I) (r []byte) {
 	cancel := true
 	// if the query was for just Ids, we know there can't be any more results,
 	// so cancel the subscription.
-	for _, f := range allowed.F {
+	for i, f := range allowed.F {
 		if f.Ids.Len() < 1 {
 			cancel = false
 			break
 		}
 		// also, if we received the limit number of events, subscription ded
 		if pointers.Present(f.Limit) {
-			if len(events) < int(*f.Limit) {
+			if sent[i] < int(*f.Limit) {
 				cancel = false
 			}
 		}
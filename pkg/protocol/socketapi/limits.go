@@ -0,0 +1,74 @@
+package socketapi
+
+import (
+	"orly.dev/pkg/encoders/envelopes/reqenvelope"
+	"orly.dev/pkg/encoders/reason"
+	"orly.dev/pkg/interfaces/server"
+)
+
+// CheckLimits checks a REQ against the NIP-11 limits of the relay.
+//
+// # Parameters
+//
+//   - env: the REQ envelope, the limits of its filters are reduced to the
+//     MaxLimit of the relay if they are larger or not set.
+//
+//   - srv: the server, which provides the limits and the publisher that holds
+//     the open subscriptions.
+//
+// # Return Values
+//
+//   - msg: if the REQ exceeds a limit, the reason to send in a CLOSED
+//     envelope, otherwise nil.
+func (a *A) CheckLimits(env *reqenvelope.T, srv server.I) (msg []byte) {
+	l := srv.Limits()
+	id := env.Subscription.String()
+	if l.MaxSubidLength > 0 && len(id) > l.MaxSubidLength {
+		return reason.Invalid.F(
+			"subscription id is longer than %d characters", l.MaxSubidLength,
+		)
+	}
+	if l.MaxFilters > 0 && len(env.Filters.F) > l.MaxFilters {
+		return reason.Invalid.F(
+			"%d filters, maximum is %d", len(env.Filters.F), l.MaxFilters,
+		)
+	}
+	if l.MaxSubscriptions > 0 {
+		// a REQ with the id of an open subscription replaces it.
+		if n, open := a.subscriptions(
+			srv, id,
+		); !open && n >= l.MaxSubscriptions {
+			return reason.Blocked.F(
+				"too many subscriptions, maximum is %d",
+				l.MaxSubscriptions,
+			)
+		}
+	}
+	if l.MaxLimit > 0 {
+		for _, f := range env.Filters.F {
+			if f.Limit == nil || *f.Limit > uint(l.MaxLimit) {
+				limit := uint(l.MaxLimit)
+				f.Limit = &limit
+			}
+		}
+	}
+	return
+}
+
+// subscriptions returns the number of open subscriptions and negentropy syncs
+// of the socket, which share the MaxSubscriptions limit, and whether one of
+// the subscriptions has the given id.
+func (a *A) subscriptions(srv server.I, id string) (n int, open bool) {
+	for _, p := range srv.Publisher().Publishers {
+		s, ok := p.(*S)
+		if !ok {
+			continue
+		}
+		subs, o := s.Subscriptions(a.Listener, id)
+		n, open = n+subs, open || o
+	}
+	a.negentropyMx.Lock()
+	n += len(a.negentropy)
+	a.negentropyMx.Unlock()
+	return
+}
@@ -1,13 +1,17 @@
 package socketapi
 
 import (
+	"orly.dev/pkg/encoders/envelopes/closedenvelope"
 	"orly.dev/pkg/encoders/envelopes/eventenvelope"
 	"orly.dev/pkg/encoders/event"
 	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/reason"
+	"orly.dev/pkg/encoders/subscription"
 	"orly.dev/pkg/interfaces/publisher"
 	"orly.dev/pkg/interfaces/server"
 	"orly.dev/pkg/interfaces/typer"
 	"orly.dev/pkg/protocol/auth"
+	"orly.dev/pkg/protocol/subindex"
 	"orly.dev/pkg/protocol/ws"
 	"orly.dev/pkg/utils/chk"
 	"orly.dev/pkg/utils/log"
@@ -16,10 +20,6 @@ import (
 
 const Type = "socketapi"
 
-// Map is a map of filters associated with a collection of ws.Listener
-// connections.
-type Map map[*ws.Listener]map[string]*filters.T
-
 type W struct {
 	*ws.Listener
 
@@ -47,21 +47,50 @@ type Close struct {
 	Id string
 }
 
+// sub identifies a subscription of a websocket listener in the index.
+type sub struct {
+	*ws.Listener
+	id string
+}
+
+// listener is the subscriptions of a websocket listener and its send queue,
+// which have their own lock, so that delivering to a listener neither waits
+// for nor holds up the other listeners.
+type listener struct {
+	sync.Mutex
+	subs  map[string]*filters.T
+	queue *queue
+}
+
 // S is a structure that manages subscriptions and associated filters for
 // websocket listeners. It uses a mutex to synchronize access to a map storing
 // subscriber connections and their filter configurations.
+//
+// The filters are also kept in an inverted index, so that delivering an event
+// only matches it against the subscriptions that can match it, and events are
+// written to each listener through a bounded queue, so a slow listener doesn't
+// hold up delivery to the others. The queue of a listener is closed when its
+// last subscription is.
 type S struct {
-	// Mx is the mutex for the Map.
+	// Mx is the mutex for the map of listeners.
 	Mx sync.Mutex
-	// Map is the map of subscribers and subscriptions from the websocket api.
-	Map
+	// listeners are the websocket listeners with subscriptions.
+	listeners map[*ws.Listener]*listener
 	// Server is an interface to the server.
 	Server server.I
+	// index is the inverted index of the subscription filters.
+	index *subindex.Index[sub]
 }
 
 var _ publisher.I = &S{}
 
-func New(s server.I) (publisher *S) { return &S{Map: make(Map), Server: s} }
+func New(s server.I) (publisher *S) {
+	return &S{
+		listeners: make(map[*ws.Listener]*listener),
+		Server:    s,
+		index:     subindex.New[sub](),
+	}
+}
 
 func (p *S) Type() (typeName string) { return Type }
 
@@ -79,7 +108,8 @@ func (p *S) Type() (typeName string) { return Type }
 //
 // - If Cancel is true, removes a subscriber by ID or the entire listener.
 //
-// - Otherwise, adds the subscription to the map under a mutex lock.
+// - Otherwise, adds the subscription to the listener and the index under a
+// mutex lock, and creates the listener and its send queue if it has none.
 //
 // - Logs actions related to subscription creation or removal.
 func (p *S) Receive(msg typer.T) {
@@ -99,16 +129,24 @@ func (p *S) Receive(msg typer.T) {
 		}
 		p.Mx.Lock()
 		defer p.Mx.Unlock()
-		if subs, ok := p.Map[m.Listener]; !ok {
-			subs = make(map[string]*filters.T)
-			subs[m.Id] = m.Filters
-			p.Map[m.Listener] = subs
+		l, ok := p.listeners[m.Listener]
+		if !ok {
+			l = &listener{
+				subs:  make(map[string]*filters.T),
+				queue: newQueue(m.Listener, DefaultSendQueueSize),
+			}
+			p.listeners[m.Listener] = l
+		}
+		l.Lock()
+		defer l.Unlock()
+		l.subs[m.Id] = m.Filters
+		p.index.Add(sub{m.Listener, m.Id}, m.Filters.F...)
+		if !ok {
 			log.T.F(
 				"created new subscription for %s, %s", m.Listener.RealRemote(),
 				m.Filters.Marshal(nil),
 			)
 		} else {
-			subs[m.Id] = m.Filters
 			log.T.F(
 				"added subscription %s for %s", m.Id, m.Listener.RealRemote(),
 			)
@@ -124,73 +162,138 @@ func (p *S) Receive(msg typer.T) {
 //
 // # Expected behaviour
 //
-// Delivers the event to all subscribers whose filters match the event. It
-// applies authentication checks if required by the server, and skips delivery
-// for unauthenticated users when events are privileged.
+// Delivers the event to all subscribers whose filters match the event, found
+// with the index of the subscriptions. It applies authentication checks if
+// required by the server, and skips delivery for unauthenticated users when
+// events are privileged. The events are added to the send queue of each
+// listener, and the subscriptions of a listener whose queue is full are
+// closed.
 func (p *S) Deliver(ev *event.E) {
-	var err error
-	p.Mx.Lock()
-	defer p.Mx.Unlock()
+	matches := p.index.Match(ev)
 	log.T.F(
-		"delivering event %0x to websocket subscribers %d", ev.ID, len(p.Map),
+		"delivering event %0x to %d websocket subscribers", ev.ID,
+		len(matches),
 	)
-	for w, subs := range p.Map {
-		log.I.F("%v %s", subs, w.RealRemote())
-		for id, subscriber := range subs {
-			log.T.F(
-				"subscriber %s\n%s", w.RealRemote(),
-				subscriber.Marshal(nil),
-			)
-			if !subscriber.Match(ev) {
-				log.I.F(
-					"subscriber %s filter %s not match", id,
-					subscriber.Marshal(nil),
+	for _, m := range matches {
+		if p.Server.AuthRequired() {
+			if !auth.CheckPrivilege(m.AuthedPubkey(), ev) {
+				log.W.F(
+					"not privileged %0x ev pubkey %0x ev pubkey %0x kind %s privileged: %v",
+					m.AuthedPubkey(), ev.Pubkey,
+					m.AuthedPubkey(), ev.Kind.Name(),
+					ev.Kind.IsPrivileged(),
 				)
 				continue
 			}
-			if p.Server.AuthRequired() {
-				if !auth.CheckPrivilege(w.AuthedPubkey(), ev) {
-					log.W.F(
-						"not privileged %0x ev pubkey %0x ev pubkey %0x kind %s privileged: %v",
-						w.AuthedPubkey(), ev.Pubkey,
-						w.AuthedPubkey(), ev.Kind.Name(),
-						ev.Kind.IsPrivileged(),
-					)
-					continue
-				}
-				var res *eventenvelope.Result
-				if res, err = eventenvelope.NewResultWith(id, ev); chk.E(err) {
-					continue
-				}
-				if err = res.Write(w); chk.E(err) {
-					continue
-				}
-				log.T.F("dispatched event %0x to subscription %s", ev.ID, id)
-			}
+		}
+		p.Mx.Lock()
+		l, ok := p.listeners[m.Listener]
+		p.Mx.Unlock()
+		if ok {
+			p.deliver(l, m, ev)
 		}
 	}
 }
 
+// deliver adds an event to the send queue of a listener for one of its
+// subscriptions, under the lock of the listener, if the subscription is still
+// open, as it may have been closed since it was matched.
+//
+// If the queue is full the client isn't reading the events as fast as they
+// are delivered, and would miss some without knowing it, so the subscription
+// is closed with a CLOSED, and the client can open it again from where it
+// left off.
+func (p *S) deliver(l *listener, m sub, ev *event.E) {
+	if !p.enqueue(l, m, ev) {
+		return
+	}
+	log.W.F(
+		"closing subscription %s for %s: send queue full", m.id,
+		m.RealRemote(),
+	)
+	p.removeSubscriberId(m.Listener, m.id)
+	// the queue is full, so the CLOSED is written without waiting for it.
+	go func() {
+		if err := closedenvelope.NewFrom(
+			subscription.MustNew(m.id), reason.Error.F("slow consumer"),
+		).Write(m.Listener); chk.E(err) {
+			return
+		}
+	}()
+}
+
+// enqueue adds an event to the send queue of a listener for one of its
+// subscriptions, if it is still open and matches, and returns true if the
+// queue was full.
+func (p *S) enqueue(l *listener, m sub, ev *event.E) (full bool) {
+	l.Lock()
+	defer l.Unlock()
+	if ff, open := l.subs[m.id]; !open || !ff.Match(ev) {
+		// the subscription was closed, or replaced by one with other filters.
+		return
+	}
+	res, err := eventenvelope.NewResultWith(m.id, ev)
+	if chk.E(err) {
+		return
+	}
+	if !l.queue.send(res.Marshal(nil)) {
+		// the subscription is closed before the lock is released, so no more
+		// events are queued for it.
+		delete(l.subs, m.id)
+		return true
+	}
+	log.T.F("dispatched event %0x to subscription %s", ev.ID, m.id)
+	return
+}
+
 // removeSubscriberId removes a specific subscription from a subscriber
-// websocket.
+// websocket, and the listener, closing its send queue, if it was the last.
 func (p *S) removeSubscriberId(ws *ws.Listener, id string) {
 	p.Mx.Lock()
-	var subs map[string]*filters.T
-	var ok bool
-	if subs, ok = p.Map[ws]; ok {
-		delete(p.Map[ws], id)
-		_ = subs
-		if len(subs) == 0 {
-			delete(p.Map, ws)
-		}
+	defer p.Mx.Unlock()
+	p.index.Remove(sub{ws, id})
+	l, ok := p.listeners[ws]
+	if !ok {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	delete(l.subs, id)
+	if len(l.subs) == 0 {
+		l.queue.close()
+		delete(p.listeners, ws)
 	}
-	p.Mx.Unlock()
 }
 
 // removeSubscriber removes a websocket from the S collection.
 func (p *S) removeSubscriber(ws *ws.Listener) {
 	p.Mx.Lock()
-	clear(p.Map[ws])
-	delete(p.Map, ws)
+	defer p.Mx.Unlock()
+	l, ok := p.listeners[ws]
+	if !ok {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	for id := range l.subs {
+		p.index.Remove(sub{ws, id})
+	}
+	clear(l.subs)
+	l.queue.close()
+	delete(p.listeners, ws)
+}
+
+// Subscriptions returns the number of open subscriptions of a websocket, and
+// whether one of them has the given id.
+func (p *S) Subscriptions(ws *ws.Listener, id string) (n int, open bool) {
+	p.Mx.Lock()
+	l, ok := p.listeners[ws]
 	p.Mx.Unlock()
+	if !ok {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	_, open = l.subs[id]
+	return len(l.subs), open
 }
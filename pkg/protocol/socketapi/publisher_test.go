@@ -0,0 +1,99 @@
+package socketapi
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"orly.dev/pkg/crypto/sha256"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/filters"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tags"
+	"orly.dev/pkg/encoders/timestamp"
+	"orly.dev/pkg/interfaces/server"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/ws"
+	"orly.dev/pkg/utils/qu"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// testServer is a server.I that doesn't require auth, with a store.
+type testServer struct {
+	server.I
+	sto  store.I
+	auth bool
+}
+
+func (s testServer) AuthRequired() bool { return s.auth }
+
+func (s testServer) Storage() store.I { return s.sto }
+
+func TestDeliverSlowConsumer(t *testing.T) {
+	listeners := make(chan *ws.Listener, 1)
+	srv := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				listeners <- ws.NewListener(conn, r, false)
+			},
+		),
+	)
+	defer srv.Close()
+	client, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http"), nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	l := <-listeners
+	p := New(testServer{})
+	p.Receive(
+		&W{
+			Listener: l, Id: "slow",
+			Filters: filters.New(&filter.F{Kinds: kinds.New(kind.TextNote)}),
+		},
+	)
+	// nothing writes the messages of the queue, so it is full after one.
+	p.Mx.Lock()
+	p.listeners[l].queue.close()
+	p.listeners[l].queue = &queue{messages: make(chan []byte, 1), quit: qu.T()}
+	p.Mx.Unlock()
+	ev := event.New()
+	id := sha256.Sum256([]byte("event"))
+	pk := sha256.Sum256([]byte("pubkey"))
+	ev.ID, ev.Pubkey, ev.Sig = id[:], pk[:], make([]byte, 64)
+	ev.CreatedAt = timestamp.FromUnix(time.Now().Unix())
+	ev.Kind = kind.TextNote
+	ev.Tags = tags.New()
+	p.Deliver(ev)
+	if n, _ := p.Subscriptions(l, "slow"); n != 1 {
+		t.Fatal("the subscription was closed before the queue was full")
+	}
+	p.Deliver(ev)
+	if n, _ := p.Subscriptions(l, "slow"); n != 0 {
+		t.Fatal("the subscription of a full queue was not closed")
+	}
+	// the client is told the subscription was closed.
+	if err = client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	var msg []byte
+	if _, msg, err = client.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(msg, []byte(`["CLOSED","slow",`)) ||
+		!bytes.Contains(msg, []byte("error: slow consumer")) {
+		t.Fatalf("expected a CLOSED for a slow consumer, got %s", msg)
+	}
+}
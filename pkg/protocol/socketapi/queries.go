@@ -0,0 +1,57 @@
+package socketapi
+
+import (
+	"orly.dev/pkg/utils/context"
+)
+
+// query is a running query of a subscription.
+type query struct{ cancel context.F }
+
+// startQuery registers a query for a subscription and returns a context that
+// is cancelled when the subscription is closed, replaced by another REQ with
+// the same id, or when the socket is closed.
+//
+// The returned done function must be called when the query is finished.
+func (a *A) startQuery(c context.T, id string) (qc context.T, done func()) {
+	q := &query{}
+	qc, q.cancel = context.Cancel(c)
+	a.queriesMx.Lock()
+	if a.queries == nil {
+		a.queries = make(map[string]*query)
+	}
+	if prev, ok := a.queries[id]; ok {
+		prev.cancel()
+	}
+	a.queries[id] = q
+	a.queriesMx.Unlock()
+	done = func() {
+		q.cancel()
+		a.queriesMx.Lock()
+		// a REQ with the same id may have replaced this one.
+		if a.queries[id] == q {
+			delete(a.queries, id)
+		}
+		a.queriesMx.Unlock()
+	}
+	return
+}
+
+// stopQuery cancels the query of a subscription, if one is running.
+func (a *A) stopQuery(id string) {
+	a.queriesMx.Lock()
+	if q, ok := a.queries[id]; ok {
+		q.cancel()
+		delete(a.queries, id)
+	}
+	a.queriesMx.Unlock()
+}
+
+// stopQueries cancels all the running queries of the socket.
+func (a *A) stopQueries() {
+	a.queriesMx.Lock()
+	for id, q := range a.queries {
+		q.cancel()
+		delete(a.queries, id)
+	}
+	a.queriesMx.Unlock()
+}
@@ -0,0 +1,55 @@
+package socketapi
+
+import (
+	"io"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/qu"
+)
+
+// DefaultSendQueueSize is the number of messages that can wait to be written
+// to a websocket before the subscriptions that further events are delivered
+// to are closed.
+const DefaultSendQueueSize = 256
+
+// queue is a bounded queue of messages that are written to a websocket by its
+// own goroutine, so that a slow client doesn't hold up delivery to the others.
+type queue struct {
+	messages chan []byte
+	quit     qu.C
+}
+
+// newQueue creates a queue and starts the goroutine that writes its messages.
+func newQueue(w io.Writer, size int) (q *queue) {
+	q = &queue{messages: make(chan []byte, size), quit: qu.T()}
+	go func() {
+		for {
+			select {
+			case <-q.quit:
+				return
+			case b := <-q.messages:
+				if _, err := w.Write(b); chk.E(err) {
+					continue
+				}
+			}
+		}
+	}()
+	return
+}
+
+// send adds a message to the queue, and returns false if the queue is full or
+// closed.
+func (q *queue) send(b []byte) (ok bool) {
+	if q.quit.IsClosed() {
+		return
+	}
+	select {
+	case q.messages <- b:
+		return true
+	default:
+		return
+	}
+}
+
+// close stops the goroutine of the queue, the messages still waiting are
+// dropped.
+func (q *queue) close() { q.quit.Q() }
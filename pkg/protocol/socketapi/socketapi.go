@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"orly.dev/pkg/app/relay/helpers"
 	"orly.dev/pkg/encoders/envelopes/authenvelope"
+	"orly.dev/pkg/encoders/envelopes/noticeenvelope"
+	"orly.dev/pkg/encoders/reason"
 	"orly.dev/pkg/interfaces/server"
 	"orly.dev/pkg/protocol/ws"
 	"orly.dev/pkg/utils/chk"
@@ -12,6 +14,7 @@ import (
 	"orly.dev/pkg/utils/log"
 	"orly.dev/pkg/utils/units"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fasthttp/websocket"
@@ -32,6 +35,13 @@ type A struct {
 	Ctx context.T
 	*ws.Listener
 	server.I
+	// queries are the running queries of the REQs of the socket, by
+	// subscription id.
+	queries   map[string]*query
+	queriesMx sync.Mutex
+	// negentropy are the open NIP-77 syncs of the socket, by subscription id.
+	negentropy   map[string]*negSession
+	negentropyMx sync.Mutex
 }
 
 // Serve handles an incoming WebSocket request by upgrading the HTTP request,
@@ -96,6 +106,8 @@ func (a *A) Serve(w http.ResponseWriter, r *http.Request, s server.I) {
 	a.Listener = ws.NewListener(conn, r, a.I.AuthRequired())
 	defer func() {
 		cancel()
+		a.stopQueries()
+		a.closeNegSessions()
 		ticker.Stop()
 		a.Publisher().Receive(
 			&W{
@@ -105,7 +117,11 @@ func (a *A) Serve(w http.ResponseWriter, r *http.Request, s server.I) {
 		)
 		chk.E(a.Listener.Conn.Close())
 	}()
-	conn.SetReadLimit(DefaultMaxMessageSize)
+	if c.MaxMessageLength > 0 {
+		conn.SetReadLimit(int64(c.MaxMessageLength))
+	} else {
+		conn.SetReadLimit(DefaultMaxMessageSize)
+	}
 	chk.E(conn.SetReadDeadline(time.Now().Add(DefaultPongWait)))
 	conn.SetPongHandler(
 		func(string) error {
@@ -162,6 +178,16 @@ func (a *A) Serve(w http.ResponseWriter, r *http.Request, s server.I) {
 			}
 			continue
 		}
+		if !s.AllowBytes(
+			a.Listener.RealRemote(), a.Listener.AuthedPubkey(), len(message),
+		) {
+			if err = noticeenvelope.NewFrom(
+				reason.RateLimited.F("too much data, message dropped"),
+			).Write(a.Listener); chk.E(err) {
+				return
+			}
+			continue
+		}
 		go a.HandleMessage(message, a.Listener.AuthedPubkey())
 	}
 }
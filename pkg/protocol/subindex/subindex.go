@@ -0,0 +1,217 @@
+// Package subindex is an inverted index of the filters of live subscriptions,
+// so that a new event only has to be matched against the subscriptions that
+// can possibly match it, instead of all of them.
+package subindex
+
+import (
+	"encoding/binary"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"sync"
+)
+
+// prefixes of the keys of the index, followed by the value.
+const (
+	idKey     = 'i'
+	authorKey = 'a'
+	tagKey    = 't'
+	kindKey   = 'k'
+)
+
+// sub is the filters of one subscription and the keys of the index it is
+// found under.
+type sub struct {
+	filters []*filter.F
+	keys    []string
+	all     bool
+}
+
+// Index is an inverted index of subscriptions, identified by a comparable key
+// of type K, by the ids, authors, tag values and kinds in their filters.
+//
+// Each filter is indexed by the values of only one of its fields, in order of
+// preference ids, authors, tags and kinds, as an event must match all of the
+// fields of a filter to match it. Filters with none of these fields match any
+// event, and are always candidates.
+type Index[K comparable] struct {
+	mx    sync.RWMutex
+	subs  map[K]*sub
+	index map[string]map[K]struct{}
+	all   map[K]struct{}
+}
+
+// New creates a new empty Index.
+func New[K comparable]() (x *Index[K]) {
+	return &Index[K]{
+		subs:  make(map[K]*sub),
+		index: make(map[string]map[K]struct{}),
+		all:   make(map[K]struct{}),
+	}
+}
+
+// Add indexes the filters of a subscription, replacing the filters previously
+// added with the same key.
+func (x *Index[K]) Add(key K, ff ...*filter.F) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+	x.remove(key)
+	s := &sub{filters: ff}
+	seen := make(map[string]struct{})
+	for _, f := range ff {
+		keys := filterKeys(f)
+		if len(keys) == 0 {
+			s.all = true
+			continue
+		}
+		for _, k := range keys {
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			s.keys = append(s.keys, k)
+		}
+	}
+	for _, k := range s.keys {
+		if x.index[k] == nil {
+			x.index[k] = make(map[K]struct{})
+		}
+		x.index[k][key] = struct{}{}
+	}
+	if s.all {
+		x.all[key] = struct{}{}
+	}
+	x.subs[key] = s
+}
+
+// Remove deletes a subscription from the index.
+func (x *Index[K]) Remove(key K) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+	x.remove(key)
+}
+
+// RemoveFunc deletes all the subscriptions whose key fn returns true for.
+func (x *Index[K]) RemoveFunc(fn func(key K) bool) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+	for key := range x.subs {
+		if fn(key) {
+			x.remove(key)
+		}
+	}
+}
+
+func (x *Index[K]) remove(key K) {
+	s, ok := x.subs[key]
+	if !ok {
+		return
+	}
+	for _, k := range s.keys {
+		delete(x.index[k], key)
+		if len(x.index[k]) == 0 {
+			delete(x.index, k)
+		}
+	}
+	delete(x.all, key)
+	delete(x.subs, key)
+}
+
+// Len returns the number of subscriptions in the index.
+func (x *Index[K]) Len() (n int) {
+	x.mx.RLock()
+	defer x.mx.RUnlock()
+	return len(x.subs)
+}
+
+// Match returns the keys of the subscriptions that have a filter matching an
+// event.
+func (x *Index[K]) Match(ev *event.E) (keys []K) {
+	x.mx.RLock()
+	defer x.mx.RUnlock()
+	candidates := make(map[K]struct{})
+	for _, k := range eventKeys(ev) {
+		for key := range x.index[k] {
+			candidates[key] = struct{}{}
+		}
+	}
+	for key := range x.all {
+		candidates[key] = struct{}{}
+	}
+	for key := range candidates {
+		for _, f := range x.subs[key].filters {
+			if f.Matches(ev) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return
+}
+
+// filterKeys returns the keys a filter is indexed under, or nil if it has none
+// of the indexed fields.
+func filterKeys(f *filter.F) (keys []string) {
+	switch {
+	case f.Ids.Len() > 0:
+		for _, id := range f.Ids.ToSliceOfBytes() {
+			keys = append(keys, key(idKey, id))
+		}
+	case f.Authors.Len() > 0:
+		for _, pk := range f.Authors.ToSliceOfBytes() {
+			keys = append(keys, key(authorKey, pk))
+		}
+	case f.Tags.Len() > 0:
+		// an event can match the tags of a filter with any of the values of
+		// any of the tags, so the filter is indexed under all of them.
+		for _, t := range f.Tags.ToSliceOfTags() {
+			// the events are not indexed by the values of tag queries.
+			if t.Len() < 2 || filter.IsTagQuery(t) {
+				continue
+			}
+			k := t.FilterKey()
+			for _, v := range t.ToSliceOfBytes()[1:] {
+				keys = append(keys, tagValueKey(k, v))
+			}
+		}
+		if len(keys) > 0 {
+			break
+		}
+		fallthrough
+	case f.Kinds.Len() > 0:
+		for _, k := range f.Kinds.ToUint16() {
+			keys = append(keys, kindValueKey(k))
+		}
+	}
+	return
+}
+
+// eventKeys returns all the keys in the index that filters matching an event
+// can be found under.
+func eventKeys(ev *event.E) (keys []string) {
+	keys = append(
+		keys, key(idKey, ev.ID), key(authorKey, ev.Pubkey),
+		kindValueKey(ev.Kind.K),
+	)
+	for _, t := range ev.Tags.ToSliceOfTags() {
+		if t.Len() < 2 {
+			continue
+		}
+		keys = append(keys, tagValueKey(t.Key(), t.Value()))
+	}
+	return
+}
+
+func key(prefix byte, value []byte) string {
+	return string(append([]byte{prefix}, value...))
+}
+
+func kindValueKey(k uint16) string {
+	return key(kindKey, binary.BigEndian.AppendUint16(nil, k))
+}
+
+// tagValueKey prefixes the tag key with its length, so that the boundary
+// between the tag key and the value is unambiguous.
+func tagValueKey(k, v []byte) string {
+	b := append([]byte{tagKey, byte(len(k))}, k...)
+	return string(append(b, v...))
+}
@@ -0,0 +1,67 @@
+package subindex
+
+import (
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/kind"
+	"orly.dev/pkg/encoders/kinds"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/encoders/tags"
+	"slices"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	id := []byte("01234567890123456789012345678901")
+	pk := []byte("abcdefghijabcdefghijabcdefghijab")
+	ev := &event.E{
+		ID:     id,
+		Pubkey: pk,
+		Kind:   kind.New(1),
+		Tags:   tags.New(tag.New("e", "x")),
+	}
+	x := New[string]()
+	x.Add("ids", &filter.F{Ids: tag.New(id)})
+	x.Add(
+		"author",
+		&filter.F{Authors: tag.New(pk), Kinds: kinds.New(kind.New(1))},
+	)
+	x.Add(
+		"author-kind",
+		&filter.F{Authors: tag.New(pk), Kinds: kinds.New(kind.New(7))},
+	)
+	x.Add("tag", &filter.F{Tags: tags.New(tag.New("#e", "y", "x"))})
+	x.Add("other-tag", &filter.F{Tags: tags.New(tag.New("#p", "x"))})
+	x.Add("kind", &filter.F{Kinds: kinds.New(kind.New(7))})
+	x.Add("all", &filter.F{})
+	x.Add(
+		"second-filter", &filter.F{Kinds: kinds.New(kind.New(7))},
+		&filter.F{Kinds: kinds.New(kind.New(1))},
+	)
+	if x.Len() != 8 {
+		t.Fatalf("expected 8 subscriptions, got %d", x.Len())
+	}
+	check := func(want ...string) {
+		t.Helper()
+		got := x.Match(ev)
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Fatalf("expected matches %v, got %v", want, got)
+		}
+	}
+	check("ids", "author", "tag", "all", "second-filter")
+	x.Remove("tag")
+	check("ids", "author", "all", "second-filter")
+	// adding a subscription with the same key replaces its filters.
+	x.Add("ids", &filter.F{Kinds: kinds.New(kind.New(7))})
+	check("author", "all", "second-filter")
+	x.RemoveFunc(func(key string) bool { return key != "all" })
+	check("all")
+	if x.Len() != 1 || len(x.index) != 0 {
+		t.Fatalf(
+			"index not emptied, %d subscriptions %d keys", x.Len(),
+			len(x.index),
+		)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"orly.dev/pkg/encoders/envelopes/countenvelope"
 	"orly.dev/pkg/encoders/envelopes/eoseenvelope"
 	"orly.dev/pkg/encoders/envelopes/eventenvelope"
+	"orly.dev/pkg/encoders/envelopes/negentropyenvelope"
 	"orly.dev/pkg/encoders/envelopes/noticeenvelope"
 	"orly.dev/pkg/encoders/envelopes/okenvelope"
 	"orly.dev/pkg/encoders/event"
@@ -57,6 +58,8 @@ type Client struct {
 
 	okCallbacks *xsync.MapOf[string, func(bool, string)]
 
+	negentropy *xsync.MapOf[string, chan negResult] // NIP-77 syncs
+
 	writeQueue chan writeRequest
 
 	subscriptionChannelCloseQueue chan *Subscription
@@ -83,6 +86,7 @@ func NewRelay(c context.T, url string, opts ...RelayOption) *Client {
 		okCallbacks: xsync.NewMapOf[string, func(
 			bool, string,
 		)](),
+		negentropy:                    xsync.NewMapOf[string, chan negResult](),
 		writeQueue:                    make(chan writeRequest),
 		subscriptionChannelCloseQueue: make(chan *Subscription),
 		signatureChecker:              func(e *event.E) bool { ok, _ := e.Verify(); return ok },
@@ -334,6 +338,28 @@ func (r *Client) ConnectWithTLS(ctx context.T, tlsConfig *tls.Config) error {
 				if subscription, ok := r.Subscriptions.Load(env.ID.String()); ok && subscription.countResult != nil {
 					subscription.countResult <- env.Count
 				}
+			case negentropyenvelope.LMsg:
+				env := negentropyenvelope.NewMsg()
+				if env, message, err = negentropyenvelope.ParseMsg(message); chk.E(err) {
+					continue
+				}
+				if results, ok := r.negentropy.Load(env.Subscription.String()); ok {
+					select {
+					case results <- negResult{msg: env.Message}:
+					default:
+					}
+				}
+			case negentropyenvelope.LErr:
+				env := negentropyenvelope.NewErr()
+				if env, message, err = negentropyenvelope.ParseErr(message); chk.E(err) {
+					continue
+				}
+				if results, ok := r.negentropy.Load(env.Subscription.String()); ok {
+					select {
+					case results <- negResult{reason: string(env.Reason)}:
+					default:
+					}
+				}
 			case okenvelope.L:
 				env := okenvelope.New()
 				if env, message, err = okenvelope.Parse(message); chk.E(err) {
@@ -537,33 +563,38 @@ func (r *Client) QuerySync(
 	}
 }
 
-// TODO: count is a dumb idea anyway, and nothing is using this
-// func (r *Client) Count(c context.F, ff *filters.F, opts ...SubscriptionOption) (int, error) {
-// 	sub := r.PrepareSubscription(c, ff, opts...)
-// 	sub.countResult = make(chan int)
-//
-// 	if err := sub.Fire(); chk.F(err) {
-// 		return 0, err
-// 	}
-//
-// 	defer sub.Unsub()
+// Count sends a NIP-45 COUNT request with the given filters and waits for the
+// relay to respond with the number of matching events.
 //
-// 	if _, ok := c.Deadline(); !ok {
-// 		// if no timeout is set, force it to 7 seconds
-// 		var cancel context.F
-// 		c, cancel = context.Timeout(c, 7*time.Second)
-// 		defer cancel()
-// 	}
-//
-// 	for {
-// 		select {
-// 		case count := <-sub.countResult:
-// 			return count, nil
-// 		case <-c.Done():
-// 			return 0, c.Err()
-// 		}
-// 	}
-// }
+// If the relay closes the request, for example because auth is required, the
+// reason is returned as an error. If the context has no deadline, a timeout of
+// 7 seconds is applied.
+func (r *Client) Count(
+	c context.T, ff *filters.T, opts ...SubscriptionOption,
+) (count int, err error) {
+	sub := r.PrepareSubscription(c, ff, opts...)
+	// buffered so the read loop never blocks on a response that arrives after
+	// the wait has been abandoned.
+	sub.countResult = make(chan int, 1)
+	if err = sub.Fire(); chk.E(err) {
+		return
+	}
+	defer sub.Unsub()
+	if _, ok := c.Deadline(); !ok {
+		// if no timeout is set, force it to 7 seconds
+		var cancel context.F
+		c, cancel = context.Timeout(c, 7*time.Second)
+		defer cancel()
+	}
+	select {
+	case count = <-sub.countResult:
+	case reason := <-sub.ClosedReason:
+		err = errorf.E("count closed by relay: %s", reason)
+	case <-c.Done():
+		err = c.Err()
+	}
+	return
+}
 
 // Close shuts down a websocket client connection.
 func (r *Client) Close() error {
@@ -0,0 +1,221 @@
+package ws
+
+import (
+	"errors"
+	"orly.dev/pkg/encoders/envelopes/negentropyenvelope"
+	"orly.dev/pkg/encoders/event"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/encoders/subscription"
+	"orly.dev/pkg/encoders/tag"
+	"orly.dev/pkg/interfaces/store"
+	"orly.dev/pkg/protocol/negentropy"
+	"orly.dev/pkg/utils/chk"
+	"orly.dev/pkg/utils/context"
+	"orly.dev/pkg/utils/errorf"
+	"strings"
+	"time"
+)
+
+// NegentropyFrameSizeLimit is the limit of the size of the negentropy messages
+// sent by the client, which is well within the message size limits of relays
+// once hex encoded.
+const NegentropyFrameSizeLimit = 60000
+
+// SyncBatchSize is the number of events that are requested in one REQ when
+// pulling missing events in a Sync.
+const SyncBatchSize = 500
+
+// ErrSyncTooLarge is the error of a negentropy sync that the relay refused
+// because the filter matches too many events, which may succeed with a
+// narrower one.
+var ErrSyncTooLarge = errors.New("negentropy sync too large")
+
+// ErrSyncAuthRequired is the error of a negentropy sync that the relay refused
+// until the client authenticates, which may succeed after an Auth.
+var ErrSyncAuthRequired = errors.New("negentropy sync requires auth")
+
+// negResult is a response of a relay to a negentropy sync.
+type negResult struct {
+	msg    []byte
+	reason string
+}
+
+// Reconcile runs a NIP-77 negentropy sync with the relay of the events matching
+// a filter, and returns the ids of the events that are in the local vector but
+// not on the relay (have) and those that are on the relay but not in the
+// vector (need).
+//
+// The vector must hold the created_at and id of the local events that match
+// the filter. If the context has no deadline, each response of the relay is
+// waited for for up to 30 seconds.
+//
+// ErrSyncTooLarge is returned if the relay refuses the sync as the filter
+// matches too many events, and ErrSyncAuthRequired if it refuses it until the
+// client authenticates.
+func (r *Client) Reconcile(
+	c context.T, f *filter.F, v *negentropy.Vector,
+) (have, need [][]byte, err error) {
+	v.Seal()
+	var n *negentropy.N
+	if n, err = negentropy.New(v, NegentropyFrameSizeLimit); chk.E(err) {
+		return
+	}
+	var msg []byte
+	if msg, err = n.Initiate(); chk.E(err) {
+		return
+	}
+	id := subscription.NewStd()
+	// buffered so the read loop never blocks on a response that arrives after
+	// the wait has been abandoned.
+	results := make(chan negResult, 1)
+	r.negentropy.Store(id.String(), results)
+	defer r.negentropy.Delete(id.String())
+	if err = <-r.Write(
+		negentropyenvelope.NewOpenWith(id, f, msg).Marshal(nil),
+	); chk.E(err) {
+		return
+	}
+	var failed bool
+	defer func() {
+		// the relay has already discarded the sync if it sent an error.
+		if !failed && r.IsConnected() {
+			<-r.Write(negentropyenvelope.NewCloseWith(id).Marshal(nil))
+		}
+	}()
+	for {
+		var res negResult
+		wait, cancel := c, context.F(func() {})
+		if _, ok := c.Deadline(); !ok {
+			wait, cancel = context.Timeout(c, 30*time.Second)
+		}
+		select {
+		case res = <-results:
+		case <-wait.Done():
+			err = wait.Err()
+		case <-r.connectionContext.Done():
+			err = errorf.E("connection closed")
+		}
+		cancel()
+		if err != nil {
+			return
+		}
+		if res.reason != "" {
+			failed = true
+			if strings.Contains(res.reason, "too many") ||
+				strings.Contains(res.reason, "too big") {
+				err = errorf.E("%w: %s", ErrSyncTooLarge, res.reason)
+				return
+			}
+			if strings.HasPrefix(res.reason, "auth-required") {
+				err = errorf.E("%w: %s", ErrSyncAuthRequired, res.reason)
+				return
+			}
+			err = errorf.E("negentropy sync failed: %s", res.reason)
+			return
+		}
+		var h, nd [][]byte
+		if msg, h, nd, err = n.ReconcileWithIds(res.msg); chk.E(err) {
+			return
+		}
+		have = append(have, h...)
+		need = append(need, nd...)
+		if msg == nil {
+			return
+		}
+		if err = <-r.Write(
+			negentropyenvelope.NewMsgWith(id, msg).Marshal(nil),
+		); chk.E(err) {
+			return
+		}
+	}
+}
+
+// SyncStore is the local store of events that are synced with a relay.
+type SyncStore interface {
+	store.Querier
+	store.Querent
+	store.Saver
+}
+
+// Sync reconciles the events matching a filter in a local store with those of
+// the relay, then fetches the events that are missing locally and saves them in
+// the store (pull), and publishes the events that are missing on the relay to
+// it (push). Only the missing events are transferred.
+//
+// The local events are found with the fpc index by QueryForIds, so the filter
+// must not have ids. Events that the relay rejects are counted as not pushed
+// and don't stop the sync.
+func (r *Client) Sync(
+	c context.T, f *filter.F, local SyncStore, pull, push bool,
+) (pulled, pushed int, err error) {
+	if f.Ids != nil && f.Ids.Len() > 0 {
+		err = errorf.E("sync filter must not have ids")
+		return
+	}
+	var idPkTs []store.IdPkTs
+	if idPkTs, err = local.QueryForIds(c, f); chk.E(err) {
+		return
+	}
+	v := negentropy.NewVector()
+	for _, idpk := range idPkTs {
+		if err = v.Insert(uint64(max(idpk.Ts, 0)), idpk.Id); chk.E(err) {
+			return
+		}
+	}
+	var have, need [][]byte
+	if have, need, err = r.Reconcile(c, f, v); err != nil {
+		return
+	}
+	if pull {
+		for ids := range batches(need) {
+			var evs []*event.E
+			if evs, err = r.QuerySync(
+				c, &filter.F{Ids: tag.New(ids...)},
+			); chk.E(err) {
+				return
+			}
+			for _, ev := range evs {
+				if _, _, err = local.SaveEvent(c, ev, false, nil); err != nil {
+					// the event may be already stored or superseded.
+					err = nil
+					continue
+				}
+				pulled++
+			}
+		}
+	}
+	if push {
+		for ids := range batches(have) {
+			var evs event.S
+			if evs, err = local.QueryEvents(
+				c, &filter.F{Ids: tag.New(ids...)},
+			); chk.E(err) {
+				return
+			}
+			for _, ev := range evs {
+				if err = r.Publish(c, ev); err != nil {
+					if !r.IsConnected() {
+						return
+					}
+					err = nil
+					continue
+				}
+				pushed++
+			}
+		}
+	}
+	return
+}
+
+// batches yields the ids in slices of up to SyncBatchSize.
+func batches(ids [][]byte) func(yield func([][]byte) bool) {
+	return func(yield func([][]byte) bool) {
+		for len(ids) > 0 {
+			n := min(len(ids), SyncBatchSize)
+			if !yield(ids[:n]) {
+				return
+			}
+			ids = ids[n:]
+		}
+	}
+}
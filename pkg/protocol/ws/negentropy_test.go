@@ -0,0 +1,144 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"orly.dev/pkg/encoders/envelopes"
+	"orly.dev/pkg/encoders/envelopes/negentropyenvelope"
+	"orly.dev/pkg/encoders/filter"
+	"orly.dev/pkg/protocol/negentropy"
+	"orly.dev/pkg/utils/chk"
+	"testing"
+
+	"golang.org/x/net/websocket"
+	"lukechampine.com/frand"
+)
+
+func TestReconcile(t *testing.T) {
+	var shared, relayOnly, clientOnly [][]byte
+	for range 1000 {
+		shared = append(shared, frand.Bytes(32))
+	}
+	for range 40 {
+		relayOnly = append(relayOnly, frand.Bytes(32))
+		clientOnly = append(clientOnly, frand.Bytes(32))
+	}
+	vector := func(ids ...[][]byte) (v *negentropy.Vector) {
+		v = negentropy.NewVector()
+		for _, s := range ids {
+			for _, id := range s {
+				// the timestamp is derived from the id so both sides agree.
+				if err := v.Insert(1700000000+uint64(id[0]), id); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		v.Seal()
+		return
+	}
+	// fake relay server
+	closed := make(chan struct{})
+	ws := newWebsocketServer(
+		func(conn *websocket.Conn) {
+			var n *negentropy.N
+			for {
+				// the client writes large messages in fragments, which are
+				// received separately.
+				var msg []byte
+				for len(msg) == 0 || !json.Valid(msg) {
+					var frag []byte
+					if err := websocket.Message.Receive(conn, &frag); err != nil {
+						return
+					}
+					msg = append(msg, frag...)
+				}
+				l, rem, err := envelopes.Identify(msg)
+				if chk.E(err) {
+					t.Error(err)
+					return
+				}
+				var sub []byte
+				var res []byte
+				switch l {
+				case negentropyenvelope.LOpen:
+					env := negentropyenvelope.NewOpen()
+					if _, err = env.Unmarshal(rem); chk.E(err) {
+						t.Error(err)
+						return
+					}
+					if n, err = negentropy.New(
+						vector(shared, relayOnly), 0,
+					); chk.E(err) {
+						t.Error(err)
+						return
+					}
+					sub = env.Subscription.T
+					if res, err = n.Reconcile(env.Message); chk.E(err) {
+						t.Error(err)
+						return
+					}
+				case negentropyenvelope.LMsg:
+					env := negentropyenvelope.NewMsg()
+					if _, err = env.Unmarshal(rem); chk.E(err) {
+						t.Error(err)
+						return
+					}
+					sub = env.Subscription.T
+					if res, err = n.Reconcile(env.Message); chk.E(err) {
+						t.Error(err)
+						return
+					}
+				case negentropyenvelope.LClose:
+					close(closed)
+					return
+				default:
+					t.Errorf("unexpected message %s", msg)
+					return
+				}
+				env := negentropyenvelope.NewMsg()
+				env.Subscription.T = sub
+				env.Message = res
+				if err = websocket.Message.Send(
+					conn, env.Marshal(nil),
+				); chk.T(err) {
+					t.Error(err)
+					return
+				}
+			}
+		},
+	)
+	defer ws.Close()
+	rl := mustRelayConnect(ws.URL)
+	defer rl.Close()
+	have, need, err := rl.Reconcile(
+		context.Background(), filter.New(), vector(shared, clientOnly),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contains := func(ids [][]byte, id []byte) bool {
+		for _, i := range ids {
+			if bytes.Equal(i, id) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(have) != len(clientOnly) || len(need) != len(relayOnly) {
+		t.Fatalf(
+			"expected 40 have and need ids, got %d, %d", len(have), len(need),
+		)
+	}
+	for _, id := range clientOnly {
+		if !contains(have, id) {
+			t.Fatalf("missing have id %0x", id)
+		}
+	}
+	for _, id := range relayOnly {
+		if !contains(need, id) {
+			t.Fatalf("missing need id %0x", id)
+		}
+	}
+	<-closed
+}
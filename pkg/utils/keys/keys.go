@@ -14,7 +14,7 @@ func DecodeNpubOrHex(v string) (pk []byte, err error) {
 	var bits5 []byte
 	if prf, bits5, err = bech32.DecodeNoLimit([]byte(v)); chk.D(err) {
 		// try hex then
-		if _, err = hex.DecBytes(pk, []byte(v)); chk.E(err) {
+		if pk, err = hex.Dec(v); chk.E(err) {
 			log.W.F(
 				"owner key %s is neither bech32 npub nor hex",
 				v,
@@ -0,0 +1,115 @@
+// Package ratelimit provides token bucket rate limiters keyed by a string,
+// such as an IP address or a pubkey.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a set of token buckets, one for each key. Each bucket refills at
+// Rate tokens per second, up to Burst tokens.
+type Limiter struct {
+	// Rate is the number of tokens added to each bucket per second.
+	Rate float64
+	// Burst is the number of tokens a bucket holds when it is full.
+	Burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a Limiter that allows rate tokens per second per key, with
+// bursts of up to burst tokens. If burst is less than one token it is set to
+// one.
+func New(rate, burst float64) (l *Limiter) {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		Rate:    rate,
+		Burst:   burst,
+		buckets: make(map[string]*bucket),
+		pruned:  time.Now(),
+	}
+}
+
+// Allow takes n tokens from the bucket of a key, if it has enough.
+//
+// The rate and burst of the bucket are multiplied by scale, so that some keys
+// can be given more headroom than others with the same Limiter. A scale of
+// zero or less is treated as one.
+//
+// A nil Limiter allows everything.
+func (l *Limiter) Allow(key string, n, scale float64) (ok bool) {
+	return l.AllowAll([]string{key}, n, scale)
+}
+
+// AllowAll takes n tokens from the buckets of each of the keys if all of them
+// have enough, and none if any of them doesn't, so a request that one of its
+// keys is refused for isn't charged to the others.
+//
+// The scale is applied as by Allow, and a nil Limiter allows everything.
+//
+// A request of more tokens than the burst only needs, and takes, a full
+// bucket, so that it is throttled like any other rather than refused forever.
+func (l *Limiter) AllowAll(keys []string, n, scale float64) (ok bool) {
+	if l == nil {
+		return true
+	}
+	if scale <= 0 {
+		scale = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.prune(now)
+	burst := l.Burst * scale
+	n = min(n, burst)
+	buckets := make([]*bucket, len(keys))
+	for i, key := range keys {
+		b, found := l.buckets[key]
+		if !found {
+			b = &bucket{tokens: burst, last: now}
+			l.buckets[key] = b
+		} else {
+			b.tokens += now.Sub(b.last).Seconds() * l.Rate * scale
+			if b.tokens > burst {
+				b.tokens = burst
+			}
+			b.last = now
+		}
+		if b.tokens < n {
+			return false
+		}
+		buckets[i] = b
+	}
+	for _, b := range buckets {
+		b.tokens -= n
+	}
+	return true
+}
+
+// prune removes the buckets that would have refilled completely, as they are
+// the same as a new bucket, so the map does not grow without bound.
+func (l *Limiter) prune(now time.Time) {
+	if l.Rate <= 0 {
+		return
+	}
+	full := time.Duration(l.Burst / l.Rate * float64(time.Second))
+	if now.Sub(l.pruned) < full {
+		return
+	}
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, k)
+		}
+	}
+	l.pruned = now
+}
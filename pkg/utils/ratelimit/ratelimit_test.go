@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(10, 5)
+	for i := 0; i < 5; i++ {
+		if !l.Allow("a", 1, 1) {
+			t.Fatalf("request %d was limited", i)
+		}
+	}
+	if l.Allow("a", 1, 1) {
+		t.Fatal("request over the burst was allowed")
+	}
+	// other keys have their own bucket
+	if !l.Allow("b", 1, 1) {
+		t.Fatal("request from another key was limited")
+	}
+	// a larger scale gives a larger burst
+	for i := 0; i < 10; i++ {
+		if !l.Allow("c", 1, 2) {
+			t.Fatalf("scaled request %d was limited", i)
+		}
+	}
+	if l.Allow("c", 1, 2) {
+		t.Fatal("request over the scaled burst was allowed")
+	}
+	// the bucket refills over time
+	time.Sleep(250 * time.Millisecond)
+	if !l.Allow("a", 2, 1) {
+		t.Fatal("bucket was not refilled")
+	}
+	// nil limiters allow everything
+	var nl *Limiter
+	if !nl.Allow("a", 1000, 1) {
+		t.Fatal("nil limiter limited a request")
+	}
+}
+
+func TestAllowAll(t *testing.T) {
+	l := New(1, 2)
+	if !l.Allow("b", 2, 1) {
+		t.Fatal("request within the burst was limited")
+	}
+	// b has no tokens left, so a is not charged for the refused request
+	if l.AllowAll([]string{"a", "b"}, 1, 1) {
+		t.Fatal("request over the burst of one key was allowed")
+	}
+	for i := 0; i < 2; i++ {
+		if !l.Allow("a", 1, 1) {
+			t.Fatalf("request %d was charged for a refused request", i)
+		}
+	}
+}
+
+func TestAllowLarger(t *testing.T) {
+	l := New(100, 5)
+	// a request larger than the burst takes a full bucket
+	if !l.Allow("a", 50, 1) {
+		t.Fatal("request larger than the burst was refused with a full bucket")
+	}
+	if l.Allow("a", 50, 1) {
+		t.Fatal("request larger than the burst was allowed with an empty bucket")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !l.Allow("a", 50, 1) {
+		t.Fatal("request larger than the burst was refused after a refill")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l := New(1000, 1)
+	l.Allow("a", 1, 1)
+	time.Sleep(5 * time.Millisecond)
+	l.Allow("b", 1, 1)
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("full bucket was not pruned")
+	}
+}